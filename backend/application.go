@@ -9,11 +9,12 @@ package backend
 
 import (
 	"errors"
-	"hash/fnv"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"janusec/data"
 	"janusec/firewall"
@@ -43,10 +44,16 @@ func SelectDestination(app *models.Application) string {
 
 // SelectBackendRoute will replace SelectDestination
 func SelectBackendRoute(app *models.Application, r *http.Request, srcIP string) *models.Destination {
+	return selectBackendRoute(app, r, srcIP, nil)
+}
+
+// selectBackendRoute select from online destinations except the excluded (tried) ones
+func selectBackendRoute(app *models.Application, r *http.Request, srcIP string, excluded map[int64]bool) *models.Destination {
 	routePath := utils.GetRoutePath(r.URL.Path)
 	var dests []*models.Destination
 	var onlineDests = []*models.Destination{}
 	hit := false
+	routeKey := routePath
 	if routePath != "/" {
 		// First check /abc/
 		valueI, ok := app.Route.Load(routePath)
@@ -58,11 +65,12 @@ func SelectBackendRoute(app *models.Application, r *http.Request, srcIP string)
 
 	if !hit {
 		// Second check .php
-		ext := filepath.Ext(r.URL.Path)
-		valueI, ok := app.Route.Load(ext)
+		routeKey = filepath.Ext(r.URL.Path)
+		valueI, ok := app.Route.Load(routeKey)
 		// Third check /
 		if !ok {
-			valueI, ok = app.Route.Load("/")
+			routeKey = "/"
+			valueI, ok = app.Route.Load(routeKey)
 		}
 		if !ok {
 			// lack of route /
@@ -71,32 +79,54 @@ func SelectBackendRoute(app *models.Application, r *http.Request, srcIP string)
 		dests = valueI.([]*models.Destination)
 	}
 
-	// get online destinations
+	// get online destinations, weight 0 means draining
 	for _, dest := range dests {
-		if dest.Online {
+		if dest.Online.Load() && dest.Weight > 0 && !excluded[dest.ID] && IsCircuitAvailable(dest) {
 			onlineDests = append(onlineDests, dest)
 		}
 	}
 
-	destLen := uint32(len(onlineDests))
-	if destLen == 0 {
+	// Split traffic between destination groups, v1.2.4
+	// The group selected by traffic rule falls back to the default group "" only, so that canary and stable are not mixed,
+	// requests without matched rule use all destinations only if no destination is in the default group
+	group := GetDestinationGroup(app, routeKey, r, srcIP)
+	if len(group) > 0 || len(filterDestinationGroup(dests, "")) > 0 {
+		groupDests := filterDestinationGroup(onlineDests, group)
+		if len(groupDests) == 0 && len(group) > 0 {
+			groupDests = filterDestinationGroup(onlineDests, "")
+		}
+		onlineDests = groupDests
+	}
+
+	if len(onlineDests) == 0 {
 		return nil
 	}
-	var dest *models.Destination
-	if destLen == 1 {
-		dest = onlineDests[0]
-	} else if destLen > 1 {
-		// According to Hash(IP+UA)
-		h := fnv.New32a()
-		_, err := h.Write([]byte(srcIP + r.UserAgent()))
-		if err != nil {
-			utils.DebugPrintln("SelectBackendRoute h.Write", err)
+	dest := onlineDests[0]
+	if len(onlineDests) > 1 {
+		lbMethod := models.LBIPHash
+		// According to Hash(IP+UA) by default
+		hashValue := srcIP + r.UserAgent()
+		routePolicy := GetRoutePolicy(app, routeKey)
+		if routePolicy != nil {
+			lbMethod = routePolicy.LBMethod
+			if lbMethod == models.LBConsistentHash {
+				hashValue = GetHashValue(r, srcIP, routePolicy)
+			}
 		}
-		hashUInt32 := h.Sum32()
-		destIndex := hashUInt32 % destLen
-		dest = onlineDests[destIndex]
+		candidates := []*balanceCandidate{}
+		for _, onlineDest := range onlineDests {
+			candidates = append(candidates, &balanceCandidate{
+				ID:          onlineDest.ID,
+				Name:        onlineDest.Destination,
+				Weight:      onlineDest.Weight,
+				Connections: atomic.LoadInt64(&onlineDest.Connections),
+			})
+		}
+		stateKey := "app-" + strconv.FormatInt(app.ID, 10) + "-" + routeKey
+		dest = onlineDests[selectCandidate(lbMethod, stateKey, candidates, hashValue)]
 	}
-	if dest.RouteType == models.ReverseProxyRoute {
+	// uWSGI and SCGI use the same path mapping as reverse proxy, v1.2.4
+	if dest.RouteType == models.ReverseProxyRoute || dest.RouteType == models.UWSGIRoute || dest.RouteType == models.SCGIRoute {
 		if dest.RequestRoute != dest.BackendRoute {
 			r.URL.Path = strings.Replace(r.URL.Path, dest.RequestRoute, dest.BackendRoute, 1)
 		}
@@ -104,6 +134,23 @@ func SelectBackendRoute(app *models.Application, r *http.Request, srcIP string)
 	return dest
 }
 
+// GetHashValue return the consistent hashing key of the request, fall back to IP if not found
+func GetHashValue(r *http.Request, srcIP string, routePolicy *models.RoutePolicy) string {
+	switch routePolicy.HashKey {
+	case models.HashKeyCookie:
+		cookie, err := r.Cookie(routePolicy.HashKeyName)
+		if err == nil && len(cookie.Value) > 0 {
+			return cookie.Value
+		}
+	case models.HashKeyHeader:
+		headerValue := r.Header.Get(routePolicy.HashKeyName)
+		if len(headerValue) > 0 {
+			return headerValue
+		}
+	}
+	return srcIP
+}
+
 // GetApplicationByID ...
 func GetApplicationByID(appID int64) (*models.Application, error) {
 	for _, app := range Apps {
@@ -147,21 +194,30 @@ func LoadApps() {
 		dbApps := data.DAL.SelectApplications()
 		for _, dbApp := range dbApps {
 			app := &models.Application{ID: dbApp.ID,
-				Name:           dbApp.Name,
-				InternalScheme: dbApp.InternalScheme,
-				RedirectHTTPS:  dbApp.RedirectHTTPS,
-				HSTSEnabled:    dbApp.HSTSEnabled,
-				WAFEnabled:     dbApp.WAFEnabled,
-				ShieldEnabled:  dbApp.ShieldEnabled,
-				ClientIPMethod: dbApp.ClientIPMethod,
-				Description:    dbApp.Description,
-				Destinations:   []*models.Destination{},
-				Route:          sync.Map{},
-				OAuthRequired:  dbApp.OAuthRequired,
-				SessionSeconds: dbApp.SessionSeconds,
-				Owner:          dbApp.Owner,
-				CSPEnabled:     dbApp.CSPEnabled,
-				CSP:            dbApp.CSP,
+				Name:            dbApp.Name,
+				InternalScheme:  dbApp.InternalScheme,
+				RedirectHTTPS:   dbApp.RedirectHTTPS,
+				HSTSEnabled:     dbApp.HSTSEnabled,
+				WAFEnabled:      dbApp.WAFEnabled,
+				ShieldEnabled:   dbApp.ShieldEnabled,
+				ClientIPMethod:  dbApp.ClientIPMethod,
+				Description:     dbApp.Description,
+				Destinations:    []*models.Destination{},
+				Route:           sync.Map{},
+				OAuthRequired:   dbApp.OAuthRequired,
+				SessionSeconds:  dbApp.SessionSeconds,
+				Owner:           dbApp.Owner,
+				CSPEnabled:      dbApp.CSPEnabled,
+				CSP:             dbApp.CSP,
+				TrustedProxies:  dbApp.TrustedProxies,
+				MaxBodySize:     dbApp.MaxBodySize,
+				CacheMaxSize:    dbApp.CacheMaxSize,
+				RoutePolicies:   []*models.RoutePolicy{},
+				HeaderRules:     []*models.HeaderRule{},
+				RewriteRules:    []*models.RewriteRule{},
+				CacheRules:      []*models.CacheRule{},
+				TrafficRules:    []*models.TrafficRule{},
+				ClientCertRules: []*models.ClientCertRule{},
 			}
 			Apps = append(Apps, app)
 		}
@@ -237,6 +293,9 @@ func UpdateDestinations(app *models.Application, destinations []interface{}) {
 		// delete outdated destinations from DB
 		if !InterfaceContainsDestinationID(destinations, dest.ID) {
 			app.Route.Delete(dest.RequestRoute)
+			DeleteTransport(dest.ID)
+			DeleteCircuitBreaker(dest.ID)
+			DeleteDestinationLimiter(dest.ID)
 			err := data.DAL.DeleteDestinationByID(dest.ID)
 			if err != nil {
 				utils.DebugPrintln("DeleteDestinationByID", err)
@@ -254,27 +313,50 @@ func UpdateDestinations(app *models.Application, destinations []interface{}) {
 		destDest := strings.TrimSpace(destMap["destination"].(string))
 		appID := app.ID //int64(destMap["appID"].(float64))
 		nodeID := int64(destMap["node_id"].(float64))
+		weight := int64(1)
+		if weightF, ok := destMap["weight"].(float64); ok {
+			weight = int64(weightF)
+		}
+		proxyProtocol := ProxyProtocolNone
+		if proxyProtocolF, ok := destMap["proxy_protocol"].(float64); ok {
+			proxyProtocol = int64(proxyProtocolF)
+		}
+		groupName, _ := destMap["group"].(string)
+		groupName = strings.TrimSpace(groupName)
+		var maxConcurrency int64
+		if maxConcurrencyF, ok := destMap["max_concurrency"].(float64); ok && maxConcurrencyF > 0 {
+			maxConcurrency = int64(maxConcurrencyF)
+		}
 		var err error
 		if destID == 0 {
-			destID, err = data.DAL.InsertDestination(routeType, requestRoute, backendRoute, destDest, appID, nodeID)
+			destID, err = data.DAL.InsertDestination(routeType, requestRoute, backendRoute, destDest, appID, nodeID, weight, proxyProtocol, groupName, maxConcurrency)
 			if err != nil {
 				utils.DebugPrintln("InsertDestination", err)
 			}
 		} else {
-			err = data.DAL.UpdateDestinationNode(routeType, requestRoute, backendRoute, destDest, appID, nodeID, destID)
+			err = data.DAL.UpdateDestinationNode(routeType, requestRoute, backendRoute, destDest, appID, nodeID, weight, proxyProtocol, groupName, maxConcurrency, destID)
 			if err != nil {
 				utils.DebugPrintln("UpdateDestinationNode", err)
 			}
 		}
 		dest := &models.Destination{
-			ID:           destID,
-			RouteType:    models.RouteType(routeType),
-			RequestRoute: requestRoute,
-			BackendRoute: backendRoute,
-			Destination:  destDest,
-			AppID:        appID,
-			NodeID:       nodeID,
-			Online:       true,
+			ID:             destID,
+			RouteType:      models.RouteType(routeType),
+			RequestRoute:   requestRoute,
+			BackendRoute:   backendRoute,
+			Destination:    destDest,
+			AppID:          appID,
+			NodeID:         nodeID,
+			Online:         models.NewOnlineStatus(true),
+			Weight:         weight,
+			ProxyProtocol:  proxyProtocol,
+			Group:          groupName,
+			MaxConcurrency: maxConcurrency,
+		}
+		if dest.RouteType == models.ReverseProxyRoute {
+			UpdateTransport(app, dest)
+		} else {
+			DeleteTransport(dest.ID)
 		}
 		newDestinations = append(newDestinations, dest)
 	}
@@ -340,34 +422,56 @@ func UpdateApplication(param map[string]interface{}, clientIP string, authUser *
 	if csp, ok = application["csp"].(string); !ok {
 		csp = ""
 	}
+	var trustedProxies string
+	if trustedProxies, ok = application["trusted_proxies"].(string); !ok {
+		trustedProxies = ""
+	}
+	trustedProxies = strings.TrimSpace(trustedProxies)
+	var maxBodySize int64
+	if maxBodySizeF, ok := application["max_body_size"].(float64); ok && maxBodySizeF > 0 {
+		maxBodySize = int64(maxBodySizeF)
+	}
+	var cacheMaxSize int64
+	if cacheMaxSizeF, ok := application["cache_max_size"].(float64); ok && cacheMaxSizeF > 0 {
+		cacheMaxSize = int64(cacheMaxSizeF)
+	}
 	owner := application["owner"].(string)
 	var app *models.Application
 	if appID == 0 {
 		// new application
-		newID := data.DAL.InsertApplication(appName, internalScheme, redirectHTTPS, hstsEnabled, wafEnabled, shieldEnabled, ipMethod, description, oauthRequired, sessionSeconds, owner, cspEnabled, csp)
+		newID := data.DAL.InsertApplication(appName, internalScheme, redirectHTTPS, hstsEnabled, wafEnabled, shieldEnabled, ipMethod, description, oauthRequired, sessionSeconds, owner, cspEnabled, csp, trustedProxies, maxBodySize, cacheMaxSize)
 		app = &models.Application{
 			ID: newID, Name: appName,
 			InternalScheme: internalScheme,
 			//Destinations:   []*models.Destination{},
-			Route:          sync.Map{},
-			Domains:        []*models.Domain{},
-			RedirectHTTPS:  redirectHTTPS,
-			HSTSEnabled:    hstsEnabled,
-			WAFEnabled:     wafEnabled,
-			ShieldEnabled:  shieldEnabled,
-			ClientIPMethod: ipMethod,
-			Description:    description,
-			OAuthRequired:  oauthRequired,
-			SessionSeconds: sessionSeconds,
-			Owner:          owner,
-			CSPEnabled:     cspEnabled,
-			CSP:            csp}
+			Route:           sync.Map{},
+			Domains:         []*models.Domain{},
+			RedirectHTTPS:   redirectHTTPS,
+			HSTSEnabled:     hstsEnabled,
+			WAFEnabled:      wafEnabled,
+			ShieldEnabled:   shieldEnabled,
+			ClientIPMethod:  ipMethod,
+			Description:     description,
+			OAuthRequired:   oauthRequired,
+			SessionSeconds:  sessionSeconds,
+			Owner:           owner,
+			CSPEnabled:      cspEnabled,
+			CSP:             csp,
+			TrustedProxies:  trustedProxies,
+			MaxBodySize:     maxBodySize,
+			CacheMaxSize:    cacheMaxSize,
+			RoutePolicies:   []*models.RoutePolicy{},
+			HeaderRules:     []*models.HeaderRule{},
+			RewriteRules:    []*models.RewriteRule{},
+			CacheRules:      []*models.CacheRule{},
+			TrafficRules:    []*models.TrafficRule{},
+			ClientCertRules: []*models.ClientCertRule{}}
 		Apps = append(Apps, app)
 		go utils.OperationLog(clientIP, authUser.Username, "Add Application", app.Name)
 	} else {
 		app, _ = GetApplicationByID(appID)
 		if app != nil {
-			err := data.DAL.UpdateApplication(appName, internalScheme, redirectHTTPS, hstsEnabled, wafEnabled, shieldEnabled, ipMethod, description, oauthRequired, sessionSeconds, owner, cspEnabled, csp, appID)
+			err := data.DAL.UpdateApplication(appName, internalScheme, redirectHTTPS, hstsEnabled, wafEnabled, shieldEnabled, ipMethod, description, oauthRequired, sessionSeconds, owner, cspEnabled, csp, trustedProxies, maxBodySize, cacheMaxSize, appID)
 			if err != nil {
 				utils.DebugPrintln("UpdateApplication", err)
 			}
@@ -384,6 +488,9 @@ func UpdateApplication(param map[string]interface{}, clientIP string, authUser *
 			app.Owner = owner
 			app.CSPEnabled = cspEnabled
 			app.CSP = csp
+			app.TrustedProxies = trustedProxies
+			app.MaxBodySize = maxBodySize
+			app.CacheMaxSize = cacheMaxSize
 			go utils.OperationLog(clientIP, authUser.Username, "Update Application", app.Name)
 		} else {
 			return nil, errors.New("application not found")
@@ -391,10 +498,68 @@ func UpdateApplication(param map[string]interface{}, clientIP string, authUser *
 	}
 	destinations := application["destinations"].([]interface{})
 	UpdateDestinations(app, destinations)
+	if routePolicies, ok := application["route_policies"].([]interface{}); ok {
+		UpdateRoutePolicies(app, routePolicies)
+	}
+	if headerRules, ok := application["header_rules"].([]interface{}); ok {
+		UpdateHeaderRules(app, headerRules)
+	}
+	if rewriteRules, ok := application["rewrite_rules"].([]interface{}); ok {
+		UpdateRewriteRules(app, rewriteRules)
+	}
+	if cacheRules, ok := application["cache_rules"].([]interface{}); ok {
+		UpdateCacheRules(app, cacheRules)
+	}
+	var err error
+	if trafficRules, ok := application["traffic_rules"].([]interface{}); ok {
+		if trafficRulesErr := UpdateTrafficRules(app, trafficRules); trafficRulesErr != nil {
+			utils.DebugPrintln("UpdateTrafficRules", trafficRulesErr)
+			err = trafficRulesErr
+		}
+	}
+	if healthCheck, ok := application["health_check"].(map[string]interface{}); ok {
+		if healthCheckErr := UpdateHealthCheck(app, healthCheck); healthCheckErr != nil {
+			utils.DebugPrintln("UpdateHealthCheck", healthCheckErr)
+			err = healthCheckErr
+		}
+	}
+	if compression, ok := application["compression"].(map[string]interface{}); ok {
+		if compressionErr := UpdateCompression(app, compression); compressionErr != nil {
+			utils.DebugPrintln("UpdateCompression", compressionErr)
+			err = compressionErr
+		}
+	}
+	if clientAuth, ok := application["client_auth"].(map[string]interface{}); ok {
+		if clientAuthErr := UpdateClientAuth(app, clientAuth); clientAuthErr != nil {
+			utils.DebugPrintln("UpdateClientAuth", clientAuthErr)
+			err = clientAuthErr
+		}
+	}
+	if clientCertRules, ok := application["client_cert_rules"].([]interface{}); ok {
+		UpdateClientCertRules(app, clientCertRules)
+	}
+	if upstreamTLS, ok := application["upstream_tls"].(map[string]interface{}); ok {
+		if upstreamTLSErr := UpdateUpstreamTLS(app, upstreamTLS); upstreamTLSErr != nil {
+			utils.DebugPrintln("UpdateUpstreamTLS", upstreamTLSErr)
+			err = upstreamTLSErr
+		}
+	}
+	if concurrencyLimit, ok := application["concurrency_limit"].(map[string]interface{}); ok {
+		if concurrencyLimitErr := UpdateConcurrencyLimit(app, concurrencyLimit); concurrencyLimitErr != nil {
+			utils.DebugPrintln("UpdateConcurrencyLimit", concurrencyLimitErr)
+			err = concurrencyLimitErr
+		}
+	}
+	if waitingRoom, ok := application["waiting_room"].(map[string]interface{}); ok {
+		if waitingRoomErr := UpdateWaitingRoom(app, waitingRoom); waitingRoomErr != nil {
+			utils.DebugPrintln("UpdateWaitingRoom", waitingRoomErr)
+			err = waitingRoomErr
+		}
+	}
 	appDomains := application["domains"].([]interface{})
 	UpdateAppDomains(app, appDomains)
 	data.UpdateBackendLastModified()
-	return app, nil
+	return app, err
 }
 
 // GetApplicationIndex ...
@@ -422,7 +587,23 @@ func DeleteApplicationByID(appID int64, clientIP string, authUser *models.AuthUs
 		return err
 	}
 	DeleteDomainsByApp(app)
+	for _, dest := range app.Destinations {
+		DeleteTransport(dest.ID)
+		DeleteCircuitBreaker(dest.ID)
+		DeleteDestinationLimiter(dest.ID)
+	}
 	DeleteDestinationsByApp(appID)
+	DeleteRoutePoliciesByApp(appID)
+	DeleteHealthCheckByApp(appID)
+	DeleteHeaderRulesByApp(appID)
+	DeleteRewriteRulesByApp(appID)
+	DeleteCacheRulesByApp(appID)
+	DeleteTrafficRulesByApp(appID)
+	DeleteCompressionByApp(appID)
+	DeleteClientAuthByApp(appID)
+	DeleteUpstreamTLSByApp(appID)
+	DeleteConcurrencyLimitByApp(appID)
+	DeleteWaitingRoomByApp(appID)
 	err = firewall.DeleteCCPolicyByAppID(appID, clientIP, authUser, false)
 	if err != nil {
 		utils.DebugPrintln("DeleteApplicationByID DeleteCCPolicyByAppID", err)
@@ -0,0 +1,111 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-18 21:36:40
+ * @Last Modified: U2, 2021-05-18 21:36:40
+ */
+
+package backend
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"janusec/models"
+	"janusec/utils"
+)
+
+// balanceStates map[string]*balanceState, runtime state of round-robin
+// key: app-{appID}-{route} for destinations, vip-{vipAppID} for port forwarding
+var balanceStates = sync.Map{}
+
+type balanceState struct {
+	counter uint64
+	mutex   sync.Mutex
+	// currentWeights used for smooth weighted round-robin, key: ID of destination or target
+	currentWeights map[int64]int64
+}
+
+// balanceCandidate is the common view of Destination and VipTarget
+type balanceCandidate struct {
+	ID          int64
+	Name        string
+	Weight      int64
+	Connections int64
+}
+
+func getBalanceState(key string) *balanceState {
+	stateI, _ := balanceStates.LoadOrStore(key, &balanceState{currentWeights: map[int64]int64{}})
+	return stateI.(*balanceState)
+}
+
+// selectCandidate return the index of selected candidate, all candidates are online and weight > 0
+func selectCandidate(method models.LBMethod, stateKey string, candidates []*balanceCandidate, hashValue string) int {
+	count := len(candidates)
+	if count == 1 {
+		return 0
+	}
+	switch method {
+	case models.LBRoundRobin:
+		state := getBalanceState(stateKey)
+		index := atomic.AddUint64(&state.counter, 1) - 1
+		return int(index % uint64(count))
+	case models.LBWeightedRoundRobin:
+		// Smooth weighted round-robin, same as nginx
+		state := getBalanceState(stateKey)
+		state.mutex.Lock()
+		defer state.mutex.Unlock()
+		var totalWeight int64
+		best := -1
+		for i, candidate := range candidates {
+			state.currentWeights[candidate.ID] += candidate.Weight
+			totalWeight += candidate.Weight
+			if best < 0 || state.currentWeights[candidate.ID] > state.currentWeights[candidates[best].ID] {
+				best = i
+			}
+		}
+		state.currentWeights[candidates[best].ID] -= totalWeight
+		return best
+	case models.LBLeastConnections:
+		// Compare connections/weight, begin with a rotated index to spread the ties
+		state := getBalanceState(stateKey)
+		offset := int(atomic.AddUint64(&state.counter, 1) % uint64(count))
+		best := offset
+		for j := 1; j < count; j++ {
+			i := (offset + j) % count
+			if candidates[i].Connections*candidates[best].Weight < candidates[best].Connections*candidates[i].Weight {
+				best = i
+			}
+		}
+		return best
+	case models.LBConsistentHash:
+		// Weighted rendezvous hashing, only keys of the removed candidate are remapped
+		best := 0
+		bestScore := math.Inf(-1)
+		for i, candidate := range candidates {
+			h := fnv.New64a()
+			_, err := h.Write([]byte(hashValue + "#" + candidate.Name))
+			if err != nil {
+				utils.DebugPrintln("selectCandidate h.Write", err)
+			}
+			// map hash to (0, 1)
+			u := (float64(h.Sum64()>>11) + 0.5) / float64(uint64(1)<<53)
+			score := -float64(candidate.Weight) / math.Log(u)
+			if score > bestScore {
+				best = i
+				bestScore = score
+			}
+		}
+		return best
+	default:
+		// models.LBIPHash
+		h := fnv.New32a()
+		_, err := h.Write([]byte(hashValue))
+		if err != nil {
+			utils.DebugPrintln("selectCandidate h.Write", err)
+		}
+		return int(h.Sum32() % uint32(count))
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-30 15:12:40
+ * @Last Modified: U2, 2021-05-30 15:12:40
+ */
+
+package backend
+
+import (
+	"errors"
+	"io"
+
+	"janusec/data"
+	"janusec/models"
+)
+
+// ErrRequestBodyTooLarge is returned by MaxBodyReader, it is not a failure of destination
+var ErrRequestBodyTooLarge = errors.New("request body too large")
+
+// MaxBodyReader limit the request body, used for chunked requests without Content-Length
+type MaxBodyReader struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+// NewMaxBodyReader ...
+func NewMaxBodyReader(body io.ReadCloser, maxBodySize int64) *MaxBodyReader {
+	return &MaxBodyReader{ReadCloser: body, remaining: maxBodySize}
+}
+
+// Read implements io.Reader
+func (body *MaxBodyReader) Read(p []byte) (int, error) {
+	if body.exceeded {
+		return 0, ErrRequestBodyTooLarge
+	}
+	// Read one more byte to check whether the limit is exceeded
+	if int64(len(p)) > body.remaining+1 {
+		p = p[:body.remaining+1]
+	}
+	n, err := body.ReadCloser.Read(p)
+	if int64(n) > body.remaining {
+		n = int(body.remaining)
+		body.remaining = 0
+		body.exceeded = true
+		return n, ErrRequestBodyTooLarge
+	}
+	body.remaining -= int64(n)
+	return n, err
+}
+
+// Exceeded return true if the body is larger than the limit
+func (body *MaxBodyReader) Exceeded() bool {
+	return body.exceeded
+}
+
+// GetMaxBodySize return the max request body size of application, or the default value in config.json
+func GetMaxBodySize(app *models.Application) int64 {
+	if app.MaxBodySize > 0 {
+		return app.MaxBodySize
+	}
+	return data.GetConfig().Server.MaxBodySize
+}
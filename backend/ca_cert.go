@@ -0,0 +1,209 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-31 20:45:09
+ * @Last Modified: U2, 2021-05-31 20:45:09
+ */
+
+package backend
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strconv"
+	"strings"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CACerts list of CA bundles, v1.2.4
+var CACerts = []*models.CACertItem{}
+
+// LoadCACerts load CA bundles from database (primary) or primary node (replica)
+func LoadCACerts() {
+	var caCerts []*models.CACertItem
+	if data.IsPrimary {
+		caCerts = data.DAL.SelectCACerts()
+	} else {
+		caCerts = RPCSelectCACerts()
+		if caCerts == nil {
+			return
+		}
+	}
+	for _, caCert := range caCerts {
+		if err := ParseCACert(caCert); err != nil {
+			utils.DebugPrintln("LoadCACerts ParseCACert", caCert.Name, err)
+		}
+	}
+	CACerts = caCerts
+}
+
+// ParseCACert build the certificate pool and the revoked serial numbers of the CA bundle
+func ParseCACert(caCert *models.CACertItem) error {
+	pool := x509.NewCertPool()
+	cas := []*x509.Certificate{}
+	caCert.ExpireTime = 0
+	rest := []byte(caCert.CAContent)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		pool.AddCert(ca)
+		cas = append(cas, ca)
+		if caCert.ExpireTime == 0 || ca.NotAfter.Unix() < caCert.ExpireTime {
+			caCert.ExpireTime = ca.NotAfter.Unix()
+		}
+	}
+	if len(cas) == 0 {
+		return errors.New("no CA certificate found in PEM content")
+	}
+	revokedSerials := map[string]bool{}
+	crlCount := 0
+	caCert.CRLNextUpdate = 0
+	rest = []byte(caCert.CRLContent)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseCRL(block.Bytes)
+		if err != nil {
+			return err
+		}
+		signed := false
+		for _, ca := range cas {
+			if ca.CheckCRLSignature(crl) == nil {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return errors.New("the CRL is not signed by the CA certificates")
+		}
+		crlCount++
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			revokedSerials[revoked.SerialNumber.String()] = true
+		}
+		nextUpdate := crl.TBSCertList.NextUpdate.Unix()
+		if !crl.TBSCertList.NextUpdate.IsZero() && (caCert.CRLNextUpdate == 0 || nextUpdate < caCert.CRLNextUpdate) {
+			caCert.CRLNextUpdate = nextUpdate
+		}
+	}
+	if len(strings.TrimSpace(caCert.CRLContent)) > 0 && crlCount == 0 {
+		return errors.New("no CRL found in PEM content")
+	}
+	caCert.Pool = pool
+	caCert.RevokedSerials = revokedSerials
+	return nil
+}
+
+// GetCACerts ...
+func GetCACerts() ([]*models.CACertItem, error) {
+	return CACerts, nil
+}
+
+// GetCACertByID ...
+func GetCACertByID(id int64) (*models.CACertItem, error) {
+	for _, caCert := range CACerts {
+		if caCert.ID == id {
+			return caCert, nil
+		}
+	}
+	return nil, errors.New("CA certificate not found")
+}
+
+// GetCACertIndex ...
+func GetCACertIndex(id int64) int {
+	for i := 0; i < len(CACerts); i++ {
+		if CACerts[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// UpdateCACert add or update the CA bundle
+// ca cert example: {"id":0,"name":"Internal CA","ca_content":"-----BEGIN CERTIFICATE-----...","crl_content":"-----BEGIN X509 CRL-----...","description":""}
+func UpdateCACert(param map[string]interface{}, clientIP string, authUser *models.AuthUser) (*models.CACertItem, error) {
+	if !authUser.IsCertAdmin {
+		return nil, errors.New("you have no privilege to update CA certificates")
+	}
+	caCertMap := param["object"].(map[string]interface{})
+	caCert := &models.CACertItem{
+		ID:        int64(caCertMap["id"].(float64)),
+		Name:      strings.TrimSpace(caCertMap["name"].(string)),
+		CAContent: strings.TrimSpace(caCertMap["ca_content"].(string)),
+	}
+	if crlContent, ok := caCertMap["crl_content"].(string); ok {
+		caCert.CRLContent = strings.TrimSpace(crlContent)
+	}
+	if description, ok := caCertMap["description"].(string); ok {
+		caCert.Description = description
+	}
+	if err := ParseCACert(caCert); err != nil {
+		utils.DebugPrintln("UpdateCACert ParseCACert", err)
+		return nil, err
+	}
+	var err error
+	if caCert.ID == 0 {
+		caCert.ID, err = data.DAL.InsertCACert(caCert)
+		if err != nil {
+			return nil, err
+		}
+		CACerts = append(CACerts, caCert)
+		go utils.OperationLog(clientIP, authUser.Username, "Add CA Certificate", caCert.Name)
+	} else {
+		i := GetCACertIndex(caCert.ID)
+		if i < 0 {
+			return nil, errors.New("CA certificate not found")
+		}
+		err = data.DAL.UpdateCACert(caCert)
+		if err != nil {
+			return nil, err
+		}
+		CACerts[i] = caCert
+		go utils.OperationLog(clientIP, authUser.Username, "Update CA Certificate", caCert.Name)
+	}
+	data.UpdateBackendLastModified()
+	return caCert, nil
+}
+
+// DeleteCACertByID ...
+func DeleteCACertByID(id int64, clientIP string, authUser *models.AuthUser) error {
+	if !authUser.IsCertAdmin {
+		return errors.New("you have no privilege to delete CA certificates")
+	}
+	for _, app := range Apps {
+		if (app.ClientAuth != nil && app.ClientAuth.CACertID == id) || (app.UpstreamTLS != nil && app.UpstreamTLS.CACertID == id) {
+			return errors.New("this CA certificate is used by application " + app.Name)
+		}
+	}
+	i := GetCACertIndex(id)
+	if i < 0 {
+		return errors.New("CA certificate not found")
+	}
+	err := data.DAL.DeleteCACertByID(id)
+	if err != nil {
+		return err
+	}
+	CACerts = append(CACerts[:i], CACerts[i+1:]...)
+	go utils.OperationLog(clientIP, authUser.Username, "Delete CA Certificate", strconv.FormatInt(id, 10))
+	data.UpdateBackendLastModified()
+	return nil
+}
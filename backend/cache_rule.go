@@ -0,0 +1,129 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-05 20:31:09
+ * @Last Modified: U2, 2021-06-05 20:31:09
+ */
+
+package backend
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// LoadCacheRules load cache rules of all applications, primary node only
+func LoadCacheRules() {
+	for _, app := range Apps {
+		app.CacheRules = data.DAL.SelectCacheRulesByAppID(app.ID)
+	}
+}
+
+// UpdateCacheRules update the ordered cache rules of the application, the priority is the index in list
+// cache rule example: [{"id":0,"methods":"GET","path_pattern":"^/api/products$","query_params":"","status_codes":"200","ttl":5,"stale_while_revalidate":10,"ignore_origin":false,"key_query_params":"page,size","key_headers":"Accept-Language","key_cookies":"","bypass_headers":"Authorization","bypass_cookies":"sessionid","bypass_query_params":"nocache"}]
+func UpdateCacheRules(app *models.Application, cacheRules []interface{}) {
+	for _, cacheRule := range app.CacheRules {
+		// delete outdated cache rules from DB
+		if !InterfaceContainsDestinationID(cacheRules, cacheRule.ID) {
+			err := data.DAL.DeleteCacheRuleByID(cacheRule.ID)
+			if err != nil {
+				utils.DebugPrintln("DeleteCacheRuleByID", err)
+			}
+		}
+	}
+	newCacheRules := []*models.CacheRule{}
+	for i, cacheRuleInterface := range cacheRules {
+		cacheRuleMap := cacheRuleInterface.(map[string]interface{})
+		cacheRule := &models.CacheRule{
+			ID:       int64(cacheRuleMap["id"].(float64)),
+			AppID:    app.ID,
+			Priority: int64(i),
+		}
+		getString := func(name string) string {
+			value, _ := cacheRuleMap[name].(string)
+			return strings.TrimSpace(value)
+		}
+		cacheRule.Methods = normalizeCacheMethods(getString("methods"))
+		cacheRule.PathPattern = getString("path_pattern")
+		cacheRule.QueryParams = normalizeNameList(getString("query_params"), false)
+		cacheRule.StatusCodes = getString("status_codes")
+		cacheRule.KeyQueryParams = normalizeNameList(getString("key_query_params"), false)
+		cacheRule.KeyHeaders = normalizeNameList(getString("key_headers"), true)
+		cacheRule.KeyCookies = normalizeNameList(getString("key_cookies"), false)
+		cacheRule.BypassHeaders = normalizeNameList(getString("bypass_headers"), true)
+		cacheRule.BypassCookies = normalizeNameList(getString("bypass_cookies"), false)
+		cacheRule.BypassQueryParams = normalizeNameList(getString("bypass_query_params"), false)
+		if ttl, ok := cacheRuleMap["ttl"].(float64); ok && ttl > 0 {
+			cacheRule.TTL = int64(ttl)
+		}
+		if staleWhileRevalidate, ok := cacheRuleMap["stale_while_revalidate"].(float64); ok && staleWhileRevalidate > 0 {
+			cacheRule.StaleWhileRevalidate = int64(staleWhileRevalidate)
+		}
+		if ignoreOrigin, ok := cacheRuleMap["ignore_origin"].(bool); ok {
+			cacheRule.IgnoreOrigin = ignoreOrigin
+		}
+		if len(getString("methods")) > 0 && len(cacheRule.Methods) == 0 {
+			utils.DebugPrintln("UpdateCacheRules only GET and HEAD can be cached", getString("methods"))
+			continue
+		}
+		if _, err := regexp.Compile(cacheRule.PathPattern); err != nil {
+			utils.DebugPrintln("UpdateCacheRules invalid pattern", cacheRule.PathPattern, err)
+			continue
+		}
+		var err error
+		if cacheRule.ID == 0 {
+			cacheRule.ID, err = data.DAL.InsertCacheRule(cacheRule)
+			if err != nil {
+				utils.DebugPrintln("InsertCacheRule", err)
+			}
+		} else {
+			err = data.DAL.UpdateCacheRule(cacheRule)
+			if err != nil {
+				utils.DebugPrintln("UpdateCacheRule", err)
+			}
+		}
+		newCacheRules = append(newCacheRules, cacheRule)
+	}
+	app.CacheRules = newCacheRules
+}
+
+// normalizeCacheMethods keep GET and HEAD only, other methods are never cached
+func normalizeCacheMethods(methods string) string {
+	normalized := []string{}
+	for _, method := range strings.Split(methods, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == http.MethodGet || method == http.MethodHead {
+			normalized = append(normalized, method)
+		}
+	}
+	return strings.Join(normalized, ",")
+}
+
+// normalizeNameList trim the comma separated names, header names are canonicalized
+func normalizeNameList(names string, isHeader bool) string {
+	normalized := []string{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if isHeader {
+			name = http.CanonicalHeaderKey(name)
+		}
+		normalized = append(normalized, name)
+	}
+	return strings.Join(normalized, ",")
+}
+
+// DeleteCacheRulesByApp ...
+func DeleteCacheRulesByApp(appID int64) {
+	err := data.DAL.DeleteCacheRulesByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteCacheRulesByAppID", err)
+	}
+}
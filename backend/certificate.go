@@ -208,6 +208,11 @@ func DeleteCertificateByID(certID int64, clientIP string, authUser *models.AuthU
 	if certDomainsCount > 0 {
 		return errors.New("this certificate is in use, please delete relevant applications at first")
 	}
+	for _, app := range Apps {
+		if app.UpstreamTLS != nil && app.UpstreamTLS.ClientCertID == certID {
+			return errors.New("this certificate is used as the upstream client certificate of application " + app.Name)
+		}
+	}
 	err := data.DAL.DeleteCertificate(certID)
 	if err != nil {
 		return err
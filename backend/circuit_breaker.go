@@ -0,0 +1,151 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-22 15:26:08
+ * @Last Modified: U2, 2021-05-22 15:26:08
+ */
+
+package backend
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker is the runtime state of one destination
+type circuitBreaker struct {
+	mutex               sync.Mutex
+	state               circuitState
+	consecutiveFailures int64
+	windowStart         int64
+	requests            int64
+	serverErrors        int64
+	openTime            int64
+}
+
+// circuitBreakers map[destID int64]*circuitBreaker
+var circuitBreakers = sync.Map{}
+
+func getCircuitBreaker(destID int64) *circuitBreaker {
+	breakerI, _ := circuitBreakers.LoadOrStore(destID, &circuitBreaker{windowStart: time.Now().Unix()})
+	return breakerI.(*circuitBreaker)
+}
+
+// IsCircuitAvailable return false if the circuit of destination is open and in cooldown, or half-open with a trial request,
+// used to select destinations, the request must still acquire the circuit before sent
+func IsCircuitAvailable(dest *models.Destination) bool {
+	if !data.GetConfig().CircuitBreaker.Enabled {
+		return true
+	}
+	breaker := getCircuitBreaker(dest.ID)
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	switch breaker.state {
+	case circuitOpen:
+		return time.Now().Unix()-breaker.openTime >= data.GetConfig().CircuitBreaker.Cooldown
+	case circuitHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// AcquireCircuit return false if the circuit is open and in cooldown, or half-open with a trial request in progress,
+// the open circuit turns half-open after cooldown in the same step, so that only one trial request is sent (trial is true)
+func AcquireCircuit(dest *models.Destination) (acquired bool, trial bool) {
+	if !data.GetConfig().CircuitBreaker.Enabled {
+		return true, false
+	}
+	breaker := getCircuitBreaker(dest.ID)
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	switch breaker.state {
+	case circuitOpen:
+		if time.Now().Unix()-breaker.openTime < data.GetConfig().CircuitBreaker.Cooldown {
+			return false, false
+		}
+		breaker.state = circuitHalfOpen
+		return true, true
+	case circuitHalfOpen:
+		return false, false
+	default:
+		return true, false
+	}
+}
+
+// ReleaseCircuit give back the trial without result, such as the request cancelled by client,
+// the circuit turns open again with the cooldown elapsed, so that the next request is the trial
+func ReleaseCircuit(dest *models.Destination, trial bool) {
+	if !trial || !data.GetConfig().CircuitBreaker.Enabled {
+		return
+	}
+	breaker := getCircuitBreaker(dest.ID)
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if breaker.state == circuitHalfOpen {
+		breaker.state = circuitOpen
+	}
+}
+
+// ReportCircuit record the result of a request to the destination, connection errors and 5xx are failures
+func ReportCircuit(dest *models.Destination, resp *http.Response, err error) {
+	cfg := data.GetConfig().CircuitBreaker
+	if !cfg.Enabled {
+		return
+	}
+	failed := err != nil || resp.StatusCode >= 500
+	breaker := getCircuitBreaker(dest.ID)
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	now := time.Now().Unix()
+	if now-breaker.windowStart >= cfg.Window {
+		breaker.windowStart = now
+		breaker.requests = 0
+		breaker.serverErrors = 0
+	}
+	breaker.requests++
+	if !failed {
+		breaker.consecutiveFailures = 0
+		if breaker.state == circuitHalfOpen {
+			breaker.state = circuitClosed
+			utils.DebugPrintln("CircuitBreaker closed", dest.Destination)
+		}
+		return
+	}
+	breaker.consecutiveFailures++
+	breaker.serverErrors++
+	switch breaker.state {
+	case circuitHalfOpen:
+		// trial request failed, open again
+		breaker.state = circuitOpen
+		breaker.openTime = now
+		utils.DebugPrintln("CircuitBreaker reopen", dest.Destination)
+	case circuitClosed:
+		if breaker.consecutiveFailures >= cfg.ConsecutiveFailures ||
+			(breaker.requests >= cfg.MinRequests && float64(breaker.serverErrors) >= cfg.ErrorRatio*float64(breaker.requests)) {
+			breaker.state = circuitOpen
+			breaker.openTime = now
+			breaker.requests = 0
+			breaker.serverErrors = 0
+			utils.DebugPrintln("CircuitBreaker open", dest.Destination, "consecutive failures", breaker.consecutiveFailures)
+		}
+	}
+}
+
+// DeleteCircuitBreaker remove the state of deleted destination
+func DeleteCircuitBreaker(destID int64) {
+	circuitBreakers.Delete(destID)
+}
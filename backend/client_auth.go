@@ -0,0 +1,190 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-31 21:18:40
+ * @Last Modified: U2, 2021-05-31 21:18:40
+ */
+
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// LoadClientAuths load mutual TLS settings and client certificate rules of all applications, primary node only
+func LoadClientAuths() {
+	for _, app := range Apps {
+		app.ClientAuth = data.DAL.SelectClientAuthByAppID(app.ID)
+		app.ClientCertRules = data.DAL.SelectClientCertRulesByAppID(app.ID)
+	}
+}
+
+// UpdateClientAuth update the mutual TLS setting of the application
+// client auth example: {"id":0,"enabled":true,"mode":2,"ca_cert_id":1,"crl_check":true,"forward_headers":true}
+func UpdateClientAuth(app *models.Application, clientAuthMap map[string]interface{}) error {
+	clientAuth := &models.ClientAuth{
+		AppID:          app.ID,
+		Mode:           models.ClientCertRequired,
+		ForwardHeaders: true,
+	}
+	if app.ClientAuth != nil {
+		clientAuth.ID = app.ClientAuth.ID
+	}
+	if enabled, ok := clientAuthMap["enabled"].(bool); ok {
+		clientAuth.Enabled = enabled
+	}
+	if mode, ok := clientAuthMap["mode"].(float64); ok && models.ClientCertMode(mode) == models.ClientCertOptional {
+		clientAuth.Mode = models.ClientCertOptional
+	}
+	if caCertID, ok := clientAuthMap["ca_cert_id"].(float64); ok {
+		clientAuth.CACertID = int64(caCertID)
+	}
+	if crlCheck, ok := clientAuthMap["crl_check"].(bool); ok {
+		clientAuth.CRLCheck = crlCheck
+	}
+	if forwardHeaders, ok := clientAuthMap["forward_headers"].(bool); ok {
+		clientAuth.ForwardHeaders = forwardHeaders
+	}
+	if clientAuth.Enabled {
+		caCert, err := GetCACertByID(clientAuth.CACertID)
+		if err != nil {
+			return err
+		}
+		if clientAuth.CRLCheck && len(caCert.CRLContent) == 0 {
+			return errors.New("CRL check requires the CRL in CA certificate " + caCert.Name)
+		}
+	}
+	var err error
+	if clientAuth.ID == 0 {
+		clientAuth.ID, err = data.DAL.InsertClientAuth(clientAuth)
+	} else {
+		err = data.DAL.UpdateClientAuth(clientAuth)
+	}
+	if err != nil {
+		return err
+	}
+	app.ClientAuth = clientAuth
+	return nil
+}
+
+// UpdateClientCertRules update the client certificate subject allowlist of the application
+// client cert rule example: [{"id":0,"path_prefix":"/admin/","subject_pattern":"^CN=admin,O=Janusec$"}]
+func UpdateClientCertRules(app *models.Application, clientCertRules []interface{}) {
+	for _, clientCertRule := range app.ClientCertRules {
+		// delete outdated rules from DB
+		if !InterfaceContainsDestinationID(clientCertRules, clientCertRule.ID) {
+			err := data.DAL.DeleteClientCertRuleByID(clientCertRule.ID)
+			if err != nil {
+				utils.DebugPrintln("DeleteClientCertRuleByID", err)
+			}
+		}
+	}
+	newClientCertRules := []*models.ClientCertRule{}
+	for _, clientCertRuleInterface := range clientCertRules {
+		clientCertRuleMap := clientCertRuleInterface.(map[string]interface{})
+		clientCertRule := &models.ClientCertRule{
+			ID:             int64(clientCertRuleMap["id"].(float64)),
+			AppID:          app.ID,
+			PathPrefix:     strings.TrimSpace(clientCertRuleMap["path_prefix"].(string)),
+			SubjectPattern: strings.TrimSpace(clientCertRuleMap["subject_pattern"].(string)),
+		}
+		if len(clientCertRule.PathPrefix) == 0 {
+			clientCertRule.PathPrefix = "/"
+		}
+		if _, err := regexp.Compile(clientCertRule.SubjectPattern); err != nil {
+			utils.DebugPrintln("UpdateClientCertRules invalid pattern", clientCertRule.SubjectPattern, err)
+			continue
+		}
+		var err error
+		if clientCertRule.ID == 0 {
+			clientCertRule.ID, err = data.DAL.InsertClientCertRule(clientCertRule)
+			if err != nil {
+				utils.DebugPrintln("InsertClientCertRule", err)
+			}
+		} else {
+			err = data.DAL.UpdateClientCertRule(clientCertRule)
+			if err != nil {
+				utils.DebugPrintln("UpdateClientCertRule", err)
+			}
+		}
+		newClientCertRules = append(newClientCertRules, clientCertRule)
+	}
+	app.ClientCertRules = newClientCertRules
+}
+
+// DeleteClientAuthByApp delete both the mutual TLS setting and client certificate rules
+func DeleteClientAuthByApp(appID int64) {
+	err := data.DAL.DeleteClientAuthByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteClientAuthByAppID", err)
+	}
+	err = data.DAL.DeleteClientCertRulesByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteClientCertRulesByAppID", err)
+	}
+}
+
+// applyClientAuth request the client certificate, the chain is verified by VerifyClientCert for each request,
+// since the Host of request may be different from the SNI
+func applyClientAuth(config *tls.Config, clientAuth *models.ClientAuth) {
+	config.ClientAuth = tls.RequestClientCert
+	if clientAuth.Mode == models.ClientCertRequired {
+		config.ClientAuth = tls.RequireAnyClientCert
+	}
+	// The acceptable CAs are sent to client for selecting certificate
+	if caCert, err := GetCACertByID(clientAuth.CACertID); err == nil {
+		config.ClientCAs = caCert.Pool
+	}
+}
+
+// VerifyClientCert verify the certificate chain sent by client with the CA bundle and CRL,
+// return nil certificate and nil error if the client did not send certificate
+func VerifyClientCert(clientAuth *models.ClientAuth, connState *tls.ConnectionState) (*x509.Certificate, error) {
+	if connState == nil || len(connState.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	caCert, err := GetCACertByID(clientAuth.CACertID)
+	if err != nil {
+		return nil, err
+	}
+	if caCert.Pool == nil {
+		return nil, errors.New("invalid CA certificate " + caCert.Name)
+	}
+	cert := connState.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range connState.PeerCertificates[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         caCert.Pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !clientAuth.CRLCheck {
+		return cert, nil
+	}
+	if len(caCert.CRLContent) == 0 {
+		return nil, errors.New("no CRL in CA certificate " + caCert.Name)
+	}
+	if caCert.CRLNextUpdate > 0 && time.Now().Unix() > caCert.CRLNextUpdate {
+		return nil, errors.New("the CRL of CA certificate " + caCert.Name + " has expired")
+	}
+	for _, chainCert := range chains[0] {
+		if caCert.RevokedSerials[chainCert.SerialNumber.String()] {
+			return nil, errors.New("certificate " + chainCert.Subject.String() + " has been revoked")
+		}
+	}
+	return cert, nil
+}
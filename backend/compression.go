@@ -0,0 +1,71 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-29 20:06:51
+ * @Last Modified: U2, 2021-05-29 20:06:51
+ */
+
+package backend
+
+import (
+	"strings"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// LoadCompressions load compression settings of all applications, primary node only
+func LoadCompressions() {
+	for _, app := range Apps {
+		app.Compression = data.DAL.SelectCompressionByAppID(app.ID)
+	}
+}
+
+// UpdateCompression update the compression setting of the application
+// compression example: {"id":0,"enabled":true,"gzip":true,"brotli":true,"mime_types":"text/html,application/json","min_size":1024}
+func UpdateCompression(app *models.Application, compressionMap map[string]interface{}) error {
+	compression := &models.Compression{
+		AppID:   app.ID,
+		Gzip:    true,
+		Brotli:  true,
+		MinSize: 1024,
+	}
+	if app.Compression != nil {
+		compression.ID = app.Compression.ID
+	}
+	if enabled, ok := compressionMap["enabled"].(bool); ok {
+		compression.Enabled = enabled
+	}
+	if gzip, ok := compressionMap["gzip"].(bool); ok {
+		compression.Gzip = gzip
+	}
+	if brotli, ok := compressionMap["brotli"].(bool); ok {
+		compression.Brotli = brotli
+	}
+	if mimeTypes, ok := compressionMap["mime_types"].(string); ok {
+		compression.MIMETypes = strings.TrimSpace(mimeTypes)
+	}
+	if minSize, ok := compressionMap["min_size"].(float64); ok && minSize >= 0 {
+		compression.MinSize = int64(minSize)
+	}
+	var err error
+	if compression.ID == 0 {
+		compression.ID, err = data.DAL.InsertCompression(compression)
+	} else {
+		err = data.DAL.UpdateCompression(compression)
+	}
+	if err != nil {
+		return err
+	}
+	app.Compression = compression
+	return nil
+}
+
+// DeleteCompressionByApp ...
+func DeleteCompressionByApp(appID int64) {
+	err := data.DAL.DeleteCompressionByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteCompressionByAppID", err)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-11 20:25:37
+ * @Last Modified: U2, 2021-06-11 20:25:37
+ */
+
+package backend
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// limiter is the FIFO semaphore of in-flight requests of an application or a destination
+type limiter struct {
+	mutex    sync.Mutex
+	inFlight int64
+	// waiters list of *limitWaiter, the first one is admitted when a slot is released
+	waiters *list.List
+}
+
+type limitWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+var (
+	// appLimiters map[appID int64]*limiter
+	appLimiters = sync.Map{}
+	// destLimiters map[destID int64]*limiter
+	destLimiters = sync.Map{}
+)
+
+func getLimiter(limiters *sync.Map, id int64) *limiter {
+	limiterI, _ := limiters.LoadOrStore(id, &limiter{waiters: list.New()})
+	return limiterI.(*limiter)
+}
+
+// acquire wait in the queue until a slot is released, return false if the queue is full or timeout
+func (l *limiter) acquire(ctx context.Context, max int64, queueSize int64, deadline time.Time) bool {
+	l.mutex.Lock()
+	// The limit may be raised after the requests queued
+	l.dispatch(max)
+	if l.inFlight < max {
+		l.inFlight++
+		l.mutex.Unlock()
+		return true
+	}
+	timeout := time.Until(deadline)
+	if int64(l.waiters.Len()) >= queueSize || timeout <= 0 {
+		l.mutex.Unlock()
+		return false
+	}
+	waiter := &limitWaiter{ready: make(chan struct{})}
+	elem := l.waiters.PushBack(waiter)
+	l.mutex.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !waiter.granted {
+		l.waiters.Remove(elem)
+	}
+	// Granted at the same time of timeout, the slot is used
+	return waiter.granted
+}
+
+// acquirePriority count the request without waiting
+func (l *limiter) acquirePriority() {
+	l.mutex.Lock()
+	l.inFlight++
+	l.mutex.Unlock()
+}
+
+func (l *limiter) release(max int64) {
+	l.mutex.Lock()
+	l.inFlight--
+	l.dispatch(max)
+	l.mutex.Unlock()
+}
+
+// dispatch admit the waiters in order while slots are available, called with mutex locked
+func (l *limiter) dispatch(max int64) {
+	for l.inFlight < max && l.waiters.Len() > 0 {
+		waiter := l.waiters.Remove(l.waiters.Front()).(*limitWaiter)
+		waiter.granted = true
+		l.inFlight++
+		close(waiter.ready)
+	}
+}
+
+// ErrConcurrencyLimit is returned if the slot of destination is not acquired in the queue timeout
+var ErrConcurrencyLimit = errors.New("concurrency limit reached")
+
+// ConcurrencyTicket hold the slots of a request in the limiters of application and destination
+type ConcurrencyTicket struct {
+	// Limit of application when the request arrived, the queue settings also apply to destinations
+	Limit      *models.ConcurrencyLimit
+	appMax     int64
+	priority   bool
+	deadline   time.Time
+	appRelease func()
+	// destID of the destination slot held, retries to another destination release it first
+	destID      int64
+	destRelease func()
+}
+
+// NewConcurrencyTicket return nil if neither the concurrency limit of application is enabled nor any destination is limited,
+// the queue timeout is shared by the application and the destinations
+func NewConcurrencyTicket(app *models.Application, path string) *ConcurrencyTicket {
+	limit := app.ConcurrencyLimit
+	appLimited := limit != nil && limit.Enabled && limit.MaxConcurrency > 0
+	if !appLimited && !hasDestinationLimit(app) {
+		return nil
+	}
+	if limit == nil {
+		// Default settings for destination limits
+		limit = &models.ConcurrencyLimit{AppID: app.ID, QueueTimeout: 3000, RetryAfter: 5}
+	}
+	ticket := &ConcurrencyTicket{
+		Limit:    limit,
+		priority: IsPriorityPath(limit, path),
+		deadline: time.Now().Add(time.Duration(limit.QueueTimeout) * time.Millisecond),
+	}
+	if appLimited {
+		ticket.appMax = limit.MaxConcurrency
+	}
+	return ticket
+}
+
+func hasDestinationLimit(app *models.Application) bool {
+	for _, dest := range app.Destinations {
+		if dest.MaxConcurrency > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPriorityPath return true if the path starts with one of the priority paths
+func IsPriorityPath(limit *models.ConcurrencyLimit, path string) bool {
+	for _, prefix := range strings.Split(limit.PriorityPaths, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if len(prefix) > 0 && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AcquireApp wait for a slot of the application, priority requests are admitted immediately but counted
+func (ticket *ConcurrencyTicket) AcquireApp(ctx context.Context, appID int64) bool {
+	if ticket == nil || ticket.appRelease != nil {
+		return true
+	}
+	release, ok := ticket.acquire(ctx, getLimiter(&appLimiters, appID), ticket.appMax)
+	ticket.appRelease = release
+	return ok
+}
+
+// AcquireDestination wait for a slot of the destination of current attempt, the slot of previous destination is released,
+// called again for the same destination does nothing
+func (ticket *ConcurrencyTicket) AcquireDestination(ctx context.Context, dest *models.Destination) bool {
+	if ticket == nil || (ticket.destRelease != nil && ticket.destID == dest.ID) {
+		return true
+	}
+	ticket.releaseDestination()
+	release, ok := ticket.acquire(ctx, getLimiter(&destLimiters, dest.ID), dest.MaxConcurrency)
+	if release != nil {
+		ticket.destID = dest.ID
+		ticket.destRelease = release
+	}
+	return ok
+}
+
+// acquire return the release function, nil if not limited or failed
+func (ticket *ConcurrencyTicket) acquire(ctx context.Context, l *limiter, max int64) (func(), bool) {
+	if max <= 0 {
+		return nil, true
+	}
+	if ticket.priority {
+		l.acquirePriority()
+	} else if !l.acquire(ctx, max, ticket.Limit.QueueSize, ticket.deadline) {
+		return nil, false
+	}
+	return func() {
+		l.release(max)
+	}, true
+}
+
+func (ticket *ConcurrencyTicket) releaseDestination() {
+	if ticket.destRelease != nil {
+		ticket.destRelease()
+		ticket.destRelease = nil
+	}
+}
+
+// Release the slots after the response completes
+func (ticket *ConcurrencyTicket) Release() {
+	if ticket == nil {
+		return
+	}
+	ticket.releaseDestination()
+	if ticket.appRelease != nil {
+		ticket.appRelease()
+		ticket.appRelease = nil
+	}
+}
+
+// LoadConcurrencyLimits load concurrency limits of all applications, primary node only
+func LoadConcurrencyLimits() {
+	for _, app := range Apps {
+		app.ConcurrencyLimit = data.DAL.SelectConcurrencyLimitByAppID(app.ID)
+	}
+}
+
+// UpdateConcurrencyLimit update the concurrency limit of the application
+// concurrencyLimit example: {"id":0,"enabled":true,"max_concurrency":200,"queue_size":100,"queue_timeout":3000,"retry_after":5,"priority_paths":"/checkout/,/api/pay","error_page":""}
+func UpdateConcurrencyLimit(app *models.Application, limitMap map[string]interface{}) error {
+	limit := &models.ConcurrencyLimit{
+		AppID:        app.ID,
+		QueueTimeout: 3000,
+		RetryAfter:   5,
+	}
+	if app.ConcurrencyLimit != nil {
+		limit.ID = app.ConcurrencyLimit.ID
+	}
+	if enabled, ok := limitMap["enabled"].(bool); ok {
+		limit.Enabled = enabled
+	}
+	if maxConcurrency, ok := limitMap["max_concurrency"].(float64); ok && maxConcurrency >= 0 {
+		limit.MaxConcurrency = int64(maxConcurrency)
+	}
+	if queueSize, ok := limitMap["queue_size"].(float64); ok && queueSize >= 0 {
+		limit.QueueSize = int64(queueSize)
+	}
+	if queueTimeout, ok := limitMap["queue_timeout"].(float64); ok && queueTimeout >= 0 {
+		limit.QueueTimeout = int64(queueTimeout)
+	}
+	if retryAfter, ok := limitMap["retry_after"].(float64); ok && retryAfter >= 0 {
+		limit.RetryAfter = int64(retryAfter)
+	}
+	if priorityPaths, ok := limitMap["priority_paths"].(string); ok {
+		limit.PriorityPaths = strings.TrimSpace(priorityPaths)
+	}
+	if errorPage, ok := limitMap["error_page"].(string); ok {
+		limit.ErrorPage = errorPage
+	}
+	var err error
+	if limit.ID == 0 {
+		limit.ID, err = data.DAL.InsertConcurrencyLimit(limit)
+	} else {
+		err = data.DAL.UpdateConcurrencyLimit(limit)
+	}
+	if err != nil {
+		return err
+	}
+	app.ConcurrencyLimit = limit
+	return nil
+}
+
+// DeleteConcurrencyLimitByApp ...
+func DeleteConcurrencyLimitByApp(appID int64) {
+	appLimiters.Delete(appID)
+	err := data.DAL.DeleteConcurrencyLimitByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteConcurrencyLimitByAppID", err)
+	}
+}
+
+// DeleteDestinationLimiter is called when the destination is removed, the in-flight requests release the old limiter
+func DeleteDestinationLimiter(destID int64) {
+	destLimiters.Delete(destID)
+}
@@ -10,9 +10,26 @@ package backend
 import (
 	"janusec/models"
 	"net"
+	"strings"
 	"time"
 )
 
+// UnixSocketPrefix of destination which listens on Unix domain socket, such as unix:/run/php/php-fpm.sock
+const UnixSocketPrefix = "unix:"
+
+// GetDestinationAddr return the network and address for dialing the destination, tcp or unix
+func GetDestinationAddr(destination string) (network string, address string) {
+	if strings.HasPrefix(destination, UnixSocketPrefix) {
+		return "unix", strings.TrimPrefix(destination, UnixSocketPrefix)
+	}
+	return "tcp", destination
+}
+
+// IsUnixSocket return true if the destination is a Unix domain socket
+func IsUnixSocket(destination string) bool {
+	return strings.HasPrefix(destination, UnixSocketPrefix)
+}
+
 // InterfaceContainsDestinationID ...
 // destination example: [{"id":16,"route_type":1,"request_route":"/","backend_route":"/","destination":"127.0.0.1:8800","app_id":14,"node_id":0,"online":true,"check_time":0}]
 func InterfaceContainsDestinationID(destinations []interface{}, destID int64) bool {
@@ -27,18 +44,24 @@ func InterfaceContainsDestinationID(destinations []interface{}, destID int64) bo
 }
 
 // CheckOfflineDestinations check offline destinations and reset the online status
+// Reverse proxy destinations of applications with active health check enabled are handled by HealthCheckTick
 func CheckOfflineDestinations(nowTimeStamp int64) {
 	for _, app := range Apps {
+		healthCheckEnabled := IsHealthCheckEnabled(app)
 		for _, dest := range app.Destinations {
-			if dest.RouteType == models.ReverseProxyRoute && !dest.Online {
-				go func() {
-					conn, err := net.DialTimeout("tcp", dest.Destination, time.Second)
+			if dest.RouteType == models.StaticRoute || (dest.RouteType == models.ReverseProxyRoute && healthCheckEnabled) {
+				continue
+			}
+			if !dest.Online.Load() {
+				go func(dest *models.Destination) {
+					network, address := GetDestinationAddr(dest.Destination)
+					conn, err := net.DialTimeout(network, address, time.Second)
 					if err == nil {
 						defer conn.Close()
-						dest.Online = true
-						dest.CheckTime = nowTimeStamp
+						SetDestinationOnline(dest, true)
+						dest.CheckTime.Store(nowTimeStamp)
 					}
-				}()
+				}(dest)
 			}
 		}
 	}
@@ -40,16 +40,20 @@ func LoadDomains() {
 		pApp, _ := GetApplicationByID(dbDomain.AppID)
 		pCert, _ := SysCallGetCertByID(dbDomain.CertID)
 		domain := &models.Domain{
-			ID:       dbDomain.ID,
-			Name:     dbDomain.Name,
-			AppID:    dbDomain.AppID,
-			CertID:   dbDomain.CertID,
-			Redirect: dbDomain.Redirect,
-			Location: dbDomain.Location,
-			App:      pApp,
-			Cert:     pCert}
+			ID:           dbDomain.ID,
+			Name:         dbDomain.Name,
+			AppID:        dbDomain.AppID,
+			CertID:       dbDomain.CertID,
+			Redirect:     dbDomain.Redirect,
+			Location:     dbDomain.Location,
+			App:          pApp,
+			Cert:         pCert,
+			TLSProfileID: dbDomain.TLSProfileID}
+		domain.RedirectStatus = dbDomain.RedirectStatus
+		domain.PreservePath = dbDomain.PreservePath
+		domain.PreserveQuery = dbDomain.PreserveQuery
 		Domains = append(Domains, domain)
-		DomainsMap.Store(domain.Name, models.DomainRelation{App: pApp, Cert: pCert, Redirect: dbDomain.Redirect, Location: dbDomain.Location})
+		DomainsMap.Store(domain.Name, models.DomainRelation{App: pApp, Cert: pCert, Redirect: dbDomain.Redirect, Location: dbDomain.Location, TLSProfileID: dbDomain.TLSProfileID})
 	}
 }
 
@@ -81,16 +85,31 @@ func UpdateDomain(app *models.Application, domainMapInterface interface{}) *mode
 	certID := int64(domainMap["cert_id"].(float64))
 	redirect := domainMap["redirect"].(bool)
 	location := domainMap["location"].(string)
+	var tlsProfileID int64
+	if tlsProfileIDF, ok := domainMap["tls_profile_id"].(float64); ok {
+		tlsProfileID = int64(tlsProfileIDF)
+	}
+	// The legacy redirect is 301 with query string
+	redirectStatus, preservePath, preserveQuery := int64(301), false, true
+	if redirectStatusF, ok := domainMap["redirect_status"].(float64); ok && IsRedirectStatus(int64(redirectStatusF)) {
+		redirectStatus = int64(redirectStatusF)
+	}
+	if preservePathB, ok := domainMap["preserve_path"].(bool); ok {
+		preservePath = preservePathB
+	}
+	if preserveQueryB, ok := domainMap["preserve_query"].(bool); ok {
+		preserveQuery = preserveQueryB
+	}
 	pCert, _ := SysCallGetCertByID(certID)
 	domain := GetDomainByID(domainID)
 	if domainID == 0 {
 		// New domain
-		newDomainID := data.DAL.InsertDomain(domainName, app.ID, certID, redirect, location)
+		newDomainID := data.DAL.InsertDomain(domainName, app.ID, certID, redirect, location, tlsProfileID, redirectStatus, preservePath, preserveQuery)
 		domain = &models.Domain{}
 		domain.ID = newDomainID
 		Domains = append(Domains, domain)
 	} else {
-		err := data.DAL.UpdateDomain(domainName, app.ID, certID, redirect, location, domain.ID)
+		err := data.DAL.UpdateDomain(domainName, app.ID, certID, redirect, location, tlsProfileID, redirectStatus, preservePath, preserveQuery, domain.ID)
 		if err != nil {
 			utils.DebugPrintln("UpdateDomain", err)
 		}
@@ -102,7 +121,11 @@ func UpdateDomain(app *models.Application, domainMapInterface interface{}) *mode
 	domain.Location = location
 	domain.App = app
 	domain.Cert = pCert
-	DomainsMap.Store(domainName, models.DomainRelation{App: app, Cert: pCert, Redirect: redirect, Location: location})
+	domain.TLSProfileID = tlsProfileID
+	domain.RedirectStatus = redirectStatus
+	domain.PreservePath = preservePath
+	domain.PreserveQuery = preserveQuery
+	DomainsMap.Store(domainName, models.DomainRelation{App: app, Cert: pCert, Redirect: redirect, Location: location, TLSProfileID: tlsProfileID})
 	return domain
 }
 
@@ -0,0 +1,274 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-30 20:18:55
+ * @Last Modified: U2, 2021-05-30 20:18:55
+ */
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"janusec/models"
+	"janusec/utils"
+)
+
+const (
+	// inheritListenersEnv is the list of network:address of inherited listeners, the fd starts from 3
+	inheritListenersEnv = "JANUSEC_INHERIT_LISTENERS"
+	// inheritParentEnv is the pid of old process which will be notified to shutdown
+	inheritParentEnv = "JANUSEC_PARENT_PID"
+)
+
+var (
+	listenersMutex sync.Mutex
+
+	// listeners map[network:address string]filer, all listening sockets which can be handed over to new process
+	listeners = map[string]filer{}
+
+	// inheritedFiles map[network:address string]*os.File, received from old process
+	inheritedFiles = map[string]*os.File{}
+
+	// activeVipConns is the count of TCP streams of port forwarding
+	activeVipConns int64
+
+	// handingOver is 1 when the new process is running, so the old process should not notify systemd
+	handingOver int32
+)
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// registeredListener remove itself from listeners when closed
+type registeredListener struct {
+	net.Listener
+	key string
+}
+
+// Close implements net.Listener
+func (l *registeredListener) Close() error {
+	listenersMutex.Lock()
+	delete(listeners, l.key)
+	listenersMutex.Unlock()
+	return l.Listener.Close()
+}
+
+// InitInheritedListeners load the listeners handed over by the old process, called before any listen
+func InitInheritedListeners() {
+	keys := os.Getenv(inheritListenersEnv)
+	if len(keys) == 0 {
+		return
+	}
+	for i, key := range strings.Split(keys, ",") {
+		inheritedFiles[key] = os.NewFile(uintptr(3+i), key)
+	}
+	utils.DebugPrintln("Inherited listeners", keys)
+}
+
+// getInheritedFile return nil if not found
+func getInheritedFile(key string) *os.File {
+	file, ok := inheritedFiles[key]
+	if ok {
+		delete(inheritedFiles, key)
+	}
+	return file
+}
+
+// Listen announce on the TCP address, or use the listener inherited from old process
+func Listen(network string, address string) (net.Listener, error) {
+	key := network + ":" + address
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	var listener net.Listener
+	var err error
+	if file := getInheritedFile(key); file != nil {
+		listener, err = net.FileListener(file)
+		file.Close()
+		if err != nil {
+			utils.DebugPrintln("Listen inherited", key, err)
+		}
+	}
+	if listener == nil {
+		listener, err = net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listenerFiler, ok := listener.(filer)
+	if !ok {
+		return listener, nil
+	}
+	listeners[key] = listenerFiler
+	return &registeredListener{Listener: listener, key: key}, nil
+}
+
+// ListenUDP announce on the UDP address, or use the socket inherited from old process, close it with CloseUDP
+func ListenUDP(address string) (*net.UDPConn, error) {
+	key := "udp:" + address
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	if file := getInheritedFile(key); file != nil {
+		packetConn, err := net.FilePacketConn(file)
+		file.Close()
+		if udpConn, ok := packetConn.(*net.UDPConn); ok && err == nil {
+			listeners[key] = udpConn
+			return udpConn, nil
+		}
+		utils.DebugPrintln("ListenUDP inherited", key, err)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	listeners[key] = udpConn
+	return udpConn, nil
+}
+
+// CloseUDP close the socket and remove it from listeners
+func CloseUDP(address string, udpConn *net.UDPConn) error {
+	listenersMutex.Lock()
+	delete(listeners, "udp:"+address)
+	listenersMutex.Unlock()
+	return udpConn.Close()
+}
+
+// closeInheritedFiles close the inherited listeners which are not used by current configuration
+func closeInheritedFiles() {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	for key, file := range inheritedFiles {
+		utils.DebugPrintln("Close unused inherited listener", key)
+		file.Close()
+		delete(inheritedFiles, key)
+	}
+}
+
+// StartNewProcess start the executable (may be upgraded) with all listeners, the old process keeps serving
+// until the new process is ready and sends SIGTERM to it
+func StartNewProcess() error {
+	listenersMutex.Lock()
+	keys := []string{}
+	files := []*os.File{}
+	for key, listener := range listeners {
+		file, err := listener.File()
+		if err != nil {
+			utils.DebugPrintln("StartNewProcess File", key, err)
+			continue
+		}
+		keys = append(keys, key)
+		files = append(files, file)
+	}
+	listenersMutex.Unlock()
+	defer func() {
+		// The new process has its own copy
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	if len(files) == 0 {
+		return errors.New("no listener to hand over")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	env := []string{}
+	for _, item := range os.Environ() {
+		if !strings.HasPrefix(item, inheritListenersEnv+"=") && !strings.HasPrefix(item, inheritParentEnv+"=") {
+			env = append(env, item)
+		}
+	}
+	env = append(env, inheritListenersEnv+"="+strings.Join(keys, ","), inheritParentEnv+"="+strconv.Itoa(os.Getpid()))
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	utils.DebugPrintln("StartNewProcess pid", cmd.Process.Pid, executable)
+	atomic.StoreInt32(&handingOver, 1)
+	go func() {
+		// Reap the new process if it fails before taking over
+		err := cmd.Wait()
+		atomic.StoreInt32(&handingOver, 0)
+		utils.DebugPrintln("New process", cmd.Process.Pid, "exited", err)
+	}()
+	return nil
+}
+
+// NotifyReady tell systemd (Type=notify) the main pid, and tell the old process to shutdown after handover
+func NotifyReady() {
+	notifySystemd(fmt.Sprintf("MAINPID=%d\nREADY=1", os.Getpid()))
+	ppid, err := strconv.Atoi(os.Getenv(inheritParentEnv))
+	if err == nil && ppid == os.Getppid() {
+		utils.DebugPrintln("NotifyReady shutdown old process", ppid)
+		if err = syscall.Kill(ppid, syscall.SIGTERM); err != nil {
+			utils.DebugPrintln("NotifyReady Kill", ppid, err)
+		}
+	}
+	// Listeners of removed port forwarding are not claimed
+	time.AfterFunc(10*time.Second, closeInheritedFiles)
+}
+
+// NotifyStopping tell systemd the service is stopping, except that the new process has taken over
+func NotifyStopping() {
+	if atomic.LoadInt32(&handingOver) == 0 {
+		notifySystemd("STOPPING=1")
+	}
+}
+
+func notifySystemd(state string) {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if len(socketAddr) == 0 {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddr, Net: "unixgram"})
+	if err != nil {
+		utils.DebugPrintln("notifySystemd", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		utils.DebugPrintln("notifySystemd Write", err)
+	}
+}
+
+// ActiveVipConns return the count of TCP streams of port forwarding, used for draining
+func ActiveVipConns() int64 {
+	return atomic.LoadInt64(&activeVipConns)
+}
+
+// StopVipApps close the listeners of port forwarding, the established streams are not interrupted
+func StopVipApps() {
+	for _, vipApp := range VipApps {
+		stopVipApp(vipApp)
+	}
+}
+
+// stopVipApp send exit signal to ListenOnVIP if it is waiting
+func stopVipApp(vipApp *models.VipApp) {
+	select {
+	case vipApp.ExitChan <- true:
+	case <-time.After(time.Second):
+		utils.DebugPrintln("stopVipApp timeout", vipApp.Name)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-26 21:40:07
+ * @Last Modified: U2, 2021-05-26 21:40:07
+ */
+
+package backend
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// LoadHeaderRules load header rules of all applications, primary node only
+func LoadHeaderRules() {
+	for _, app := range Apps {
+		app.HeaderRules = data.DAL.SelectHeaderRulesByAppID(app.ID)
+	}
+}
+
+// UpdateHeaderRules update the ordered header rules of the application, the priority is the index in list
+// header rule example: [{"id":0,"direction":2,"action":1,"name":"X-Frame-Options","value":"SAMEORIGIN","pattern":"","path_prefix":"/","status_codes":""}]
+func UpdateHeaderRules(app *models.Application, headerRules []interface{}) {
+	for _, headerRule := range app.HeaderRules {
+		// delete outdated header rules from DB
+		if !InterfaceContainsDestinationID(headerRules, headerRule.ID) {
+			err := data.DAL.DeleteHeaderRuleByID(headerRule.ID)
+			if err != nil {
+				utils.DebugPrintln("DeleteHeaderRuleByID", err)
+			}
+		}
+	}
+	newHeaderRules := []*models.HeaderRule{}
+	for i, headerRuleInterface := range headerRules {
+		headerRuleMap := headerRuleInterface.(map[string]interface{})
+		headerRule := &models.HeaderRule{
+			ID:        int64(headerRuleMap["id"].(float64)),
+			AppID:     app.ID,
+			Priority:  int64(i),
+			Direction: models.HeaderDirection(headerRuleMap["direction"].(float64)),
+			Action:    models.HeaderAction(headerRuleMap["action"].(float64)),
+			Name:      http.CanonicalHeaderKey(strings.TrimSpace(headerRuleMap["name"].(string))),
+		}
+		if value, ok := headerRuleMap["value"].(string); ok {
+			headerRule.Value = value
+		}
+		if pattern, ok := headerRuleMap["pattern"].(string); ok {
+			headerRule.Pattern = pattern
+		}
+		if pathPrefix, ok := headerRuleMap["path_prefix"].(string); ok {
+			headerRule.PathPrefix = strings.TrimSpace(pathPrefix)
+		}
+		if statusCodes, ok := headerRuleMap["status_codes"].(string); ok {
+			headerRule.StatusCodes = strings.TrimSpace(statusCodes)
+		}
+		if len(headerRule.Name) == 0 {
+			continue
+		}
+		if headerRule.Action == models.HeaderReplace {
+			if _, err := regexp.Compile(headerRule.Pattern); err != nil {
+				utils.DebugPrintln("UpdateHeaderRules invalid pattern", headerRule.Pattern, err)
+				continue
+			}
+		}
+		var err error
+		if headerRule.ID == 0 {
+			headerRule.ID, err = data.DAL.InsertHeaderRule(headerRule)
+			if err != nil {
+				utils.DebugPrintln("InsertHeaderRule", err)
+			}
+		} else {
+			err = data.DAL.UpdateHeaderRule(headerRule)
+			if err != nil {
+				utils.DebugPrintln("UpdateHeaderRule", err)
+			}
+		}
+		newHeaderRules = append(newHeaderRules, headerRule)
+	}
+	app.HeaderRules = newHeaderRules
+}
+
+// DeleteHeaderRulesByApp ...
+func DeleteHeaderRulesByApp(appID int64) {
+	err := data.DAL.DeleteHeaderRulesByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteHeaderRulesByAppID", err)
+	}
+}
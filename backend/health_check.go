@@ -0,0 +1,262 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-20 21:05:43
+ * @Last Modified: U2, 2021-05-20 21:05:43
+ */
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// LoadHealthChecks load health check settings of all applications, primary node only
+func LoadHealthChecks() {
+	for _, app := range Apps {
+		app.HealthCheck = data.DAL.SelectHealthCheckByAppID(app.ID)
+	}
+}
+
+// IsHealthCheckEnabled ...
+func IsHealthCheckEnabled(app *models.Application) bool {
+	return app.HealthCheck != nil && app.HealthCheck.Enabled
+}
+
+// UpdateHealthCheck update the health check setting of the application
+// health check example: {"id":0,"enabled":true,"path":"/health","expected_status":"200","body_regex":"","interval":10,"timeout":3,"rise":2,"fall":3}
+func UpdateHealthCheck(app *models.Application, healthCheckMap map[string]interface{}) error {
+	healthCheck := &models.HealthCheck{
+		AppID:    app.ID,
+		Path:     "/",
+		Interval: 10,
+		Timeout:  3,
+		Rise:     2,
+		Fall:     3,
+	}
+	if app.HealthCheck != nil {
+		healthCheck.ID = app.HealthCheck.ID
+	}
+	if enabled, ok := healthCheckMap["enabled"].(bool); ok {
+		healthCheck.Enabled = enabled
+	}
+	if path, ok := healthCheckMap["path"].(string); ok && len(strings.TrimSpace(path)) > 0 {
+		healthCheck.Path = strings.TrimSpace(path)
+	}
+	if !strings.HasPrefix(healthCheck.Path, "/") {
+		return errors.New("health check path must begin with /")
+	}
+	if expectedStatus, ok := healthCheckMap["expected_status"].(string); ok {
+		healthCheck.ExpectedStatus = strings.TrimSpace(expectedStatus)
+	}
+	if bodyRegex, ok := healthCheckMap["body_regex"].(string); ok {
+		healthCheck.BodyRegex = bodyRegex
+		if _, err := regexp.Compile(bodyRegex); err != nil {
+			return err
+		}
+	}
+	if interval, ok := healthCheckMap["interval"].(float64); ok && interval >= 1 {
+		healthCheck.Interval = int64(interval)
+	}
+	if timeout, ok := healthCheckMap["timeout"].(float64); ok && timeout >= 1 {
+		healthCheck.Timeout = int64(timeout)
+	}
+	if rise, ok := healthCheckMap["rise"].(float64); ok && rise >= 1 {
+		healthCheck.Rise = int64(rise)
+	}
+	if fall, ok := healthCheckMap["fall"].(float64); ok && fall >= 1 {
+		healthCheck.Fall = int64(fall)
+	}
+	var err error
+	if healthCheck.ID == 0 {
+		healthCheck.ID, err = data.DAL.InsertHealthCheck(healthCheck)
+	} else {
+		err = data.DAL.UpdateHealthCheck(healthCheck)
+	}
+	if err != nil {
+		return err
+	}
+	app.HealthCheck = healthCheck
+	return nil
+}
+
+// DeleteHealthCheckByApp ...
+func DeleteHealthCheckByApp(appID int64) {
+	err := data.DAL.DeleteHealthCheckByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteHealthCheckByAppID", err)
+	}
+}
+
+// HealthCheckTick probe destinations of applications at the interval of each application, both primary and replica nodes
+func HealthCheckTick() {
+	healthCheckTicker := time.NewTicker(time.Second)
+	for range healthCheckTicker.C {
+		now := time.Now().Unix()
+		for _, app := range Apps {
+			healthCheck := app.HealthCheck
+			if healthCheck == nil || !healthCheck.Enabled {
+				continue
+			}
+			if now-healthCheck.LastCheckTime < healthCheck.Interval {
+				continue
+			}
+			healthCheck.LastCheckTime = now
+			for _, dest := range app.Destinations {
+				if dest.RouteType == models.ReverseProxyRoute {
+					go CheckDestinationHealth(app, healthCheck, dest)
+				}
+			}
+		}
+	}
+}
+
+// CheckDestinationHealth send a probe request to the destination and update its online status by rise and fall
+func CheckDestinationHealth(app *models.Application, healthCheck *models.HealthCheck, dest *models.Destination) {
+	healthy, result := probeDestination(app, healthCheck, dest)
+	dest.CheckTime.Store(time.Now().Unix())
+	dest.CheckResult.Store(result)
+	if healthy {
+		atomic.StoreInt64(&dest.HealthFailures, 0)
+		successes := atomic.AddInt64(&dest.HealthSuccesses, 1)
+		if !dest.Online.Load() && successes >= healthCheck.Rise && SetDestinationOnline(dest, true) {
+			utils.DebugPrintln("HealthCheck", dest.Destination, "online", result)
+		}
+		return
+	}
+	atomic.StoreInt64(&dest.HealthSuccesses, 0)
+	failures := atomic.AddInt64(&dest.HealthFailures, 1)
+	if dest.Online.Load() && failures >= healthCheck.Fall && SetDestinationOnline(dest, false) {
+		utils.DebugPrintln("HealthCheck", dest.Destination, "offline", result)
+		SendOfflineNotification(app, dest.Destination, "health check failed")
+	}
+}
+
+// SetDestinationOnline update the online status by health checks and dial errors, return true if it changed,
+// the consecutive probe results are reset when changed, so that rise and fall always start over
+func SetDestinationOnline(dest *models.Destination, online bool) bool {
+	if !dest.Online.Store(online) {
+		return false
+	}
+	atomic.StoreInt64(&dest.HealthSuccesses, 0)
+	atomic.StoreInt64(&dest.HealthFailures, 0)
+	return true
+}
+
+type healthProbeKey struct{}
+
+// isHealthProbe return true if the request is sent by health check, its dial errors are counted by fall only
+func isHealthProbe(ctx context.Context) bool {
+	probe, _ := ctx.Value(healthProbeKey{}).(bool)
+	return probe
+}
+
+func probeDestination(app *models.Application, healthCheck *models.HealthCheck, dest *models.Destination) (bool, string) {
+	client := &http.Client{
+		Transport: GetTransport(app, dest),
+		Timeout:   time.Duration(healthCheck.Timeout) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	// use the domain of application as Host and SNI, so that virtual hosts and upstream TLS verification can work,
+	// the transport always dials to the destination
+	host := dest.Destination
+	if IsUnixSocket(host) {
+		host = "localhost"
+	}
+	if len(app.Domains) > 0 {
+		host = app.Domains[0].Name
+	}
+	ctx := context.WithValue(context.Background(), healthProbeKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, "GET", app.InternalScheme+"://"+host+healthCheck.Path, nil)
+	if err != nil {
+		return false, err.Error()
+	}
+	req.Header.Set("User-Agent", "Janusec-Health-Check/"+data.Version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err.Error()
+	}
+	defer resp.Body.Close()
+	result := resp.Status
+	if !IsExpectedStatus(healthCheck.ExpectedStatus, resp.StatusCode) {
+		return false, result
+	}
+	if len(healthCheck.BodyRegex) > 0 {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 65536))
+		if err != nil {
+			return false, err.Error()
+		}
+		matched, err := regexp.Match(healthCheck.BodyRegex, body)
+		if err != nil {
+			return false, err.Error()
+		}
+		if !matched {
+			return false, result + ", body mismatch"
+		}
+	}
+	return true, result
+}
+
+// IsExpectedStatus check status code with expected status such as "200,301-302", empty means 200-399
+func IsExpectedStatus(expectedStatus string, statusCode int) bool {
+	if len(expectedStatus) == 0 {
+		return statusCode >= 200 && statusCode < 400
+	}
+	for _, item := range strings.Split(expectedStatus, ",") {
+		item = strings.TrimSpace(item)
+		if strings.Contains(item, "-") {
+			codeRange := strings.SplitN(item, "-", 2)
+			low, err1 := strconv.Atoi(strings.TrimSpace(codeRange[0]))
+			high, err2 := strconv.Atoi(strings.TrimSpace(codeRange[1]))
+			if err1 == nil && err2 == nil && statusCode >= low && statusCode <= high {
+				return true
+			}
+			continue
+		}
+		if code, err := strconv.Atoi(item); err == nil && code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// SendOfflineNotification send email to administrators if SMTP is enabled, the reason is optional
+func SendOfflineNotification(app *models.Application, dest string, reason string) {
+	if !data.NodeSetting.SMTP.SMTPEnabled {
+		return
+	}
+	var emails string
+	if data.IsPrimary {
+		emails = data.DAL.GetAppAdminAndOwnerEmails(app.Owner)
+	} else {
+		emails = data.NodeSetting.SMTP.AdminEmails
+	}
+	mailBody := "Backend server: " + dest + " (" + app.Name + ") was offline."
+	if len(reason) > 0 {
+		mailBody = "Backend server: " + dest + " (" + app.Name + ") was offline, " + reason + "."
+	}
+	if len(emails) > 0 {
+		go utils.SendEmail(data.NodeSetting.SMTP.SMTPServer,
+			data.NodeSetting.SMTP.SMTPPort,
+			data.NodeSetting.SMTP.SMTPAccount,
+			data.NodeSetting.SMTP.SMTPPassword,
+			emails,
+			"[JANUSEC] Backend server offline notification",
+			mailBody)
+	}
+}
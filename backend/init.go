@@ -44,6 +44,79 @@ func InitDatabase() {
 	if err != nil {
 		utils.DebugPrintln("InitDatabase vip_targets", err)
 	}
+	// v1.2.4 load balancing of routes
+	err = dal.CreateTableIfNotExistsRoutePolicies()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase route_policies", err)
+	}
+	// v1.2.4 active health check of destinations
+	err = dal.CreateTableIfNotExistsHealthChecks()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase health_checks", err)
+	}
+	// v1.2.4 header rewrite rules
+	err = dal.CreateTableIfNotExistsHeaderRules()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase header_rules", err)
+	}
+	// v1.2.4 URL rewrite and redirect rules
+	err = dal.CreateTableIfNotExistsRewriteRules()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase rewrite_rules", err)
+	}
+	// v1.2.4 micro-caching rules of dynamic responses
+	err = dal.CreateTableIfNotExistsCacheRules()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase cache_rules", err)
+	}
+	// v1.2.4 traffic splitting between destination groups
+	err = dal.CreateTableIfNotExistsTrafficRules()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase traffic_rules", err)
+	}
+	// v1.2.4 response compression
+	err = dal.CreateTableIfNotExistsCompressions()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase compressions", err)
+	}
+	// v1.2.4 mutual TLS
+	err = dal.CreateTableIfNotExistsCACerts()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase ca_certs", err)
+	}
+	err = dal.CreateTableIfNotExistsClientAuths()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase client_auths", err)
+	}
+	err = dal.CreateTableIfNotExistsClientCertRules()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase client_cert_rules", err)
+	}
+	// v1.2.4 verification of HTTPS backends
+	err = dal.CreateTableIfNotExistsUpstreamTLS()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase upstream_tls", err)
+	}
+	// v1.2.4 concurrency limits and request queueing
+	err = dal.CreateTableIfNotExistsConcurrencyLimits()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase concurrency_limits", err)
+	}
+	// v1.2.4 waiting rooms
+	err = dal.CreateTableIfNotExistsWaitingRooms()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase waiting_rooms", err)
+	}
+	// v1.2.4 TLS profiles of domains
+	err = dal.CreateTableIfNotExistsTLSProfiles()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase tls_profiles", err)
+	}
+	// v1.2.4 purges of shared cache, replayed by replica nodes
+	err = dal.CreateTableIfNotExistsCachePurges()
+	if err != nil {
+		utils.DebugPrintln("InitDatabase cache_purges", err)
+	}
 	err = dal.CreateTableIfNotExistsSettings()
 	if err != nil {
 		utils.DebugPrintln("InitDatabase settings", err)
@@ -131,16 +204,118 @@ func InitDatabase() {
 			utils.DebugPrintln("InitDatabase ALTER TABLE applications add shield_enabled", err)
 		}
 	}
+
+	// v1.2.4 add weight to destinations and vip_targets, lb_method to vip_apps
+	if !dal.ExistColumnInTable("destinations", "weight") {
+		err = dal.ExecSQL(`ALTER TABLE "destinations" ADD COLUMN "weight" bigint default 1`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE destinations add weight", err)
+		}
+	}
+	if !dal.ExistColumnInTable("vip_targets", "weight") {
+		err = dal.ExecSQL(`ALTER TABLE "vip_targets" ADD COLUMN "weight" bigint default 1`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE vip_targets add weight", err)
+		}
+	}
+	if !dal.ExistColumnInTable("vip_apps", "lb_method") {
+		err = dal.ExecSQL(`ALTER TABLE "vip_apps" ADD COLUMN "lb_method" bigint default 1`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE vip_apps add lb_method", err)
+		}
+	}
+	// v1.2.4 add PROXY protocol to destinations and vip_apps
+	if !dal.ExistColumnInTable("destinations", "proxy_protocol") {
+		err = dal.ExecSQL(`ALTER TABLE "destinations" ADD COLUMN "proxy_protocol" bigint default 0`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE destinations add proxy_protocol", err)
+		}
+	}
+	if !dal.ExistColumnInTable("vip_apps", "accept_proxy_protocol") {
+		err = dal.ExecSQL(`ALTER TABLE "vip_apps" ADD COLUMN "accept_proxy_protocol" boolean default false, ADD COLUMN "send_proxy_protocol" bigint default 0`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE vip_apps add proxy_protocol", err)
+		}
+	}
+	// v1.2.4 add trusted_proxies to applications
+	if !dal.ExistColumnInTable("applications", "trusted_proxies") {
+		err = dal.ExecSQL(`ALTER TABLE "applications" ADD COLUMN "trusted_proxies" VARCHAR(1024) NOT NULL DEFAULT ''`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE applications add trusted_proxies", err)
+		}
+	}
+	// v1.2.4 add tls_profile_id to domains
+	if !dal.ExistColumnInTable("domains", "tls_profile_id") {
+		err = dal.ExecSQL(`ALTER TABLE "domains" ADD COLUMN "tls_profile_id" bigint default 0`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE domains add tls_profile_id", err)
+		}
+	}
+	// v1.2.4 add redirect settings to domains, the legacy redirect is 301 with query string
+	if !dal.ExistColumnInTable("domains", "redirect_status") {
+		err = dal.ExecSQL(`ALTER TABLE "domains" ADD COLUMN "redirect_status" bigint default 301, ADD COLUMN "preserve_path" boolean default false, ADD COLUMN "preserve_query" boolean default true`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE domains add redirect settings", err)
+		}
+	}
+	// v1.2.4 add max_body_size to applications
+	if !dal.ExistColumnInTable("applications", "max_body_size") {
+		err = dal.ExecSQL(`ALTER TABLE "applications" ADD COLUMN "max_body_size" bigint default 0`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE applications add max_body_size", err)
+		}
+	}
+	// v1.2.4 add cache_max_size to applications
+	if !dal.ExistColumnInTable("applications", "cache_max_size") {
+		err = dal.ExecSQL(`ALTER TABLE "applications" ADD COLUMN "cache_max_size" bigint default 0`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE applications add cache_max_size", err)
+		}
+	}
+	// v1.2.4 add group_name to destinations
+	if !dal.ExistColumnInTable("destinations", "group_name") {
+		err = dal.ExecSQL(`ALTER TABLE "destinations" ADD COLUMN "group_name" VARCHAR(64) NOT NULL DEFAULT ''`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE destinations add group_name", err)
+		}
+	}
+	// v1.2.4 add request mirroring to route_policies
+	if !dal.ExistColumnInTable("route_policies", "mirror_enabled") {
+		err = dal.ExecSQL(`ALTER TABLE "route_policies" ADD COLUMN "mirror_enabled" boolean default false, ADD COLUMN "mirror_destination" VARCHAR(128) NOT NULL DEFAULT '', ADD COLUMN "mirror_percent" bigint default 100, ADD COLUMN "mirror_max_body_size" bigint default 0, ADD COLUMN "mirror_max_concurrency" bigint default 0`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE route_policies add mirror", err)
+		}
+	}
+	// v1.2.4 add max_concurrency to destinations
+	if !dal.ExistColumnInTable("destinations", "max_concurrency") {
+		err = dal.ExecSQL(`ALTER TABLE "destinations" ADD COLUMN "max_concurrency" bigint default 0`)
+		if err != nil {
+			utils.DebugPrintln("InitDatabase ALTER TABLE destinations add max_concurrency", err)
+		}
+	}
 }
 
 // LoadAppConfiguration ...
 func LoadAppConfiguration() {
 	utils.DebugPrintln("LoadAppConfiguration")
 	LoadCerts()
+	LoadCACerts()
+	LoadTLSProfiles()
 	LoadApps()
 	LoadVipApps()
 	if data.IsPrimary {
 		LoadDestinations()
+		LoadRoutePolicies()
+		LoadHealthChecks()
+		LoadHeaderRules()
+		LoadRewriteRules()
+		LoadCacheRules()
+		LoadTrafficRules()
+		LoadCompressions()
+		LoadClientAuths()
+		LoadUpstreamTLS()
+		LoadConcurrencyLimits()
+		LoadWaitingRooms()
 		LoadDomains()
 		LoadAppDomainNames()
 		LoadNodes()
@@ -148,4 +323,6 @@ func LoadAppConfiguration() {
 		LoadRoute()
 		LoadDomains()
 	}
+	ClearIPNetsCache()
+	LoadTransports()
 }
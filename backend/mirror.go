@@ -0,0 +1,283 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-06 20:12:57
+ * @Last Modified: U2, 2021-06-06 20:12:57
+ */
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"janusec/models"
+	"janusec/utils"
+)
+
+// mirrorTimeout is the timeout of mirrored requests, and the max time to wait for the primary response
+const mirrorTimeout = 30 * time.Second
+
+// defaultMirrorMaxBodySize is used if the mirror_max_body_size of route policy is 0
+const defaultMirrorMaxBodySize = 1024 * 1024
+
+// defaultMirrorMaxConcurrency is used if the mirror_max_concurrency of route policy is 0
+const defaultMirrorMaxConcurrency = 100
+
+// mirrorHopHeaders are not sent to the shadow destination
+var mirrorHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// mirrorTransport is the pooled transport to the shadow destination of a route policy
+type mirrorTransport struct {
+	destination string
+	upstreamTLS *models.UpstreamTLS
+	rootCAs     *x509.CertPool
+	transport   *http.Transport
+}
+
+// mirrorCounter is the statistics of a route policy, accessed atomically
+type mirrorCounter struct {
+	inFlight         int64
+	mirrored         int64
+	dropped          int64
+	errors           int64
+	compared         int64
+	statusMatched    int64
+	statusMismatched int64
+	// primaryLatency and shadowLatency are the sums of compared requests in milliseconds
+	primaryLatency int64
+	shadowLatency  int64
+}
+
+var (
+	// mirrorTransports map[routePolicyID int64]*mirrorTransport
+	mirrorTransports = sync.Map{}
+	// mirrorCounters map[routePolicyID int64]*mirrorCounter
+	mirrorCounters = sync.Map{}
+)
+
+// Mirror is a copy of request sent to the shadow destination, compared with the primary response when both are received
+type Mirror struct {
+	counter *mirrorCounter
+	start   time.Time
+	// primary receives the status code and latency of primary destination, buffered so that Done never blocks
+	primary chan mirrorResult
+}
+
+type mirrorResult struct {
+	statusCode int
+	latency    time.Duration
+}
+
+// MirrorRequest send a copy of the request to the shadow destination of route asynchronously, return nil if not mirrored.
+// The body is buffered up to the limit of route policy, and restored for the primary destination
+func MirrorRequest(app *models.Application, r *http.Request, dest *models.Destination) *Mirror {
+	if dest.RouteType != models.ReverseProxyRoute {
+		return nil
+	}
+	routePolicy := GetRoutePolicy(app, dest.RequestRoute)
+	if routePolicy == nil || !routePolicy.MirrorEnabled || len(routePolicy.MirrorDestination) == 0 {
+		return nil
+	}
+	// WebSocket and other upgraded connections are not mirrored
+	if len(r.Header.Get("Upgrade")) > 0 {
+		return nil
+	}
+	if routePolicy.MirrorPercent < 100 && rand.Int63n(100) >= routePolicy.MirrorPercent {
+		return nil
+	}
+	counter := getMirrorCounter(routePolicy.ID)
+	maxConcurrency := routePolicy.MirrorMaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMirrorMaxConcurrency
+	}
+	if atomic.AddInt64(&counter.inFlight, 1) > maxConcurrency {
+		atomic.AddInt64(&counter.inFlight, -1)
+		atomic.AddInt64(&counter.dropped, 1)
+		return nil
+	}
+	body, ok := bufferMirrorBody(r, routePolicy)
+	if !ok {
+		atomic.AddInt64(&counter.inFlight, -1)
+		atomic.AddInt64(&counter.dropped, 1)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	req := r.Clone(ctx)
+	req.RequestURI = ""
+	req.Body = nil
+	req.ContentLength = 0
+	if len(body) > 0 {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	for _, name := range mirrorHopHeaders {
+		req.Header.Del(name)
+	}
+	// Same as httputil.ReverseProxy does for the primary destination
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := req.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		req.Header.Set("X-Forwarded-For", clientIP)
+	}
+	mirror := &Mirror{counter: counter, start: time.Now(), primary: make(chan mirrorResult, 1)}
+	transport := getMirrorTransport(app, routePolicy)
+	go func() {
+		defer cancel()
+		defer atomic.AddInt64(&counter.inFlight, -1)
+		mirror.send(transport, req)
+	}()
+	return mirror
+}
+
+// bufferMirrorBody read the body up to the limit, return false if it is too large or failed
+func bufferMirrorBody(r *http.Request, routePolicy *models.RoutePolicy) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	maxBodySize := routePolicy.MirrorMaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMirrorMaxBodySize
+	}
+	if r.ContentLength > maxBodySize {
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	// The primary destination reads the buffered part and the rest, including the error such as body too large
+	r.Body = &mirrorBody{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+	if err != nil || int64(len(body)) > maxBodySize {
+		return nil, false
+	}
+	return body, true
+}
+
+// mirrorBody read the buffered body and close the original one
+type mirrorBody struct {
+	io.Reader
+	io.Closer
+}
+
+// send the mirrored request, discard the response, then compare it with the primary response
+func (mirror *Mirror) send(transport *http.Transport, req *http.Request) {
+	counter := mirror.counter
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	latency := time.Since(start)
+	atomic.AddInt64(&counter.mirrored, 1)
+	if err != nil {
+		utils.DebugPrintln("Mirror", req.Method, req.URL.Path, err)
+		atomic.AddInt64(&counter.errors, 1)
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	select {
+	case result := <-mirror.primary:
+		atomic.AddInt64(&counter.compared, 1)
+		if result.statusCode == resp.StatusCode {
+			atomic.AddInt64(&counter.statusMatched, 1)
+		} else {
+			atomic.AddInt64(&counter.statusMismatched, 1)
+		}
+		atomic.AddInt64(&counter.primaryLatency, int64(result.latency/time.Millisecond))
+		atomic.AddInt64(&counter.shadowLatency, int64(latency/time.Millisecond))
+	case <-req.Context().Done():
+	}
+}
+
+// Done report the status code of primary destination, such as 502 if it failed
+func (mirror *Mirror) Done(statusCode int) {
+	select {
+	case mirror.primary <- mirrorResult{statusCode: statusCode, latency: time.Since(mirror.start)}:
+	default:
+		// Reported already
+	}
+}
+
+// getMirrorTransport return the pooled transport of the shadow destination, create it if not exists or outdated
+func getMirrorTransport(app *models.Application, routePolicy *models.RoutePolicy) *http.Transport {
+	rootCAs := getUpstreamRootCAs(app.UpstreamTLS)
+	if mtI, ok := mirrorTransports.Load(routePolicy.ID); ok {
+		mt := mtI.(*mirrorTransport)
+		if mt.destination == routePolicy.MirrorDestination && mt.upstreamTLS == app.UpstreamTLS && mt.rootCAs == rootCAs {
+			return mt.transport
+		}
+	}
+	shadowDest := &models.Destination{
+		RouteType:   models.ReverseProxyRoute,
+		Destination: routePolicy.MirrorDestination,
+		AppID:       app.ID,
+		Online:      models.NewOnlineStatus(true),
+	}
+	transport := NewTransport(shadowDest, app.UpstreamTLS, rootCAs)
+	oldI, loaded := mirrorTransports.Load(routePolicy.ID)
+	mirrorTransports.Store(routePolicy.ID, &mirrorTransport{
+		destination: routePolicy.MirrorDestination,
+		upstreamTLS: app.UpstreamTLS,
+		rootCAs:     rootCAs,
+		transport:   transport,
+	})
+	if loaded {
+		oldI.(*mirrorTransport).transport.CloseIdleConnections()
+	}
+	return transport
+}
+
+// getMirrorCounter return the statistics of route policy
+func getMirrorCounter(routePolicyID int64) *mirrorCounter {
+	if counter, ok := mirrorCounters.Load(routePolicyID); ok {
+		return counter.(*mirrorCounter)
+	}
+	counter, _ := mirrorCounters.LoadOrStore(routePolicyID, &mirrorCounter{})
+	return counter.(*mirrorCounter)
+}
+
+// GetMirrorStats return the mirroring statistics of route policies on current node
+func GetMirrorStats(authUser *models.AuthUser) ([]*models.MirrorStat, error) {
+	apps, err := GetApplications(authUser)
+	if err != nil {
+		return nil, err
+	}
+	mirrorStats := []*models.MirrorStat{}
+	for _, app := range apps {
+		for _, routePolicy := range app.RoutePolicies {
+			if !routePolicy.MirrorEnabled {
+				continue
+			}
+			counter := getMirrorCounter(routePolicy.ID)
+			mirrorStat := &models.MirrorStat{
+				AppID:             app.ID,
+				RoutePolicyID:     routePolicy.ID,
+				RequestRoute:      routePolicy.RequestRoute,
+				MirrorDestination: routePolicy.MirrorDestination,
+				InFlight:          atomic.LoadInt64(&counter.inFlight),
+				Mirrored:          atomic.LoadInt64(&counter.mirrored),
+				Dropped:           atomic.LoadInt64(&counter.dropped),
+				Errors:            atomic.LoadInt64(&counter.errors),
+				Compared:          atomic.LoadInt64(&counter.compared),
+				StatusMatched:     atomic.LoadInt64(&counter.statusMatched),
+				StatusMismatched:  atomic.LoadInt64(&counter.statusMismatched),
+			}
+			if mirrorStat.Compared > 0 {
+				mirrorStat.PrimaryLatency = atomic.LoadInt64(&counter.primaryLatency) / mirrorStat.Compared
+				mirrorStat.ShadowLatency = atomic.LoadInt64(&counter.shadowLatency) / mirrorStat.Compared
+			}
+			mirrorStats = append(mirrorStats, mirrorStat)
+		}
+	}
+	return mirrorStats, nil
+}
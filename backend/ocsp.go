@@ -0,0 +1,179 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-02 21:36:07
+ * @Last Modified: U2, 2021-06-02 21:36:07
+ */
+
+package backend
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"janusec/models"
+	"janusec/utils"
+)
+
+// ocspStaple is the cached OCSP response of a certificate, v1.2.4
+type ocspStaple struct {
+	// certContent is used for detecting the updated certificate
+	certContent string
+	response    []byte
+	nextUpdate  time.Time
+
+	// refreshTime is the time to fetch again, half of the validity period, or later after failure
+	refreshTime time.Time
+}
+
+// ocspStaples map[certID int64]*ocspStaple
+var ocspStaples = sync.Map{}
+
+// GetStapledCertificate return the certificate with the cached OCSP response if it is still valid
+func GetStapledCertificate(certItem *models.CertItem) *tls.Certificate {
+	stapleI, ok := ocspStaples.Load(certItem.ID)
+	if !ok {
+		return &certItem.TlsCert
+	}
+	staple := stapleI.(*ocspStaple)
+	if len(staple.response) == 0 || staple.certContent != certItem.CertContent || time.Now().After(staple.nextUpdate) {
+		return &certItem.TlsCert
+	}
+	cert := certItem.TlsCert
+	cert.OCSPStaple = staple.response
+	return &cert
+}
+
+// OCSPStaplingTick fetch OCSP responses in background for certificates of domains whose TLS profile enables stapling
+func OCSPStaplingTick() {
+	refreshOCSPStaples()
+	ocspTicker := time.NewTicker(5 * time.Minute)
+	for range ocspTicker.C {
+		refreshOCSPStaples()
+	}
+}
+
+func refreshOCSPStaples() {
+	certItems := map[int64]*models.CertItem{}
+	for _, domain := range Domains {
+		if domain.Cert == nil {
+			continue
+		}
+		tlsProfile, err := GetTLSProfileByID(domain.TLSProfileID)
+		if err != nil || !tlsProfile.OCSPStapling {
+			continue
+		}
+		certItems[domain.Cert.ID] = domain.Cert
+	}
+	now := time.Now()
+	for certID, certItem := range certItems {
+		var oldStaple *ocspStaple
+		if stapleI, ok := ocspStaples.Load(certID); ok {
+			oldStaple = stapleI.(*ocspStaple)
+			if oldStaple.certContent != certItem.CertContent {
+				oldStaple = nil
+			} else if now.Before(oldStaple.refreshTime) {
+				continue
+			}
+		}
+		staple, err := fetchOCSPStaple(certItem)
+		if err != nil {
+			utils.DebugPrintln("OCSP stapling", certItem.CommonName, err)
+			// Keep the previous response until it expires, and retry later
+			staple = &ocspStaple{certContent: certItem.CertContent, refreshTime: now.Add(time.Hour)}
+			if oldStaple != nil && now.Before(oldStaple.nextUpdate) {
+				staple.response = oldStaple.response
+				staple.nextUpdate = oldStaple.nextUpdate
+				staple.refreshTime = now.Add(10 * time.Minute)
+			}
+		}
+		ocspStaples.Store(certID, staple)
+	}
+	// Remove the responses of certificates which are not used
+	ocspStaples.Range(func(key, value interface{}) bool {
+		if _, ok := certItems[key.(int64)]; !ok {
+			ocspStaples.Delete(key)
+		}
+		return true
+	})
+}
+
+// fetchOCSPStaple request the OCSP responder of the certificate, the issuer must be the second certificate of the chain
+func fetchOCSPStaple(certItem *models.CertItem) (*ocspStaple, error) {
+	certs := []*x509.Certificate{}
+	rest := []byte(certItem.CertContent)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) < 2 {
+		return nil, errors.New("the issuer certificate is not found in the certificate chain")
+	}
+	leaf, issuer := certs[0], certs[1]
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("no OCSP server in the certificate")
+	}
+	reqBytes, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("OCSP server responded " + resp.Status)
+	}
+	respBytes, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	ocspResp, err := ocsp.ParseResponseForCert(respBytes, leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	switch ocspResp.Status {
+	case ocsp.Good:
+	case ocsp.Revoked:
+		return nil, errors.New("the certificate has been revoked at " + ocspResp.RevokedAt.Format(time.RFC3339))
+	default:
+		return nil, errors.New("the certificate status is unknown to the OCSP server")
+	}
+	now := time.Now()
+	staple := &ocspStaple{
+		certContent: certItem.CertContent,
+		response:    respBytes,
+		nextUpdate:  ocspResp.NextUpdate,
+		refreshTime: now.Add(time.Hour),
+	}
+	if ocspResp.NextUpdate.IsZero() {
+		// The responder always has fresh status, the response is used until next refresh
+		staple.nextUpdate = now.Add(2 * time.Hour)
+	} else if ocspResp.NextUpdate.After(ocspResp.ThisUpdate) {
+		staple.refreshTime = ocspResp.ThisUpdate.Add(ocspResp.NextUpdate.Sub(ocspResp.ThisUpdate) / 2)
+	}
+	return staple, nil
+}
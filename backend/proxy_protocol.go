@@ -0,0 +1,298 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-24 20:46:19
+ * @Last Modified: U2, 2021-05-24 20:46:19
+ */
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"janusec/data"
+	"janusec/utils"
+)
+
+const (
+	// ProxyProtocolNone do not send PROXY protocol header
+	ProxyProtocolNone int64 = 0
+	// ProxyProtocolV1 human-readable header
+	ProxyProtocolV1 int64 = 1
+	// ProxyProtocolV2 binary header
+	ProxyProtocolV2 int64 = 2
+)
+
+var (
+	proxyProtocolV1Prefix = []byte("PROXY ")
+	proxyProtocolV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	// proxyProtocolTimeout is the deadline for reading the header
+	proxyProtocolTimeout = 10 * time.Second
+)
+
+// ProxyProtocolListener parse the PROXY protocol v1/v2 header of connections from trusted sources
+type ProxyProtocolListener struct {
+	net.Listener
+}
+
+// NewProxyProtocolListener wrap the listener, the header is parsed in the goroutine of connection, not in Accept
+func NewProxyProtocolListener(listener net.Listener) net.Listener {
+	return &ProxyProtocolListener{Listener: listener}
+}
+
+// Accept implements net.Listener
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	return &proxyProtocolConn{Conn: conn}, nil
+}
+
+// proxyProtocolConn read the header at the first call of Read or RemoteAddr
+type proxyProtocolConn struct {
+	net.Conn
+	once       sync.Once
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	err        error
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		if !IsTrustedProxySource(c.Conn.RemoteAddr()) {
+			return
+		}
+		err := c.Conn.SetReadDeadline(time.Now().Add(proxyProtocolTimeout))
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.remoteAddr, c.localAddr, c.err = readProxyProtocolHeader(c.reader)
+		if c.err != nil {
+			utils.DebugPrintln("PROXY protocol from", c.Conn.RemoteAddr(), c.err)
+			return
+		}
+		c.err = c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Read implements net.Conn
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr return the source address in header if exists
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr return the destination address in header if exists
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	c.init()
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// IsTrustedProxySource check whether the peer is a trusted L4 balancer in config.json
+func IsTrustedProxySource(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, source := range data.GetConfig().ProxyProtocol.TrustedSources {
+		if !strings.Contains(source, "/") {
+			if ip := net.ParseIP(source); ip != nil && ip.Equal(tcpAddr.IP) {
+				return true
+			}
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(source)
+		if err == nil && ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// readProxyProtocolHeader return nil addresses if there is no header, or the command is LOCAL/UNKNOWN
+func readProxyProtocolHeader(reader *bufio.Reader) (remoteAddr net.Addr, localAddr net.Addr, err error) {
+	peek, err := reader.Peek(len(proxyProtocolV1Prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(peek, proxyProtocolV1Prefix) {
+		return readProxyProtocolV1(reader)
+	}
+	peek, err = reader.Peek(len(proxyProtocolV2Sig))
+	if err == nil && bytes.Equal(peek, proxyProtocolV2Sig) {
+		return readProxyProtocolV2(reader)
+	}
+	// No header
+	return nil, nil, nil
+}
+
+func readProxyProtocolV1(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	// The maximum length of v1 header is 107 bytes
+	line := make([]byte, 0, 107)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= 107 {
+			return nil, nil, errors.New("PROXY v1 header too long")
+		}
+	}
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errors.New("invalid PROXY v1 header")
+	}
+	srcIP := net.ParseIP(fields[2])
+	dstIP := net.ParseIP(fields[3])
+	srcPort, err1 := strconv.Atoi(fields[4])
+	dstPort, err2 := strconv.Atoi(fields[5])
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return nil, nil, errors.New("invalid PROXY v1 address")
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}, nil
+}
+
+func readProxyProtocolV2(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, errors.New("invalid PROXY v2 version")
+	}
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, nil, err
+	}
+	command := header[12] & 0x0F
+	if command == 0x00 {
+		// LOCAL, health check of balancer
+		return nil, nil, nil
+	}
+	switch header[13] {
+	case 0x11:
+		// TCP over IPv4
+		if length < 12 {
+			return nil, nil, errors.New("invalid PROXY v2 IPv4 length")
+		}
+		src := &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		dst := &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+		return src, dst, nil
+	case 0x21:
+		// TCP over IPv6
+		if length < 36 {
+			return nil, nil, errors.New("invalid PROXY v2 IPv6 length")
+		}
+		src := &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		dst := &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+		return src, dst, nil
+	}
+	// Unsupported family such as UDP or unix socket, keep the connection address
+	return nil, nil, nil
+}
+
+// WriteProxyProtocolHeader send the header to backend, write UNKNOWN/LOCAL if the addresses are not TCP
+func WriteProxyProtocolHeader(w io.Writer, version int64, srcAddr net.Addr, dstAddr net.Addr) error {
+	src, srcOK := srcAddr.(*net.TCPAddr)
+	dst, dstOK := dstAddr.(*net.TCPAddr)
+	isTCP := srcOK && dstOK && src != nil && dst != nil
+	isIPv4 := isTCP && src.IP.To4() != nil && dst.IP.To4() != nil
+	var header []byte
+	switch version {
+	case ProxyProtocolV1:
+		if !isTCP {
+			header = []byte("PROXY UNKNOWN\r\n")
+		} else if isIPv4 {
+			header = []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", src.IP.To4(), dst.IP.To4(), src.Port, dst.Port))
+		} else {
+			header = []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", src.IP.To16(), dst.IP.To16(), src.Port, dst.Port))
+		}
+	case ProxyProtocolV2:
+		header = append(header, proxyProtocolV2Sig...)
+		switch {
+		case !isTCP:
+			// LOCAL
+			header = append(header, 0x20, 0x00, 0x00, 0x00)
+		case isIPv4:
+			header = append(header, 0x21, 0x11, 0x00, 12)
+			header = append(header, src.IP.To4()...)
+			header = append(header, dst.IP.To4()...)
+			header = appendPort(header, src.Port, dst.Port)
+		default:
+			header = append(header, 0x21, 0x21, 0x00, 36)
+			header = append(header, src.IP.To16()...)
+			header = append(header, dst.IP.To16()...)
+			header = appendPort(header, src.Port, dst.Port)
+		}
+	default:
+		return nil
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+func appendPort(header []byte, srcPort int, dstPort int) []byte {
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(ports[2:4], uint16(dstPort))
+	return append(header, ports...)
+}
+
+type proxyProtocolAddrKey struct{}
+
+// proxyProtocolAddr is the client and local address of the inbound request
+type proxyProtocolAddr struct {
+	src net.Addr
+	dst net.Addr
+}
+
+// WithProxyProtocolAddr save the client address to context, used for sending PROXY protocol header to destination
+func WithProxyProtocolAddr(ctx context.Context, srcAddr net.Addr, dstAddr net.Addr) context.Context {
+	return context.WithValue(ctx, proxyProtocolAddrKey{}, &proxyProtocolAddr{src: srcAddr, dst: dstAddr})
+}
+
+// getProxyProtocolAddr return nil addresses if not found
+func getProxyProtocolAddr(ctx context.Context) (net.Addr, net.Addr) {
+	if addr, ok := ctx.Value(proxyProtocolAddrKey{}).(*proxyProtocolAddr); ok {
+		return addr.src, addr.dst
+	}
+	return nil, nil
+}
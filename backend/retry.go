@@ -0,0 +1,136 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-22 16:40:51
+ * @Last Modified: U2, 2021-05-22 16:40:51
+ */
+
+package backend
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// retryBudgetWindow in seconds
+const retryBudgetWindow = 10
+
+// retryBudget limit the retries of the node to a ratio of requests in current window
+var retryBudget struct {
+	windowStart int64
+	requests    int64
+	retries     int64
+}
+
+func resetRetryBudget(now int64) {
+	windowStart := atomic.LoadInt64(&retryBudget.windowStart)
+	if now-windowStart >= retryBudgetWindow && atomic.CompareAndSwapInt64(&retryBudget.windowStart, windowStart, now) {
+		atomic.StoreInt64(&retryBudget.requests, 0)
+		atomic.StoreInt64(&retryBudget.retries, 0)
+	}
+}
+
+// depositRetryBudget count a request
+func depositRetryBudget() {
+	resetRetryBudget(time.Now().Unix())
+	atomic.AddInt64(&retryBudget.requests, 1)
+}
+
+// withdrawRetryBudget return true if a retry is allowed
+func withdrawRetryBudget() bool {
+	cfg := data.GetConfig().BackendRetry
+	resetRetryBudget(time.Now().Unix())
+	allowed := float64(cfg.MinRetriesPerSecond*retryBudgetWindow) + cfg.BudgetRatio*float64(atomic.LoadInt64(&retryBudget.requests))
+	if float64(atomic.AddInt64(&retryBudget.retries, 1)) > allowed {
+		atomic.AddInt64(&retryBudget.retries, -1)
+		return false
+	}
+	return true
+}
+
+// ErrCircuitOpen is returned if the circuits of all destinations of the route are open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// RetryRoundTripper send the request to the selected destination,
+// and retry idempotent requests on other destinations of the same route when failed
+type RetryRoundTripper struct {
+	App   *models.Application
+	SrcIP string
+
+	// Dest is the selected destination, and replaced by the destination of last attempt
+	Dest *models.Destination
+
+	// OriginPath is the request path before replaced with the backend route
+	OriginPath string
+
+	// Ticket of concurrency limits, the slot of destination is acquired for each attempt, nil if not limited
+	Ticket *ConcurrencyTicket
+}
+
+// RoundTrip implements http.RoundTripper
+func (rt *RetryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	depositRetryBudget()
+	tried := map[int64]bool{}
+	dest := rt.Dest
+	for retries := int64(0); ; {
+		rt.Dest = dest
+		if !rt.Ticket.AcquireDestination(req.Context(), dest) {
+			return nil, ErrConcurrencyLimit
+		}
+		acquired, trial := AcquireCircuit(dest)
+		var resp *http.Response
+		var err error
+		if acquired {
+			resp, err = GetTransport(rt.App, dest).RoundTrip(req)
+			if errors.Is(err, ErrRequestBodyTooLarge) {
+				// Client error, not a failure of destination, the circuit state is not changed
+				ReleaseCircuit(dest, trial)
+				return nil, err
+			}
+			if err != nil && req.Context().Err() != nil {
+				// Cancelled by client, neither a failure of destination nor retried
+				ReleaseCircuit(dest, trial)
+				return nil, err
+			}
+			ReportCircuit(dest, resp, err)
+			if err == nil {
+				return resp, nil
+			}
+			if !IsRetryableRequest(req) || retries >= data.GetConfig().BackendRetry.MaxRetries || !withdrawRetryBudget() {
+				return nil, err
+			}
+			retries++
+		} else {
+			// Opened or taken by a trial request after selected, choose another one without sending
+			err = ErrCircuitOpen
+		}
+		tried[dest.ID] = true
+		retryReq := req.Clone(req.Context())
+		retryReq.URL.Path = rt.OriginPath
+		nextDest := selectBackendRoute(rt.App, retryReq, rt.SrcIP, tried)
+		if nextDest == nil {
+			return nil, err
+		}
+		utils.DebugPrintln("Retry", req.Method, req.URL.Path, "from", dest.Destination, "to", nextDest.Destination, err)
+		req = retryReq
+		dest = nextDest
+	}
+}
+
+// IsRetryableRequest return true for idempotent requests without body
+func IsRetryableRequest(req *http.Request) bool {
+	if !data.GetConfig().BackendRetry.Enabled {
+		return false
+	}
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
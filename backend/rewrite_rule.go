@@ -0,0 +1,101 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-28 21:25:36
+ * @Last Modified: U2, 2021-05-28 21:25:36
+ */
+
+package backend
+
+import (
+	"regexp"
+	"strings"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// LoadRewriteRules load rewrite rules of all applications, primary node only
+func LoadRewriteRules() {
+	for _, app := range Apps {
+		app.RewriteRules = data.DAL.SelectRewriteRulesByAppID(app.ID)
+	}
+}
+
+// UpdateRewriteRules update the ordered rewrite rules of the application, the priority is the index in list
+// rewrite rule example: [{"id":0,"domain":"","pattern":"^/old/(.*)$","target":"/new/$1","type":1,"status_code":301,"preserve_path":false,"preserve_query":true}]
+func UpdateRewriteRules(app *models.Application, rewriteRules []interface{}) {
+	for _, rewriteRule := range app.RewriteRules {
+		// delete outdated rewrite rules from DB
+		if !InterfaceContainsDestinationID(rewriteRules, rewriteRule.ID) {
+			err := data.DAL.DeleteRewriteRuleByID(rewriteRule.ID)
+			if err != nil {
+				utils.DebugPrintln("DeleteRewriteRuleByID", err)
+			}
+		}
+	}
+	newRewriteRules := []*models.RewriteRule{}
+	for i, rewriteRuleInterface := range rewriteRules {
+		rewriteRuleMap := rewriteRuleInterface.(map[string]interface{})
+		rewriteRule := &models.RewriteRule{
+			ID:         int64(rewriteRuleMap["id"].(float64)),
+			AppID:      app.ID,
+			Priority:   int64(i),
+			Pattern:    strings.TrimSpace(rewriteRuleMap["pattern"].(string)),
+			Target:     strings.TrimSpace(rewriteRuleMap["target"].(string)),
+			Type:       models.RewriteType(rewriteRuleMap["type"].(float64)),
+			StatusCode: 301,
+		}
+		if domain, ok := rewriteRuleMap["domain"].(string); ok {
+			rewriteRule.Domain = strings.TrimSpace(domain)
+		}
+		if statusCode, ok := rewriteRuleMap["status_code"].(float64); ok {
+			rewriteRule.StatusCode = int64(statusCode)
+		}
+		if preservePath, ok := rewriteRuleMap["preserve_path"].(bool); ok {
+			rewriteRule.PreservePath = preservePath
+		}
+		if preserveQuery, ok := rewriteRuleMap["preserve_query"].(bool); ok {
+			rewriteRule.PreserveQuery = preserveQuery
+		}
+		if !IsRedirectStatus(rewriteRule.StatusCode) {
+			rewriteRule.StatusCode = 301
+		}
+		if _, err := regexp.Compile(rewriteRule.Pattern); err != nil {
+			utils.DebugPrintln("UpdateRewriteRules invalid pattern", rewriteRule.Pattern, err)
+			continue
+		}
+		var err error
+		if rewriteRule.ID == 0 {
+			rewriteRule.ID, err = data.DAL.InsertRewriteRule(rewriteRule)
+			if err != nil {
+				utils.DebugPrintln("InsertRewriteRule", err)
+			}
+		} else {
+			err = data.DAL.UpdateRewriteRule(rewriteRule)
+			if err != nil {
+				utils.DebugPrintln("UpdateRewriteRule", err)
+			}
+		}
+		newRewriteRules = append(newRewriteRules, rewriteRule)
+	}
+	app.RewriteRules = newRewriteRules
+}
+
+// DeleteRewriteRulesByApp ...
+func DeleteRewriteRulesByApp(appID int64) {
+	err := data.DAL.DeleteRewriteRulesByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteRewriteRulesByAppID", err)
+	}
+}
+
+// IsRedirectStatus return true if the status code is 301, 302, 307 or 308
+func IsRedirectStatus(statusCode int64) bool {
+	switch statusCode {
+	case 301, 302, 307, 308:
+		return true
+	}
+	return false
+}
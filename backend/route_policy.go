@@ -0,0 +1,112 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-18 22:10:25
+ * @Last Modified: U2, 2021-05-18 22:10:25
+ */
+
+package backend
+
+import (
+	"strings"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// LoadRoutePolicies load route policies of all applications, primary node only
+func LoadRoutePolicies() {
+	for _, app := range Apps {
+		app.RoutePolicies = data.DAL.SelectRoutePoliciesByAppID(app.ID)
+	}
+}
+
+// GetRoutePolicy return the route policy of request route, nil if not configured
+func GetRoutePolicy(app *models.Application, requestRoute string) *models.RoutePolicy {
+	for _, routePolicy := range app.RoutePolicies {
+		if routePolicy.RequestRoute == requestRoute {
+			return routePolicy
+		}
+	}
+	return nil
+}
+
+// UpdateRoutePolicies update the route policies of the application
+// route policy example: [{"id":0,"request_route":"/","lb_method":2,"hash_key":1,"hash_key_name":"","mirror_enabled":true,"mirror_destination":"10.0.0.8:8080","mirror_percent":10,"mirror_max_body_size":1048576,"mirror_max_concurrency":100}]
+func UpdateRoutePolicies(app *models.Application, routePolicies []interface{}) {
+	for _, routePolicy := range app.RoutePolicies {
+		// delete outdated route policies from DB
+		if !InterfaceContainsDestinationID(routePolicies, routePolicy.ID) {
+			err := data.DAL.DeleteRoutePolicyByID(routePolicy.ID)
+			if err != nil {
+				utils.DebugPrintln("DeleteRoutePolicyByID", err)
+			}
+		}
+	}
+	newRoutePolicies := []*models.RoutePolicy{}
+	for _, routePolicyInterface := range routePolicies {
+		routePolicyMap := routePolicyInterface.(map[string]interface{})
+		routePolicyID := int64(routePolicyMap["id"].(float64))
+		requestRoute := strings.TrimSpace(routePolicyMap["request_route"].(string))
+		lbMethod := models.LBMethod(routePolicyMap["lb_method"].(float64))
+		hashKey := models.HashKeyIP
+		if hashKeyF, ok := routePolicyMap["hash_key"].(float64); ok {
+			hashKey = models.HashKey(hashKeyF)
+		}
+		var hashKeyName string
+		var ok bool
+		if hashKeyName, ok = routePolicyMap["hash_key_name"].(string); !ok {
+			hashKeyName = ""
+		}
+		routePolicy := &models.RoutePolicy{
+			ID:            routePolicyID,
+			AppID:         app.ID,
+			RequestRoute:  requestRoute,
+			LBMethod:      lbMethod,
+			HashKey:       hashKey,
+			HashKeyName:   strings.TrimSpace(hashKeyName),
+			MirrorPercent: 100,
+		}
+		if mirrorEnabled, ok := routePolicyMap["mirror_enabled"].(bool); ok {
+			routePolicy.MirrorEnabled = mirrorEnabled
+		}
+		if mirrorDestination, ok := routePolicyMap["mirror_destination"].(string); ok {
+			routePolicy.MirrorDestination = strings.TrimSpace(mirrorDestination)
+		}
+		if mirrorPercent, ok := routePolicyMap["mirror_percent"].(float64); ok && mirrorPercent >= 1 && mirrorPercent <= 100 {
+			routePolicy.MirrorPercent = int64(mirrorPercent)
+		}
+		if mirrorMaxBodySize, ok := routePolicyMap["mirror_max_body_size"].(float64); ok && mirrorMaxBodySize > 0 {
+			routePolicy.MirrorMaxBodySize = int64(mirrorMaxBodySize)
+		}
+		if mirrorMaxConcurrency, ok := routePolicyMap["mirror_max_concurrency"].(float64); ok && mirrorMaxConcurrency > 0 {
+			routePolicy.MirrorMaxConcurrency = int64(mirrorMaxConcurrency)
+		}
+		if len(routePolicy.MirrorDestination) == 0 {
+			routePolicy.MirrorEnabled = false
+		}
+		var err error
+		if routePolicy.ID == 0 {
+			routePolicy.ID, err = data.DAL.InsertRoutePolicy(routePolicy)
+			if err != nil {
+				utils.DebugPrintln("InsertRoutePolicy", err)
+			}
+		} else {
+			err = data.DAL.UpdateRoutePolicy(routePolicy)
+			if err != nil {
+				utils.DebugPrintln("UpdateRoutePolicy", err)
+			}
+		}
+		newRoutePolicies = append(newRoutePolicies, routePolicy)
+	}
+	app.RoutePolicies = newRoutePolicies
+}
+
+// DeleteRoutePoliciesByApp ...
+func DeleteRoutePoliciesByApp(appID int64) {
+	err := data.DAL.DeleteRoutePoliciesByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteRoutePoliciesByAppID", err)
+	}
+}
@@ -41,3 +41,37 @@ func RPCSelectCertificates() []*models.CertItem {
 	}
 	return certs
 }
+
+// RPCSelectCACerts return nil if failed, v1.2.4
+func RPCSelectCACerts() []*models.CACertItem {
+	rpcRequest := &models.RPCRequest{
+		Action: "get_ca_certs", Object: nil}
+	resp, err := data.GetRPCResponse(rpcRequest)
+	if err != nil {
+		utils.DebugPrintln("RPCSelectCACerts GetResponse", err)
+		return nil
+	}
+	rpcCACertItems := &models.RPCCACertItems{}
+	if err = json.Unmarshal(resp, rpcCACertItems); err != nil {
+		utils.DebugPrintln("RPCSelectCACerts Unmarshal", err)
+		return nil
+	}
+	return rpcCACertItems.Object
+}
+
+// RPCSelectTLSProfiles return nil if failed, v1.2.4
+func RPCSelectTLSProfiles() []*models.TLSProfile {
+	rpcRequest := &models.RPCRequest{
+		Action: "get_tls_profiles", Object: nil}
+	resp, err := data.GetRPCResponse(rpcRequest)
+	if err != nil {
+		utils.DebugPrintln("RPCSelectTLSProfiles GetResponse", err)
+		return nil
+	}
+	rpcTLSProfiles := &models.RPCTLSProfiles{}
+	if err = json.Unmarshal(resp, rpcTLSProfiles); err != nil {
+		utils.DebugPrintln("RPCSelectTLSProfiles Unmarshal", err)
+		return nil
+	}
+	return rpcTLSProfiles.Object
+}
@@ -0,0 +1,311 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-02 20:14:52
+ * @Last Modified: U2, 2021-06-02 20:14:52
+ */
+
+package backend
+
+import (
+	"crypto/tls"
+	"errors"
+	"strconv"
+	"strings"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// TLSProfiles list of TLS profiles, v1.2.4
+var TLSProfiles = []*models.TLSProfile{}
+
+var tlsVersions = map[string]uint16{
+	"TLS1.0": tls.VersionTLS10,
+	"TLS1.1": tls.VersionTLS11,
+	"TLS1.2": tls.VersionTLS12,
+	"TLS1.3": tls.VersionTLS13,
+}
+
+// GetTLSVersionName return the name such as TLS1.3, used by access log
+func GetTLSVersionName(version uint16) string {
+	for name, tlsVersion := range tlsVersions {
+		if tlsVersion == version {
+			return name
+		}
+	}
+	return ""
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+var (
+	defaultCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+
+	intermediateCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	}
+
+	legacyCipherSuites = append(append([]uint16{}, intermediateCipherSuites...),
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	)
+)
+
+// LoadTLSProfiles load TLS profiles from database (primary) or primary node (replica)
+func LoadTLSProfiles() {
+	var tlsProfiles []*models.TLSProfile
+	if data.IsPrimary {
+		tlsProfiles = data.DAL.SelectTLSProfiles()
+	} else {
+		tlsProfiles = RPCSelectTLSProfiles()
+		if tlsProfiles == nil {
+			return
+		}
+	}
+	for _, tlsProfile := range tlsProfiles {
+		if err := ParseTLSProfile(tlsProfile); err != nil {
+			utils.DebugPrintln("LoadTLSProfiles ParseTLSProfile", tlsProfile.Name, err)
+		}
+	}
+	TLSProfiles = tlsProfiles
+}
+
+// ParseTLSProfile fill the versions, cipher suites and curves according to the type
+func ParseTLSProfile(tlsProfile *models.TLSProfile) error {
+	switch tlsProfile.Type {
+	case models.TLSProfileModern:
+		tlsProfile.MinTLSVersion = tls.VersionTLS13
+		tlsProfile.MaxTLSVersion = tls.VersionTLS13
+		tlsProfile.CipherSuiteIDs = nil
+		tlsProfile.CurveIDs = defaultCurves
+	case models.TLSProfileIntermediate:
+		tlsProfile.MinTLSVersion = tls.VersionTLS12
+		tlsProfile.MaxTLSVersion = tls.VersionTLS13
+		tlsProfile.CipherSuiteIDs = intermediateCipherSuites
+		tlsProfile.CurveIDs = defaultCurves
+	case models.TLSProfileLegacy:
+		tlsProfile.MinTLSVersion = tls.VersionTLS10
+		tlsProfile.MaxTLSVersion = tls.VersionTLS13
+		tlsProfile.CipherSuiteIDs = legacyCipherSuites
+		tlsProfile.CurveIDs = defaultCurves
+	case models.TLSProfileCustom:
+		return parseCustomTLSProfile(tlsProfile)
+	default:
+		return errors.New("unknown TLS profile type " + strconv.FormatInt(int64(tlsProfile.Type), 10))
+	}
+	return nil
+}
+
+func parseCustomTLSProfile(tlsProfile *models.TLSProfile) error {
+	tlsProfile.MinTLSVersion = tls.VersionTLS12
+	tlsProfile.MaxTLSVersion = tls.VersionTLS13
+	if len(tlsProfile.MinVersion) > 0 {
+		version, ok := tlsVersions[tlsProfile.MinVersion]
+		if !ok {
+			return errors.New("unknown TLS version " + tlsProfile.MinVersion)
+		}
+		tlsProfile.MinTLSVersion = version
+	}
+	if len(tlsProfile.MaxVersion) > 0 {
+		version, ok := tlsVersions[tlsProfile.MaxVersion]
+		if !ok {
+			return errors.New("unknown TLS version " + tlsProfile.MaxVersion)
+		}
+		tlsProfile.MaxTLSVersion = version
+	}
+	if tlsProfile.MinTLSVersion > tlsProfile.MaxTLSVersion {
+		return errors.New("the min version is greater than the max version")
+	}
+	cipherSuiteIDs := map[string]uint16{}
+	for _, cipherSuite := range tls.CipherSuites() {
+		cipherSuiteIDs[cipherSuite.Name] = cipherSuite.ID
+	}
+	for _, cipherSuite := range tls.InsecureCipherSuites() {
+		cipherSuiteIDs[cipherSuite.Name] = cipherSuite.ID
+	}
+	tlsProfile.CipherSuiteIDs = nil
+	for _, name := range strings.Split(tlsProfile.CipherSuites, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		id, ok := cipherSuiteIDs[name]
+		if !ok {
+			return errors.New("unknown cipher suite " + name)
+		}
+		tlsProfile.CipherSuiteIDs = append(tlsProfile.CipherSuiteIDs, id)
+	}
+	tlsProfile.CurveIDs = nil
+	for _, name := range strings.Split(tlsProfile.Curves, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		id, ok := tlsCurves[name]
+		if !ok {
+			return errors.New("unknown curve " + name)
+		}
+		tlsProfile.CurveIDs = append(tlsProfile.CurveIDs, id)
+	}
+	return nil
+}
+
+// GetTLSProfiles ...
+func GetTLSProfiles() ([]*models.TLSProfile, error) {
+	return TLSProfiles, nil
+}
+
+// GetTLSProfileByID ...
+func GetTLSProfileByID(id int64) (*models.TLSProfile, error) {
+	for _, tlsProfile := range TLSProfiles {
+		if tlsProfile.ID == id {
+			return tlsProfile, nil
+		}
+	}
+	return nil, errors.New("TLS profile not found")
+}
+
+// GetTLSProfileIndex ...
+func GetTLSProfileIndex(id int64) int {
+	for i := 0; i < len(TLSProfiles); i++ {
+		if TLSProfiles[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// UpdateTLSProfile add or update the TLS profile, it takes effect on new handshakes
+// tls profile example: {"id":0,"name":"Compliance","type":8,"min_version":"TLS1.2","max_version":"TLS1.3","cipher_suites":"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256","curves":"X25519,P256","ocsp_stapling":true,"description":""}
+func UpdateTLSProfile(param map[string]interface{}, clientIP string, authUser *models.AuthUser) (*models.TLSProfile, error) {
+	if !authUser.IsCertAdmin {
+		return nil, errors.New("you have no privilege to update TLS profiles")
+	}
+	tlsProfileMap := param["object"].(map[string]interface{})
+	tlsProfile := &models.TLSProfile{
+		ID:   int64(tlsProfileMap["id"].(float64)),
+		Name: strings.TrimSpace(tlsProfileMap["name"].(string)),
+		Type: models.TLSProfileType(tlsProfileMap["type"].(float64)),
+	}
+	if minVersion, ok := tlsProfileMap["min_version"].(string); ok {
+		tlsProfile.MinVersion = strings.TrimSpace(minVersion)
+	}
+	if maxVersion, ok := tlsProfileMap["max_version"].(string); ok {
+		tlsProfile.MaxVersion = strings.TrimSpace(maxVersion)
+	}
+	if cipherSuites, ok := tlsProfileMap["cipher_suites"].(string); ok {
+		tlsProfile.CipherSuites = strings.TrimSpace(cipherSuites)
+	}
+	if curves, ok := tlsProfileMap["curves"].(string); ok {
+		tlsProfile.Curves = strings.TrimSpace(curves)
+	}
+	if ocspStapling, ok := tlsProfileMap["ocsp_stapling"].(bool); ok {
+		tlsProfile.OCSPStapling = ocspStapling
+	}
+	if description, ok := tlsProfileMap["description"].(string); ok {
+		tlsProfile.Description = description
+	}
+	if err := ParseTLSProfile(tlsProfile); err != nil {
+		return nil, err
+	}
+	var err error
+	if tlsProfile.ID == 0 {
+		tlsProfile.ID, err = data.DAL.InsertTLSProfile(tlsProfile)
+		if err != nil {
+			return nil, err
+		}
+		TLSProfiles = append(TLSProfiles, tlsProfile)
+		go utils.OperationLog(clientIP, authUser.Username, "Add TLS Profile", tlsProfile.Name)
+	} else {
+		i := GetTLSProfileIndex(tlsProfile.ID)
+		if i < 0 {
+			return nil, errors.New("TLS profile not found")
+		}
+		err = data.DAL.UpdateTLSProfile(tlsProfile)
+		if err != nil {
+			return nil, err
+		}
+		TLSProfiles[i] = tlsProfile
+		go utils.OperationLog(clientIP, authUser.Username, "Update TLS Profile", tlsProfile.Name)
+	}
+	data.UpdateBackendLastModified()
+	return tlsProfile, nil
+}
+
+// DeleteTLSProfileByID ...
+func DeleteTLSProfileByID(id int64, clientIP string, authUser *models.AuthUser) error {
+	if !authUser.IsCertAdmin {
+		return errors.New("you have no privilege to delete TLS profiles")
+	}
+	if data.DAL.SelectDomainsCountByTLSProfileID(id) > 0 {
+		return errors.New("this TLS profile is in use, please modify relevant domains at first")
+	}
+	i := GetTLSProfileIndex(id)
+	if i < 0 {
+		return errors.New("TLS profile not found")
+	}
+	err := data.DAL.DeleteTLSProfileByID(id)
+	if err != nil {
+		return err
+	}
+	TLSProfiles = append(TLSProfiles[:i], TLSProfiles[i+1:]...)
+	go utils.OperationLog(clientIP, authUser.Username, "Delete TLS Profile", strconv.FormatInt(id, 10))
+	data.UpdateBackendLastModified()
+	return nil
+}
+
+// GetTLSConfigForClient apply the TLS profile of the SNI domain and the mutual TLS setting of its application,
+// return nil to use the base config
+func GetTLSConfigForClient(baseConfig *tls.Config, helloInfo *tls.ClientHelloInfo) (*tls.Config, error) {
+	domainRelationI, ok := DomainsMap.Load(helloInfo.ServerName)
+	if !ok {
+		return nil, nil
+	}
+	domainRelation := domainRelationI.(models.DomainRelation)
+	tlsProfile, _ := GetTLSProfileByID(domainRelation.TLSProfileID)
+	app := domainRelation.App
+	clientAuthEnabled := app != nil && app.ClientAuth != nil && app.ClientAuth.Enabled
+	if tlsProfile == nil && !clientAuthEnabled {
+		return nil, nil
+	}
+	config := baseConfig.Clone()
+	config.GetConfigForClient = nil
+	if tlsProfile != nil {
+		config.MinVersion = tlsProfile.MinTLSVersion
+		config.MaxVersion = tlsProfile.MaxTLSVersion
+		config.CipherSuites = tlsProfile.CipherSuiteIDs
+		config.CurvePreferences = tlsProfile.CurveIDs
+		if tlsProfile.OCSPStapling && domainRelation.Cert != nil {
+			certItem := domainRelation.Cert
+			config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return GetStapledCertificate(certItem), nil
+			}
+		}
+	}
+	if clientAuthEnabled {
+		applyClientAuth(config, app.ClientAuth)
+	}
+	return config, nil
+}
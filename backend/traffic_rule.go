@@ -0,0 +1,303 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-06 21:52:34
+ * @Last Modified: U2, 2021-06-06 21:52:34
+ */
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// ipNetsCache map[ruleID int64]*ruleIPNets, parsed CIDRs of traffic rules
+var ipNetsCache = sync.Map{}
+
+// ruleIPNets keep the value parsed, replica nodes reload the rules with the same ID
+type ruleIPNets struct {
+	value  string
+	ipNets []*net.IPNet
+}
+
+// LoadTrafficRules load traffic rules of all applications, primary node only
+func LoadTrafficRules() {
+	for _, app := range Apps {
+		app.TrafficRules = data.DAL.SelectTrafficRulesByAppID(app.ID)
+	}
+}
+
+// ClearIPNetsCache remove the parsed CIDRs, called when the applications are reloaded
+func ClearIPNetsCache() {
+	ipNetsCache.Range(func(key, value interface{}) bool {
+		ipNetsCache.Delete(key)
+		return true
+	})
+}
+
+// UpdateTrafficRules update the ordered traffic rules of the application, the priority is the index in list,
+// nothing is changed if any rule is invalid
+// traffic rule example: [{"id":0,"request_route":"/","type":2,"name":"X-Canary","value":"1","percent":0,"group":"canary"},{"id":0,"request_route":"/","type":1,"name":"","value":"","percent":10,"group":"canary"}]
+func UpdateTrafficRules(app *models.Application, trafficRules []interface{}) error {
+	newTrafficRules := []*models.TrafficRule{}
+	for i, trafficRuleInterface := range trafficRules {
+		trafficRuleMap, ok := trafficRuleInterface.(map[string]interface{})
+		if !ok {
+			return errors.New("invalid traffic rule")
+		}
+		trafficRule := &models.TrafficRule{
+			AppID:    app.ID,
+			Priority: int64(i),
+		}
+		if id, ok := trafficRuleMap["id"].(float64); ok {
+			trafficRule.ID = int64(id)
+		}
+		if requestRoute, ok := trafficRuleMap["request_route"].(string); ok {
+			trafficRule.RequestRoute = strings.TrimSpace(requestRoute)
+		}
+		if splitType, ok := trafficRuleMap["type"].(float64); ok {
+			trafficRule.Type = models.TrafficSplitType(splitType)
+		}
+		if name, ok := trafficRuleMap["name"].(string); ok {
+			trafficRule.Name = strings.TrimSpace(name)
+		}
+		if value, ok := trafficRuleMap["value"].(string); ok {
+			trafficRule.Value = strings.TrimSpace(value)
+		}
+		if percent, ok := trafficRuleMap["percent"].(float64); ok {
+			trafficRule.Percent = int64(percent)
+		}
+		if group, ok := trafficRuleMap["group"].(string); ok {
+			trafficRule.Group = strings.TrimSpace(group)
+		}
+		switch trafficRule.Type {
+		case models.SplitPercent:
+			if trafficRule.Percent < 0 || trafficRule.Percent > 100 {
+				return fmt.Errorf("traffic rule %d: invalid percent %d", i+1, trafficRule.Percent)
+			}
+		case models.SplitHeader, models.SplitCookie:
+			if len(trafficRule.Name) == 0 {
+				return fmt.Errorf("traffic rule %d: name is required", i+1)
+			}
+			if trafficRule.Type == models.SplitHeader {
+				trafficRule.Name = http.CanonicalHeaderKey(trafficRule.Name)
+			}
+		case models.SplitCIDR:
+			if _, err := parseIPNets(trafficRule.Value); err != nil {
+				return fmt.Errorf("traffic rule %d: %v", i+1, err)
+			}
+		default:
+			return fmt.Errorf("traffic rule %d: unknown type %d", i+1, trafficRule.Type)
+		}
+		newTrafficRules = append(newTrafficRules, trafficRule)
+	}
+	for _, trafficRule := range app.TrafficRules {
+		ipNetsCache.Delete(trafficRule.ID)
+		// delete outdated traffic rules from DB
+		if !InterfaceContainsDestinationID(trafficRules, trafficRule.ID) {
+			err := data.DAL.DeleteTrafficRuleByID(trafficRule.ID)
+			if err != nil {
+				utils.DebugPrintln("DeleteTrafficRuleByID", err)
+			}
+		}
+	}
+	for _, trafficRule := range newTrafficRules {
+		var err error
+		if trafficRule.ID == 0 {
+			trafficRule.ID, err = data.DAL.InsertTrafficRule(trafficRule)
+			if err != nil {
+				utils.DebugPrintln("InsertTrafficRule", err)
+			}
+		} else {
+			err = data.DAL.UpdateTrafficRule(trafficRule)
+			if err != nil {
+				utils.DebugPrintln("UpdateTrafficRule", err)
+			}
+		}
+	}
+	app.TrafficRules = newTrafficRules
+	return nil
+}
+
+// UpdateAppTrafficRules replace the traffic rules of an application without submitting the whole application,
+// such as shifting the percentage of canary, replica nodes reload it at next sync
+// param object example: {"app_id":1,"traffic_rules":[{"id":3,"request_route":"/","type":1,"name":"","value":"","percent":100,"group":"green"}]}
+func UpdateAppTrafficRules(param map[string]interface{}, clientIP string, authUser *models.AuthUser) ([]*models.TrafficRule, error) {
+	object, ok := param["object"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("object is required")
+	}
+	appID, _ := object["app_id"].(float64)
+	app, err := GetApplicationByID(int64(appID))
+	if err != nil {
+		return nil, err
+	}
+	if !authUser.IsAppAdmin && app.Owner != authUser.Username {
+		return nil, errors.New("no privilege to update the traffic rules of " + app.Name)
+	}
+	trafficRules, ok := object["traffic_rules"].([]interface{})
+	if !ok {
+		return nil, errors.New("traffic_rules is required")
+	}
+	if err = UpdateTrafficRules(app, trafficRules); err != nil {
+		return nil, err
+	}
+	data.UpdateBackendLastModified()
+	go utils.OperationLog(clientIP, authUser.Username, "Update Traffic Rules", app.Name)
+	return app.TrafficRules, nil
+}
+
+// GetDestinationGroup return the destination group of the request by the first matched traffic rule of the route,
+// empty string if no rule matched
+func GetDestinationGroup(app *models.Application, routeKey string, r *http.Request, srcIP string) string {
+	// The consecutive shares of percentage rules, the bucket of client is in [0, 100)
+	percentStart := int64(0)
+	bucket := int64(-1)
+	for _, rule := range app.TrafficRules {
+		if rule.RequestRoute != routeKey {
+			continue
+		}
+		switch rule.Type {
+		case models.SplitPercent:
+			if bucket < 0 {
+				bucket = getClientBucket(r, srcIP, rule.Name)
+			}
+			percentStart += rule.Percent
+			if bucket < percentStart {
+				return rule.Group
+			}
+		case models.SplitHeader:
+			if containsValue(rule.Value, r.Header.Get(rule.Name)) {
+				return rule.Group
+			}
+		case models.SplitCookie:
+			if cookie, err := r.Cookie(rule.Name); err == nil && containsValue(rule.Value, cookie.Value) {
+				return rule.Group
+			}
+		case models.SplitCIDR:
+			if isIPInNets(srcIP, rule) {
+				return rule.Group
+			}
+		}
+	}
+	return ""
+}
+
+// filterDestinationGroup return the destinations of the group
+func filterDestinationGroup(dests []*models.Destination, group string) []*models.Destination {
+	groupDests := []*models.Destination{}
+	for _, dest := range dests {
+		if dest.Group == group {
+			groupDests = append(groupDests, dest)
+		}
+	}
+	return groupDests
+}
+
+// getClientBucket hash the cookie if present, otherwise client IP and User-Agent, so that a client stays in the same group
+func getClientBucket(r *http.Request, srcIP string, cookieName string) int64 {
+	hashValue := srcIP + r.UserAgent()
+	if len(cookieName) > 0 {
+		if cookie, err := r.Cookie(cookieName); err == nil && len(cookie.Value) > 0 {
+			hashValue = cookie.Value
+		}
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(hashValue))
+	return int64(h.Sum32() % 100)
+}
+
+// containsValue check the comma separated values, an empty list matches any non-empty value
+func containsValue(values string, value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+	if len(values) == 0 {
+		return true
+	}
+	for _, item := range strings.Split(values, ",") {
+		if strings.TrimSpace(item) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPNets parse the comma separated CIDRs or IPs
+func parseIPNets(value string) ([]*net.IPNet, error) {
+	ipNets := []*net.IPNet{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, errors.New("invalid IP " + item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			ipNets = append(ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+// isIPInNets check the client IP with the cached CIDRs of traffic rule
+func isIPInNets(srcIP string, rule *models.TrafficRule) bool {
+	ip := net.ParseIP(srcIP)
+	if ip == nil {
+		return false
+	}
+	var cached *ruleIPNets
+	if cachedI, ok := ipNetsCache.Load(rule.ID); ok {
+		cached = cachedI.(*ruleIPNets)
+	}
+	if cached == nil || cached.value != rule.Value {
+		cached = &ruleIPNets{value: rule.Value}
+		var err error
+		if cached.ipNets, err = parseIPNets(rule.Value); err != nil {
+			utils.DebugPrintln("isIPInNets", rule.Value, err)
+		}
+		ipNetsCache.Store(rule.ID, cached)
+	}
+	for _, ipNet := range cached.ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteTrafficRulesByApp ...
+func DeleteTrafficRulesByApp(appID int64) {
+	if app, err := GetApplicationByID(appID); err == nil {
+		for _, trafficRule := range app.TrafficRules {
+			ipNetsCache.Delete(trafficRule.ID)
+		}
+	}
+	err := data.DAL.DeleteTrafficRulesByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteTrafficRulesByAppID", err)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-16 10:12:35
+ * @Last Modified: U2, 2021-05-16 10:12:35
+ */
+
+package backend
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+
+	"golang.org/x/net/http2"
+)
+
+// destTransport bind the pooled transport to the destination it dials and the TLS setting it uses
+type destTransport struct {
+	dest        *models.Destination
+	upstreamTLS *models.UpstreamTLS
+	rootCAs     *x509.CertPool
+	transport   *http.Transport
+}
+
+// transports map[destID int64]*destTransport, one long-lived transport per destination
+var transports = sync.Map{}
+
+// NewTransport create a transport which always dial to the destination, keep-alive and HTTP/2 enabled
+// If PROXY protocol is enabled, each request uses a new connection, as the header is bound to the client
+// The destination can be a Unix domain socket such as unix:/run/app.sock
+func NewTransport(dest *models.Destination, upstreamTLS *models.UpstreamTLS, rootCAs *x509.CertPool) *http.Transport {
+	cfg := data.GetConfig().BackendTransport
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(cfg.DialTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	destNetwork, destAddr := GetDestinationAddr(dest.Destination)
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, destNetwork, destAddr)
+			dest.CheckTime.Store(time.Now().Unix())
+			if err != nil {
+				// Cancelled by client or timeout of the request, not a failure of destination,
+				// and the probes of health check go offline by fall
+				if ctx.Err() == nil && !isHealthProbe(ctx) {
+					SetDestinationOnline(dest, false)
+				}
+				utils.DebugPrintln("DialContext error", dest.Destination, err)
+				return conn, err
+			}
+			if dest.ProxyProtocol != ProxyProtocolNone {
+				srcAddr, dstAddr := getProxyProtocolAddr(ctx)
+				err = WriteProxyProtocolHeader(conn, dest.ProxyProtocol, srcAddr, dstAddr)
+				if err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+			return conn, nil
+		},
+		TLSClientConfig:       newUpstreamTLSConfig(upstreamTLS, rootCAs),
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(cfg.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(cfg.TLSHandshakeTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(cfg.ResponseHeaderTimeout) * time.Second,
+		ExpectContinueTimeout: 5 * time.Second,
+	}
+	if dest.ProxyProtocol != ProxyProtocolNone {
+		transport.DisableKeepAlives = true
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		return transport
+	}
+	err := http2.ConfigureTransport(transport)
+	if err != nil {
+		utils.DebugPrintln("http2.ConfigureTransport error", err)
+	}
+	return transport
+}
+
+// GetTransport return the pooled transport of the destination, create it if not exists or outdated
+func GetTransport(app *models.Application, dest *models.Destination) *http.Transport {
+	if dtI, ok := transports.Load(dest.ID); ok {
+		dt := dtI.(*destTransport)
+		if dt.dest == dest && dt.upstreamTLS == app.UpstreamTLS && dt.rootCAs == getUpstreamRootCAs(app.UpstreamTLS) {
+			return dt.transport
+		}
+	}
+	return UpdateTransport(app, dest)
+}
+
+// UpdateTransport rebuild the transport when the destination or upstream TLS setting changed, and close the old one
+func UpdateTransport(app *models.Application, dest *models.Destination) *http.Transport {
+	upstreamTLS := app.UpstreamTLS
+	rootCAs := getUpstreamRootCAs(upstreamTLS)
+	transport := NewTransport(dest, upstreamTLS, rootCAs)
+	oldI, loaded := transports.Load(dest.ID)
+	transports.Store(dest.ID, &destTransport{dest: dest, upstreamTLS: upstreamTLS, rootCAs: rootCAs, transport: transport})
+	if loaded {
+		oldI.(*destTransport).transport.CloseIdleConnections()
+	}
+	return transport
+}
+
+// DeleteTransport tear down the transport of deleted destination
+func DeleteTransport(destID int64) {
+	if dtI, loaded := transports.LoadAndDelete(destID); loaded {
+		dtI.(*destTransport).transport.CloseIdleConnections()
+	}
+}
+
+// LoadTransports rebuild transports for all reverse proxy destinations, and remove outdated transports
+func LoadTransports() {
+	validIDs := map[int64]bool{}
+	for _, app := range Apps {
+		for _, dest := range app.Destinations {
+			if dest.RouteType == models.ReverseProxyRoute {
+				validIDs[dest.ID] = true
+				UpdateTransport(app, dest)
+			}
+		}
+	}
+	transports.Range(func(key, value interface{}) bool {
+		destID := key.(int64)
+		if !validIDs[destID] {
+			DeleteTransport(destID)
+		}
+		return true
+	})
+}
@@ -0,0 +1,138 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-01 20:51:43
+ * @Last Modified: U2, 2021-06-01 20:51:43
+ */
+
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"strings"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// LoadUpstreamTLS load upstream TLS settings of all applications, primary node only
+func LoadUpstreamTLS() {
+	for _, app := range Apps {
+		app.UpstreamTLS = data.DAL.SelectUpstreamTLSByAppID(app.ID)
+	}
+}
+
+// UpdateUpstreamTLS update the upstream TLS setting of the application, transports are rebuilt on next request
+// upstream tls example: {"id":0,"verify":true,"ca_cert_id":0,"server_name":"backend.internal","client_cert_id":0}
+func UpdateUpstreamTLS(app *models.Application, upstreamTLSMap map[string]interface{}) error {
+	upstreamTLS := &models.UpstreamTLS{
+		AppID:  app.ID,
+		Verify: true,
+	}
+	if app.UpstreamTLS != nil {
+		upstreamTLS.ID = app.UpstreamTLS.ID
+	}
+	if verify, ok := upstreamTLSMap["verify"].(bool); ok {
+		upstreamTLS.Verify = verify
+	}
+	if caCertID, ok := upstreamTLSMap["ca_cert_id"].(float64); ok {
+		upstreamTLS.CACertID = int64(caCertID)
+	}
+	if serverName, ok := upstreamTLSMap["server_name"].(string); ok {
+		upstreamTLS.ServerName = strings.TrimSpace(serverName)
+	}
+	if clientCertID, ok := upstreamTLSMap["client_cert_id"].(float64); ok {
+		upstreamTLS.ClientCertID = int64(clientCertID)
+	}
+	if upstreamTLS.CACertID > 0 {
+		if _, err := GetCACertByID(upstreamTLS.CACertID); err != nil {
+			return err
+		}
+	}
+	if upstreamTLS.ClientCertID > 0 {
+		if _, err := SysCallGetCertByID(upstreamTLS.ClientCertID); err != nil {
+			return err
+		}
+	}
+	var err error
+	if upstreamTLS.ID == 0 {
+		upstreamTLS.ID, err = data.DAL.InsertUpstreamTLS(upstreamTLS)
+	} else {
+		err = data.DAL.UpdateUpstreamTLS(upstreamTLS)
+	}
+	if err != nil {
+		return err
+	}
+	app.UpstreamTLS = upstreamTLS
+	return nil
+}
+
+// DeleteUpstreamTLSByApp ...
+func DeleteUpstreamTLSByApp(appID int64) {
+	err := data.DAL.DeleteUpstreamTLSByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteUpstreamTLSByAppID", err)
+	}
+}
+
+// getUpstreamRootCAs return the pool of CA bundle, or nil for the system roots
+func getUpstreamRootCAs(upstreamTLS *models.UpstreamTLS) *x509.CertPool {
+	if upstreamTLS == nil || !upstreamTLS.Verify || upstreamTLS.CACertID == 0 {
+		return nil
+	}
+	caCert, err := GetCACertByID(upstreamTLS.CACertID)
+	if err != nil || caCert.Pool == nil {
+		// An empty pool fails the verification instead of trusting the system roots
+		return x509.NewCertPool()
+	}
+	return caCert.Pool
+}
+
+// newUpstreamTLSConfig return the TLS config of transport, ServerName is taken from the request host if not overridden
+func newUpstreamTLSConfig(upstreamTLS *models.UpstreamTLS, rootCAs *x509.CertPool) *tls.Config {
+	tlsConfig := &tls.Config{
+		NextProtos:         []string{"h2", "http/1.1"},
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+	}
+	if upstreamTLS == nil {
+		return tlsConfig
+	}
+	tlsConfig.InsecureSkipVerify = !upstreamTLS.Verify
+	tlsConfig.RootCAs = rootCAs
+	tlsConfig.ServerName = upstreamTLS.ServerName
+	if upstreamTLS.ClientCertID > 0 {
+		clientCertID := upstreamTLS.ClientCertID
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			// Lookup for each handshake, so that the renewed certificate is used
+			certItem, err := SysCallGetCertByID(clientCertID)
+			if err != nil {
+				utils.DebugPrintln("Upstream client certificate", clientCertID, err)
+				return &tls.Certificate{}, nil
+			}
+			return &certItem.TlsCert, nil
+		}
+	}
+	return tlsConfig
+}
+
+// DescribeUpstreamTLSError return the reason if the error is caused by TLS handshake with backend, otherwise empty
+func DescribeUpstreamTLSError(err error) string {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &unknownAuthorityErr):
+		return "the backend certificate is not signed by a trusted CA: " + unknownAuthorityErr.Error()
+	case errors.As(err, &hostnameErr):
+		return "the backend certificate does not match the server name: " + hostnameErr.Error()
+	case errors.As(err, &certInvalidErr):
+		return "the backend certificate is invalid: " + certInvalidErr.Error()
+	case err != nil && strings.Contains(err.Error(), "tls: "):
+		return "TLS handshake with backend failed: " + err.Error()
+	}
+	return ""
+}
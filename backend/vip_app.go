@@ -9,7 +9,6 @@ package backend
 
 import (
 	"errors"
-	"hash/fnv"
 	"io"
 	"janusec/data"
 	"janusec/models"
@@ -17,15 +16,53 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // VipApps : list of all port forwarding configuration
 var VipApps = []*models.VipApp{}
 
+// vipCounter is the statistics of a VipApp, kept across reloading
+type vipCounter struct {
+	connections       int64
+	activeConnections int64
+	bytesIn           int64
+	bytesOut          int64
+}
+
+// vipCounters map[vipAppID int64]*vipCounter
+var vipCounters = sync.Map{}
+
+func getVipCounter(vipAppID int64) *vipCounter {
+	counterI, _ := vipCounters.LoadOrStore(vipAppID, &vipCounter{})
+	return counterI.(*vipCounter)
+}
+
+// GetVipStats return the statistics of port forwarding on current node, v1.2.4
+func GetVipStats() []*models.VipStat {
+	vipStats := []*models.VipStat{}
+	for _, vipApp := range VipApps {
+		counter := getVipCounter(vipApp.ID)
+		vipStats = append(vipStats, &models.VipStat{
+			VipAppID:          vipApp.ID,
+			Name:              vipApp.Name,
+			IsTCP:             vipApp.IsTCP,
+			Connections:       atomic.LoadInt64(&counter.connections),
+			ActiveConnections: atomic.LoadInt64(&counter.activeConnections),
+			BytesIn:           atomic.LoadInt64(&counter.bytesIn),
+			BytesOut:          atomic.LoadInt64(&counter.bytesOut),
+		})
+	}
+	return vipStats
+}
+
 // LoadVipApps load vip applications for port forwarding
 func LoadVipApps() {
-	VipApps = VipApps[0:0]
+	// Stop the listeners of last loading, v1.2.4
+	StopVipApps()
+	VipApps = []*models.VipApp{}
 	if data.IsPrimary {
 		dbApps := data.DAL.SelectVipApplications()
 		for _, dbApp := range dbApps {
@@ -37,7 +74,11 @@ func LoadVipApps() {
 				Targets:     []*models.VipTarget{},
 				Owner:       dbApp.Owner,
 				Description: dbApp.Description,
+				LBMethod:    dbApp.LBMethod,
 				ExitChan:    make(chan bool),
+
+				AcceptProxyProtocol: dbApp.AcceptProxyProtocol,
+				SendProxyProtocol:   dbApp.SendProxyProtocol,
 			}
 			VipApps = append(VipApps, vipApp)
 		}
@@ -51,6 +92,9 @@ func LoadVipApps() {
 		if rpcVipApps != nil {
 			VipApps = rpcVipApps
 		}
+		for _, vipApp := range VipApps {
+			vipApp.ExitChan = make(chan bool)
+		}
 	}
 	// Start Port Forwarding
 	for _, vipApp := range VipApps {
@@ -62,12 +106,24 @@ func LoadVipApps() {
 func ListenOnVIP(vipApp *models.VipApp) {
 	address := ":" + strconv.FormatInt(vipApp.ListenPort, 10)
 	if vipApp.IsTCP {
-		vipListener, err := net.Listen("tcp", address)
+		var vipListener net.Listener
+		var err error
+		// The port may be released by the last listener a moment later
+		for i := 0; i < 10; i++ {
+			vipListener, err = Listen("tcp", address)
+			if err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
 		if err != nil {
 			utils.DebugPrintln("could not start server on port ", vipApp.ListenPort, err)
 		}
 		if vipListener != nil {
 			defer vipListener.Close()
+			if vipApp.AcceptProxyProtocol {
+				vipListener = NewProxyProtocolListener(vipListener)
+			}
 		}
 		go TCPForwarding(vipApp, vipListener)
 		// Waiting exit signal
@@ -75,19 +131,22 @@ func ListenOnVIP(vipApp *models.VipApp) {
 		return
 	}
 	// UDP
-	udpAddr, err := net.ResolveUDPAddr("udp", address)
-	if err != nil {
-		utils.DebugPrintln("ResolveUDPAddr", address, err)
-		return
+	var udpListenConn *net.UDPConn
+	var err error
+	for i := 0; i < 10; i++ {
+		udpListenConn, err = ListenUDP(address)
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
-
-	udpListenConn, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
 		utils.DebugPrintln("ListenOnVIP could not start udp port ", vipApp.ListenPort, err)
+		// Waiting exit signal so that the sender is not blocked
+		<-vipApp.ExitChan
+		return
 	}
-	if udpListenConn != nil {
-		defer udpListenConn.Close()
-	}
+	defer CloseUDP(address, udpListenConn)
 	// Mode: not use ListenUDP, when response uses service port
 	go UDPForwarding(vipApp, udpListenConn)
 
@@ -96,6 +155,7 @@ func ListenOnVIP(vipApp *models.VipApp) {
 
 // UDPForwarding with DialUDP
 func UDPForwarding(vipApp *models.VipApp, udpListenConn *net.UDPConn) {
+	counter := getVipCounter(vipApp.ID)
 	for {
 		dataBuf := make([]byte, 2048)
 		dataInLen, clientAddr, err := udpListenConn.ReadFromUDP(dataBuf)
@@ -125,6 +185,7 @@ func UDPForwarding(vipApp *models.VipApp, udpListenConn *net.UDPConn) {
 				break
 			}
 			defer udpTargetConn.Close()
+			atomic.AddInt64(&counter.connections, 1)
 
 			// Log to file
 			proxyAddr := udpListenConn.LocalAddr().String()
@@ -148,6 +209,7 @@ func UDPForwarding(vipApp *models.VipApp, udpListenConn *net.UDPConn) {
 					if err != nil {
 						break
 					}
+					atomic.AddInt64(&counter.bytesOut, int64(n))
 				}
 			}()
 
@@ -157,6 +219,7 @@ func UDPForwarding(vipApp *models.VipApp, udpListenConn *net.UDPConn) {
 				utils.DebugPrintln("UDPForwarding to target", vipTarget.Destination, err)
 				continue
 			}
+			atomic.AddInt64(&counter.bytesIn, int64(dataInLen))
 		}
 	}
 }
@@ -176,64 +239,87 @@ func TCPForwarding(vipApp *models.VipApp, vipListener net.Listener) {
 		if err != nil {
 			utils.DebugPrintln("TCPForwarding port forwarding: could not accept client connection", err)
 		}
-		remoteAddr := proxy.RemoteAddr()
-		vipTarget := SelectVipTarget(vipApp, remoteAddr.String())
-		if vipTarget != nil {
-			target, err := net.Dial("tcp", vipTarget.Destination)
-			vipTarget.CheckTime = time.Now().Unix()
-			if err != nil {
-				utils.DebugPrintln("TCPForwarding could not connect to target", vipTarget.Destination, err)
-				vipTarget.Online = false
-				if data.NodeSetting.SMTP.SMTPEnabled {
-					sendVIPOfflineNotification(vipApp, vipTarget.Destination)
-				}
-				continue
-			}
-			vipTarget.Online = true
-			// Log to file
-			utils.VipAccessLog(vipApp.Name, remoteAddr.String(), proxy.LocalAddr().String(), vipTarget.Destination)
-			// stream copy
-			go func() {
-				io.Copy(target, proxy)
-			}()
-			go func() {
-				io.Copy(proxy, target)
-				proxy.Close()
-				target.Close()
-			}()
-		} else {
+		// RemoteAddr may wait for PROXY protocol header, so handle it in goroutine
+		go forwardTCPConn(vipApp, proxy)
+	}
+}
+
+// forwardTCPConn select target and copy stream between client and target
+func forwardTCPConn(vipApp *models.VipApp, proxy net.Conn) {
+	remoteAddr := proxy.RemoteAddr()
+	vipTarget := SelectVipTarget(vipApp, remoteAddr.String())
+	if vipTarget == nil {
+		proxy.Close()
+		return
+	}
+	target, err := net.Dial("tcp", vipTarget.Destination)
+	vipTarget.CheckTime = time.Now().Unix()
+	if err != nil {
+		utils.DebugPrintln("TCPForwarding could not connect to target", vipTarget.Destination, err)
+		vipTarget.Online = false
+		if data.NodeSetting.SMTP.SMTPEnabled {
+			sendVIPOfflineNotification(vipApp, vipTarget.Destination)
+		}
+		proxy.Close()
+		return
+	}
+	vipTarget.Online = true
+	if vipApp.SendProxyProtocol != ProxyProtocolNone {
+		err = WriteProxyProtocolHeader(target, vipApp.SendProxyProtocol, remoteAddr, proxy.LocalAddr())
+		if err != nil {
+			utils.DebugPrintln("TCPForwarding send PROXY protocol header", vipTarget.Destination, err)
 			proxy.Close()
+			target.Close()
+			return
 		}
 	}
+	atomic.AddInt64(&vipTarget.Connections, 1)
+	atomic.AddInt64(&activeVipConns, 1)
+	counter := getVipCounter(vipApp.ID)
+	atomic.AddInt64(&counter.connections, 1)
+	atomic.AddInt64(&counter.activeConnections, 1)
+	// Log to file
+	utils.VipAccessLog(vipApp.Name, remoteAddr.String(), proxy.LocalAddr().String(), vipTarget.Destination)
+	// stream copy, the bytes are counted when each direction ends, so that zero-copy is kept
+	go func() {
+		n, _ := io.Copy(target, proxy)
+		atomic.AddInt64(&counter.bytesIn, n)
+	}()
+	go func(vipTarget *models.VipTarget) {
+		n, _ := io.Copy(proxy, target)
+		atomic.AddInt64(&counter.bytesOut, n)
+		proxy.Close()
+		target.Close()
+		atomic.AddInt64(&vipTarget.Connections, -1)
+		atomic.AddInt64(&activeVipConns, -1)
+		atomic.AddInt64(&counter.activeConnections, -1)
+	}(vipTarget)
 }
 
 // SelectVipTarget will replace SelectDestination
 func SelectVipTarget(vipApp *models.VipApp, srcIP string) *models.VipTarget {
 	var onlineTargets = []*models.VipTarget{}
 	for _, target := range vipApp.Targets {
-		if target.Online {
+		// weight 0 means draining
+		if target.Online && target.Weight > 0 {
 			onlineTargets = append(onlineTargets, target)
 		}
 	}
-	targetLen := uint32(len(onlineTargets))
-	if targetLen == 0 {
+	if len(onlineTargets) == 0 {
 		return nil
 	}
-	var target *models.VipTarget
-	if targetLen == 1 {
-		target = onlineTargets[0]
-	} else if targetLen > 1 {
-		// According to Hash(IP)
-		h := fnv.New32a()
-		_, err := h.Write([]byte(srcIP))
-		if err != nil {
-			utils.DebugPrintln("SelectVipTarget h.Write", err)
-		}
-		hashUInt32 := h.Sum32()
-		targetIndex := hashUInt32 % targetLen
-		target = onlineTargets[targetIndex]
+	candidates := []*balanceCandidate{}
+	for _, target := range onlineTargets {
+		candidates = append(candidates, &balanceCandidate{
+			ID:          target.ID,
+			Name:        target.Destination,
+			Weight:      target.Weight,
+			Connections: atomic.LoadInt64(&target.Connections),
+		})
 	}
-	return target
+	// According to Hash(IP) by default
+	stateKey := "vip-" + strconv.FormatInt(vipApp.ID, 10)
+	return onlineTargets[selectCandidate(vipApp.LBMethod, stateKey, candidates, srcIP)]
 }
 
 // GetVipApps return list of all port forwarding configuration
@@ -265,10 +351,22 @@ func UpdateVipApp(param map[string]interface{}, clientIP string, authUser *model
 		description = ""
 	}
 	owner := application["owner"].(string)
+	lbMethod := models.LBIPHash
+	if lbMethodF, ok := application["lb_method"].(float64); ok {
+		lbMethod = models.LBMethod(lbMethodF)
+	}
+	var acceptProxyProtocol bool
+	if acceptProxyProtocol, ok = application["accept_proxy_protocol"].(bool); !ok {
+		acceptProxyProtocol = false
+	}
+	sendProxyProtocol := ProxyProtocolNone
+	if sendProxyProtocolF, ok := application["send_proxy_protocol"].(float64); ok {
+		sendProxyProtocol = int64(sendProxyProtocolF)
+	}
 	var vipApp *models.VipApp
 	if appID == 0 {
 		// new application
-		newID := data.DAL.InsertVipApp(appName, listenPort, isTCP, owner, description)
+		newID := data.DAL.InsertVipApp(appName, listenPort, isTCP, owner, description, lbMethod, acceptProxyProtocol, sendProxyProtocol)
 		vipApp = &models.VipApp{
 			ID:          newID,
 			Name:        appName,
@@ -276,14 +374,18 @@ func UpdateVipApp(param map[string]interface{}, clientIP string, authUser *model
 			IsTCP:       isTCP,
 			Owner:       owner,
 			Description: description,
+			LBMethod:    lbMethod,
 			ExitChan:    make(chan bool),
+
+			AcceptProxyProtocol: acceptProxyProtocol,
+			SendProxyProtocol:   sendProxyProtocol,
 		}
 		VipApps = append(VipApps, vipApp)
 		go utils.OperationLog(clientIP, authUser.Username, "Add Port Forwarding", vipApp.Name)
 	} else {
 		vipApp, _ = GetVipAppByID(appID)
 		if vipApp != nil {
-			err := data.DAL.UpdateVipAppByID(appName, listenPort, isTCP, owner, description, appID)
+			err := data.DAL.UpdateVipAppByID(appName, listenPort, isTCP, owner, description, lbMethod, acceptProxyProtocol, sendProxyProtocol, appID)
 			if err != nil {
 				utils.DebugPrintln("UpdateVipApp", err)
 			}
@@ -292,6 +394,9 @@ func UpdateVipApp(param map[string]interface{}, clientIP string, authUser *model
 			vipApp.IsTCP = isTCP
 			vipApp.Owner = owner
 			vipApp.Description = description
+			vipApp.LBMethod = lbMethod
+			vipApp.AcceptProxyProtocol = acceptProxyProtocol
+			vipApp.SendProxyProtocol = sendProxyProtocol
 			// fmt.Println("send exit signal to", vipApp.Name)
 			vipApp.ExitChan <- true
 			go utils.OperationLog(clientIP, authUser.Username, "Update Port Forwarding", vipApp.Name)
@@ -341,14 +446,18 @@ func UpdateTargets(vipApp *models.VipApp, targets []interface{}) {
 		targetMap := targetInterface.(map[string]interface{})
 		targetID := int64(targetMap["id"].(float64))
 		destination := strings.TrimSpace(targetMap["destination"].(string))
+		weight := int64(1)
+		if weightF, ok := targetMap["weight"].(float64); ok {
+			weight = int64(weightF)
+		}
 		var err error
 		if targetID == 0 {
-			targetID, err = data.DAL.InsertVipTarget(vipApp.ID, destination)
+			targetID, err = data.DAL.InsertVipTarget(vipApp.ID, destination, weight)
 			if err != nil {
 				utils.DebugPrintln("InsertVipTarget", err)
 			}
 		} else {
-			err = data.DAL.UpdateVipTarget(vipApp.ID, destination, targetID)
+			err = data.DAL.UpdateVipTarget(vipApp.ID, destination, weight, targetID)
 			if err != nil {
 				utils.DebugPrintln("UpdateVipTarget", err)
 			}
@@ -358,6 +467,7 @@ func UpdateTargets(vipApp *models.VipApp, targets []interface{}) {
 			VipAppID:    vipApp.ID,
 			Destination: destination,
 			Online:      true,
+			Weight:      weight,
 		}
 		newTargets = append(newTargets, target)
 	}
@@ -0,0 +1,268 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-12 21:18:40
+ * @Last Modified: U2, 2021-06-12 21:18:40
+ */
+
+package backend
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// maxTicketIDLength of queue ticket received from replica nodes
+const maxTicketIDLength = 64
+
+// provisionalSessionTimeout of new tickets admitted directly, longer than the admitted cache of gateway (10 seconds),
+// so that the session is kept until the ticket comes back with cookie
+const provisionalSessionTimeout = 30
+
+// waitingRoomState is the admission state of an application, kept in the memory of primary node,
+// the visitors queue again if the primary node restarts
+type waitingRoomState struct {
+	mutex sync.Mutex
+	// active map[ticketID]*activeSession of admitted visitors
+	active map[string]*activeSession
+	// queue list of *queuedTicket in order of arrival
+	queue *list.List
+	// queued map[ticketID]*list.Element of queue
+	queued map[string]*list.Element
+	// confirmedQueued is the count of confirmed tickets in queue
+	confirmedQueued int64
+	lastSweep       int64
+}
+
+type activeSession struct {
+	lastSeen int64
+	// confirmed after the ticket comes back with cookie, the provisional sessions expire in provisionalSessionTimeout,
+	// so that the clients discarding cookies do not hold the sessions
+	confirmed bool
+}
+
+type queuedTicket struct {
+	id string
+	// order is continuous in the queue, so that the position is the difference from the front
+	order    int64
+	lastSeen int64
+	// confirmed after the ticket comes back with cookie, only confirmed tickets are admitted from queue,
+	// so that the clients discarding cookies never take the sessions of the waiting visitors
+	confirmed bool
+}
+
+// waitingRooms map[appID int64]*waitingRoomState, primary node only
+var waitingRooms = sync.Map{}
+
+func getWaitingRoomState(appID int64) *waitingRoomState {
+	stateI, _ := waitingRooms.LoadOrStore(appID, &waitingRoomState{
+		active: map[string]*activeSession{},
+		queue:  list.New(),
+		queued: map[string]*list.Element{},
+	})
+	return stateI.(*waitingRoomState)
+}
+
+// check admit the ticket if there are free sessions and no confirmed one ahead, or return its position,
+// a new ticket is admitted provisionally so that its first request (such as POST) is not answered with the position page
+func (state *waitingRoomState) check(room *models.WaitingRoom, ticketID string, now int64) *models.WaitingRoomStatus {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if now != state.lastSweep {
+		state.sweep(room, now)
+	}
+	status := &models.WaitingRoomStatus{RefreshInterval: room.RefreshInterval}
+	if session, ok := state.active[ticketID]; ok {
+		session.lastSeen = now
+		session.confirmed = true
+		status.Admitted = true
+		return status
+	}
+	elem, ok := state.queued[ticketID]
+	if ok {
+		if ticket := elem.Value.(*queuedTicket); !ticket.confirmed {
+			ticket.confirmed = true
+			state.confirmedQueued++
+		}
+	} else {
+		if state.confirmedQueued == 0 && int64(len(state.active)) < room.MaxSessions {
+			state.active[ticketID] = &activeSession{lastSeen: now}
+			status.Admitted = true
+			return status
+		}
+		ticket := &queuedTicket{id: ticketID, order: 1}
+		if back := state.queue.Back(); back != nil {
+			ticket.order = back.Value.(*queuedTicket).order + 1
+		}
+		elem = state.queue.PushBack(ticket)
+		state.queued[ticketID] = elem
+	}
+	ticket := elem.Value.(*queuedTicket)
+	ticket.lastSeen = now
+	state.admit(room.MaxSessions, now)
+	if _, ok := state.active[ticketID]; ok {
+		status.Admitted = true
+		return status
+	}
+	status.Position = ticket.order - state.queue.Front().Value.(*queuedTicket).order + 1
+	return status
+}
+
+// admit the confirmed tickets in order while sessions are available, the unconfirmed are skipped until expired,
+// called with mutex locked
+func (state *waitingRoomState) admit(maxSessions int64, now int64) {
+	for elem := state.queue.Front(); elem != nil && state.confirmedQueued > 0 && int64(len(state.active)) < maxSessions; {
+		next := elem.Next()
+		ticket := elem.Value.(*queuedTicket)
+		if ticket.confirmed {
+			state.removeQueued(elem)
+			state.active[ticket.id] = &activeSession{lastSeen: now, confirmed: true}
+		}
+		elem = next
+	}
+}
+
+// removeQueued remove the ticket from queue, called with mutex locked
+func (state *waitingRoomState) removeQueued(elem *list.Element) {
+	ticket := elem.Value.(*queuedTicket)
+	if ticket.confirmed {
+		state.confirmedQueued--
+	}
+	state.queue.Remove(elem)
+	delete(state.queued, ticket.id)
+}
+
+// sweep release the idle sessions, remove the tickets which left the position page, and renumber the queue,
+// called at most once per second with mutex locked
+func (state *waitingRoomState) sweep(room *models.WaitingRoom, now int64) {
+	for ticketID, session := range state.active {
+		timeout := room.SessionTimeout
+		if !session.confirmed {
+			timeout = provisionalSessionTimeout
+		}
+		if now-session.lastSeen > timeout {
+			delete(state.active, ticketID)
+		}
+	}
+	// The position page is refreshed every RefreshInterval seconds
+	var order int64
+	for elem := state.queue.Front(); elem != nil; {
+		next := elem.Next()
+		ticket := elem.Value.(*queuedTicket)
+		timeout := 3*room.RefreshInterval + 10
+		if !ticket.confirmed {
+			timeout = room.RefreshInterval + 5
+		}
+		if now-ticket.lastSeen > timeout {
+			state.removeQueued(elem)
+		} else {
+			order++
+			ticket.order = order
+		}
+		elem = next
+	}
+	state.lastSweep = now
+}
+
+// CheckWaitingRoom admit or queue the ticket, replica nodes ask the primary node so that all nodes share the same queue
+func CheckWaitingRoom(appID int64, ticketID string) (*models.WaitingRoomStatus, error) {
+	if !data.IsPrimary {
+		return RPCCheckWaitingRoom(appID, ticketID)
+	}
+	if len(ticketID) == 0 || len(ticketID) > maxTicketIDLength {
+		return nil, errors.New("invalid ticket")
+	}
+	app, err := GetApplicationByID(appID)
+	if err != nil {
+		return nil, err
+	}
+	room := app.WaitingRoom
+	if room == nil || !room.Enabled {
+		return &models.WaitingRoomStatus{Admitted: true}, nil
+	}
+	return getWaitingRoomState(appID).check(room, ticketID, time.Now().Unix()), nil
+}
+
+// RPCCheckWaitingRoom ...
+func RPCCheckWaitingRoom(appID int64, ticketID string) (*models.WaitingRoomStatus, error) {
+	rpcRequest := &models.RPCRequest{
+		Action: "check_waiting_room", ObjectID: appID, Object: ticketID}
+	resp, err := data.GetRPCResponse(rpcRequest)
+	if err != nil {
+		return nil, err
+	}
+	rpcStatus := &models.RPCWaitingRoomStatus{}
+	if err = json.Unmarshal(resp, rpcStatus); err != nil {
+		return nil, err
+	}
+	if rpcStatus.Error != nil {
+		return nil, errors.New(*rpcStatus.Error)
+	}
+	if rpcStatus.Object == nil {
+		return nil, errors.New("empty waiting room status")
+	}
+	return rpcStatus.Object, nil
+}
+
+// LoadWaitingRooms load waiting rooms of all applications, primary node only
+func LoadWaitingRooms() {
+	for _, app := range Apps {
+		app.WaitingRoom = data.DAL.SelectWaitingRoomByAppID(app.ID)
+	}
+}
+
+// UpdateWaitingRoom update the waiting room of the application, the queue is cleared if disabled
+// waitingRoom example: {"id":0,"enabled":true,"max_sessions":1000,"session_timeout":300,"refresh_interval":10}
+func UpdateWaitingRoom(app *models.Application, roomMap map[string]interface{}) error {
+	room := &models.WaitingRoom{
+		AppID:           app.ID,
+		SessionTimeout:  300,
+		RefreshInterval: 10,
+	}
+	if app.WaitingRoom != nil {
+		room.ID = app.WaitingRoom.ID
+	}
+	if enabled, ok := roomMap["enabled"].(bool); ok {
+		room.Enabled = enabled
+	}
+	if maxSessions, ok := roomMap["max_sessions"].(float64); ok && maxSessions >= 0 {
+		room.MaxSessions = int64(maxSessions)
+	}
+	// The admitted visitors are checked with primary node every 10 seconds by gateway
+	if sessionTimeout, ok := roomMap["session_timeout"].(float64); ok && sessionTimeout >= 30 {
+		room.SessionTimeout = int64(sessionTimeout)
+	}
+	if refreshInterval, ok := roomMap["refresh_interval"].(float64); ok && refreshInterval >= 1 {
+		room.RefreshInterval = int64(refreshInterval)
+	}
+	var err error
+	if room.ID == 0 {
+		room.ID, err = data.DAL.InsertWaitingRoom(room)
+	} else {
+		err = data.DAL.UpdateWaitingRoom(room)
+	}
+	if err != nil {
+		return err
+	}
+	if !room.Enabled {
+		waitingRooms.Delete(app.ID)
+	}
+	app.WaitingRoom = room
+	return nil
+}
+
+// DeleteWaitingRoomByApp ...
+func DeleteWaitingRoomByApp(appID int64) {
+	waitingRooms.Delete(appID)
+	err := data.DAL.DeleteWaitingRoomByAppID(appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteWaitingRoomByAppID", err)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-31 20:12:37
+ * @Last Modified: U2, 2021-05-31 20:12:37
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsCACerts create ca_certs, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsCACerts() error {
+	const sqlCreateTableIfNotExistsCACerts = `CREATE TABLE IF NOT EXISTS "ca_certs"("id" bigserial PRIMARY KEY,"name" VARCHAR(256) NOT NULL,"ca_content" text NOT NULL,"crl_content" text NOT NULL DEFAULT '',"expire_time" bigint default 0,"crl_next_update" bigint default 0,"description" VARCHAR(256) NOT NULL DEFAULT '')`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsCACerts)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsCACerts", err)
+	}
+	return err
+}
+
+// SelectCACerts ...
+func (dal *MyDAL) SelectCACerts() []*models.CACertItem {
+	caCerts := []*models.CACertItem{}
+	const sqlSelectCACerts = `SELECT "id","name","ca_content","crl_content","expire_time","crl_next_update","description" FROM "ca_certs"`
+	rows, err := dal.db.Query(sqlSelectCACerts)
+	if err != nil {
+		utils.DebugPrintln("SelectCACerts", err)
+		return caCerts
+	}
+	defer rows.Close()
+	for rows.Next() {
+		caCert := &models.CACertItem{}
+		err = rows.Scan(&caCert.ID, &caCert.Name, &caCert.CAContent, &caCert.CRLContent, &caCert.ExpireTime, &caCert.CRLNextUpdate, &caCert.Description)
+		if err != nil {
+			utils.DebugPrintln("SelectCACerts rows.Scan", err)
+		}
+		caCerts = append(caCerts, caCert)
+	}
+	return caCerts
+}
+
+// InsertCACert ...
+func (dal *MyDAL) InsertCACert(caCert *models.CACertItem) (newID int64, err error) {
+	const sqlInsertCACert = `INSERT INTO "ca_certs"("name","ca_content","crl_content","expire_time","crl_next_update","description") VALUES($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertCACert, caCert.Name, caCert.CAContent, caCert.CRLContent, caCert.ExpireTime, caCert.CRLNextUpdate, caCert.Description).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertCACert", err)
+	}
+	return newID, err
+}
+
+// UpdateCACert ...
+func (dal *MyDAL) UpdateCACert(caCert *models.CACertItem) error {
+	const sqlUpdateCACert = `UPDATE "ca_certs" SET "name"=$1,"ca_content"=$2,"crl_content"=$3,"expire_time"=$4,"crl_next_update"=$5,"description"=$6 WHERE "id"=$7`
+	_, err := dal.db.Exec(sqlUpdateCACert, caCert.Name, caCert.CAContent, caCert.CRLContent, caCert.ExpireTime, caCert.CRLNextUpdate, caCert.Description, caCert.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateCACert", err)
+	}
+	return err
+}
+
+// DeleteCACertByID ...
+func (dal *MyDAL) DeleteCACertByID(id int64) error {
+	const sqlDeleteCACertByID = `DELETE FROM "ca_certs" WHERE "id"=$1`
+	_, err := dal.db.Exec(sqlDeleteCACertByID, id)
+	if err != nil {
+		utils.DebugPrintln("DeleteCACertByID", err)
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-05 20:16:38
+ * @Last Modified: U2, 2021-06-05 20:16:38
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsCacheRules create cache_rules, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsCacheRules() error {
+	const sqlCreateTableIfNotExistsCacheRules = `CREATE TABLE IF NOT EXISTS "cache_rules"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"priority" bigint default 0,"methods" VARCHAR(64) NOT NULL DEFAULT '',"path_pattern" VARCHAR(512) NOT NULL DEFAULT '',"query_params" VARCHAR(512) NOT NULL DEFAULT '',"status_codes" VARCHAR(128) NOT NULL DEFAULT '',"ttl" bigint default 0,"stale_while_revalidate" bigint default 0,"ignore_origin" boolean default false,"key_query_params" VARCHAR(512) NOT NULL DEFAULT '',"key_headers" VARCHAR(512) NOT NULL DEFAULT '',"key_cookies" VARCHAR(512) NOT NULL DEFAULT '',"bypass_headers" VARCHAR(512) NOT NULL DEFAULT '',"bypass_cookies" VARCHAR(512) NOT NULL DEFAULT '',"bypass_query_params" VARCHAR(512) NOT NULL DEFAULT '')`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsCacheRules)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsCacheRules", err)
+	}
+	return err
+}
+
+// SelectCacheRulesByAppID ordered by priority
+func (dal *MyDAL) SelectCacheRulesByAppID(appID int64) []*models.CacheRule {
+	cacheRules := []*models.CacheRule{}
+	const sqlSelectCacheRulesByAppID = `SELECT "id","priority","methods","path_pattern","query_params","status_codes","ttl","stale_while_revalidate","ignore_origin","key_query_params","key_headers","key_cookies","bypass_headers","bypass_cookies","bypass_query_params" FROM "cache_rules" WHERE "app_id"=$1 ORDER BY "priority","id"`
+	rows, err := dal.db.Query(sqlSelectCacheRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("SelectCacheRulesByAppID", err)
+		return cacheRules
+	}
+	defer rows.Close()
+	for rows.Next() {
+		cacheRule := &models.CacheRule{AppID: appID}
+		err = rows.Scan(&cacheRule.ID, &cacheRule.Priority, &cacheRule.Methods, &cacheRule.PathPattern, &cacheRule.QueryParams, &cacheRule.StatusCodes, &cacheRule.TTL, &cacheRule.StaleWhileRevalidate, &cacheRule.IgnoreOrigin, &cacheRule.KeyQueryParams, &cacheRule.KeyHeaders, &cacheRule.KeyCookies, &cacheRule.BypassHeaders, &cacheRule.BypassCookies, &cacheRule.BypassQueryParams)
+		if err != nil {
+			utils.DebugPrintln("SelectCacheRulesByAppID rows.Scan", err)
+		}
+		cacheRules = append(cacheRules, cacheRule)
+	}
+	return cacheRules
+}
+
+// InsertCacheRule ...
+func (dal *MyDAL) InsertCacheRule(cacheRule *models.CacheRule) (newID int64, err error) {
+	const sqlInsertCacheRule = `INSERT INTO "cache_rules"("app_id","priority","methods","path_pattern","query_params","status_codes","ttl","stale_while_revalidate","ignore_origin","key_query_params","key_headers","key_cookies","bypass_headers","bypass_cookies","bypass_query_params") VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertCacheRule, cacheRule.AppID, cacheRule.Priority, cacheRule.Methods, cacheRule.PathPattern, cacheRule.QueryParams, cacheRule.StatusCodes, cacheRule.TTL, cacheRule.StaleWhileRevalidate, cacheRule.IgnoreOrigin, cacheRule.KeyQueryParams, cacheRule.KeyHeaders, cacheRule.KeyCookies, cacheRule.BypassHeaders, cacheRule.BypassCookies, cacheRule.BypassQueryParams).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertCacheRule", err)
+	}
+	return newID, err
+}
+
+// UpdateCacheRule ...
+func (dal *MyDAL) UpdateCacheRule(cacheRule *models.CacheRule) error {
+	const sqlUpdateCacheRule = `UPDATE "cache_rules" SET "app_id"=$1,"priority"=$2,"methods"=$3,"path_pattern"=$4,"query_params"=$5,"status_codes"=$6,"ttl"=$7,"stale_while_revalidate"=$8,"ignore_origin"=$9,"key_query_params"=$10,"key_headers"=$11,"key_cookies"=$12,"bypass_headers"=$13,"bypass_cookies"=$14,"bypass_query_params"=$15 WHERE "id"=$16`
+	_, err := dal.db.Exec(sqlUpdateCacheRule, cacheRule.AppID, cacheRule.Priority, cacheRule.Methods, cacheRule.PathPattern, cacheRule.QueryParams, cacheRule.StatusCodes, cacheRule.TTL, cacheRule.StaleWhileRevalidate, cacheRule.IgnoreOrigin, cacheRule.KeyQueryParams, cacheRule.KeyHeaders, cacheRule.KeyCookies, cacheRule.BypassHeaders, cacheRule.BypassCookies, cacheRule.BypassQueryParams, cacheRule.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateCacheRule", err)
+	}
+	return err
+}
+
+// DeleteCacheRuleByID ...
+func (dal *MyDAL) DeleteCacheRuleByID(id int64) error {
+	const sqlDeleteCacheRuleByID = `DELETE FROM "cache_rules" WHERE "id"=$1`
+	_, err := dal.db.Exec(sqlDeleteCacheRuleByID, id)
+	if err != nil {
+		utils.DebugPrintln("DeleteCacheRuleByID", err)
+	}
+	return err
+}
+
+// DeleteCacheRulesByAppID ...
+func (dal *MyDAL) DeleteCacheRulesByAppID(appID int64) error {
+	const sqlDeleteCacheRulesByAppID = `DELETE FROM "cache_rules" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteCacheRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteCacheRulesByAppID", err)
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-31 20:26:14
+ * @Last Modified: U2, 2021-05-31 20:26:14
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsClientAuths create client_auths, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsClientAuths() error {
+	const sqlCreateTableIfNotExistsClientAuths = `CREATE TABLE IF NOT EXISTS "client_auths"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"enabled" boolean default false,"mode" bigint default 2,"ca_cert_id" bigint default 0,"crl_check" boolean default false,"forward_headers" boolean default true)`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsClientAuths)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsClientAuths", err)
+	}
+	return err
+}
+
+// SelectClientAuthByAppID return nil if not configured
+func (dal *MyDAL) SelectClientAuthByAppID(appID int64) *models.ClientAuth {
+	const sqlSelectClientAuthByAppID = `SELECT "id","enabled","mode","ca_cert_id","crl_check","forward_headers" FROM "client_auths" WHERE "app_id"=$1 LIMIT 1`
+	clientAuth := &models.ClientAuth{AppID: appID}
+	err := dal.db.QueryRow(sqlSelectClientAuthByAppID, appID).Scan(
+		&clientAuth.ID,
+		&clientAuth.Enabled,
+		&clientAuth.Mode,
+		&clientAuth.CACertID,
+		&clientAuth.CRLCheck,
+		&clientAuth.ForwardHeaders)
+	if err != nil {
+		return nil
+	}
+	return clientAuth
+}
+
+// InsertClientAuth ...
+func (dal *MyDAL) InsertClientAuth(clientAuth *models.ClientAuth) (newID int64, err error) {
+	const sqlInsertClientAuth = `INSERT INTO "client_auths"("app_id","enabled","mode","ca_cert_id","crl_check","forward_headers") VALUES($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertClientAuth, clientAuth.AppID, clientAuth.Enabled, clientAuth.Mode, clientAuth.CACertID, clientAuth.CRLCheck, clientAuth.ForwardHeaders).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertClientAuth", err)
+	}
+	return newID, err
+}
+
+// UpdateClientAuth ...
+func (dal *MyDAL) UpdateClientAuth(clientAuth *models.ClientAuth) error {
+	const sqlUpdateClientAuth = `UPDATE "client_auths" SET "app_id"=$1,"enabled"=$2,"mode"=$3,"ca_cert_id"=$4,"crl_check"=$5,"forward_headers"=$6 WHERE "id"=$7`
+	_, err := dal.db.Exec(sqlUpdateClientAuth, clientAuth.AppID, clientAuth.Enabled, clientAuth.Mode, clientAuth.CACertID, clientAuth.CRLCheck, clientAuth.ForwardHeaders, clientAuth.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateClientAuth", err)
+	}
+	return err
+}
+
+// DeleteClientAuthByAppID ...
+func (dal *MyDAL) DeleteClientAuthByAppID(appID int64) error {
+	const sqlDeleteClientAuthByAppID = `DELETE FROM "client_auths" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteClientAuthByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteClientAuthByAppID", err)
+	}
+	return err
+}
+
+// CreateTableIfNotExistsClientCertRules create client_cert_rules, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsClientCertRules() error {
+	const sqlCreateTableIfNotExistsClientCertRules = `CREATE TABLE IF NOT EXISTS "client_cert_rules"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"path_prefix" VARCHAR(256) NOT NULL DEFAULT '/',"subject_pattern" VARCHAR(512) NOT NULL)`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsClientCertRules)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsClientCertRules", err)
+	}
+	return err
+}
+
+// SelectClientCertRulesByAppID ...
+func (dal *MyDAL) SelectClientCertRulesByAppID(appID int64) []*models.ClientCertRule {
+	clientCertRules := []*models.ClientCertRule{}
+	const sqlSelectClientCertRulesByAppID = `SELECT "id","path_prefix","subject_pattern" FROM "client_cert_rules" WHERE "app_id"=$1 ORDER BY "id"`
+	rows, err := dal.db.Query(sqlSelectClientCertRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("SelectClientCertRulesByAppID", err)
+		return clientCertRules
+	}
+	defer rows.Close()
+	for rows.Next() {
+		clientCertRule := &models.ClientCertRule{AppID: appID}
+		err = rows.Scan(&clientCertRule.ID, &clientCertRule.PathPrefix, &clientCertRule.SubjectPattern)
+		if err != nil {
+			utils.DebugPrintln("SelectClientCertRulesByAppID rows.Scan", err)
+		}
+		clientCertRules = append(clientCertRules, clientCertRule)
+	}
+	return clientCertRules
+}
+
+// InsertClientCertRule ...
+func (dal *MyDAL) InsertClientCertRule(clientCertRule *models.ClientCertRule) (newID int64, err error) {
+	const sqlInsertClientCertRule = `INSERT INTO "client_cert_rules"("app_id","path_prefix","subject_pattern") VALUES($1,$2,$3) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertClientCertRule, clientCertRule.AppID, clientCertRule.PathPrefix, clientCertRule.SubjectPattern).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertClientCertRule", err)
+	}
+	return newID, err
+}
+
+// UpdateClientCertRule ...
+func (dal *MyDAL) UpdateClientCertRule(clientCertRule *models.ClientCertRule) error {
+	const sqlUpdateClientCertRule = `UPDATE "client_cert_rules" SET "app_id"=$1,"path_prefix"=$2,"subject_pattern"=$3 WHERE "id"=$4`
+	_, err := dal.db.Exec(sqlUpdateClientCertRule, clientCertRule.AppID, clientCertRule.PathPrefix, clientCertRule.SubjectPattern, clientCertRule.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateClientCertRule", err)
+	}
+	return err
+}
+
+// DeleteClientCertRuleByID ...
+func (dal *MyDAL) DeleteClientCertRuleByID(id int64) error {
+	const sqlDeleteClientCertRuleByID = `DELETE FROM "client_cert_rules" WHERE "id"=$1`
+	_, err := dal.db.Exec(sqlDeleteClientCertRuleByID, id)
+	if err != nil {
+		utils.DebugPrintln("DeleteClientCertRuleByID", err)
+	}
+	return err
+}
+
+// DeleteClientCertRulesByAppID ...
+func (dal *MyDAL) DeleteClientCertRulesByAppID(appID int64) error {
+	const sqlDeleteClientCertRulesByAppID = `DELETE FROM "client_cert_rules" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteClientCertRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteClientCertRulesByAppID", err)
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-29 19:42:08
+ * @Last Modified: U2, 2021-05-29 19:42:08
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsCompressions create compressions, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsCompressions() error {
+	const sqlCreateTableIfNotExistsCompressions = `CREATE TABLE IF NOT EXISTS "compressions"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"enabled" boolean default false,"gzip" boolean default true,"brotli" boolean default true,"mime_types" VARCHAR(1024) NOT NULL DEFAULT '',"min_size" bigint default 1024)`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsCompressions)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsCompressions", err)
+	}
+	return err
+}
+
+// SelectCompressionByAppID return nil if not configured
+func (dal *MyDAL) SelectCompressionByAppID(appID int64) *models.Compression {
+	const sqlSelectCompressionByAppID = `SELECT "id","enabled","gzip","brotli","mime_types","min_size" FROM "compressions" WHERE "app_id"=$1 LIMIT 1`
+	compression := &models.Compression{AppID: appID}
+	err := dal.db.QueryRow(sqlSelectCompressionByAppID, appID).Scan(
+		&compression.ID,
+		&compression.Enabled,
+		&compression.Gzip,
+		&compression.Brotli,
+		&compression.MIMETypes,
+		&compression.MinSize)
+	if err != nil {
+		return nil
+	}
+	return compression
+}
+
+// InsertCompression ...
+func (dal *MyDAL) InsertCompression(compression *models.Compression) (newID int64, err error) {
+	const sqlInsertCompression = `INSERT INTO "compressions"("app_id","enabled","gzip","brotli","mime_types","min_size") VALUES($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertCompression, compression.AppID, compression.Enabled, compression.Gzip, compression.Brotli, compression.MIMETypes, compression.MinSize).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertCompression", err)
+	}
+	return newID, err
+}
+
+// UpdateCompression ...
+func (dal *MyDAL) UpdateCompression(compression *models.Compression) error {
+	const sqlUpdateCompression = `UPDATE "compressions" SET "app_id"=$1,"enabled"=$2,"gzip"=$3,"brotli"=$4,"mime_types"=$5,"min_size"=$6 WHERE "id"=$7`
+	_, err := dal.db.Exec(sqlUpdateCompression, compression.AppID, compression.Enabled, compression.Gzip, compression.Brotli, compression.MIMETypes, compression.MinSize, compression.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateCompression", err)
+	}
+	return err
+}
+
+// DeleteCompressionByAppID ...
+func (dal *MyDAL) DeleteCompressionByAppID(appID int64) error {
+	const sqlDeleteCompressionByAppID = `DELETE FROM "compressions" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteCompressionByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteCompressionByAppID", err)
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-11 19:48:23
+ * @Last Modified: U2, 2021-06-11 19:48:23
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsConcurrencyLimits create concurrency_limits, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsConcurrencyLimits() error {
+	const sqlCreateTableIfNotExistsConcurrencyLimits = `CREATE TABLE IF NOT EXISTS "concurrency_limits"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"enabled" boolean default false,"max_concurrency" bigint default 0,"queue_size" bigint default 0,"queue_timeout" bigint default 0,"retry_after" bigint default 0,"priority_paths" VARCHAR(1024) NOT NULL DEFAULT '',"error_page" VARCHAR(16384) NOT NULL DEFAULT '')`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsConcurrencyLimits)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsConcurrencyLimits", err)
+	}
+	return err
+}
+
+// SelectConcurrencyLimitByAppID return nil if not configured
+func (dal *MyDAL) SelectConcurrencyLimitByAppID(appID int64) *models.ConcurrencyLimit {
+	const sqlSelectConcurrencyLimitByAppID = `SELECT "id","enabled","max_concurrency","queue_size","queue_timeout","retry_after","priority_paths","error_page" FROM "concurrency_limits" WHERE "app_id"=$1 LIMIT 1`
+	limit := &models.ConcurrencyLimit{AppID: appID}
+	err := dal.db.QueryRow(sqlSelectConcurrencyLimitByAppID, appID).Scan(
+		&limit.ID,
+		&limit.Enabled,
+		&limit.MaxConcurrency,
+		&limit.QueueSize,
+		&limit.QueueTimeout,
+		&limit.RetryAfter,
+		&limit.PriorityPaths,
+		&limit.ErrorPage)
+	if err != nil {
+		return nil
+	}
+	return limit
+}
+
+// InsertConcurrencyLimit ...
+func (dal *MyDAL) InsertConcurrencyLimit(limit *models.ConcurrencyLimit) (newID int64, err error) {
+	const sqlInsertConcurrencyLimit = `INSERT INTO "concurrency_limits"("app_id","enabled","max_concurrency","queue_size","queue_timeout","retry_after","priority_paths","error_page") VALUES($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertConcurrencyLimit, limit.AppID, limit.Enabled, limit.MaxConcurrency, limit.QueueSize, limit.QueueTimeout, limit.RetryAfter, limit.PriorityPaths, limit.ErrorPage).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertConcurrencyLimit", err)
+	}
+	return newID, err
+}
+
+// UpdateConcurrencyLimit ...
+func (dal *MyDAL) UpdateConcurrencyLimit(limit *models.ConcurrencyLimit) error {
+	const sqlUpdateConcurrencyLimit = `UPDATE "concurrency_limits" SET "app_id"=$1,"enabled"=$2,"max_concurrency"=$3,"queue_size"=$4,"queue_timeout"=$5,"retry_after"=$6,"priority_paths"=$7,"error_page"=$8 WHERE "id"=$9`
+	_, err := dal.db.Exec(sqlUpdateConcurrencyLimit, limit.AppID, limit.Enabled, limit.MaxConcurrency, limit.QueueSize, limit.QueueTimeout, limit.RetryAfter, limit.PriorityPaths, limit.ErrorPage, limit.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateConcurrencyLimit", err)
+	}
+	return err
+}
+
+// DeleteConcurrencyLimitByAppID ...
+func (dal *MyDAL) DeleteConcurrencyLimitByAppID(appID int64) error {
+	const sqlDeleteConcurrencyLimitByAppID = `DELETE FROM "concurrency_limits" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteConcurrencyLimitByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteConcurrencyLimitByAppID", err)
+	}
+	return err
+}
@@ -13,11 +13,11 @@ import (
 )
 
 // UpdateDestinationNode ...
-func (dal *MyDAL) UpdateDestinationNode(routeType int64, requestRoute string, backendRoute string, destination string, appID int64, nodeID int64, id int64) error {
-	const sqlUpdateDestinationNode = `UPDATE "destinations" SET "route_type"=$1,"request_route"=$2,"backend_route"=$3,"destination"=$4,"app_id"=$5,"node_id"=$6 WHERE "id"=$7`
+func (dal *MyDAL) UpdateDestinationNode(routeType int64, requestRoute string, backendRoute string, destination string, appID int64, nodeID int64, weight int64, proxyProtocol int64, groupName string, maxConcurrency int64, id int64) error {
+	const sqlUpdateDestinationNode = `UPDATE "destinations" SET "route_type"=$1,"request_route"=$2,"backend_route"=$3,"destination"=$4,"app_id"=$5,"node_id"=$6,"weight"=$7,"proxy_protocol"=$8,"group_name"=$9,"max_concurrency"=$10 WHERE "id"=$11`
 	stmt, _ := dal.db.Prepare(sqlUpdateDestinationNode)
 	defer stmt.Close()
-	_, err := stmt.Exec(routeType, requestRoute, backendRoute, destination, appID, nodeID, id)
+	_, err := stmt.Exec(routeType, requestRoute, backendRoute, destination, appID, nodeID, weight, proxyProtocol, groupName, maxConcurrency, id)
 	if err != nil {
 		utils.DebugPrintln("UpdateDestinationNode", err)
 	}
@@ -37,7 +37,7 @@ func (dal *MyDAL) ExistsDestinationID(id int64) bool {
 
 // CreateTableIfNotExistsDestinations ...
 func (dal *MyDAL) CreateTableIfNotExistsDestinations() error {
-	const sqlCreateTableIfNotExistsDestinations = `CREATE TABLE IF NOT EXISTS "destinations"("id" bigserial PRIMARY KEY,"route_type" bigint default 1,"request_route" VARCHAR(128) NOT NULL DEFAULT '/',"backend_route" VARCHAR(128) NOT NULL DEFAULT '/',"destination" VARCHAR(128) NOT NULL,"app_id" bigint NOT NULL,"node_id" bigint NOT NULL)`
+	const sqlCreateTableIfNotExistsDestinations = `CREATE TABLE IF NOT EXISTS "destinations"("id" bigserial PRIMARY KEY,"route_type" bigint default 1,"request_route" VARCHAR(128) NOT NULL DEFAULT '/',"backend_route" VARCHAR(128) NOT NULL DEFAULT '/',"destination" VARCHAR(128) NOT NULL,"app_id" bigint NOT NULL,"node_id" bigint NOT NULL,"weight" bigint default 1,"proxy_protocol" bigint default 0,"group_name" VARCHAR(64) NOT NULL DEFAULT '',"max_concurrency" bigint default 0)`
 	_, err := dal.db.Exec(sqlCreateTableIfNotExistsDestinations)
 	if err != nil {
 		utils.DebugPrintln("CreateTableIfNotExistsDestinations", err)
@@ -48,7 +48,7 @@ func (dal *MyDAL) CreateTableIfNotExistsDestinations() error {
 // SelectDestinationsByAppID ...
 func (dal *MyDAL) SelectDestinationsByAppID(appID int64) []*models.Destination {
 	dests := []*models.Destination{}
-	const sqlSelectDestinationsByAppID = `SELECT "id","route_type","request_route","backend_route","destination","node_id" FROM "destinations" WHERE "app_id"=$1`
+	const sqlSelectDestinationsByAppID = `SELECT "id","route_type","request_route","backend_route","destination","node_id","weight","proxy_protocol","group_name","max_concurrency" FROM "destinations" WHERE "app_id"=$1`
 	rows, err := dal.db.Query(sqlSelectDestinationsByAppID, appID)
 	if err != nil {
 		utils.DebugPrintln("SelectDestinationsByAppID", err)
@@ -56,8 +56,8 @@ func (dal *MyDAL) SelectDestinationsByAppID(appID int64) []*models.Destination {
 	}
 	defer rows.Close()
 	for rows.Next() {
-		dest := &models.Destination{AppID: appID, Online: true}
-		err = rows.Scan(&dest.ID, &dest.RouteType, &dest.RequestRoute, &dest.BackendRoute, &dest.Destination, &dest.NodeID)
+		dest := &models.Destination{AppID: appID, Online: models.NewOnlineStatus(true)}
+		err = rows.Scan(&dest.ID, &dest.RouteType, &dest.RequestRoute, &dest.BackendRoute, &dest.Destination, &dest.NodeID, &dest.Weight, &dest.ProxyProtocol, &dest.Group, &dest.MaxConcurrency)
 		if err != nil {
 			utils.DebugPrintln("SelectDestinationsByAppID rows.Scan", err)
 		}
@@ -67,9 +67,9 @@ func (dal *MyDAL) SelectDestinationsByAppID(appID int64) []*models.Destination {
 }
 
 // InsertDestination ...
-func (dal *MyDAL) InsertDestination(routeType int64, requestRoute string, backendRoute string, dest string, appID int64, nodeID int64) (newID int64, err error) {
-	const sqlInsertDestination = `INSERT INTO "destinations"("route_type","request_route","backend_route","destination","app_id","node_id") VALUES($1,$2,$3,$4,$5,$6) RETURNING "id"`
-	err = dal.db.QueryRow(sqlInsertDestination, routeType, requestRoute, backendRoute, dest, appID, nodeID).Scan(&newID)
+func (dal *MyDAL) InsertDestination(routeType int64, requestRoute string, backendRoute string, dest string, appID int64, nodeID int64, weight int64, proxyProtocol int64, groupName string, maxConcurrency int64) (newID int64, err error) {
+	const sqlInsertDestination = `INSERT INTO "destinations"("route_type","request_route","backend_route","destination","app_id","node_id","weight","proxy_protocol","group_name","max_concurrency") VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertDestination, routeType, requestRoute, backendRoute, dest, appID, nodeID, weight, proxyProtocol, groupName, maxConcurrency).Scan(&newID)
 	if err != nil {
 		utils.DebugPrintln("InsertDestination", err)
 	}
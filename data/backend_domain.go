@@ -15,9 +15,9 @@ import (
 const (
 	sqlCreateTableIfNotExistsDomains = `CREATE TABLE IF NOT EXISTS "domains"("id" bigserial PRIMARY KEY, "name" VARCHAR(256) NOT NULL, "app_id" bigint NOT NULL, "cert_id" bigint, "redirect" boolean, "location" VARCHAR(256))`
 	sqlSelectDomainsCountByCertID    = `SELECT COUNT(1) FROM "domains" WHERE "cert_id"=$1`
-	sqlSelectDomains                 = `SELECT "id", "name", "app_id", "cert_id", "redirect", "location" FROM "domains"`
-	sqlInsertDomain                  = `INSERT INTO "domains"("name", "app_id", "cert_id", "redirect", "location") VALUES($1,$2,$3,$4,$5) RETURNING "id"`
-	sqlUpdateDomain                  = `UPDATE "domains" SET "name"=$1,"app_id"=$2,"cert_id"=$3,"redirect"=$4,"location"=$5 WHERE "id"=$6`
+	sqlSelectDomains                 = `SELECT "id", "name", "app_id", "cert_id", "redirect", "location", "tls_profile_id", "redirect_status", "preserve_path", "preserve_query" FROM "domains"`
+	sqlInsertDomain                  = `INSERT INTO "domains"("name", "app_id", "cert_id", "redirect", "location", "tls_profile_id", "redirect_status", "preserve_path", "preserve_query") VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"`
+	sqlUpdateDomain                  = `UPDATE "domains" SET "name"=$1,"app_id"=$2,"cert_id"=$3,"redirect"=$4,"location"=$5,"tls_profile_id"=$6,"redirect_status"=$7,"preserve_path"=$8,"preserve_query"=$9 WHERE "id"=$10`
 	sqlDeleteDomainByDomainID        = `DELETE FROM "domains" WHERE "id"=$1`
 	sqlDeleteDomainByAppID           = `DELETE FROM "domains" WHERE "app_id"=$1`
 )
@@ -38,12 +38,23 @@ func (dal *MyDAL) SelectDomains() []*models.DBDomain {
 	dbDomains := []*models.DBDomain{}
 	for rows.Next() {
 		dbDomain := &models.DBDomain{}
-		_ = rows.Scan(&dbDomain.ID, &dbDomain.Name, &dbDomain.AppID, &dbDomain.CertID, &dbDomain.Redirect, &dbDomain.Location)
+		_ = rows.Scan(&dbDomain.ID, &dbDomain.Name, &dbDomain.AppID, &dbDomain.CertID, &dbDomain.Redirect, &dbDomain.Location, &dbDomain.TLSProfileID, &dbDomain.RedirectStatus, &dbDomain.PreservePath, &dbDomain.PreserveQuery)
 		dbDomains = append(dbDomains, dbDomain)
 	}
 	return dbDomains
 }
 
+// SelectDomainsCountByTLSProfileID ...
+func (dal *MyDAL) SelectDomainsCountByTLSProfileID(tlsProfileID int64) int64 {
+	const sqlSelectDomainsCountByTLSProfileID = `SELECT COUNT(1) FROM "domains" WHERE "tls_profile_id"=$1`
+	var count int64
+	err := dal.db.QueryRow(sqlSelectDomainsCountByTLSProfileID, tlsProfileID).Scan(&count)
+	if err != nil {
+		utils.DebugPrintln("SelectDomainsCountByTLSProfileID", err)
+	}
+	return count
+}
+
 // SelectDomainsCountByCertID ...
 func (dal *MyDAL) SelectDomainsCountByCertID(certID int64) int64 {
 	var certDomainsCount int64
@@ -55,8 +66,8 @@ func (dal *MyDAL) SelectDomainsCountByCertID(certID int64) int64 {
 }
 
 // InsertDomain ...
-func (dal *MyDAL) InsertDomain(name string, appID int64, certID int64, redirect bool, location string) (newID int64) {
-	err := dal.db.QueryRow(sqlInsertDomain, name, appID, certID, redirect, location).Scan(&newID)
+func (dal *MyDAL) InsertDomain(name string, appID int64, certID int64, redirect bool, location string, tlsProfileID int64, redirectStatus int64, preservePath bool, preserveQuery bool) (newID int64) {
+	err := dal.db.QueryRow(sqlInsertDomain, name, appID, certID, redirect, location, tlsProfileID, redirectStatus, preservePath, preserveQuery).Scan(&newID)
 	if err != nil {
 		utils.DebugPrintln("InsertDomain", err)
 	}
@@ -64,8 +75,8 @@ func (dal *MyDAL) InsertDomain(name string, appID int64, certID int64, redirect
 }
 
 // UpdateDomain ...
-func (dal *MyDAL) UpdateDomain(name string, appID int64, certID int64, redirect bool, location string, domainID int64) error {
-	_, err := dal.db.Exec(sqlUpdateDomain, name, appID, certID, redirect, location, domainID)
+func (dal *MyDAL) UpdateDomain(name string, appID int64, certID int64, redirect bool, location string, tlsProfileID int64, redirectStatus int64, preservePath bool, preserveQuery bool, domainID int64) error {
+	_, err := dal.db.Exec(sqlUpdateDomain, name, appID, certID, redirect, location, tlsProfileID, redirectStatus, preservePath, preserveQuery, domainID)
 	if err != nil {
 		utils.DebugPrintln("UpdateDomain", err)
 	}
@@ -0,0 +1,84 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-26 21:13:52
+ * @Last Modified: U2, 2021-05-26 21:13:52
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsHeaderRules create header_rules, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsHeaderRules() error {
+	const sqlCreateTableIfNotExistsHeaderRules = `CREATE TABLE IF NOT EXISTS "header_rules"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"priority" bigint default 0,"direction" bigint default 1,"action" bigint default 1,"name" VARCHAR(128) NOT NULL,"value" VARCHAR(1024) NOT NULL DEFAULT '',"pattern" VARCHAR(256) NOT NULL DEFAULT '',"path_prefix" VARCHAR(256) NOT NULL DEFAULT '',"status_codes" VARCHAR(128) NOT NULL DEFAULT '')`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsHeaderRules)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsHeaderRules", err)
+	}
+	return err
+}
+
+// SelectHeaderRulesByAppID ordered by priority
+func (dal *MyDAL) SelectHeaderRulesByAppID(appID int64) []*models.HeaderRule {
+	headerRules := []*models.HeaderRule{}
+	const sqlSelectHeaderRulesByAppID = `SELECT "id","priority","direction","action","name","value","pattern","path_prefix","status_codes" FROM "header_rules" WHERE "app_id"=$1 ORDER BY "priority","id"`
+	rows, err := dal.db.Query(sqlSelectHeaderRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("SelectHeaderRulesByAppID", err)
+		return headerRules
+	}
+	defer rows.Close()
+	for rows.Next() {
+		headerRule := &models.HeaderRule{AppID: appID}
+		err = rows.Scan(&headerRule.ID, &headerRule.Priority, &headerRule.Direction, &headerRule.Action, &headerRule.Name, &headerRule.Value, &headerRule.Pattern, &headerRule.PathPrefix, &headerRule.StatusCodes)
+		if err != nil {
+			utils.DebugPrintln("SelectHeaderRulesByAppID rows.Scan", err)
+		}
+		headerRules = append(headerRules, headerRule)
+	}
+	return headerRules
+}
+
+// InsertHeaderRule ...
+func (dal *MyDAL) InsertHeaderRule(headerRule *models.HeaderRule) (newID int64, err error) {
+	const sqlInsertHeaderRule = `INSERT INTO "header_rules"("app_id","priority","direction","action","name","value","pattern","path_prefix","status_codes") VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertHeaderRule, headerRule.AppID, headerRule.Priority, headerRule.Direction, headerRule.Action, headerRule.Name, headerRule.Value, headerRule.Pattern, headerRule.PathPrefix, headerRule.StatusCodes).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertHeaderRule", err)
+	}
+	return newID, err
+}
+
+// UpdateHeaderRule ...
+func (dal *MyDAL) UpdateHeaderRule(headerRule *models.HeaderRule) error {
+	const sqlUpdateHeaderRule = `UPDATE "header_rules" SET "app_id"=$1,"priority"=$2,"direction"=$3,"action"=$4,"name"=$5,"value"=$6,"pattern"=$7,"path_prefix"=$8,"status_codes"=$9 WHERE "id"=$10`
+	_, err := dal.db.Exec(sqlUpdateHeaderRule, headerRule.AppID, headerRule.Priority, headerRule.Direction, headerRule.Action, headerRule.Name, headerRule.Value, headerRule.Pattern, headerRule.PathPrefix, headerRule.StatusCodes, headerRule.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateHeaderRule", err)
+	}
+	return err
+}
+
+// DeleteHeaderRuleByID ...
+func (dal *MyDAL) DeleteHeaderRuleByID(id int64) error {
+	const sqlDeleteHeaderRuleByID = `DELETE FROM "header_rules" WHERE "id"=$1`
+	_, err := dal.db.Exec(sqlDeleteHeaderRuleByID, id)
+	if err != nil {
+		utils.DebugPrintln("DeleteHeaderRuleByID", err)
+	}
+	return err
+}
+
+// DeleteHeaderRulesByAppID ...
+func (dal *MyDAL) DeleteHeaderRulesByAppID(appID int64) error {
+	const sqlDeleteHeaderRulesByAppID = `DELETE FROM "header_rules" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteHeaderRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteHeaderRulesByAppID", err)
+	}
+	return err
+}
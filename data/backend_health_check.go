@@ -0,0 +1,73 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-20 20:32:16
+ * @Last Modified: U2, 2021-05-20 20:32:16
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsHealthChecks create health_checks, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsHealthChecks() error {
+	const sqlCreateTableIfNotExistsHealthChecks = `CREATE TABLE IF NOT EXISTS "health_checks"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"enabled" boolean default false,"path" VARCHAR(256) NOT NULL DEFAULT '/',"expected_status" VARCHAR(128) NOT NULL DEFAULT '',"body_regex" VARCHAR(256) NOT NULL DEFAULT '',"interval" bigint default 10,"timeout" bigint default 3,"rise" bigint default 2,"fall" bigint default 3)`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsHealthChecks)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsHealthChecks", err)
+	}
+	return err
+}
+
+// SelectHealthCheckByAppID return nil if not configured
+func (dal *MyDAL) SelectHealthCheckByAppID(appID int64) *models.HealthCheck {
+	const sqlSelectHealthCheckByAppID = `SELECT "id","enabled","path","expected_status","body_regex","interval","timeout","rise","fall" FROM "health_checks" WHERE "app_id"=$1 LIMIT 1`
+	healthCheck := &models.HealthCheck{AppID: appID}
+	err := dal.db.QueryRow(sqlSelectHealthCheckByAppID, appID).Scan(
+		&healthCheck.ID,
+		&healthCheck.Enabled,
+		&healthCheck.Path,
+		&healthCheck.ExpectedStatus,
+		&healthCheck.BodyRegex,
+		&healthCheck.Interval,
+		&healthCheck.Timeout,
+		&healthCheck.Rise,
+		&healthCheck.Fall)
+	if err != nil {
+		return nil
+	}
+	return healthCheck
+}
+
+// InsertHealthCheck ...
+func (dal *MyDAL) InsertHealthCheck(healthCheck *models.HealthCheck) (newID int64, err error) {
+	const sqlInsertHealthCheck = `INSERT INTO "health_checks"("app_id","enabled","path","expected_status","body_regex","interval","timeout","rise","fall") VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertHealthCheck, healthCheck.AppID, healthCheck.Enabled, healthCheck.Path, healthCheck.ExpectedStatus, healthCheck.BodyRegex, healthCheck.Interval, healthCheck.Timeout, healthCheck.Rise, healthCheck.Fall).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertHealthCheck", err)
+	}
+	return newID, err
+}
+
+// UpdateHealthCheck ...
+func (dal *MyDAL) UpdateHealthCheck(healthCheck *models.HealthCheck) error {
+	const sqlUpdateHealthCheck = `UPDATE "health_checks" SET "app_id"=$1,"enabled"=$2,"path"=$3,"expected_status"=$4,"body_regex"=$5,"interval"=$6,"timeout"=$7,"rise"=$8,"fall"=$9 WHERE "id"=$10`
+	_, err := dal.db.Exec(sqlUpdateHealthCheck, healthCheck.AppID, healthCheck.Enabled, healthCheck.Path, healthCheck.ExpectedStatus, healthCheck.BodyRegex, healthCheck.Interval, healthCheck.Timeout, healthCheck.Rise, healthCheck.Fall, healthCheck.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateHealthCheck", err)
+	}
+	return err
+}
+
+// DeleteHealthCheckByAppID ...
+func (dal *MyDAL) DeleteHealthCheckByAppID(appID int64) error {
+	const sqlDeleteHealthCheckByAppID = `DELETE FROM "health_checks" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteHealthCheckByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteHealthCheckByAppID", err)
+	}
+	return err
+}
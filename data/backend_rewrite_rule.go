@@ -0,0 +1,84 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-28 20:52:14
+ * @Last Modified: U2, 2021-05-28 20:52:14
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsRewriteRules create rewrite_rules, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsRewriteRules() error {
+	const sqlCreateTableIfNotExistsRewriteRules = `CREATE TABLE IF NOT EXISTS "rewrite_rules"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"priority" bigint default 0,"domain" VARCHAR(256) NOT NULL DEFAULT '',"pattern" VARCHAR(512) NOT NULL,"target" VARCHAR(1024) NOT NULL DEFAULT '',"type" bigint default 1,"status_code" bigint default 301,"preserve_path" boolean default false,"preserve_query" boolean default true)`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsRewriteRules)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsRewriteRules", err)
+	}
+	return err
+}
+
+// SelectRewriteRulesByAppID ordered by priority
+func (dal *MyDAL) SelectRewriteRulesByAppID(appID int64) []*models.RewriteRule {
+	rewriteRules := []*models.RewriteRule{}
+	const sqlSelectRewriteRulesByAppID = `SELECT "id","priority","domain","pattern","target","type","status_code","preserve_path","preserve_query" FROM "rewrite_rules" WHERE "app_id"=$1 ORDER BY "priority","id"`
+	rows, err := dal.db.Query(sqlSelectRewriteRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("SelectRewriteRulesByAppID", err)
+		return rewriteRules
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rewriteRule := &models.RewriteRule{AppID: appID}
+		err = rows.Scan(&rewriteRule.ID, &rewriteRule.Priority, &rewriteRule.Domain, &rewriteRule.Pattern, &rewriteRule.Target, &rewriteRule.Type, &rewriteRule.StatusCode, &rewriteRule.PreservePath, &rewriteRule.PreserveQuery)
+		if err != nil {
+			utils.DebugPrintln("SelectRewriteRulesByAppID rows.Scan", err)
+		}
+		rewriteRules = append(rewriteRules, rewriteRule)
+	}
+	return rewriteRules
+}
+
+// InsertRewriteRule ...
+func (dal *MyDAL) InsertRewriteRule(rewriteRule *models.RewriteRule) (newID int64, err error) {
+	const sqlInsertRewriteRule = `INSERT INTO "rewrite_rules"("app_id","priority","domain","pattern","target","type","status_code","preserve_path","preserve_query") VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertRewriteRule, rewriteRule.AppID, rewriteRule.Priority, rewriteRule.Domain, rewriteRule.Pattern, rewriteRule.Target, rewriteRule.Type, rewriteRule.StatusCode, rewriteRule.PreservePath, rewriteRule.PreserveQuery).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertRewriteRule", err)
+	}
+	return newID, err
+}
+
+// UpdateRewriteRule ...
+func (dal *MyDAL) UpdateRewriteRule(rewriteRule *models.RewriteRule) error {
+	const sqlUpdateRewriteRule = `UPDATE "rewrite_rules" SET "app_id"=$1,"priority"=$2,"domain"=$3,"pattern"=$4,"target"=$5,"type"=$6,"status_code"=$7,"preserve_path"=$8,"preserve_query"=$9 WHERE "id"=$10`
+	_, err := dal.db.Exec(sqlUpdateRewriteRule, rewriteRule.AppID, rewriteRule.Priority, rewriteRule.Domain, rewriteRule.Pattern, rewriteRule.Target, rewriteRule.Type, rewriteRule.StatusCode, rewriteRule.PreservePath, rewriteRule.PreserveQuery, rewriteRule.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateRewriteRule", err)
+	}
+	return err
+}
+
+// DeleteRewriteRuleByID ...
+func (dal *MyDAL) DeleteRewriteRuleByID(id int64) error {
+	const sqlDeleteRewriteRuleByID = `DELETE FROM "rewrite_rules" WHERE "id"=$1`
+	_, err := dal.db.Exec(sqlDeleteRewriteRuleByID, id)
+	if err != nil {
+		utils.DebugPrintln("DeleteRewriteRuleByID", err)
+	}
+	return err
+}
+
+// DeleteRewriteRulesByAppID ...
+func (dal *MyDAL) DeleteRewriteRulesByAppID(appID int64) error {
+	const sqlDeleteRewriteRulesByAppID = `DELETE FROM "rewrite_rules" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteRewriteRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteRewriteRulesByAppID", err)
+	}
+	return err
+}
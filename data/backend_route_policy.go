@@ -0,0 +1,84 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-18 21:05:12
+ * @Last Modified: U2, 2021-05-18 21:05:12
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsRoutePolicies create route_policies, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsRoutePolicies() error {
+	const sqlCreateTableIfNotExistsRoutePolicies = `CREATE TABLE IF NOT EXISTS "route_policies"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"request_route" VARCHAR(128) NOT NULL DEFAULT '/',"lb_method" bigint default 1,"hash_key" bigint default 1,"hash_key_name" VARCHAR(128) NOT NULL DEFAULT '',"mirror_enabled" boolean default false,"mirror_destination" VARCHAR(128) NOT NULL DEFAULT '',"mirror_percent" bigint default 100,"mirror_max_body_size" bigint default 0,"mirror_max_concurrency" bigint default 0)`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsRoutePolicies)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsRoutePolicies", err)
+	}
+	return err
+}
+
+// SelectRoutePoliciesByAppID ...
+func (dal *MyDAL) SelectRoutePoliciesByAppID(appID int64) []*models.RoutePolicy {
+	routePolicies := []*models.RoutePolicy{}
+	const sqlSelectRoutePoliciesByAppID = `SELECT "id","request_route","lb_method","hash_key","hash_key_name","mirror_enabled","mirror_destination","mirror_percent","mirror_max_body_size","mirror_max_concurrency" FROM "route_policies" WHERE "app_id"=$1`
+	rows, err := dal.db.Query(sqlSelectRoutePoliciesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("SelectRoutePoliciesByAppID", err)
+		return routePolicies
+	}
+	defer rows.Close()
+	for rows.Next() {
+		routePolicy := &models.RoutePolicy{AppID: appID}
+		err = rows.Scan(&routePolicy.ID, &routePolicy.RequestRoute, &routePolicy.LBMethod, &routePolicy.HashKey, &routePolicy.HashKeyName, &routePolicy.MirrorEnabled, &routePolicy.MirrorDestination, &routePolicy.MirrorPercent, &routePolicy.MirrorMaxBodySize, &routePolicy.MirrorMaxConcurrency)
+		if err != nil {
+			utils.DebugPrintln("SelectRoutePoliciesByAppID rows.Scan", err)
+		}
+		routePolicies = append(routePolicies, routePolicy)
+	}
+	return routePolicies
+}
+
+// InsertRoutePolicy ...
+func (dal *MyDAL) InsertRoutePolicy(routePolicy *models.RoutePolicy) (newID int64, err error) {
+	const sqlInsertRoutePolicy = `INSERT INTO "route_policies"("app_id","request_route","lb_method","hash_key","hash_key_name","mirror_enabled","mirror_destination","mirror_percent","mirror_max_body_size","mirror_max_concurrency") VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertRoutePolicy, routePolicy.AppID, routePolicy.RequestRoute, routePolicy.LBMethod, routePolicy.HashKey, routePolicy.HashKeyName, routePolicy.MirrorEnabled, routePolicy.MirrorDestination, routePolicy.MirrorPercent, routePolicy.MirrorMaxBodySize, routePolicy.MirrorMaxConcurrency).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertRoutePolicy", err)
+	}
+	return newID, err
+}
+
+// UpdateRoutePolicy ...
+func (dal *MyDAL) UpdateRoutePolicy(routePolicy *models.RoutePolicy) error {
+	const sqlUpdateRoutePolicy = `UPDATE "route_policies" SET "app_id"=$1,"request_route"=$2,"lb_method"=$3,"hash_key"=$4,"hash_key_name"=$5,"mirror_enabled"=$6,"mirror_destination"=$7,"mirror_percent"=$8,"mirror_max_body_size"=$9,"mirror_max_concurrency"=$10 WHERE "id"=$11`
+	_, err := dal.db.Exec(sqlUpdateRoutePolicy, routePolicy.AppID, routePolicy.RequestRoute, routePolicy.LBMethod, routePolicy.HashKey, routePolicy.HashKeyName, routePolicy.MirrorEnabled, routePolicy.MirrorDestination, routePolicy.MirrorPercent, routePolicy.MirrorMaxBodySize, routePolicy.MirrorMaxConcurrency, routePolicy.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateRoutePolicy", err)
+	}
+	return err
+}
+
+// DeleteRoutePolicyByID ...
+func (dal *MyDAL) DeleteRoutePolicyByID(id int64) error {
+	const sqlDeleteRoutePolicyByID = `DELETE FROM "route_policies" WHERE "id"=$1`
+	_, err := dal.db.Exec(sqlDeleteRoutePolicyByID, id)
+	if err != nil {
+		utils.DebugPrintln("DeleteRoutePolicyByID", err)
+	}
+	return err
+}
+
+// DeleteRoutePoliciesByAppID ...
+func (dal *MyDAL) DeleteRoutePoliciesByAppID(appID int64) error {
+	const sqlDeleteRoutePoliciesByAppID = `DELETE FROM "route_policies" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteRoutePoliciesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteRoutePoliciesByAppID", err)
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-02 19:58:21
+ * @Last Modified: U2, 2021-06-02 19:58:21
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsTLSProfiles create tls_profiles, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsTLSProfiles() error {
+	const sqlCreateTableIfNotExistsTLSProfiles = `CREATE TABLE IF NOT EXISTS "tls_profiles"("id" bigserial PRIMARY KEY,"name" VARCHAR(256) NOT NULL,"type" bigint default 2,"min_version" VARCHAR(16) NOT NULL DEFAULT '',"max_version" VARCHAR(16) NOT NULL DEFAULT '',"cipher_suites" VARCHAR(2048) NOT NULL DEFAULT '',"curves" VARCHAR(256) NOT NULL DEFAULT '',"ocsp_stapling" boolean default false,"description" VARCHAR(256) NOT NULL DEFAULT '')`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsTLSProfiles)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsTLSProfiles", err)
+	}
+	return err
+}
+
+// SelectTLSProfiles ...
+func (dal *MyDAL) SelectTLSProfiles() []*models.TLSProfile {
+	tlsProfiles := []*models.TLSProfile{}
+	const sqlSelectTLSProfiles = `SELECT "id","name","type","min_version","max_version","cipher_suites","curves","ocsp_stapling","description" FROM "tls_profiles"`
+	rows, err := dal.db.Query(sqlSelectTLSProfiles)
+	if err != nil {
+		utils.DebugPrintln("SelectTLSProfiles", err)
+		return tlsProfiles
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tlsProfile := &models.TLSProfile{}
+		err = rows.Scan(&tlsProfile.ID, &tlsProfile.Name, &tlsProfile.Type, &tlsProfile.MinVersion, &tlsProfile.MaxVersion, &tlsProfile.CipherSuites, &tlsProfile.Curves, &tlsProfile.OCSPStapling, &tlsProfile.Description)
+		if err != nil {
+			utils.DebugPrintln("SelectTLSProfiles rows.Scan", err)
+		}
+		tlsProfiles = append(tlsProfiles, tlsProfile)
+	}
+	return tlsProfiles
+}
+
+// InsertTLSProfile ...
+func (dal *MyDAL) InsertTLSProfile(tlsProfile *models.TLSProfile) (newID int64, err error) {
+	const sqlInsertTLSProfile = `INSERT INTO "tls_profiles"("name","type","min_version","max_version","cipher_suites","curves","ocsp_stapling","description") VALUES($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertTLSProfile, tlsProfile.Name, tlsProfile.Type, tlsProfile.MinVersion, tlsProfile.MaxVersion, tlsProfile.CipherSuites, tlsProfile.Curves, tlsProfile.OCSPStapling, tlsProfile.Description).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertTLSProfile", err)
+	}
+	return newID, err
+}
+
+// UpdateTLSProfile ...
+func (dal *MyDAL) UpdateTLSProfile(tlsProfile *models.TLSProfile) error {
+	const sqlUpdateTLSProfile = `UPDATE "tls_profiles" SET "name"=$1,"type"=$2,"min_version"=$3,"max_version"=$4,"cipher_suites"=$5,"curves"=$6,"ocsp_stapling"=$7,"description"=$8 WHERE "id"=$9`
+	_, err := dal.db.Exec(sqlUpdateTLSProfile, tlsProfile.Name, tlsProfile.Type, tlsProfile.MinVersion, tlsProfile.MaxVersion, tlsProfile.CipherSuites, tlsProfile.Curves, tlsProfile.OCSPStapling, tlsProfile.Description, tlsProfile.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateTLSProfile", err)
+	}
+	return err
+}
+
+// DeleteTLSProfileByID ...
+func (dal *MyDAL) DeleteTLSProfileByID(id int64) error {
+	const sqlDeleteTLSProfileByID = `DELETE FROM "tls_profiles" WHERE "id"=$1`
+	_, err := dal.db.Exec(sqlDeleteTLSProfileByID, id)
+	if err != nil {
+		utils.DebugPrintln("DeleteTLSProfileByID", err)
+	}
+	return err
+}
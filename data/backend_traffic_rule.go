@@ -0,0 +1,84 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-06 21:35:20
+ * @Last Modified: U2, 2021-06-06 21:35:20
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsTrafficRules create traffic_rules, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsTrafficRules() error {
+	const sqlCreateTableIfNotExistsTrafficRules = `CREATE TABLE IF NOT EXISTS "traffic_rules"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"priority" bigint default 0,"request_route" VARCHAR(128) NOT NULL DEFAULT '/',"type" bigint default 1,"name" VARCHAR(128) NOT NULL DEFAULT '',"value" VARCHAR(1024) NOT NULL DEFAULT '',"percent" bigint default 0,"group_name" VARCHAR(64) NOT NULL DEFAULT '')`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsTrafficRules)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsTrafficRules", err)
+	}
+	return err
+}
+
+// SelectTrafficRulesByAppID ordered by priority
+func (dal *MyDAL) SelectTrafficRulesByAppID(appID int64) []*models.TrafficRule {
+	trafficRules := []*models.TrafficRule{}
+	const sqlSelectTrafficRulesByAppID = `SELECT "id","priority","request_route","type","name","value","percent","group_name" FROM "traffic_rules" WHERE "app_id"=$1 ORDER BY "priority","id"`
+	rows, err := dal.db.Query(sqlSelectTrafficRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("SelectTrafficRulesByAppID", err)
+		return trafficRules
+	}
+	defer rows.Close()
+	for rows.Next() {
+		trafficRule := &models.TrafficRule{AppID: appID}
+		err = rows.Scan(&trafficRule.ID, &trafficRule.Priority, &trafficRule.RequestRoute, &trafficRule.Type, &trafficRule.Name, &trafficRule.Value, &trafficRule.Percent, &trafficRule.Group)
+		if err != nil {
+			utils.DebugPrintln("SelectTrafficRulesByAppID rows.Scan", err)
+		}
+		trafficRules = append(trafficRules, trafficRule)
+	}
+	return trafficRules
+}
+
+// InsertTrafficRule ...
+func (dal *MyDAL) InsertTrafficRule(trafficRule *models.TrafficRule) (newID int64, err error) {
+	const sqlInsertTrafficRule = `INSERT INTO "traffic_rules"("app_id","priority","request_route","type","name","value","percent","group_name") VALUES($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertTrafficRule, trafficRule.AppID, trafficRule.Priority, trafficRule.RequestRoute, trafficRule.Type, trafficRule.Name, trafficRule.Value, trafficRule.Percent, trafficRule.Group).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertTrafficRule", err)
+	}
+	return newID, err
+}
+
+// UpdateTrafficRule ...
+func (dal *MyDAL) UpdateTrafficRule(trafficRule *models.TrafficRule) error {
+	const sqlUpdateTrafficRule = `UPDATE "traffic_rules" SET "app_id"=$1,"priority"=$2,"request_route"=$3,"type"=$4,"name"=$5,"value"=$6,"percent"=$7,"group_name"=$8 WHERE "id"=$9`
+	_, err := dal.db.Exec(sqlUpdateTrafficRule, trafficRule.AppID, trafficRule.Priority, trafficRule.RequestRoute, trafficRule.Type, trafficRule.Name, trafficRule.Value, trafficRule.Percent, trafficRule.Group, trafficRule.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateTrafficRule", err)
+	}
+	return err
+}
+
+// DeleteTrafficRuleByID ...
+func (dal *MyDAL) DeleteTrafficRuleByID(id int64) error {
+	const sqlDeleteTrafficRuleByID = `DELETE FROM "traffic_rules" WHERE "id"=$1`
+	_, err := dal.db.Exec(sqlDeleteTrafficRuleByID, id)
+	if err != nil {
+		utils.DebugPrintln("DeleteTrafficRuleByID", err)
+	}
+	return err
+}
+
+// DeleteTrafficRulesByAppID ...
+func (dal *MyDAL) DeleteTrafficRulesByAppID(appID int64) error {
+	const sqlDeleteTrafficRulesByAppID = `DELETE FROM "traffic_rules" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteTrafficRulesByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteTrafficRulesByAppID", err)
+	}
+	return err
+}
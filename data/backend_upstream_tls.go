@@ -0,0 +1,69 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-01 20:32:18
+ * @Last Modified: U2, 2021-06-01 20:32:18
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsUpstreamTLS create upstream_tls, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsUpstreamTLS() error {
+	const sqlCreateTableIfNotExistsUpstreamTLS = `CREATE TABLE IF NOT EXISTS "upstream_tls"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"verify" boolean default true,"ca_cert_id" bigint default 0,"server_name" VARCHAR(256) NOT NULL DEFAULT '',"client_cert_id" bigint default 0)`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsUpstreamTLS)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsUpstreamTLS", err)
+	}
+	return err
+}
+
+// SelectUpstreamTLSByAppID return nil if not configured
+func (dal *MyDAL) SelectUpstreamTLSByAppID(appID int64) *models.UpstreamTLS {
+	const sqlSelectUpstreamTLSByAppID = `SELECT "id","verify","ca_cert_id","server_name","client_cert_id" FROM "upstream_tls" WHERE "app_id"=$1 LIMIT 1`
+	upstreamTLS := &models.UpstreamTLS{AppID: appID}
+	err := dal.db.QueryRow(sqlSelectUpstreamTLSByAppID, appID).Scan(
+		&upstreamTLS.ID,
+		&upstreamTLS.Verify,
+		&upstreamTLS.CACertID,
+		&upstreamTLS.ServerName,
+		&upstreamTLS.ClientCertID)
+	if err != nil {
+		return nil
+	}
+	return upstreamTLS
+}
+
+// InsertUpstreamTLS ...
+func (dal *MyDAL) InsertUpstreamTLS(upstreamTLS *models.UpstreamTLS) (newID int64, err error) {
+	const sqlInsertUpstreamTLS = `INSERT INTO "upstream_tls"("app_id","verify","ca_cert_id","server_name","client_cert_id") VALUES($1,$2,$3,$4,$5) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertUpstreamTLS, upstreamTLS.AppID, upstreamTLS.Verify, upstreamTLS.CACertID, upstreamTLS.ServerName, upstreamTLS.ClientCertID).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertUpstreamTLS", err)
+	}
+	return newID, err
+}
+
+// UpdateUpstreamTLS ...
+func (dal *MyDAL) UpdateUpstreamTLS(upstreamTLS *models.UpstreamTLS) error {
+	const sqlUpdateUpstreamTLS = `UPDATE "upstream_tls" SET "app_id"=$1,"verify"=$2,"ca_cert_id"=$3,"server_name"=$4,"client_cert_id"=$5 WHERE "id"=$6`
+	_, err := dal.db.Exec(sqlUpdateUpstreamTLS, upstreamTLS.AppID, upstreamTLS.Verify, upstreamTLS.CACertID, upstreamTLS.ServerName, upstreamTLS.ClientCertID, upstreamTLS.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateUpstreamTLS", err)
+	}
+	return err
+}
+
+// DeleteUpstreamTLSByAppID ...
+func (dal *MyDAL) DeleteUpstreamTLSByAppID(appID int64) error {
+	const sqlDeleteUpstreamTLSByAppID = `DELETE FROM "upstream_tls" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteUpstreamTLSByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteUpstreamTLSByAppID", err)
+	}
+	return err
+}
@@ -14,14 +14,14 @@ import (
 
 // CreateTableIfNotExistsVipApplications ...
 func (dal *MyDAL) CreateTableIfNotExistsVipApplications() error {
-	const sqlCreateTableIfNotExistsVipApplications = `CREATE TABLE IF NOT EXISTS "vip_apps"("id" bigserial PRIMARY KEY, "name" VARCHAR(128) NOT NULL, "listen_port" bigint, "is_tcp" boolean default true, "owner" VARCHAR(128) NOT NULL DEFAULT '', "description" VARCHAR(256) NOT NULL DEFAULT '')`
+	const sqlCreateTableIfNotExistsVipApplications = `CREATE TABLE IF NOT EXISTS "vip_apps"("id" bigserial PRIMARY KEY, "name" VARCHAR(128) NOT NULL, "listen_port" bigint, "is_tcp" boolean default true, "owner" VARCHAR(128) NOT NULL DEFAULT '', "description" VARCHAR(256) NOT NULL DEFAULT '', "lb_method" bigint default 1, "accept_proxy_protocol" boolean default false, "send_proxy_protocol" bigint default 0)`
 	_, err := dal.db.Exec(sqlCreateTableIfNotExistsVipApplications)
 	return err
 }
 
 // SelectVipApplications ...
 func (dal *MyDAL) SelectVipApplications() []*models.VipApp {
-	const sqlSelectVipApplications = `SELECT "id","name","listen_port","is_tcp","owner","description" FROM "vip_apps"`
+	const sqlSelectVipApplications = `SELECT "id","name","listen_port","is_tcp","owner","description","lb_method","accept_proxy_protocol","send_proxy_protocol" FROM "vip_apps"`
 	rows, err := dal.db.Query(sqlSelectVipApplications)
 	if err != nil {
 		utils.DebugPrintln("SelectVipApplications", err)
@@ -37,6 +37,9 @@ func (dal *MyDAL) SelectVipApplications() []*models.VipApp {
 			&vipApp.IsTCP,
 			&vipApp.Owner,
 			&vipApp.Description,
+			&vipApp.LBMethod,
+			&vipApp.AcceptProxyProtocol,
+			&vipApp.SendProxyProtocol,
 		)
 		if err != nil {
 			utils.DebugPrintln("SelectVipApplications rows.Scan", err)
@@ -47,9 +50,9 @@ func (dal *MyDAL) SelectVipApplications() []*models.VipApp {
 }
 
 // InsertVipApp create new port forwarding
-func (dal *MyDAL) InsertVipApp(vipAppName string, listenPort int64, isTCP bool, owner string, description string) (newID int64) {
-	const sqlInsertVipApp = `INSERT INTO "vip_apps"("name","listen_port","is_tcp","owner","description") VALUES($1,$2,$3,$4,$5) RETURNING "id"`
-	err := dal.db.QueryRow(sqlInsertVipApp, vipAppName, listenPort, isTCP, owner, description).Scan(&newID)
+func (dal *MyDAL) InsertVipApp(vipAppName string, listenPort int64, isTCP bool, owner string, description string, lbMethod models.LBMethod, acceptProxyProtocol bool, sendProxyProtocol int64) (newID int64) {
+	const sqlInsertVipApp = `INSERT INTO "vip_apps"("name","listen_port","is_tcp","owner","description","lb_method","accept_proxy_protocol","send_proxy_protocol") VALUES($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`
+	err := dal.db.QueryRow(sqlInsertVipApp, vipAppName, listenPort, isTCP, owner, description, lbMethod, acceptProxyProtocol, sendProxyProtocol).Scan(&newID)
 	if err != nil {
 		utils.DebugPrintln("InsertVipApp", err)
 	}
@@ -57,9 +60,9 @@ func (dal *MyDAL) InsertVipApp(vipAppName string, listenPort int64, isTCP bool,
 }
 
 // UpdateVipAppByID update an existed VipApp
-func (dal *MyDAL) UpdateVipAppByID(vipAppName string, listenPort int64, isTCP bool, owner string, description string, vipAppID int64) error {
-	const sqlUpdateVipApp = `UPDATE "vip_apps" SET "name"=$1,"listen_port"=$2,"is_tcp"=$3,"owner"=$4,"description"=$5 WHERE "id"=$6`
-	_, err := dal.db.Exec(sqlUpdateVipApp, vipAppName, listenPort, isTCP, owner, description, vipAppID)
+func (dal *MyDAL) UpdateVipAppByID(vipAppName string, listenPort int64, isTCP bool, owner string, description string, lbMethod models.LBMethod, acceptProxyProtocol bool, sendProxyProtocol int64, vipAppID int64) error {
+	const sqlUpdateVipApp = `UPDATE "vip_apps" SET "name"=$1,"listen_port"=$2,"is_tcp"=$3,"owner"=$4,"description"=$5,"lb_method"=$6,"accept_proxy_protocol"=$7,"send_proxy_protocol"=$8 WHERE "id"=$9`
+	_, err := dal.db.Exec(sqlUpdateVipApp, vipAppName, listenPort, isTCP, owner, description, lbMethod, acceptProxyProtocol, sendProxyProtocol, vipAppID)
 	if err != nil {
 		utils.DebugPrintln("InsertVipApp", err)
 	}
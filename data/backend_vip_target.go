@@ -14,7 +14,7 @@ import (
 
 // CreateTableIfNotExistsVipTargets create vip_targets
 func (dal *MyDAL) CreateTableIfNotExistsVipTargets() error {
-	const sqlCreateTableIfNotExistsVipTargets = `CREATE TABLE IF NOT EXISTS "vip_targets"("id" bigserial PRIMARY KEY, "vip_app_id" bigint NOT NULL, "destination" VARCHAR(128) NOT NULL)`
+	const sqlCreateTableIfNotExistsVipTargets = `CREATE TABLE IF NOT EXISTS "vip_targets"("id" bigserial PRIMARY KEY, "vip_app_id" bigint NOT NULL, "destination" VARCHAR(128) NOT NULL, "weight" bigint default 1)`
 	_, err := dal.db.Exec(sqlCreateTableIfNotExistsVipTargets)
 	return err
 }
@@ -22,7 +22,7 @@ func (dal *MyDAL) CreateTableIfNotExistsVipTargets() error {
 // SelectVipTargetsByAppID ...
 func (dal *MyDAL) SelectVipTargetsByAppID(vipAppID int64) []*models.VipTarget {
 	targets := []*models.VipTarget{}
-	const sqlSelectVipTargetsByAppID = `SELECT "id","destination" FROM "vip_targets" WHERE "vip_app_id"=$1`
+	const sqlSelectVipTargetsByAppID = `SELECT "id","destination","weight" FROM "vip_targets" WHERE "vip_app_id"=$1`
 	rows, err := dal.db.Query(sqlSelectVipTargetsByAppID, vipAppID)
 	if err != nil {
 		utils.DebugPrintln("SelectDestinationsByAppID", err)
@@ -31,7 +31,7 @@ func (dal *MyDAL) SelectVipTargetsByAppID(vipAppID int64) []*models.VipTarget {
 	defer rows.Close()
 	for rows.Next() {
 		vipTarget := &models.VipTarget{VipAppID: vipAppID, Online: true}
-		err = rows.Scan(&vipTarget.ID, &vipTarget.Destination)
+		err = rows.Scan(&vipTarget.ID, &vipTarget.Destination, &vipTarget.Weight)
 		if err != nil {
 			utils.DebugPrintln("SelectDestinationsByAppID rows.Scan", err)
 		}
@@ -41,9 +41,9 @@ func (dal *MyDAL) SelectVipTargetsByAppID(vipAppID int64) []*models.VipTarget {
 }
 
 // UpdateVipTarget ... update port forwarding target
-func (dal *MyDAL) UpdateVipTarget(vipAppID int64, destination string, id int64) error {
-	const sqlUpdateTarget = `UPDATE "vip_targets" SET "vip_app_id"=$1,"destination"=$2 WHERE "id"=$3`
-	_, err := dal.db.Exec(sqlUpdateTarget, vipAppID, destination, id)
+func (dal *MyDAL) UpdateVipTarget(vipAppID int64, destination string, weight int64, id int64) error {
+	const sqlUpdateTarget = `UPDATE "vip_targets" SET "vip_app_id"=$1,"destination"=$2,"weight"=$3 WHERE "id"=$4`
+	_, err := dal.db.Exec(sqlUpdateTarget, vipAppID, destination, weight, id)
 	if err != nil {
 		utils.DebugPrintln("UpdateVipTarget", err)
 	}
@@ -51,9 +51,9 @@ func (dal *MyDAL) UpdateVipTarget(vipAppID int64, destination string, id int64)
 }
 
 // InsertVipTarget create new VipTarget
-func (dal *MyDAL) InsertVipTarget(vipAppID int64, destination string) (newID int64, err error) {
-	const sqlInsertTarget = `INSERT INTO "vip_targets"("vip_app_id", "destination") VALUES($1,$2) RETURNING "id"`
-	err = dal.db.QueryRow(sqlInsertTarget, vipAppID, destination).Scan(&newID)
+func (dal *MyDAL) InsertVipTarget(vipAppID int64, destination string, weight int64) (newID int64, err error) {
+	const sqlInsertTarget = `INSERT INTO "vip_targets"("vip_app_id", "destination", "weight") VALUES($1,$2,$3) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertTarget, vipAppID, destination, weight).Scan(&newID)
 	if err != nil {
 		utils.DebugPrintln("InsertVipTarget", err)
 	}
@@ -0,0 +1,69 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-12 21:05:12
+ * @Last Modified: U2, 2021-06-12 21:05:12
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsWaitingRooms create waiting_rooms, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsWaitingRooms() error {
+	const sqlCreateTableIfNotExistsWaitingRooms = `CREATE TABLE IF NOT EXISTS "waiting_rooms"("id" bigserial PRIMARY KEY,"app_id" bigint NOT NULL,"enabled" boolean default false,"max_sessions" bigint default 0,"session_timeout" bigint default 0,"refresh_interval" bigint default 0)`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsWaitingRooms)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsWaitingRooms", err)
+	}
+	return err
+}
+
+// SelectWaitingRoomByAppID return nil if not configured
+func (dal *MyDAL) SelectWaitingRoomByAppID(appID int64) *models.WaitingRoom {
+	const sqlSelectWaitingRoomByAppID = `SELECT "id","enabled","max_sessions","session_timeout","refresh_interval" FROM "waiting_rooms" WHERE "app_id"=$1 LIMIT 1`
+	room := &models.WaitingRoom{AppID: appID}
+	err := dal.db.QueryRow(sqlSelectWaitingRoomByAppID, appID).Scan(
+		&room.ID,
+		&room.Enabled,
+		&room.MaxSessions,
+		&room.SessionTimeout,
+		&room.RefreshInterval)
+	if err != nil {
+		return nil
+	}
+	return room
+}
+
+// InsertWaitingRoom ...
+func (dal *MyDAL) InsertWaitingRoom(room *models.WaitingRoom) (newID int64, err error) {
+	const sqlInsertWaitingRoom = `INSERT INTO "waiting_rooms"("app_id","enabled","max_sessions","session_timeout","refresh_interval") VALUES($1,$2,$3,$4,$5) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertWaitingRoom, room.AppID, room.Enabled, room.MaxSessions, room.SessionTimeout, room.RefreshInterval).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertWaitingRoom", err)
+	}
+	return newID, err
+}
+
+// UpdateWaitingRoom ...
+func (dal *MyDAL) UpdateWaitingRoom(room *models.WaitingRoom) error {
+	const sqlUpdateWaitingRoom = `UPDATE "waiting_rooms" SET "app_id"=$1,"enabled"=$2,"max_sessions"=$3,"session_timeout"=$4,"refresh_interval"=$5 WHERE "id"=$6`
+	_, err := dal.db.Exec(sqlUpdateWaitingRoom, room.AppID, room.Enabled, room.MaxSessions, room.SessionTimeout, room.RefreshInterval, room.ID)
+	if err != nil {
+		utils.DebugPrintln("UpdateWaitingRoom", err)
+	}
+	return err
+}
+
+// DeleteWaitingRoomByAppID ...
+func (dal *MyDAL) DeleteWaitingRoomByAppID(appID int64) error {
+	const sqlDeleteWaitingRoomByAppID = `DELETE FROM "waiting_rooms" WHERE "app_id"=$1`
+	_, err := dal.db.Exec(sqlDeleteWaitingRoomByAppID, appID)
+	if err != nil {
+		utils.DebugPrintln("DeleteWaitingRoomByAppID", err)
+	}
+	return err
+}
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync/atomic"
 
 	"janusec/models"
 	"janusec/utils"
@@ -30,8 +31,8 @@ type MyDAL struct {
 var (
 	// DAL is Data Access Layer
 	DAL *MyDAL
-	// CFG is config
-	CFG *models.Config
+	// cfg is *models.Config, replaced atomically when reloaded
+	cfg atomic.Value
 	// IsPrimary i.e. Is Primary Node
 	IsPrimary bool
 	// Version of JANUSEC
@@ -40,16 +41,22 @@ var (
 	NodeKey []byte
 )
 
+// GetConfig return current config, do not modify it, v1.2.4
+func GetConfig() *models.Config {
+	config, _ := cfg.Load().(*models.Config)
+	return config
+}
+
 // InitConfig init Data Access Layer
 func InitConfig() {
 	DAL = &MyDAL{}
-	var err error
-	CFG, err = NewConfig("./config.json")
+	config, err := NewConfig("./config.json")
 	if err != nil {
 		utils.DebugPrintln("InitConfig", err)
 		os.Exit(1)
 	}
-	nodeRole := strings.ToLower(CFG.NodeRole)
+	cfg.Store(config)
+	nodeRole := strings.ToLower(config.NodeRole)
 	if nodeRole != "primary" && nodeRole != "replica" {
 		fmt.Printf("Error: node_role %s is not supported, it should be primary or replica, please check config.json \n", nodeRole)
 		utils.DebugPrintln("Error: node_role ", nodeRole, " is not supported, it should be primary or replica, please check config.json")
@@ -58,11 +65,11 @@ func InitConfig() {
 	IsPrimary = (nodeRole == "primary")
 	if IsPrimary {
 		conn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-			CFG.PrimaryNode.Database.Host,
-			CFG.PrimaryNode.Database.Port,
-			CFG.PrimaryNode.Database.User,
-			CFG.PrimaryNode.Database.Password,
-			CFG.PrimaryNode.Database.DBName)
+			config.PrimaryNode.Database.Host,
+			config.PrimaryNode.Database.Port,
+			config.PrimaryNode.Database.User,
+			config.PrimaryNode.Database.Password,
+			config.PrimaryNode.Database.DBName)
 		DAL.db, err = sql.Open("postgres", conn)
 		if err != nil {
 			utils.DebugPrintln("InitConfig sql.Open:", err)
@@ -79,8 +86,35 @@ func InitConfig() {
 		DAL.db.SetMaxOpenConns(99)
 	} else {
 		// Init Node Key (Share with primary node)
-		NodeKey = NodeHexKeyToCryptKey(CFG.ReplicaNode.NodeKey)
+		NodeKey = NodeHexKeyToCryptKey(config.ReplicaNode.NodeKey)
+	}
+}
+
+// ReloadConfig reload config.json when SIGHUP received, current config is kept if failed, v1.2.4
+// The node role, database, node key, listen addresses and server settings take effect after restart
+func ReloadConfig() error {
+	newCFG, err := NewConfig("./config.json")
+	if err != nil {
+		return err
+	}
+	config := GetConfig()
+	if strings.ToLower(newCFG.NodeRole) != strings.ToLower(config.NodeRole) ||
+		newCFG.ListenHTTP != config.ListenHTTP || newCFG.ListenHTTPS != config.ListenHTTPS ||
+		newCFG.PrimaryNode.Admin != config.PrimaryNode.Admin ||
+		newCFG.PrimaryNode.Database != config.PrimaryNode.Database ||
+		newCFG.ReplicaNode != config.ReplicaNode ||
+		newCFG.Server != config.Server || newCFG.Metrics.Listen != config.Metrics.Listen {
+		utils.DebugPrintln("ReloadConfig: node role, database, node key, listen addresses and server settings require restart")
 	}
+	newCFG.NodeRole = config.NodeRole
+	newCFG.ListenHTTP = config.ListenHTTP
+	newCFG.ListenHTTPS = config.ListenHTTPS
+	newCFG.PrimaryNode = config.PrimaryNode
+	newCFG.ReplicaNode = config.ReplicaNode
+	newCFG.Server = config.Server
+	newCFG.Metrics.Listen = config.Metrics.Listen
+	cfg.Store(newCFG)
+	return nil
 }
 
 // ExecSQL Exec SQL Directly
@@ -20,7 +20,10 @@ import (
 
 // NewConfig ...
 func NewConfig(filename string) (*models.Config, error) {
-	config := &models.Config{}
+	// The defaults which can be set to 0 explicitly in config.json, v1.2.4
+	config := &models.Config{
+		Server: models.ServerConfig{ReadTimeout: 300},
+	}
 	configBytes, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
@@ -28,6 +31,7 @@ func NewConfig(filename string) (*models.Config, error) {
 	err = json.Unmarshal(configBytes, config)
 	if err != nil {
 		utils.DebugPrintln("NewConfig json.Unmarshal", err)
+		return nil, err
 	}
 	if strings.ToLower(config.NodeRole) == "primary" {
 		dbPassword := config.PrimaryNode.Database.Password
@@ -57,5 +61,104 @@ func NewConfig(filename string) (*models.Config, error) {
 	if len(config.ListenHTTPS) == 0 {
 		config.ListenHTTPS = ":443"
 	}
+	// Init default backend transport, v1.2.4
+	transport := &config.BackendTransport
+	if transport.MaxIdleConns == 0 {
+		transport.MaxIdleConns = 1024
+	}
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = 128
+	}
+	if transport.DialTimeout == 0 {
+		transport.DialTimeout = 10
+	}
+	if transport.IdleConnTimeout == 0 {
+		transport.IdleConnTimeout = 90
+	}
+	if transport.TLSHandshakeTimeout == 0 {
+		transport.TLSHandshakeTimeout = 30
+	}
+	// Init default retry and circuit breaker, v1.2.4
+	retry := &config.BackendRetry
+	if retry.MaxRetries == 0 {
+		retry.MaxRetries = 1
+	}
+	if retry.BudgetRatio == 0 {
+		retry.BudgetRatio = 0.2
+	}
+	if retry.MinRetriesPerSecond == 0 {
+		retry.MinRetriesPerSecond = 10
+	}
+	breaker := &config.CircuitBreaker
+	if breaker.ConsecutiveFailures == 0 {
+		breaker.ConsecutiveFailures = 5
+	}
+	if breaker.ErrorRatio == 0 {
+		breaker.ErrorRatio = 0.5
+	}
+	if breaker.MinRequests == 0 {
+		breaker.MinRequests = 20
+	}
+	if breaker.Window == 0 {
+		breaker.Window = 10
+	}
+	if breaker.Cooldown == 0 {
+		breaker.Cooldown = 30
+	}
+	// Init default server timeouts and limits, v1.2.4
+	server := &config.Server
+	if server.ReadHeaderTimeout == 0 {
+		server.ReadHeaderTimeout = 10
+	}
+	if server.IdleTimeout == 0 {
+		server.IdleTimeout = 120
+	}
+	if server.MaxHeaderBytes == 0 {
+		server.MaxHeaderBytes = 1 << 20
+	}
+	if server.MaxBodySize == 0 {
+		server.MaxBodySize = 100 << 20
+	}
+	if server.ShutdownTimeout == 0 {
+		server.ShutdownTimeout = 30
+	}
+	// Init default cache quota, v1.2.4
+	if config.Cache.MaxSize == 0 {
+		config.Cache.MaxSize = 10 << 30
+	}
+	if config.Cache.MaxMemorySize == 0 {
+		config.Cache.MaxMemorySize = 256 << 20
+	}
+	// Init default access log, v1.2.4
+	accessLog := &config.AccessLog
+	if len(accessLog.Format) == 0 {
+		accessLog.Format = "json"
+	}
+	if len(accessLog.File) == 0 {
+		accessLog.File = "./log/access.log"
+	}
+	if accessLog.MaxSize == 0 {
+		accessLog.MaxSize = 100 << 20
+	}
+	if accessLog.RotateInterval == 0 {
+		accessLog.RotateInterval = 86400
+	}
+	if accessLog.BufferSize == 0 {
+		accessLog.BufferSize = 8192
+	}
+	if accessLog.FlushInterval == 0 {
+		accessLog.FlushInterval = 1
+	}
+	// Init default metrics path, v1.2.4
+	if len(config.Metrics.Path) == 0 {
+		config.Metrics.Path = "/metrics"
+	}
+	// Init default tracing, v1.2.4
+	if len(config.Tracing.ServiceName) == 0 {
+		config.Tracing.ServiceName = "janusec"
+	}
+	if config.Tracing.SampleRatio <= 0 || config.Tracing.SampleRatio > 1 {
+		config.Tracing.SampleRatio = 1
+	}
 	return config, nil
 }
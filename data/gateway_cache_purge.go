@@ -0,0 +1,65 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-04 20:12:36
+ * @Last Modified: U2, 2021-06-04 20:12:36
+ */
+
+package data
+
+import (
+	"janusec/models"
+	"janusec/utils"
+)
+
+// CreateTableIfNotExistsCachePurges create cache_purges, v1.2.4
+func (dal *MyDAL) CreateTableIfNotExistsCachePurges() error {
+	const sqlCreateTableIfNotExistsCachePurges = `CREATE TABLE IF NOT EXISTS "cache_purges"("id" bigserial PRIMARY KEY,"purge_type" bigint NOT NULL,"app_id" bigint default 0,"pattern" VARCHAR(1024) NOT NULL DEFAULT '',"purge_time" bigint)`
+	_, err := dal.db.Exec(sqlCreateTableIfNotExistsCachePurges)
+	if err != nil {
+		utils.DebugPrintln("CreateTableIfNotExistsCachePurges", err)
+	}
+	return err
+}
+
+// SelectCachePurgesAfterID return the purges in order of ID, used by replica nodes
+func (dal *MyDAL) SelectCachePurgesAfterID(id int64) []*models.CachePurge {
+	const sqlSelectCachePurgesAfterID = `SELECT "id","purge_type","app_id","pattern","purge_time" FROM "cache_purges" WHERE "id">$1 ORDER BY "id"`
+	purges := []*models.CachePurge{}
+	rows, err := dal.db.Query(sqlSelectCachePurgesAfterID, id)
+	if err != nil {
+		utils.DebugPrintln("SelectCachePurgesAfterID", err)
+		return purges
+	}
+	defer rows.Close()
+	for rows.Next() {
+		purge := &models.CachePurge{}
+		err = rows.Scan(&purge.ID, &purge.Type, &purge.AppID, &purge.Pattern, &purge.PurgeTime)
+		if err != nil {
+			utils.DebugPrintln("SelectCachePurgesAfterID rows.Scan", err)
+			continue
+		}
+		purges = append(purges, purge)
+	}
+	return purges
+}
+
+// InsertCachePurge ...
+func (dal *MyDAL) InsertCachePurge(purge *models.CachePurge) (newID int64, err error) {
+	const sqlInsertCachePurge = `INSERT INTO "cache_purges"("purge_type","app_id","pattern","purge_time") VALUES($1,$2,$3,$4) RETURNING "id"`
+	err = dal.db.QueryRow(sqlInsertCachePurge, purge.Type, purge.AppID, purge.Pattern, purge.PurgeTime).Scan(&newID)
+	if err != nil {
+		utils.DebugPrintln("InsertCachePurge", err)
+	}
+	return newID, err
+}
+
+// DeleteCachePurgesBeforeTime ...
+func (dal *MyDAL) DeleteCachePurgesBeforeTime(purgeTime int64) error {
+	const sqlDeleteCachePurgesBeforeTime = `DELETE FROM "cache_purges" WHERE "purge_time"<$1`
+	_, err := dal.db.Exec(sqlDeleteCachePurgesBeforeTime, purgeTime)
+	if err != nil {
+		utils.DebugPrintln("DeleteCachePurgesBeforeTime", err)
+	}
+	return err
+}
@@ -46,6 +46,15 @@ func UpdateFirewallLastModified() {
 	utils.DebugPrintln("Firewall Modified")
 }
 
+// UpdateCachePurgeID notify replica nodes to replay the cache purges, v1.2.4
+func UpdateCachePurgeID(purgeID int64) {
+	NodeSetting.CachePurgeID = purgeID
+	err := DAL.SaveIntSetting("cache_purge_id", purgeID)
+	if err != nil {
+		utils.DebugPrintln("UpdateCachePurgeID SaveIntSetting", err)
+	}
+}
+
 // InitDefaultSettings ...
 func InitDefaultSettings() {
 	DAL.LoadInstanceKey()
@@ -86,6 +95,14 @@ func InitDefaultSettings() {
 		// used for 5-second shield, v1.2.0
 		_ = DAL.SaveStringSetting("search_engines", "Google|Baidu|MicroMessenger|miniprogram|bing|sogou|Yisou|360spider|soso|duckduck|Yandex|Yahoo|AOL|teoma")
 	}
+	if !DAL.ExistsSetting("trusted_proxies") {
+		// used for client IP resolution, v1.2.4, shared with NodeSetting
+		_ = DAL.SaveStringSetting("trusted_proxies", "")
+	}
+	if !DAL.ExistsSetting("cache_purge_id") {
+		// the latest cache purge, v1.2.4, shared with NodeSetting
+		_ = DAL.SaveIntSetting("cache_purge_id", 0)
+	}
 	if !DAL.ExistsSetting("smtp_server") {
 		_ = DAL.SaveStringSetting("smtp_server", "smtp.example.com")
 	}
@@ -226,6 +243,8 @@ func LoadSettings() {
 		// v1.2.0 add search engines for 5-second shield
 		PrimarySetting.SkipSEEnabled = DAL.SelectBoolSetting("skip_se_enabled")
 		PrimarySetting.SearchEngines = DAL.SelectStringSetting("search_engines")
+		// v1.2.4 add trusted proxies
+		PrimarySetting.TrustedProxies = DAL.SelectStringSetting("trusted_proxies")
 		// v1.2.0 add SMTP
 		smtpSetting := &models.SMTPSetting{}
 		smtpSetting.SMTPEnabled = DAL.SelectBoolSetting("smtp_enabled")
@@ -244,6 +263,8 @@ func LoadSettings() {
 		NodeSetting.SyncInterval = time.Duration(SyncScndsInt64) * time.Second
 		NodeSetting.SkipSEEnabled = PrimarySetting.SkipSEEnabled
 		NodeSetting.SearchEnginesPattern = UpdateSecondShieldPattern(PrimarySetting.SearchEngines)
+		NodeSetting.TrustedProxies = PrimarySetting.TrustedProxies
+		NodeSetting.CachePurgeID = DAL.SelectIntSetting("cache_purge_id")
 		// NodeSetting.SMTP and PrimarySetting.SMTP point to the same SMTP setting
 		NodeSetting.SMTP = smtpSetting
 		// LoadAuthConfig
@@ -559,6 +580,8 @@ func UpdatePrimarySetting(r *http.Request, param map[string]interface{}, clientI
 	DAL.SaveBoolSetting("skip_se_enabled", PrimarySetting.SkipSEEnabled)
 	DAL.SaveStringSetting("search_engines", PrimarySetting.SearchEngines)
 	NodeSetting.SearchEnginesPattern = UpdateSecondShieldPattern(PrimarySetting.SearchEngines)
+	DAL.SaveStringSetting("trusted_proxies", PrimarySetting.TrustedProxies)
+	NodeSetting.TrustedProxies = PrimarySetting.TrustedProxies
 	DAL.SaveBoolSetting("smtp_enabled", PrimarySetting.SMTP.SMTPEnabled)
 	DAL.SaveStringSetting("smtp_server", PrimarySetting.SMTP.SMTPServer)
 	DAL.SaveStringSetting("smtp_port", PrimarySetting.SMTP.SMTPPort)
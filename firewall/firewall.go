@@ -9,7 +9,6 @@ package firewall
 
 import (
 	"bytes"
-	"compress/gzip"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -313,19 +312,11 @@ func IsResponseHitPolicy(resp *http.Response, appID int64) (bool, *models.GroupP
 	if err != nil {
 		utils.DebugPrintln("IsResponseHitPolicy ChkPoint_ResponseBody ReadAll", err)
 	}
-	contentEncoding := resp.Header.Get("Content-Encoding")
-	var body1 string
-	if contentEncoding == "gzip" {
-		reader, _ := gzip.NewReader(bytes.NewBuffer(bodyBuf))
-		defer reader.Close()
-		decompressedBodyBuf, err := ioutil.ReadAll(reader)
-		if err != nil {
-			utils.DebugPrintln("Gzip decompress Error", err)
-		}
-		body1 = string(decompressedBodyBuf)
-	} else {
-		body1 = string(bodyBuf)
+	decompressedBodyBuf, err := utils.DecodeContent(resp.Header.Get("Content-Encoding"), bodyBuf)
+	if err != nil {
+		utils.DebugPrintln("IsResponseHitPolicy decompress Error", err)
 	}
+	body1 := string(decompressedBodyBuf)
 	resp.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBuf))
 	matched, policy = IsMatchGroupPolicy(ctxMap, appID, body1, models.ChkPointResponseBody, "", false)
 	//fmt.Println("IsResponseHitPolicy ChkPoint_ResponseBody", matched, resp.ContentLength, bodyLength, "000", body1)
@@ -8,10 +8,14 @@
 package firewall
 
 import (
+	"sync"
+
 	"janusec/models"
 	"janusec/utils"
 )
 
+var routineOnce sync.Once
+
 // InitFirewall ...
 func InitFirewall() {
 	utils.DebugPrintln("InitFirewall")
@@ -30,6 +34,8 @@ func InitFirewall() {
 	LoadCheckItems()
 	InitHitLog()
 	InitNFTables()
-	go RoutineCleanLogTick()
-	go RoutineCleanCacheTick()
+	// InitFirewall is called again by sync and reload, start routines only once, v1.2.4
+	routineOnce.Do(func() {
+		go RoutineCleanLogTick()
+	})
 }
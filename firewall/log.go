@@ -30,11 +30,24 @@ func InitHitLog() {
 		if err != nil {
 			utils.DebugPrintln("InitHitLog CreateTableIfNotExistsCCLog error", err)
 		}
+		// v1.2.4 add request_id to hit logs
+		if !data.DAL.ExistColumnInTable("group_hit_logs", "request_id") {
+			err = data.DAL.ExecSQL(`ALTER TABLE "group_hit_logs" ADD COLUMN "request_id" VARCHAR(128) NOT NULL DEFAULT ''`)
+			if err != nil {
+				utils.DebugPrintln("InitHitLog ALTER TABLE group_hit_logs add request_id", err)
+			}
+		}
+		if !data.DAL.ExistColumnInTable("cc_logs", "request_id") {
+			err = data.DAL.ExecSQL(`ALTER TABLE "cc_logs" ADD COLUMN "request_id" VARCHAR(128) NOT NULL DEFAULT ''`)
+			if err != nil {
+				utils.DebugPrintln("InitHitLog ALTER TABLE cc_logs add request_id", err)
+			}
+		}
 	}
 }
 
 // LogCCRequest ...
-func LogCCRequest(r *http.Request, appID int64, clientIP string, policy *models.CCPolicy) {
+func LogCCRequest(r *http.Request, appID int64, clientIP string, policy *models.CCPolicy, requestID string) {
 	requestTime := time.Now().Unix()
 	contentType := r.Header.Get("Content-Type")
 	cookies := r.Header.Get("Cookie")
@@ -51,7 +64,7 @@ func LogCCRequest(r *http.Request, appID int64, clientIP string, policy *models.
 	}
 	rawRequest := string(rawRequestBytes[:maxRawSize])
 	if data.IsPrimary {
-		err = data.DAL.InsertCCLog(requestTime, clientIP, r.Host, r.Method, r.URL.Path, r.URL.RawQuery, contentType, r.UserAgent(), cookies, rawRequest, int64(policy.Action), appID)
+		err = data.DAL.InsertCCLog(requestTime, clientIP, r.Host, r.Method, r.URL.Path, r.URL.RawQuery, contentType, r.UserAgent(), cookies, rawRequest, int64(policy.Action), appID, requestID)
 		if err != nil {
 			utils.DebugPrintln("InsertCCLog error", err)
 		}
@@ -68,13 +81,14 @@ func LogCCRequest(r *http.Request, appID int64, clientIP string, policy *models.
 			Cookies:     cookies,
 			RawRequest:  rawRequest,
 			Action:      policy.Action,
-			AppID:       appID}
+			AppID:       appID,
+			RequestID:   requestID}
 		RPCCCLog(ccLog)
 	}
 }
 
 // LogGroupHitRequest ...
-func LogGroupHitRequest(r *http.Request, appID int64, clientIP string, policy *models.GroupPolicy) {
+func LogGroupHitRequest(r *http.Request, appID int64, clientIP string, policy *models.GroupPolicy, requestID string) {
 	requestTime := time.Now().Unix()
 	contentType := r.Header.Get("Content-Type")
 	cookies := r.Header.Get("Cookie")
@@ -88,7 +102,7 @@ func LogGroupHitRequest(r *http.Request, appID int64, clientIP string, policy *m
 	}
 	rawRequest := string(rawRequestBytes[:maxRawSize])
 	if data.IsPrimary {
-		err = data.DAL.InsertGroupHitLog(requestTime, clientIP, r.Host, r.Method, r.URL.Path, r.URL.RawQuery, contentType, r.UserAgent(), cookies, rawRequest, int64(policy.Action), policy.ID, policy.VulnID, appID)
+		err = data.DAL.InsertGroupHitLog(requestTime, clientIP, r.Host, r.Method, r.URL.Path, r.URL.RawQuery, contentType, r.UserAgent(), cookies, rawRequest, int64(policy.Action), policy.ID, policy.VulnID, appID, requestID)
 		if err != nil {
 			utils.DebugPrintln("InsertGroupHitLog error", err)
 		}
@@ -107,7 +121,8 @@ func LogGroupHitRequest(r *http.Request, appID int64, clientIP string, policy *m
 			Action:      policy.Action,
 			PolicyID:    policy.ID,
 			VulnID:      policy.VulnID,
-			AppID:       appID}
+			AppID:       appID,
+			RequestID:   requestID}
 		RPCGroupHitLog(regexHitLog)
 	}
 }
@@ -124,7 +139,7 @@ func LogCCRequestAPI(r *http.Request) error {
 	if ccLog == nil {
 		return errors.New("LogCCRequestAPI parse body null")
 	}
-	return data.DAL.InsertCCLog(ccLog.RequestTime, ccLog.ClientIP, ccLog.Host, ccLog.Method, ccLog.UrlPath, ccLog.UrlQuery, ccLog.ContentType, ccLog.UserAgent, ccLog.Cookies, ccLog.RawRequest, int64(ccLog.Action), ccLog.AppID)
+	return data.DAL.InsertCCLog(ccLog.RequestTime, ccLog.ClientIP, ccLog.Host, ccLog.Method, ccLog.UrlPath, ccLog.UrlQuery, ccLog.ContentType, ccLog.UserAgent, ccLog.Cookies, ccLog.RawRequest, int64(ccLog.Action), ccLog.AppID, ccLog.RequestID)
 }
 
 // LogGroupHitRequestAPI ...
@@ -139,7 +154,7 @@ func LogGroupHitRequestAPI(r *http.Request) error {
 	if regexHitLog == nil {
 		return errors.New("LogGroupHitRequestAPI parse body null")
 	}
-	return data.DAL.InsertGroupHitLog(regexHitLog.RequestTime, regexHitLog.ClientIP, regexHitLog.Host, regexHitLog.Method, regexHitLog.UrlPath, regexHitLog.UrlQuery, regexHitLog.ContentType, regexHitLog.UserAgent, regexHitLog.Cookies, regexHitLog.RawRequest, int64(regexHitLog.Action), regexHitLog.PolicyID, regexHitLog.VulnID, regexHitLog.AppID)
+	return data.DAL.InsertGroupHitLog(regexHitLog.RequestTime, regexHitLog.ClientIP, regexHitLog.Host, regexHitLog.Method, regexHitLog.UrlPath, regexHitLog.UrlQuery, regexHitLog.ContentType, regexHitLog.UserAgent, regexHitLog.Cookies, regexHitLog.RawRequest, int64(regexHitLog.Action), regexHitLog.PolicyID, regexHitLog.VulnID, regexHitLog.AppID, regexHitLog.RequestID)
 }
 
 // GetCCLogCount ...
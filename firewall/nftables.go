@@ -8,6 +8,7 @@
 package firewall
 
 import (
+	"errors"
 	"janusec/utils"
 	"net"
 	"time"
@@ -84,12 +85,17 @@ func InitNFTables() {
 // nft add element inet janusec blocklist { 192.168.100.1 timeout 300s }
 func AddIP2NFTables(ip string, blockSeconds float64) {
 	//fmt.Println("AddIP2NFTables", ip)
+	ipv4 := net.ParseIP(ip).To4()
+	if ipv4 == nil {
+		// the set is ipv4 only
+		return
+	}
 	rules, _ := conn.GetRule(table, chain)
 	if len(rules) == 0 {
 		InitNFTables()
 	}
 	err := conn.SetAddElements(set, []nftables.SetElement{
-		{Key: []byte(net.ParseIP(ip).To4()), Timeout: time.Duration(blockSeconds) * time.Second},
+		{Key: []byte(ipv4), Timeout: time.Duration(blockSeconds) * time.Second},
 	})
 	if err != nil {
 		utils.DebugPrintln("AddIP2NFTables SetAddElements error", err)
@@ -99,3 +105,15 @@ func AddIP2NFTables(ip string, blockSeconds float64) {
 		utils.DebugPrintln("AddIP2NFTables flush error", err)
 	}
 }
+
+// GetBlockedIPCount return the count of IP addresses in the block list, v1.2.4
+func GetBlockedIPCount() (int, error) {
+	if conn == nil || set == nil {
+		return 0, errors.New("nftables is not initialized")
+	}
+	elements, err := conn.GetSetElements(set)
+	if err != nil {
+		return 0, err
+	}
+	return len(elements), nil
+}
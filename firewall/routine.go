@@ -8,9 +8,6 @@
 package firewall
 
 import (
-	"io/ioutil"
-	"os"
-	"syscall"
 	"time"
 
 	"janusec/data"
@@ -37,37 +34,3 @@ func RoutineCleanLogTick() {
 		}
 	}
 }
-
-// RoutineCleanCacheTick Clean expired cdn files
-func RoutineCleanCacheTick() {
-	routineTicker := time.NewTicker(time.Duration(7200) * time.Second)
-	for range routineTicker.C {
-		ClearExpiredFiles("./static/cdncache/", time.Now())
-	}
-}
-
-// ClearExpiredFiles clear expired static cdn files
-func ClearExpiredFiles(path string, now time.Time) {
-	fs, err := ioutil.ReadDir(path)
-	if err != nil {
-		utils.DebugPrintln("ClearExpiredFiles", err)
-	}
-	for _, file := range fs {
-		if file.IsDir() {
-			ClearExpiredFiles(path+file.Name()+"/", now)
-		} else {
-			targetFile := path + file.Name()
-			if fi, err := os.Stat(targetFile); err == nil {
-				fiStat := fi.Sys().(*syscall.Stat_t)
-				// Use ctime fiStat.Ctim.Sec to mark the last check time
-				pastSeconds := now.Unix() - int64(fiStat.Ctim.Sec)
-				if pastSeconds >= 86400*7 {
-					err = os.Remove(targetFile)
-					if err != nil {
-						utils.DebugPrintln("ClearExpiredFiles Remove", targetFile, err)
-					}
-				}
-			}
-		}
-	}
-}
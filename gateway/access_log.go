@@ -0,0 +1,143 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-08 21:45:18
+ * @Last Modified: U2, 2021-06-08 21:45:18
+ */
+
+package gateway
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"janusec/backend"
+	"janusec/models"
+)
+
+// Verdicts of WAF, CC, concurrency limit and waiting room in access log
+const (
+	verdictIPBlock    = "ip_block"
+	verdictShield     = "shield"
+	verdictCrawler    = "crawler_block"
+	verdictCCBlock    = "cc_block"
+	verdictCCLog      = "cc_log"
+	verdictCCCaptcha  = "cc_captcha"
+	verdictWAFBlock   = "waf_block"
+	verdictWAFLog     = "waf_log"
+	verdictWAFCaptcha = "waf_captcha"
+
+	verdictConcurrencyLimit = "concurrency_limit"
+	verdictWaitingRoom      = "waiting_room"
+)
+
+// accessLogWriter record the status code and the bytes of response body
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter, informational responses except 101 are not recorded
+func (w *accessLogWriter) WriteHeader(statusCode int) {
+	if w.status == 0 && (statusCode >= 200 || statusCode == http.StatusSwitchingProtocols) {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements http.ResponseWriter
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, used by streaming responses
+func (w *accessLogWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, used by WebSocket
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap is used by http.ResponseController
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// newAccessLogRecord is called after the response completes
+func newAccessLogRecord(w *accessLogWriter, r *http.Request, reqCtx *RequestContext, srcIP string) *models.AccessLogRecord {
+	now := time.Now()
+	domain := r.Host
+	if index := strings.IndexByte(domain, ':'); index > 0 {
+		domain = domain[:index]
+	}
+	status := w.status
+	if status == 0 {
+		// Nothing written, net/http responses 200
+		status = http.StatusOK
+	}
+	record := &models.AccessLogRecord{
+		Time:         reqCtx.StartTime,
+		RequestID:    reqCtx.RequestID,
+		AppID:        reqCtx.AppID,
+		Domain:       domain,
+		ClientIP:     srcIP,
+		Method:       r.Method,
+		URI:          r.RequestURI,
+		Proto:        r.Proto,
+		Status:       status,
+		Bytes:        w.bytes,
+		Referer:      r.Referer(),
+		UserAgent:    r.UserAgent(),
+		TotalLatency: getMilliseconds(now.Sub(reqCtx.StartTime)),
+		Verdict:      reqCtx.Verdict,
+		Cache:        w.Header().Get("X-Cache"),
+		AuthUser:     reqCtx.AuthUser,
+		TraceID:      reqCtx.Trace.TraceID(),
+	}
+	if len(srcIP) == 0 {
+		record.ClientIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	if reqCtx.Dest != nil {
+		record.Destination = reqCtx.Dest.Destination
+	}
+	if !reqCtx.UpstreamStart.IsZero() {
+		record.RequestLatency = getMilliseconds(reqCtx.UpstreamStart.Sub(reqCtx.StartTime))
+		upstreamLatency := reqCtx.UpstreamLatency
+		if upstreamLatency == 0 {
+			// FastCGI, uWSGI and SCGI, until the response completes
+			upstreamLatency = now.Sub(reqCtx.UpstreamStart)
+		}
+		record.UpstreamLatency = getMilliseconds(upstreamLatency)
+	} else {
+		record.RequestLatency = record.TotalLatency
+	}
+	if r.TLS != nil {
+		record.TLSVersion = backend.GetTLSVersionName(r.TLS.Version)
+	}
+	return record
+}
+
+func getMilliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
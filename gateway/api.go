@@ -92,6 +92,8 @@ func AdminAPIHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		obj, err = backend.GetVipAppByID(id)
 	case "update_app":
 		obj, err = backend.UpdateApplication(param, clientIP, authUser)
+	case "update_traffic_rules":
+		obj, err = backend.UpdateAppTrafficRules(param, clientIP, authUser)
 	case "update_vip_app":
 		obj, err = backend.UpdateVipApp(param, clientIP, authUser)
 	case "del_app":
@@ -113,11 +115,36 @@ func AdminAPIHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		id := int64(param["id"].(float64))
 		obj = nil
 		err = backend.DeleteCertificateByID(id, clientIP, authUser)
+	case "get_ca_certs":
+		obj, err = backend.GetCACerts()
+	case "get_ca_cert":
+		id := int64(param["id"].(float64))
+		obj, err = backend.GetCACertByID(id)
+	case "update_ca_cert":
+		obj, err = backend.UpdateCACert(param, clientIP, authUser)
+	case "del_ca_cert":
+		id := int64(param["id"].(float64))
+		obj = nil
+		err = backend.DeleteCACertByID(id, clientIP, authUser)
+	case "get_tls_profiles":
+		obj, err = backend.GetTLSProfiles()
+	case "get_tls_profile":
+		id := int64(param["id"].(float64))
+		obj, err = backend.GetTLSProfileByID(id)
+	case "update_tls_profile":
+		obj, err = backend.UpdateTLSProfile(param, clientIP, authUser)
+	case "del_tls_profile":
+		id := int64(param["id"].(float64))
+		obj = nil
+		err = backend.DeleteTLSProfileByID(id, clientIP, authUser)
 	case "self_sign_cert":
 		obj, err = utils.GenerateRSACertificate(param)
 	case "get_domains":
 		obj = backend.Domains
 		err = nil
+	case "get_cache_purges":
+		afterID := int64(param["id"].(float64))
+		obj, err = GetCachePurges(afterID)
 	case "get_app_users":
 		obj, err = usermgmt.GetAppUsers(authUser)
 	case "get_app_user":
@@ -196,6 +223,12 @@ func AdminAPIHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		obj, err = GetTodayPopularContent(param)
 	case "get_gateway_health":
 		obj, err = GetGatewayHealth()
+	case "get_cache_stats":
+		obj, err = GetCacheStats(authUser)
+	case "purge_cache":
+		obj, err = PurgeCache(param, clientIP, authUser)
+	case "get_mirror_stats":
+		obj, err = backend.GetMirrorStats(authUser)
 	case "get_primary_setting":
 		obj, err = data.GetPrimarySetting(authUser)
 	case "update_primary_setting":
@@ -295,6 +328,10 @@ func ReplicaAPIHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		obj, err = backend.GetVipApps(authUser)
 	case "get_certs":
 		obj, err = backend.GetCertificates(authUser)
+	case "get_ca_certs":
+		obj, err = backend.GetCACerts()
+	case "get_tls_profiles":
+		obj, err = backend.GetTLSProfiles()
 	case "get_domains":
 		obj = backend.Domains
 		err = nil
@@ -309,6 +346,13 @@ func ReplicaAPIHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		obj, err = firewall.GetVulnTypes()
 	case "get_node_setting":
 		obj, err = data.GetNodeSetting(), nil
+	case "get_cache_purges":
+		afterID := int64(param["id"].(float64))
+		obj, err = GetCachePurges(afterID)
+	case "check_waiting_room":
+		appID := int64(param["id"].(float64))
+		ticketID, _ := param["object"].(string)
+		obj, err = backend.CheckWaitingRoom(appID, ticketID)
 	case "get_oauth_conf":
 		obj, err = usermgmt.GetOAuthConfig()
 	case "log_group_hit":
@@ -0,0 +1,846 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-03 21:36:12
+ * @Last Modified: U2, 2021-06-03 21:36:12
+ */
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"janusec/backend"
+	"janusec/data"
+	"janusec/firewall"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// cacheRoot is the directory of shared cache, each application has a sub directory named by its ID
+const cacheRoot = "./static/cdncache"
+
+// maxCacheObjectSize responses bigger than 10MB are not cached
+const maxCacheObjectSize = 10 * 1024 * 1024
+
+// cacheExpiredSeconds entries stale for more than 7 days are removed
+const cacheExpiredSeconds = 86400 * 7
+
+// revalidateTimeout is the timeout of conditional requests sent to the backend
+const revalidateTimeout = 30 * time.Second
+
+// cacheMetaExt is the file extension of metadata besides the body file
+const cacheMetaExt = ".meta"
+
+// hopHeaders and the headers which are regenerated when serving from cache, are not stored
+var uncachedHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Content-Length", "Content-Encoding", "Content-Range", "Accept-Ranges",
+	"Age", "Date", "Set-Cookie", "X-Cache", "X-Request-ID",
+}
+
+// CacheEntry is the metadata of a cached response, saved as JSON besides the body file
+type CacheEntry struct {
+	// Key is host + request URI, the variants selected by Vary and the key headers and cookies of cache rule share the same key
+	Key       string   `json:"key"`
+	VaryNames []string `json:"vary_names"`
+	VaryKey   string   `json:"vary_key"`
+	AppID     int64    `json:"app_id"`
+	// Status 0 means 200, other status codes are cached by cache rules
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header"`
+	Size   int64       `json:"size"`
+	// ResponseTime is the unix time when the response was received or revalidated
+	ResponseTime         int64 `json:"response_time"`
+	InitialAge           int64 `json:"initial_age"`
+	Lifetime             int64 `json:"lifetime"`
+	StaleWhileRevalidate int64 `json:"stale_while_revalidate"`
+	StaleIfError         int64 `json:"stale_if_error"`
+	MustRevalidate       bool  `json:"must_revalidate"`
+
+	// file is the path of body file, the metadata is file + ".meta"
+	file string
+	// lastAccess is the unix time of last hit, accessed atomically
+	lastAccess int64
+}
+
+// cacheVariants are the entries of the same key, indexed by the values of request headers selected by Vary
+type cacheVariants struct {
+	varyNames []string
+	entries   map[string]*CacheEntry
+}
+
+// cacheUsage is the count and size of entries of an application, protected by cacheMutex
+type cacheUsage struct {
+	entries int64
+	size    int64
+}
+
+// cacheCounter is the statistics of an application, accessed atomically
+type cacheCounter struct {
+	hits        int64
+	misses      int64
+	stale       int64
+	revalidated int64
+	evictions   int64
+	memoryHits  int64
+}
+
+var (
+	cacheMutex sync.RWMutex
+	// cacheIndex map[key string]*cacheVariants, the metadata of all entries on disk
+	cacheIndex = map[string]*cacheVariants{}
+	// cacheUsages map[appID int64]*cacheUsage, and the total size of all applications
+	cacheUsages    = map[int64]*cacheUsage{}
+	cacheTotalSize int64
+	// cacheCounters map[appID int64]*cacheCounter
+	cacheCounters sync.Map
+	// evicting is 1 when the LRU eviction is running
+	evicting int32
+	// revalidating map[file string]bool, at most one background revalidation per entry
+	revalidating sync.Map
+)
+
+// age is the current age of the entry in seconds, RFC 7234 4.2.3
+func (entry *CacheEntry) age(now int64) int64 {
+	return entry.InitialAge + now - entry.ResponseTime
+}
+
+// statusCode of the stored response
+func (entry *CacheEntry) statusCode() int {
+	if entry.Status == 0 {
+		return http.StatusOK
+	}
+	return entry.Status
+}
+
+// getCacheHost return the host without port in lower case
+func getCacheHost(r *http.Request) string {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.ToLower(host)
+}
+
+// getCacheKey is host + path + query of the client request, before replaced with the backend route,
+// the query parameters may be selected by cache rule
+func getCacheKey(r *http.Request) string {
+	reqCtx := GetRequestContext(r)
+	if len(reqCtx.CacheKey) > 0 {
+		return reqCtx.CacheKey
+	}
+	key := getCacheHost(r) + reqCtx.OriginPath
+	if query := getCacheQuery(r, reqCtx.CacheRule); len(query) > 0 {
+		key += "?" + query
+	}
+	return key
+}
+
+// getVariantKey is the values of request headers selected by Vary, and the headers and cookies selected by cache rule
+func getVariantKey(r *http.Request, varyNames []string) string {
+	return getVaryKey(r, varyNames) + GetRequestContext(r).CacheRuleKey
+}
+
+// getCacheFile return the path of body file of the variant
+func getCacheFile(appID int64, key string, varyKey string) string {
+	sum := sha256.Sum256([]byte(key + "\n" + varyKey))
+	hash := hex.EncodeToString(sum[:])
+	return filepath.Join(cacheRoot, strconv.FormatInt(appID, 10), hash[:2], hash)
+}
+
+// lookupCacheEntry return nil if the request does not match any stored variant
+func lookupCacheEntry(r *http.Request) *CacheEntry {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	variants, ok := cacheIndex[getCacheKey(r)]
+	if !ok {
+		return nil
+	}
+	return variants.entries[getVariantKey(r, variants.varyNames)]
+}
+
+// InitCache load the metadata of cached entries, and remove files of previous versions which have no metadata
+func InitCache() {
+	loaded := 0
+	err := filepath.Walk(cacheRoot, func(path string, info os.FileInfo, err error) error {
+		// The files in root are not cache entries, such as last_purge_id
+		if err != nil || info.IsDir() || filepath.Dir(path) == filepath.Clean(cacheRoot) {
+			return nil
+		}
+		if strings.HasSuffix(path, cacheMetaExt) {
+			entry, err := loadCacheEntry(strings.TrimSuffix(path, cacheMetaExt))
+			if err != nil {
+				utils.DebugPrintln("InitCache load", path, err)
+				removeCacheFiles(strings.TrimSuffix(path, cacheMetaExt))
+				return nil
+			}
+			if addCacheEntry(entry, false) {
+				loaded++
+			}
+			return nil
+		}
+		file := path
+		for _, ext := range precompressedExt {
+			file = strings.TrimSuffix(file, ext)
+		}
+		if _, err := os.Stat(file + cacheMetaExt); os.IsNotExist(err) {
+			if err = os.Remove(path); err != nil {
+				utils.DebugPrintln("InitCache remove", path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		utils.DebugPrintln("InitCache", err)
+	}
+	utils.DebugPrintln("InitCache loaded", loaded, "entries")
+	if !data.IsPrimary {
+		// Replay the purges during downtime
+		purgeMutex.Lock()
+		initLastPurgeID()
+		cacheLoaded = true
+		purgeMutex.Unlock()
+		SyncCachePurges()
+	}
+	// The quota may be decreased
+	EvictCacheEntries()
+}
+
+// loadCacheEntry read the metadata and check the body file
+func loadCacheEntry(file string) (*CacheEntry, error) {
+	metaBytes, err := ioutil.ReadFile(file + cacheMetaExt)
+	if err != nil {
+		return nil, err
+	}
+	entry := &CacheEntry{}
+	if err = json.Unmarshal(metaBytes, entry); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() != entry.Size {
+		return nil, errors.New("size mismatch")
+	}
+	entry.file = file
+	entry.lastAccess = fi.ModTime().Unix()
+	return entry, nil
+}
+
+// addCacheEntry put the entry into index, the variants with different Vary are replaced,
+// return false if replace is false and the variant exists
+func addCacheEntry(entry *CacheEntry, replace bool) bool {
+	obsoleteFiles := []string{}
+	defer func() {
+		for _, file := range obsoleteFiles {
+			removeCacheFiles(file)
+		}
+	}()
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	variants, ok := cacheIndex[entry.Key]
+	if ok && !isSameVaryNames(variants.varyNames, entry.VaryNames) {
+		if !replace {
+			return false
+		}
+		// The Vary of the latest response takes precedence
+		for _, oldEntry := range variants.entries {
+			decreaseCacheUsage(oldEntry)
+			obsoleteFiles = append(obsoleteFiles, oldEntry.file)
+		}
+		ok = false
+	}
+	if !ok {
+		variants = &cacheVariants{varyNames: entry.VaryNames, entries: map[string]*CacheEntry{}}
+		cacheIndex[entry.Key] = variants
+	}
+	if oldEntry, exists := variants.entries[entry.VaryKey]; exists {
+		if !replace {
+			return false
+		}
+		decreaseCacheUsage(oldEntry)
+	}
+	variants.entries[entry.VaryKey] = entry
+	usage, ok := cacheUsages[entry.AppID]
+	if !ok {
+		usage = &cacheUsage{}
+		cacheUsages[entry.AppID] = usage
+	}
+	usage.entries++
+	usage.size += entry.Size
+	cacheTotalSize += entry.Size
+	return true
+}
+
+// decreaseCacheUsage is called with cacheMutex locked
+func decreaseCacheUsage(entry *CacheEntry) {
+	if usage, ok := cacheUsages[entry.AppID]; ok {
+		usage.entries--
+		usage.size -= entry.Size
+		if usage.entries <= 0 {
+			delete(cacheUsages, entry.AppID)
+		}
+	}
+	cacheTotalSize -= entry.Size
+}
+
+// removeCacheEntry remove the entry from index and disk, unless it has been replaced
+func removeCacheEntry(entry *CacheEntry) {
+	cacheMutex.Lock()
+	variants, ok := cacheIndex[entry.Key]
+	if !ok || variants.entries[entry.VaryKey] != entry {
+		cacheMutex.Unlock()
+		return
+	}
+	delete(variants.entries, entry.VaryKey)
+	if len(variants.entries) == 0 {
+		delete(cacheIndex, entry.Key)
+	}
+	decreaseCacheUsage(entry)
+	cacheMutex.Unlock()
+	removeCacheFiles(entry.file)
+}
+
+// removeCacheFiles remove the body, metadata and precompressed variants, both on disk and in memory
+func removeCacheFiles(file string) {
+	for _, ext := range []string{"", cacheMetaExt, precompressedExt["br"], precompressedExt["gzip"]} {
+		if err := os.Remove(file + ext); err != nil && !os.IsNotExist(err) {
+			utils.DebugPrintln("removeCacheFiles", file+ext, err)
+		}
+		removeMemoryFile(file + ext)
+	}
+}
+
+func isSameVaryNames(names1 []string, names2 []string) bool {
+	if len(names1) != len(names2) {
+		return false
+	}
+	for i := range names1 {
+		if names1[i] != names2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFileAtomic write to a temporary file and rename it, so that readers never see partial content
+func writeFileAtomic(file string, content []byte) error {
+	tmpFile := fmt.Sprintf("%s.%d.tmp", file, time.Now().UnixNano())
+	if err := ioutil.WriteFile(tmpFile, content, 0600); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+// saveCacheMeta write the metadata of entry
+func saveCacheMeta(entry *CacheEntry) error {
+	metaBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(entry.file+cacheMetaExt, metaBytes)
+}
+
+// newCacheEntry check the response with RFC 7234, return nil if it can not be stored
+func newCacheEntry(r *http.Request, resp *http.Response, app *models.Application, responseTime time.Time, isStatic bool) *CacheEntry {
+	policy := getCachePolicy(r, resp, responseTime, isStatic, GetRequestContext(r).CacheRule)
+	if !policy.storable {
+		return nil
+	}
+	varyNames, _ := getVaryNames(resp.Header)
+	entry := &CacheEntry{
+		Key:                  getCacheKey(r),
+		VaryNames:            varyNames,
+		VaryKey:              getVariantKey(r, varyNames),
+		AppID:                app.ID,
+		Header:               http.Header{},
+		ResponseTime:         responseTime.Unix(),
+		InitialAge:           policy.initialAge,
+		Lifetime:             policy.lifetime,
+		StaleWhileRevalidate: policy.staleWhileRevalidate,
+		StaleIfError:         policy.staleIfError,
+		MustRevalidate:       policy.mustRevalidate,
+		lastAccess:           responseTime.Unix(),
+	}
+	if resp.StatusCode != http.StatusOK {
+		entry.Status = resp.StatusCode
+	}
+	entry.file = getCacheFile(app.ID, entry.Key, entry.VaryKey)
+	for name, values := range resp.Header {
+		entry.Header[name] = append([]string(nil), values...)
+	}
+	for _, name := range uncachedHeaders {
+		entry.Header.Del(name)
+	}
+	return entry
+}
+
+// storeCacheEntry write the decoded body and metadata, then put the entry into index
+func storeCacheEntry(app *models.Application, entry *CacheEntry, body []byte) error {
+	entry.Size = int64(len(body))
+	if err := os.MkdirAll(filepath.Dir(entry.file), 0700); err != nil {
+		return err
+	}
+	// Outdated precompressed variants must not be served with the new body
+	for _, ext := range precompressedExt {
+		if err := os.Remove(entry.file + ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removeMemoryFile(entry.file + ext)
+	}
+	if err := writeFileAtomic(entry.file, body); err != nil {
+		removeMemoryFile(entry.file)
+		return err
+	}
+	setMemoryFile(entry.file, body)
+	if err := saveCacheMeta(entry); err != nil {
+		return err
+	}
+	addCacheEntry(entry, true)
+	if isCacheOverQuota(app) {
+		go EvictCacheEntries()
+	}
+	if entry.statusCode() != http.StatusOK {
+		// Served without compression
+		return nil
+	}
+	go func() {
+		// Skip if a newer response has been stored during the compression of previous one
+		if isCurrentCacheEntry(entry) {
+			WritePrecompressedFiles(app, entry.file, entry.Header.Get("Content-Type"), body, getLastModified(entry.Header))
+		}
+	}()
+	return nil
+}
+
+// isCurrentCacheEntry check whether the entry is still in index
+func isCurrentCacheEntry(entry *CacheEntry) bool {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	variants, ok := cacheIndex[entry.Key]
+	return ok && variants.entries[entry.VaryKey] == entry
+}
+
+// StoreCacheResponse save the response to shared cache if it is storable, the body of response is kept for the client
+func StoreCacheResponse(resp *http.Response, app *models.Application) {
+	r := resp.Request
+	if r.Method != http.MethodGet || GetRequestContext(r).CacheBypass {
+		return
+	}
+	entry := newCacheEntry(r, resp, app, time.Now(), firewall.IsStaticResource(r))
+	if entry == nil {
+		return
+	}
+	if resp.ContentLength > maxCacheObjectSize {
+		return
+	}
+	// The body may be chunked, read no more than the limit, and give the rest to the client
+	bodyBuf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCacheObjectSize+1))
+	if err != nil || int64(len(bodyBuf)) > maxCacheObjectSize {
+		resp.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(bodyBuf), resp.Body), Closer: resp.Body}
+		return
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(bodyBuf))
+	decodedBody, err := utils.DecodeContent(resp.Header.Get("Content-Encoding"), bodyBuf)
+	if err != nil {
+		utils.DebugPrintln("StoreCacheResponse decode", entry.Key, err)
+		return
+	}
+	if err = storeCacheEntry(app, entry, decodedBody); err != nil {
+		utils.DebugPrintln("StoreCacheResponse", entry.Key, err)
+		return
+	}
+	resp.Header.Set("X-Cache", "MISS")
+}
+
+// multiReadCloser read the buffered head and the rest of body
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// getLastModified return zero time if absent or invalid
+func getLastModified(header http.Header) time.Time {
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return lastModified
+}
+
+// ServeFromCache serve the request with a stored response if it is fresh or validated by the backend,
+// return false if the request should be forwarded to the backend
+func ServeFromCache(w http.ResponseWriter, r *http.Request, app *models.Application, dest *models.Destination, srcIP string) bool {
+	reqCC := parseCacheControl(r.Header)
+	if !isCacheableRequest(r, reqCC) {
+		return false
+	}
+	prepareCacheRequest(r, app)
+	if GetRequestContext(r).CacheBypass {
+		return false
+	}
+	entry := lookupCacheEntry(r)
+	if entry == nil {
+		atomic.AddInt64(&getCacheCounter(app.ID).misses, 1)
+		if reqCC.has("only-if-cached") {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return true
+		}
+		return false
+	}
+	now := time.Now().Unix()
+	age := entry.age(now)
+	fresh := age < entry.Lifetime
+	clientRevalidate := isNoCacheRequest(r, reqCC)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		clientRevalidate = true
+	}
+	if fresh && !clientRevalidate {
+		serveCacheEntry(w, r, app, entry, "HIT")
+		return true
+	}
+	staleness := age - entry.Lifetime
+	revalidateReq := newRevalidateRequest(r, app, dest, srcIP, entry)
+	isStatic := firewall.IsStaticResource(r)
+	if !fresh && !clientRevalidate && staleness < entry.StaleWhileRevalidate {
+		if _, loaded := revalidating.LoadOrStore(entry.file, true); !loaded {
+			go func() {
+				defer revalidating.Delete(entry.file)
+				if _, err := revalidateCacheEntry(app, dest, revalidateReq, entry, isStatic); err != nil {
+					utils.DebugPrintln("Cache revalidate in background", entry.Key, err)
+				}
+			}()
+		}
+		serveCacheEntry(w, r, app, entry, "STALE")
+		return true
+	}
+	newEntry, err := revalidateCacheEntry(app, dest, revalidateReq, entry, isStatic)
+	if err != nil {
+		utils.DebugPrintln("Cache revalidate", entry.Key, err)
+		if !fresh && staleness < entry.StaleIfError {
+			serveCacheEntry(w, r, app, entry, "STALE")
+			return true
+		}
+		atomic.AddInt64(&getCacheCounter(app.ID).misses, 1)
+		return false
+	}
+	if newEntry == nil {
+		// No longer storable, forward the request
+		atomic.AddInt64(&getCacheCounter(app.ID).misses, 1)
+		return false
+	}
+	serveCacheEntry(w, r, app, newEntry, "REVALIDATED")
+	return true
+}
+
+// newRevalidateRequest build the conditional request with the validators of entry and the headers selected by Vary,
+// it does not depend on the client request, so that it can be sent in background
+func newRevalidateRequest(r *http.Request, app *models.Application, dest *models.Destination, srcIP string, entry *CacheEntry) *http.Request {
+	// Keep the request context of gateway, which has the origin path used as cache key
+	ctx := context.WithValue(context.Background(), requestContextKey{}, GetRequestContext(r))
+	if dest.ProxyProtocol != backend.ProxyProtocolNone {
+		localAddr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		ctx = backend.WithProxyProtocolAddr(ctx, getClientAddr(r, srcIP), localAddr)
+	}
+	// The transport always dials to the destination
+	host := getCacheHost(r)
+	req := r.Clone(ctx)
+	req.Method = http.MethodGet
+	req.URL.Scheme = app.InternalScheme
+	req.URL.Host = host
+	req.Host = host
+	req.RequestURI = ""
+	req.Body = nil
+	req.ContentLength = 0
+	req.Header = http.Header{}
+	for _, name := range entry.VaryNames {
+		if values := r.Header.Values(name); len(values) > 0 {
+			req.Header[name] = append([]string(nil), values...)
+		}
+	}
+	if rule := GetRequestContext(r).CacheRule; rule != nil {
+		for name, values := range getCacheRuleHeader(r, rule) {
+			req.Header[name] = values
+		}
+	}
+	for _, name := range []string{"User-Agent", "X-Forwarded-For", "X-Real-IP", "X-Auth-Token", "X-Auth-User"} {
+		if value := r.Header.Get(name); len(value) > 0 {
+			req.Header.Set(name, value)
+		}
+	}
+	if etag := entry.Header.Get("ETag"); len(etag) > 0 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); len(lastModified) > 0 {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return req
+}
+
+// revalidateCacheEntry send the conditional request to the backend, a 304 response refreshes the entry,
+// other responses replace it. Return nil entry if the new response can not be stored
+func revalidateCacheEntry(app *models.Application, dest *models.Destination, req *http.Request, entry *CacheEntry, isStatic bool) (*CacheEntry, error) {
+	client := &http.Client{
+		Transport: backend.GetTransport(app, dest),
+		Timeout:   revalidateTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	responseTime := time.Now()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		// Update the stored headers with the 304 response, RFC 7234 4.3.4
+		updated := &http.Response{StatusCode: entry.statusCode(), Header: http.Header{}, Request: req}
+		for name, values := range entry.Header {
+			updated.Header[name] = values
+		}
+		for name, values := range resp.Header {
+			updated.Header[name] = values
+		}
+		newEntry := newCacheEntry(req, updated, app, responseTime, isStatic)
+		if newEntry == nil || newEntry.file != entry.file {
+			removeCacheEntry(entry)
+			return nil, nil
+		}
+		newEntry.Size = entry.Size
+		if err = saveCacheMeta(newEntry); err != nil {
+			return nil, err
+		}
+		addCacheEntry(newEntry, true)
+		return newEntry, nil
+	}
+	if resp.StatusCode >= 500 {
+		return nil, errors.New("revalidate status " + resp.Status)
+	}
+	newEntry := newCacheEntry(req, resp, app, responseTime, isStatic)
+	if newEntry == nil {
+		// Such as 404 without cache rule, the resource is gone
+		removeCacheEntry(entry)
+		return nil, nil
+	}
+	bodyBuf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCacheObjectSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(bodyBuf)) > maxCacheObjectSize {
+		removeCacheEntry(entry)
+		return nil, nil
+	}
+	// The transport decodes gzip transparently if it added Accept-Encoding
+	decodedBody, err := utils.DecodeContent(resp.Header.Get("Content-Encoding"), bodyBuf)
+	if err != nil {
+		return nil, err
+	}
+	if err = storeCacheEntry(app, newEntry, decodedBody); err != nil {
+		return nil, err
+	}
+	return newEntry, nil
+}
+
+// serveCacheEntry write the stored response with its age, the application headers are applied as proxied responses
+func serveCacheEntry(w http.ResponseWriter, r *http.Request, app *models.Application, entry *CacheEntry, cacheStatus string) {
+	atomic.StoreInt64(&entry.lastAccess, time.Now().Unix())
+	counter := getCacheCounter(app.ID)
+	switch cacheStatus {
+	case "HIT":
+		atomic.AddInt64(&counter.hits, 1)
+	case "STALE":
+		atomic.AddInt64(&counter.stale, 1)
+	case "REVALIDATED":
+		atomic.AddInt64(&counter.revalidated, 1)
+	}
+	header := w.Header()
+	for name, values := range entry.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	age := entry.age(time.Now().Unix())
+	if age < 0 {
+		age = 0
+	}
+	header.Set("Age", strconv.FormatInt(age, 10))
+	header.Set("X-Cache", cacheStatus)
+	statusCode := entry.statusCode()
+	SetAppResponseHeaders(header, r, app, statusCode)
+	var fromMemory bool
+	if statusCode == http.StatusOK {
+		fromMemory = ServeCacheFile(w, r, app, entry.file, entry.Header.Get("Content-Type"), getLastModified(entry.Header))
+	} else {
+		fromMemory = serveCacheStatus(w, r, entry)
+	}
+	if fromMemory {
+		atomic.AddInt64(&counter.memoryHits, 1)
+	}
+}
+
+// serveCacheStatus write the stored response other than 200 as it is, without conditional and range requests
+func serveCacheStatus(w http.ResponseWriter, r *http.Request, entry *CacheEntry) (fromMemory bool) {
+	content, closer, fromMemory, err := openCacheFile(entry.file)
+	if err != nil {
+		utils.DebugPrintln("serveCacheStatus", entry.file, err)
+		http.Error(w, "cache file not found", http.StatusInternalServerError)
+		return false
+	}
+	defer closer.Close()
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	w.WriteHeader(entry.statusCode())
+	if r.Method != http.MethodHead {
+		_, _ = io.Copy(w, content)
+	}
+	return fromMemory
+}
+
+// CacheCleanTick remove the entries which have been stale for a long time, and the purges replayed by replica nodes
+func CacheCleanTick() {
+	cacheTicker := time.NewTicker(time.Duration(600) * time.Second)
+	for range cacheTicker.C {
+		now := time.Now().Unix()
+		if data.IsPrimary {
+			_ = data.DAL.DeleteCachePurgesBeforeTime(now - cachePurgeKeepSeconds)
+		} else {
+			touchLastPurgeID()
+		}
+		expiredEntries := []*CacheEntry{}
+		cacheMutex.RLock()
+		for _, variants := range cacheIndex {
+			for _, entry := range variants.entries {
+				if entry.age(now)-entry.Lifetime > cacheExpiredSeconds {
+					expiredEntries = append(expiredEntries, entry)
+				}
+			}
+		}
+		cacheMutex.RUnlock()
+		for _, entry := range expiredEntries {
+			removeCacheEntry(entry)
+		}
+	}
+}
+
+// getCacheCounter return the statistics of application
+func getCacheCounter(appID int64) *cacheCounter {
+	if counter, ok := cacheCounters.Load(appID); ok {
+		return counter.(*cacheCounter)
+	}
+	counter, _ := cacheCounters.LoadOrStore(appID, &cacheCounter{})
+	return counter.(*cacheCounter)
+}
+
+// isCacheOverQuota check the quota of application and the global quota in config.json
+func isCacheOverQuota(app *models.Application) bool {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	if cacheTotalSize > data.GetConfig().Cache.MaxSize {
+		return true
+	}
+	usage, ok := cacheUsages[app.ID]
+	return ok && app.CacheMaxSize > 0 && usage.size > app.CacheMaxSize
+}
+
+// EvictCacheEntries remove the least recently used entries of applications over quota, then globally,
+// until the size is under 90% of the quota
+func EvictCacheEntries() {
+	if !atomic.CompareAndSwapInt32(&evicting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&evicting, 0)
+	for _, app := range backend.Apps {
+		if app.CacheMaxSize > 0 {
+			evictLeastRecentlyUsed(app.ID, app.CacheMaxSize)
+		}
+	}
+	evictLeastRecentlyUsed(0, data.GetConfig().Cache.MaxSize)
+}
+
+// evictLeastRecentlyUsed appID 0 means all applications
+func evictLeastRecentlyUsed(appID int64, maxSize int64) {
+	cacheMutex.RLock()
+	size := cacheTotalSize
+	if appID > 0 {
+		size = 0
+		if usage, ok := cacheUsages[appID]; ok {
+			size = usage.size
+		}
+	}
+	if size <= maxSize {
+		cacheMutex.RUnlock()
+		return
+	}
+	entries := []*CacheEntry{}
+	for _, variants := range cacheIndex {
+		for _, entry := range variants.entries {
+			if appID == 0 || entry.AppID == appID {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	cacheMutex.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return atomic.LoadInt64(&entries[i].lastAccess) < atomic.LoadInt64(&entries[j].lastAccess)
+	})
+	target := maxSize / 10 * 9
+	for _, entry := range entries {
+		if size <= target {
+			break
+		}
+		removeCacheEntry(entry)
+		size -= entry.Size
+		atomic.AddInt64(&getCacheCounter(entry.AppID).evictions, 1)
+	}
+}
+
+// GetCacheStats return the statistics of shared cache on current node
+func GetCacheStats(authUser *models.AuthUser) (*models.CacheStats, error) {
+	apps, _ := backend.GetApplications(authUser)
+	cacheStats := &models.CacheStats{MaxSize: data.GetConfig().Cache.MaxSize, MaxMemorySize: data.GetConfig().Cache.MaxMemorySize, Apps: []*models.CacheStat{}}
+	cacheStats.MemoryFiles, cacheStats.MemorySize = getMemoryUsage()
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	for _, app := range apps {
+		counter := getCacheCounter(app.ID)
+		cacheStat := &models.CacheStat{
+			AppID:       app.ID,
+			MaxSize:     app.CacheMaxSize,
+			Hits:        atomic.LoadInt64(&counter.hits),
+			Misses:      atomic.LoadInt64(&counter.misses),
+			Stale:       atomic.LoadInt64(&counter.stale),
+			Revalidated: atomic.LoadInt64(&counter.revalidated),
+			Evictions:   atomic.LoadInt64(&counter.evictions),
+			MemoryHits:  atomic.LoadInt64(&counter.memoryHits),
+		}
+		if usage, ok := cacheUsages[app.ID]; ok {
+			cacheStat.Entries = usage.entries
+			cacheStat.Size = usage.size
+		}
+		cacheStats.Entries += cacheStat.Entries
+		cacheStats.Size += cacheStat.Size
+		cacheStats.Apps = append(cacheStats.Apps, cacheStat)
+	}
+	return cacheStats, nil
+}
@@ -0,0 +1,261 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-03 20:08:45
+ * @Last Modified: U2, 2021-06-03 20:08:45
+ */
+
+package gateway
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"janusec/models"
+)
+
+// heuristicLifetime is the freshness of static resources without explicit expiration and Last-Modified, in seconds
+const heuristicLifetime = 1800
+
+// maxHeuristicLifetime is the upper limit of 10% of the time since Last-Modified, in seconds
+const maxHeuristicLifetime = 86400
+
+// cacheControl is the parsed Cache-Control header, map[directive in lower case]value
+type cacheControl map[string]string
+
+// parseCacheControl parse all Cache-Control headers, the quoted values may contain comma, such as private="a, b"
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for len(value) > 0 {
+			var item string
+			item, value = nextDirective(value)
+			item = strings.TrimSpace(item)
+			if len(item) == 0 {
+				continue
+			}
+			name, arg := item, ""
+			if i := strings.Index(item, "="); i >= 0 {
+				name, arg = strings.TrimSpace(item[:i]), strings.Trim(strings.TrimSpace(item[i+1:]), `"`)
+			}
+			cc[strings.ToLower(name)] = arg
+		}
+	}
+	return cc
+}
+
+// nextDirective split the first directive of Cache-Control, commas inside quotes are skipped
+func nextDirective(value string) (string, string) {
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return value[:i], value[i+1:]
+			}
+		}
+	}
+	return value, ""
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds return the delta-seconds value of the directive, false if absent or invalid
+func (cc cacheControl) seconds(name string) (int64, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if seconds < 0 {
+		seconds = 0
+	}
+	return seconds, true
+}
+
+// isNoCacheRequest check whether the client requires the validation with origin
+func isNoCacheRequest(r *http.Request, reqCC cacheControl) bool {
+	if reqCC.has("no-cache") {
+		return true
+	}
+	// HTTP/1.0 clients
+	return len(reqCC) == 0 && strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache")
+}
+
+// isCacheableRequest only GET and HEAD without no-store are served from cache
+func isCacheableRequest(r *http.Request, reqCC cacheControl) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return !reqCC.has("no-store")
+}
+
+// getVaryNames return the canonical request header names selected by Vary, sorted,
+// Accept-Encoding is excluded because the body is stored decoded and compressed by gateway.
+// The second return value is false if the response varies on everything (Vary: *)
+func getVaryNames(header http.Header) ([]string, bool) {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, vary := range header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if len(name) == 0 || name == "Accept-Encoding" || seen[name] {
+				continue
+			}
+			if name == "*" {
+				return nil, false
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// getVaryKey join the values of request headers selected by Vary
+func getVaryKey(r *http.Request, varyNames []string) string {
+	var sb strings.Builder
+	for _, name := range varyNames {
+		sb.WriteString(name)
+		sb.WriteString(":")
+		values := r.Header.Values(name)
+		for i, value := range values {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(strings.TrimSpace(value))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// cachePolicy is the result of checking a response for shared cache, RFC 7234
+type cachePolicy struct {
+	storable             bool
+	lifetime             int64
+	staleWhileRevalidate int64
+	staleIfError         int64
+	mustRevalidate       bool
+	initialAge           int64
+}
+
+// getCachePolicy check whether the response to the GET request can be stored by a shared cache, and its freshness,
+// heuristic freshness is only used for static resources, as previous versions.
+// The TTL of matched cache rule is used if the backend does not specify the freshness, or ignored by the rule
+func getCachePolicy(r *http.Request, resp *http.Response, responseTime time.Time, isStatic bool, rule *models.CacheRule) cachePolicy {
+	policy := cachePolicy{}
+	if r.Method != http.MethodGet {
+		return policy
+	}
+	if rule == nil && resp.StatusCode != http.StatusOK {
+		return policy
+	}
+	if rule != nil && !isCacheRuleStatus(rule, resp.StatusCode) {
+		return policy
+	}
+	reqCC := parseCacheControl(r.Header)
+	if reqCC.has("no-store") {
+		return policy
+	}
+	ignoreOrigin := rule != nil && rule.IgnoreOrigin
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") {
+		return policy
+	}
+	if ignoreOrigin {
+		// Only the freshness of backend is replaced by the TTL of rule
+		delete(cc, "max-age")
+		delete(cc, "s-maxage")
+	}
+	// Responses which set cookies are always user-specific
+	if len(resp.Header.Values("Set-Cookie")) > 0 {
+		return policy
+	}
+	if _, ok := getVaryNames(resp.Header); !ok {
+		return policy
+	}
+	sMaxAge, hasSMaxAge := cc.seconds("s-maxage")
+	// The response is not shared between users if the rule adds Authorization to cache key
+	isAuthorizationKey := rule != nil && containsName(rule.KeyHeaders, "Authorization", false)
+	if len(r.Header.Get("Authorization")) > 0 && !cc.has("public") && !hasSMaxAge && !cc.has("must-revalidate") && !isAuthorizationKey {
+		return policy
+	}
+	// The user authenticated by gateway (OAuth) is not in the cache key, unless the rule adds X-Auth-User
+	isAuthUserKey := rule != nil && containsName(rule.KeyHeaders, "X-Auth-User", false)
+	if len(GetRequestContext(r).AuthUser) > 0 && !cc.has("public") && !hasSMaxAge && !isAuthUserKey {
+		return policy
+	}
+	date := responseTime
+	if dateValue, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		date = dateValue
+	}
+	hasValidator := len(resp.Header.Get("ETag")) > 0 || len(resp.Header.Get("Last-Modified")) > 0
+	explicit := true
+	if hasSMaxAge {
+		policy.lifetime = sMaxAge
+	} else if maxAge, ok := cc.seconds("max-age"); ok {
+		policy.lifetime = maxAge
+	} else if expires := resp.Header.Get("Expires"); len(expires) > 0 && !ignoreOrigin {
+		// An invalid Expires means already expired
+		if expiresTime, err := http.ParseTime(expires); err == nil && expiresTime.After(date) {
+			policy.lifetime = int64(expiresTime.Sub(date) / time.Second)
+		}
+	} else {
+		explicit = false
+	}
+	if !explicit && rule != nil {
+		policy.lifetime = rule.TTL
+	} else if !explicit {
+		if !isStatic {
+			return policy
+		}
+		policy.lifetime = heuristicLifetime
+		if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+			policy.lifetime = int64(date.Sub(lastModified)/time.Second) / 10
+			if policy.lifetime > maxHeuristicLifetime {
+				policy.lifetime = maxHeuristicLifetime
+			}
+		}
+	}
+	if cc.has("no-cache") {
+		// Stored, but validated with origin before each use
+		policy.lifetime = 0
+		policy.mustRevalidate = true
+	}
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") || hasSMaxAge {
+		policy.mustRevalidate = true
+	}
+	if !policy.mustRevalidate {
+		var ok bool
+		if policy.staleWhileRevalidate, ok = cc.seconds("stale-while-revalidate"); !ok && rule != nil {
+			policy.staleWhileRevalidate = rule.StaleWhileRevalidate
+		}
+		policy.staleIfError, _ = cc.seconds("stale-if-error")
+	}
+	if policy.lifetime == 0 && !hasValidator {
+		// Nothing to reuse
+		return policy
+	}
+	apparentAge := int64(responseTime.Sub(date) / time.Second)
+	if age, err := strconv.ParseInt(resp.Header.Get("Age"), 10, 64); err == nil && age > apparentAge {
+		apparentAge = age
+	}
+	if apparentAge > 0 {
+		policy.initialAge = apparentAge
+	}
+	policy.storable = true
+	return policy
+}
@@ -0,0 +1,133 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-05 21:40:52
+ * @Last Modified: U2, 2021-06-05 21:40:52
+ */
+
+package gateway
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"janusec/data"
+)
+
+// maxMemoryObjectSize files bigger than 1MB are served from disk only
+const maxMemoryObjectSize = 1024 * 1024
+
+// memoryFile is the content of a cache file kept in memory, including precompressed variants
+type memoryFile struct {
+	file    string
+	content []byte
+}
+
+var (
+	// memoryMutex protects the in-memory tier, the least recently used files are at the back of memoryLRU
+	memoryMutex sync.Mutex
+	memoryFiles = map[string]*list.Element{}
+	memoryLRU   = list.New()
+	memorySize  int64
+	// memoryGeneration is increased whenever a cache file is written or removed,
+	// the content read from disk is not kept if it changed during the read
+	memoryGeneration int64
+)
+
+// isMemoryCacheEnabled check the cache.max_memory_size in config.json
+func isMemoryCacheEnabled() bool {
+	return data.GetConfig().Cache.MaxMemorySize > 0
+}
+
+// getMemoryFile return the content and mark it as recently used
+func getMemoryFile(file string) ([]byte, bool) {
+	memoryMutex.Lock()
+	defer memoryMutex.Unlock()
+	element, ok := memoryFiles[file]
+	if !ok {
+		return nil, false
+	}
+	memoryLRU.MoveToFront(element)
+	return element.Value.(*memoryFile).content, true
+}
+
+// setMemoryFile is called after the cache file is written, the content must not be modified later
+func setMemoryFile(file string, content []byte) {
+	memoryMutex.Lock()
+	defer memoryMutex.Unlock()
+	memoryGeneration++
+	putMemoryFile(file, content)
+}
+
+// removeMemoryFile is called after the cache file is removed
+func removeMemoryFile(file string) {
+	memoryMutex.Lock()
+	defer memoryMutex.Unlock()
+	memoryGeneration++
+	deleteMemoryFile(file)
+}
+
+// putMemoryFile is called with memoryMutex locked, files too big are removed from memory
+func putMemoryFile(file string, content []byte) {
+	deleteMemoryFile(file)
+	if !isMemoryCacheEnabled() || len(content) > maxMemoryObjectSize {
+		return
+	}
+	memoryFiles[file] = memoryLRU.PushFront(&memoryFile{file: file, content: content})
+	memorySize += int64(len(content))
+	for memorySize > data.GetConfig().Cache.MaxMemorySize {
+		deleteMemoryFile(memoryLRU.Back().Value.(*memoryFile).file)
+	}
+}
+
+// deleteMemoryFile is called with memoryMutex locked
+func deleteMemoryFile(file string) {
+	element, ok := memoryFiles[file]
+	if !ok {
+		return
+	}
+	memoryLRU.Remove(element)
+	delete(memoryFiles, file)
+	memorySize -= int64(len(element.Value.(*memoryFile).content))
+}
+
+// openCacheFile read the cache file from memory, or from disk and keep it in memory if it is small enough,
+// the caller must close the returned closer
+func openCacheFile(file string) (content io.ReadSeeker, closer io.Closer, fromMemory bool, err error) {
+	if memoryContent, ok := getMemoryFile(file); ok {
+		return bytes.NewReader(memoryContent), ioutil.NopCloser(nil), true, nil
+	}
+	memoryMutex.Lock()
+	generation := memoryGeneration
+	memoryMutex.Unlock()
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	fi, err := f.Stat()
+	if err != nil || !isMemoryCacheEnabled() || fi.Size() > maxMemoryObjectSize {
+		return f, f, false, nil
+	}
+	fileContent, err := ioutil.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	memoryMutex.Lock()
+	if generation == memoryGeneration {
+		putMemoryFile(file, fileContent)
+	}
+	memoryMutex.Unlock()
+	return bytes.NewReader(fileContent), ioutil.NopCloser(nil), false, nil
+}
+
+// getMemoryUsage return the count and size of files in memory
+func getMemoryUsage() (files int64, size int64) {
+	memoryMutex.Lock()
+	defer memoryMutex.Unlock()
+	return int64(len(memoryFiles)), memorySize
+}
@@ -0,0 +1,282 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-04 21:05:27
+ * @Last Modified: U2, 2021-06-04 21:05:27
+ */
+
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"janusec/backend"
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// cachePurgeKeepSeconds purges older than 30 days are deleted from database,
+// a replica node which has not synchronized for such a long time purges all
+const cachePurgeKeepSeconds = 86400 * 30
+
+// lastPurgeIDFile records the last purge replayed by replica node, so that purges during downtime are replayed after restart
+var lastPurgeIDFile = filepath.Join(cacheRoot, "last_purge_id")
+
+var (
+	// purgeMutex serialize the replay of purges on replica nodes
+	purgeMutex  sync.Mutex
+	lastPurgeID int64
+	// cacheLoaded is true after the cache index is loaded and lastPurgeID is initialized
+	cacheLoaded bool
+)
+
+// PurgeCache remove cached responses by application, URL, prefix or wildcard, or all, replica nodes replay it at next sync
+// param object example: {"type":4,"app_id":0,"pattern":"https://www.example.com/static/*.js"}
+func PurgeCache(param map[string]interface{}, clientIP string, authUser *models.AuthUser) (*models.CachePurge, error) {
+	purgeMap, ok := param["object"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("purge object is required")
+	}
+	purge := &models.CachePurge{PurgeTime: time.Now().Unix()}
+	if purgeType, ok := purgeMap["type"].(float64); ok {
+		purge.Type = models.CachePurgeType(purgeType)
+	}
+	var description string
+	switch purge.Type {
+	case models.PurgeAll:
+		if !authUser.IsAppAdmin {
+			return nil, errors.New("only application administrators can purge all")
+		}
+		description = "all"
+	case models.PurgeApp:
+		appID, _ := purgeMap["app_id"].(float64)
+		app, err := backend.GetApplicationByID(int64(appID))
+		if err != nil {
+			return nil, err
+		}
+		if !authUser.IsAppAdmin && app.Owner != authUser.Username {
+			return nil, errors.New("no privilege to purge the cache of " + app.Name)
+		}
+		purge.AppID = app.ID
+		description = app.Name
+	case models.PurgeURL, models.PurgePrefix:
+		pattern, _ := purgeMap["pattern"].(string)
+		purge.Pattern = normalizePurgePattern(pattern)
+		if len(purge.Pattern) == 0 {
+			return nil, errors.New("purge pattern is required")
+		}
+		host := strings.SplitN(purge.Pattern, "/", 2)[0]
+		if strings.Contains(host, "*") {
+			if purge.Type == models.PurgeURL {
+				return nil, errors.New("wildcard is not allowed in URL, purge by prefix instead")
+			}
+			if !authUser.IsAppAdmin {
+				return nil, errors.New("only application administrators can purge multiple domains")
+			}
+		} else {
+			app := backend.GetApplicationByDomain(host)
+			if app == nil {
+				return nil, errors.New("no application for " + host)
+			}
+			if !authUser.IsAppAdmin && app.Owner != authUser.Username {
+				return nil, errors.New("no privilege to purge the cache of " + app.Name)
+			}
+			purge.AppID = app.ID
+		}
+		description = purge.Pattern
+	default:
+		return nil, errors.New("unknown purge type")
+	}
+	var err error
+	purge.ID, err = data.DAL.InsertCachePurge(purge)
+	if err != nil {
+		return nil, err
+	}
+	data.UpdateCachePurgeID(purge.ID)
+	removed := ExecuteCachePurge(purge)
+	utils.DebugPrintln("PurgeCache", purge.ID, description, "removed", removed)
+	go utils.OperationLog(clientIP, authUser.Username, "Purge Cache", description)
+	return purge, nil
+}
+
+// normalizePurgePattern remove the scheme and port, and convert host to lower case, same as the cache key
+func normalizePurgePattern(pattern string) string {
+	pattern = strings.TrimSpace(pattern)
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		pattern = pattern[i+3:]
+	}
+	if len(pattern) == 0 {
+		return ""
+	}
+	parts := strings.SplitN(pattern, "/", 2)
+	host := parts[0]
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	path := "/"
+	if len(parts) == 2 {
+		path += parts[1]
+	}
+	return strings.ToLower(host) + path
+}
+
+// newPurgeMatcher return nil if the pattern is invalid
+func newPurgeMatcher(purge *models.CachePurge) func(entry *CacheEntry) bool {
+	switch purge.Type {
+	case models.PurgeAll:
+		return func(entry *CacheEntry) bool {
+			return true
+		}
+	case models.PurgeApp:
+		return func(entry *CacheEntry) bool {
+			return entry.AppID == purge.AppID
+		}
+	case models.PurgeURL:
+		return func(entry *CacheEntry) bool {
+			return entry.Key == purge.Pattern
+		}
+	case models.PurgePrefix:
+		if !strings.Contains(purge.Pattern, "*") {
+			return func(entry *CacheEntry) bool {
+				return strings.HasPrefix(entry.Key, purge.Pattern)
+			}
+		}
+		// The wildcard * matches any characters, including /
+		regex, err := regexp.Compile("^" + strings.Replace(regexp.QuoteMeta(purge.Pattern), `\*`, `.*`, -1) + "$")
+		if err != nil {
+			utils.DebugPrintln("newPurgeMatcher", purge.Pattern, err)
+			return nil
+		}
+		return func(entry *CacheEntry) bool {
+			return regex.MatchString(entry.Key)
+		}
+	}
+	return nil
+}
+
+// ExecuteCachePurge remove the matched entries on current node, return the count of removed entries
+func ExecuteCachePurge(purge *models.CachePurge) int {
+	match := newPurgeMatcher(purge)
+	if match == nil {
+		return 0
+	}
+	matchedEntries := []*CacheEntry{}
+	cacheMutex.RLock()
+	for _, variants := range cacheIndex {
+		for _, entry := range variants.entries {
+			if (purge.AppID == 0 || entry.AppID == purge.AppID) && match(entry) {
+				matchedEntries = append(matchedEntries, entry)
+			}
+		}
+	}
+	cacheMutex.RUnlock()
+	for _, entry := range matchedEntries {
+		removeCacheEntry(entry)
+	}
+	return len(matchedEntries)
+}
+
+// GetCachePurges return the purges after the ID, used by replica nodes
+func GetCachePurges(afterID int64) ([]*models.CachePurge, error) {
+	return data.DAL.SelectCachePurgesAfterID(afterID), nil
+}
+
+// RPCSelectCachePurges return nil if failed
+func RPCSelectCachePurges(afterID int64) []*models.CachePurge {
+	rpcRequest := &models.RPCRequest{
+		Action: "get_cache_purges", ObjectID: afterID, Object: nil}
+	resp, err := data.GetRPCResponse(rpcRequest)
+	if err != nil {
+		utils.DebugPrintln("RPCSelectCachePurges GetResponse", err)
+		return nil
+	}
+	rpcCachePurges := &models.RPCCachePurges{}
+	if err = json.Unmarshal(resp, rpcCachePurges); err != nil {
+		utils.DebugPrintln("RPCSelectCachePurges Unmarshal", err)
+		return nil
+	}
+	if rpcCachePurges.Object == nil {
+		return []*models.CachePurge{}
+	}
+	return rpcCachePurges.Object
+}
+
+// initLastPurgeID load the last replayed purge of replica node, called after the cache index is loaded
+func initLastPurgeID() {
+	fi, err := os.Stat(lastPurgeIDFile)
+	if err != nil {
+		// New node, or upgraded from previous versions
+		lastPurgeID = data.NodeSetting.CachePurgeID
+		saveLastPurgeID()
+		return
+	}
+	content, err := ioutil.ReadFile(lastPurgeIDFile)
+	if err == nil {
+		lastPurgeID, err = strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	}
+	if err != nil || time.Now().Unix()-fi.ModTime().Unix() > cachePurgeKeepSeconds {
+		// The purges may have been deleted from database
+		utils.DebugPrintln("initLastPurgeID outdated, purge all", err)
+		ExecuteCachePurge(&models.CachePurge{Type: models.PurgeAll})
+		lastPurgeID = data.NodeSetting.CachePurgeID
+		saveLastPurgeID()
+	}
+}
+
+func saveLastPurgeID() {
+	if err := os.MkdirAll(cacheRoot, 0700); err != nil {
+		utils.DebugPrintln("saveLastPurgeID", err)
+		return
+	}
+	if err := writeFileAtomic(lastPurgeIDFile, []byte(fmt.Sprintf("%d", lastPurgeID))); err != nil {
+		utils.DebugPrintln("saveLastPurgeID", err)
+	}
+}
+
+// touchLastPurgeID mark the replica node as synchronized, the modification time is checked after restart
+func touchLastPurgeID() {
+	purgeMutex.Lock()
+	defer purgeMutex.Unlock()
+	if !cacheLoaded {
+		return
+	}
+	now := time.Now()
+	if err := os.Chtimes(lastPurgeIDFile, now, now); err != nil {
+		utils.DebugPrintln("touchLastPurgeID", err)
+	}
+}
+
+// SyncCachePurges replay the purges after the last one on replica node
+func SyncCachePurges() {
+	purgeMutex.Lock()
+	defer purgeMutex.Unlock()
+	if !cacheLoaded || data.NodeSetting == nil || data.NodeSetting.CachePurgeID <= lastPurgeID {
+		return
+	}
+	purges := RPCSelectCachePurges(lastPurgeID)
+	if purges == nil {
+		// Retry at next sync
+		return
+	}
+	for _, purge := range purges {
+		removed := ExecuteCachePurge(purge)
+		utils.DebugPrintln("SyncCachePurges", purge.ID, purge.Type, purge.Pattern, "removed", removed)
+		lastPurgeID = purge.ID
+	}
+	if lastPurgeID < data.NodeSetting.CachePurgeID {
+		lastPurgeID = data.NodeSetting.CachePurgeID
+	}
+	saveLastPurgeID()
+}
@@ -0,0 +1,173 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-05 21:02:46
+ * @Last Modified: U2, 2021-06-05 21:02:46
+ */
+
+package gateway
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"janusec/backend"
+	"janusec/models"
+)
+
+// prepareCacheRequest match the cache rules and compute the cache key before the request is modified for the backend
+func prepareCacheRequest(r *http.Request, app *models.Application) {
+	reqCtx := GetRequestContext(r)
+	reqCtx.CacheRule = matchCacheRule(r, app, reqCtx.OriginPath)
+	if reqCtx.CacheRule != nil {
+		reqCtx.CacheBypass = isCacheBypassed(r, reqCtx.CacheRule)
+		reqCtx.CacheRuleKey = getCacheRuleKey(r, reqCtx.CacheRule)
+	}
+	reqCtx.CacheKey = getCacheKey(r)
+}
+
+// matchCacheRule return the first cache rule matched by method, path and query parameters, nil if none
+func matchCacheRule(r *http.Request, app *models.Application, path string) *models.CacheRule {
+	var query url.Values
+	for _, rule := range app.CacheRules {
+		if len(rule.Methods) > 0 && !containsName(rule.Methods, r.Method, false) {
+			continue
+		}
+		if len(rule.PathPattern) > 0 {
+			re := getCachedRegexp(rule.PathPattern)
+			if re == nil || !re.MatchString(path) {
+				continue
+			}
+		}
+		if len(rule.QueryParams) > 0 {
+			if query == nil {
+				query = r.URL.Query()
+			}
+			if !isQueryParamsMatched(query, rule.QueryParams) {
+				continue
+			}
+		}
+		return rule
+	}
+	return nil
+}
+
+// isQueryParamsMatched check the conditions such as "page,type=list"
+func isQueryParamsMatched(query url.Values, conditions string) bool {
+	for _, condition := range strings.Split(conditions, ",") {
+		nameValue := strings.SplitN(condition, "=", 2)
+		values, ok := query[nameValue[0]]
+		if !ok {
+			return false
+		}
+		if len(nameValue) == 2 && (len(values) == 0 || values[0] != nameValue[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isCacheBypassed check whether the request has any of the bypass headers, cookies or query parameters of rule
+func isCacheBypassed(r *http.Request, rule *models.CacheRule) bool {
+	for _, name := range splitNames(rule.BypassHeaders) {
+		if len(r.Header.Values(name)) > 0 {
+			return true
+		}
+	}
+	for _, name := range splitNames(rule.BypassCookies) {
+		if _, err := r.Cookie(name); err == nil {
+			return true
+		}
+	}
+	if len(rule.BypassQueryParams) > 0 {
+		query := r.URL.Query()
+		for _, name := range splitNames(rule.BypassQueryParams) {
+			if _, ok := query[name]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getCacheRuleKey join the values of request headers and cookies selected by rule, empty if none selected
+func getCacheRuleKey(r *http.Request, rule *models.CacheRule) string {
+	var builder strings.Builder
+	for _, name := range splitNames(rule.KeyHeaders) {
+		builder.WriteString(name + ":" + strings.Join(r.Header.Values(name), ",") + "\n")
+	}
+	for _, name := range splitNames(rule.KeyCookies) {
+		value := ""
+		if cookie, err := r.Cookie(name); err == nil {
+			value = cookie.Value
+		}
+		builder.WriteString("Cookie " + name + "=" + value + "\n")
+	}
+	return builder.String()
+}
+
+// getCacheQuery return the query string in cache key, only the parameters selected by rule are kept in sorted order
+func getCacheQuery(r *http.Request, rule *models.CacheRule) string {
+	if rule == nil || len(rule.KeyQueryParams) == 0 {
+		return r.URL.RawQuery
+	}
+	query := r.URL.Query()
+	selected := url.Values{}
+	for _, name := range splitNames(rule.KeyQueryParams) {
+		if values, ok := query[name]; ok {
+			selected[name] = values
+		}
+	}
+	return selected.Encode()
+}
+
+// getCacheRuleHeader return the request headers sent to backend when revalidating, so that the variant is the same
+func getCacheRuleHeader(r *http.Request, rule *models.CacheRule) http.Header {
+	header := http.Header{}
+	for _, name := range splitNames(rule.KeyHeaders) {
+		if values := r.Header.Values(name); len(values) > 0 {
+			header[name] = append([]string(nil), values...)
+		}
+	}
+	cookies := []string{}
+	for _, name := range splitNames(rule.KeyCookies) {
+		if cookie, err := r.Cookie(name); err == nil {
+			cookies = append(cookies, cookie.String())
+		}
+	}
+	if len(cookies) > 0 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	return header
+}
+
+// isCacheRuleStatus check the status of response with rule, empty means 200,
+// 5xx, partial and not modified responses are never cached
+func isCacheRuleStatus(rule *models.CacheRule, statusCode int) bool {
+	if statusCode >= 500 || statusCode == http.StatusPartialContent || statusCode == http.StatusNotModified {
+		return false
+	}
+	if len(rule.StatusCodes) == 0 {
+		return statusCode == http.StatusOK
+	}
+	return backend.IsExpectedStatus(rule.StatusCodes, statusCode)
+}
+
+// splitNames split the normalized comma separated names
+func splitNames(names string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	return strings.Split(names, ",")
+}
+
+// containsName check the comma separated names
+func containsName(names string, name string, ignoreCase bool) bool {
+	for _, item := range splitNames(names) {
+		if item == name || (ignoreCase && strings.EqualFold(item, name)) {
+			return true
+		}
+	}
+	return false
+}
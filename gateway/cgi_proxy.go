@@ -0,0 +1,242 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-07 20:36:15
+ * @Last Modified: U2, 2021-06-07 20:36:15
+ */
+
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"janusec/backend"
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// cgiParam is a CGI environment variable, the order is kept as SCGI requires CONTENT_LENGTH first
+type cgiParam struct {
+	name  string
+	value string
+}
+
+// cgiHopHeaders are not forwarded to client, and X-Request-ID of gateway has been set
+var cgiHopHeaders = []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Status", "X-Request-ID"}
+
+// ServeCGIProxy forward the request to uWSGI or SCGI destination over TCP or Unix domain socket,
+// r.URL.Path has been mapped from RequestRoute to BackendRoute
+func ServeCGIProxy(w http.ResponseWriter, r *http.Request, dest *models.Destination, srcIP string) {
+	// Both protocols require the CONTENT_LENGTH, a chunked body is buffered, and limited by MaxBodyReader
+	var body io.Reader = r.Body
+	contentLength := r.ContentLength
+	if contentLength < 0 {
+		buf, err := ioutil.ReadAll(r.Body)
+		if errors.Is(err, backend.ErrRequestBodyTooLarge) {
+			GenerateRequestTooLargeResponse(w)
+			return
+		}
+		if err != nil {
+			utils.DebugPrintln("ServeCGIProxy read body", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = bytes.NewReader(buf)
+		contentLength = int64(len(buf))
+	}
+	params := newCGIParams(r, srcIP, contentLength)
+	cfg := data.GetConfig().BackendTransport
+	network, address := backend.GetDestinationAddr(dest.Destination)
+	dialer := &net.Dialer{Timeout: time.Duration(cfg.DialTimeout) * time.Second}
+	conn, err := dialer.DialContext(r.Context(), network, address)
+	dest.CheckTime.Store(time.Now().Unix())
+	if err != nil {
+		utils.DebugPrintln("ServeCGIProxy dial error", dest.Destination, err)
+		incUpstreamError(dest)
+		if r.Context().Err() == nil {
+			backend.SetDestinationOnline(dest, false)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		GenerateInternalErrorResponse(w, &models.InternalErrorInfo{Description: "Internal Server Offline"})
+		return
+	}
+	defer conn.Close()
+	bufWriter := bufio.NewWriter(conn)
+	if dest.RouteType == models.UWSGIRoute {
+		err = writeUWSGIHeader(bufWriter, params)
+	} else {
+		err = writeSCGIHeader(bufWriter, params)
+	}
+	if err == nil && contentLength > 0 {
+		_, err = io.CopyN(bufWriter, body, contentLength)
+	}
+	if err == nil {
+		err = bufWriter.Flush()
+	}
+	if err != nil {
+		utils.DebugPrintln("ServeCGIProxy write request", dest.Destination, err)
+		incUpstreamError(dest)
+		w.WriteHeader(http.StatusBadGateway)
+		GenerateInternalErrorResponse(w, &models.InternalErrorInfo{Description: "Bad Gateway"})
+		return
+	}
+	if cfg.ResponseHeaderTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Duration(cfg.ResponseHeaderTimeout) * time.Second))
+	}
+	resp, err := readCGIResponse(bufio.NewReader(conn), r)
+	if err != nil {
+		utils.DebugPrintln("ServeCGIProxy read response", dest.Destination, err)
+		incUpstreamError(dest)
+		w.WriteHeader(http.StatusBadGateway)
+		GenerateInternalErrorResponse(w, &models.InternalErrorInfo{Description: "Bad Gateway"})
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	defer resp.Body.Close()
+	for _, name := range cgiHopHeaders {
+		resp.Header.Del(name)
+	}
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		utils.DebugPrintln("ServeCGIProxy copy response", dest.Destination, err)
+	}
+}
+
+// newCGIParams return the CGI environment variables of request, SCRIPT_NAME is empty and PATH_INFO is the mapped path
+func newCGIParams(r *http.Request, srcIP string, contentLength int64) []cgiParam {
+	serverName, serverPort := r.Host, ""
+	if host, port, err := net.SplitHostPort(r.Host); err == nil {
+		serverName = host
+		serverPort = port
+	}
+	if localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(localAddr.String()); err == nil {
+			serverPort = port
+		}
+	}
+	_, remotePort, _ := net.SplitHostPort(r.RemoteAddr)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	params := []cgiParam{
+		{"CONTENT_LENGTH", strconv.FormatInt(contentLength, 10)},
+		{"REQUEST_METHOD", r.Method},
+		{"REQUEST_URI", r.URL.RequestURI()},
+		{"REQUEST_SCHEME", scheme},
+		{"SCRIPT_NAME", ""},
+		{"PATH_INFO", r.URL.Path},
+		{"QUERY_STRING", r.URL.RawQuery},
+		{"CONTENT_TYPE", r.Header.Get("Content-Type")},
+		{"SERVER_PROTOCOL", r.Proto},
+		{"SERVER_NAME", serverName},
+		{"SERVER_PORT", serverPort},
+		{"SERVER_SOFTWARE", "Janusec/" + data.Version},
+		{"GATEWAY_INTERFACE", "CGI/1.1"},
+		{"REMOTE_ADDR", srcIP},
+		{"REMOTE_PORT", remotePort},
+	}
+	if r.TLS != nil {
+		params = append(params, cgiParam{"HTTPS", "on"})
+	}
+	for name, values := range r.Header {
+		// Content-Type and Content-Length are sent above, and Proxy is dropped to prevent httpoxy,
+		// the names with underscore are dropped as nginx and Apache, such as X_Auth_User which collides with X-Auth-User
+		if name == "Content-Type" || name == "Content-Length" || name == "Proxy" || strings.Contains(name, "_") {
+			continue
+		}
+		params = append(params, cgiParam{"HTTP_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)), strings.Join(values, ", ")})
+	}
+	// Host is removed from r.Header by net/http
+	params = append(params, cgiParam{"HTTP_HOST", r.Host})
+	return params
+}
+
+// writeUWSGIHeader write the uwsgi packet header with modifier1 0, and the vars block in little endian
+func writeUWSGIHeader(w io.Writer, params []cgiParam) error {
+	var vars bytes.Buffer
+	for _, param := range params {
+		if len(param.name) > 0xffff || len(param.value) > 0xffff {
+			return errors.New("uwsgi var too large: " + param.name)
+		}
+		_ = binary.Write(&vars, binary.LittleEndian, uint16(len(param.name)))
+		vars.WriteString(param.name)
+		_ = binary.Write(&vars, binary.LittleEndian, uint16(len(param.value)))
+		vars.WriteString(param.value)
+	}
+	if vars.Len() > 0xffff {
+		return errors.New("uwsgi vars too large")
+	}
+	header := []byte{0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(header[1:3], uint16(vars.Len()))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := vars.WriteTo(w)
+	return err
+}
+
+// writeSCGIHeader write the headers as netstring, CONTENT_LENGTH must be the first one and followed by SCGI
+func writeSCGIHeader(w io.Writer, params []cgiParam) error {
+	params = append([]cgiParam{params[0], {"SCGI", "1"}}, params[1:]...)
+	var headers bytes.Buffer
+	for _, param := range params {
+		headers.WriteString(param.name)
+		headers.WriteByte(0)
+		headers.WriteString(param.value)
+		headers.WriteByte(0)
+	}
+	if _, err := io.WriteString(w, strconv.Itoa(headers.Len())+":"); err != nil {
+		return err
+	}
+	if _, err := headers.WriteTo(w); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, ",")
+	return err
+}
+
+// readCGIResponse parse the response, which may start with a HTTP status line (uWSGI),
+// or CGI headers with Status header (SCGI), the body is read until the connection is closed
+func readCGIResponse(reader *bufio.Reader, r *http.Request) (*http.Response, error) {
+	if prefix, err := reader.Peek(5); err == nil && string(prefix) == "HTTP/" {
+		return http.ReadResponse(reader, r)
+	}
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header(header),
+		Body:       ioutil.NopCloser(reader),
+		Request:    r,
+	}
+	if status := resp.Header.Get("Status"); len(status) > 0 {
+		statusCode, err := strconv.Atoi(strings.SplitN(strings.TrimSpace(status), " ", 2)[0])
+		if err != nil || statusCode < 100 || statusCode > 999 {
+			return nil, errors.New("invalid status " + status)
+		}
+		resp.StatusCode = statusCode
+	} else if len(resp.Header.Get("Location")) > 0 {
+		resp.StatusCode = http.StatusFound
+	}
+	return resp, nil
+}
@@ -0,0 +1,134 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-31 22:03:26
+ * @Last Modified: U2, 2021-05-31 22:03:26
+ */
+
+package gateway
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strings"
+
+	"janusec/backend"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// clientCertHeaders are sent to backend, and removed from the client request to avoid forgery
+var clientCertHeaders = []string{
+	"X-Client-Verify",
+	"X-Client-Cert-Subject",
+	"X-Client-Cert-Issuer",
+	"X-Client-Cert-SAN",
+	"X-Client-Cert-Fingerprint",
+	"X-Client-Cert-Serial",
+}
+
+// CheckClientCert verify the client certificate of mutual TLS and the subject allowlist of path,
+// return false if the request is rejected and the response has been written
+func CheckClientCert(w http.ResponseWriter, r *http.Request, app *models.Application) bool {
+	clientAuth := app.ClientAuth
+	if clientAuth == nil || !clientAuth.Enabled {
+		return true
+	}
+	for _, header := range clientCertHeaders {
+		r.Header.Del(header)
+	}
+	cert, err := backend.VerifyClientCert(clientAuth, r.TLS)
+	if err != nil {
+		utils.DebugPrintln("CheckClientCert", app.Name, r.RemoteAddr, err)
+		GenerateForbiddenResponse(w, "Client Certificate Error", "The client certificate is invalid: "+err.Error())
+		return false
+	}
+	if cert == nil && clientAuth.Mode == models.ClientCertRequired {
+		GenerateForbiddenResponse(w, "Client Certificate Required", "A valid client certificate is required")
+		return false
+	}
+	if !IsClientCertAllowed(app, r.URL.Path, cert) {
+		subject := "no client certificate"
+		if cert != nil {
+			subject = cert.Subject.String()
+		}
+		utils.DebugPrintln("CheckClientCert not allowed", app.Name, r.URL.Path, subject)
+		GenerateForbiddenResponse(w, "Forbidden", "The client certificate is not allowed to access "+r.URL.Path)
+		return false
+	}
+	if clientAuth.ForwardHeaders {
+		setClientCertHeaders(r.Header, cert)
+	}
+	return true
+}
+
+// IsClientCertAllowed check the subject with the rules of longest matched path prefix, allowed if no rule matched
+func IsClientCertAllowed(app *models.Application, urlPath string, cert *x509.Certificate) bool {
+	path := cleanURLPath(urlPath)
+	longestPrefix := -1
+	allowed := false
+	for _, rule := range app.ClientCertRules {
+		if !strings.HasPrefix(path, rule.PathPrefix) || len(rule.PathPrefix) < longestPrefix {
+			continue
+		}
+		if len(rule.PathPrefix) > longestPrefix {
+			longestPrefix = len(rule.PathPrefix)
+			allowed = false
+		}
+		if cert == nil || allowed {
+			continue
+		}
+		re := getCachedRegexp(rule.SubjectPattern)
+		if re != nil && re.MatchString(cert.Subject.String()) {
+			allowed = true
+		}
+	}
+	return longestPrefix < 0 || allowed
+}
+
+// setClientCertHeaders set the verify result and certificate information for backend
+func setClientCertHeaders(header http.Header, cert *x509.Certificate) {
+	if cert == nil {
+		header.Set("X-Client-Verify", "NONE")
+		return
+	}
+	header.Set("X-Client-Verify", "SUCCESS")
+	header.Set("X-Client-Cert-Subject", cert.Subject.String())
+	header.Set("X-Client-Cert-Issuer", cert.Issuer.String())
+	sans := []string{}
+	for _, dnsName := range cert.DNSNames {
+		sans = append(sans, "DNS:"+dnsName)
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	if len(sans) > 0 {
+		header.Set("X-Client-Cert-SAN", strings.Join(sans, ", "))
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	header.Set("X-Client-Cert-Fingerprint", hex.EncodeToString(fingerprint[:]))
+	header.Set("X-Client-Cert-Serial", cert.SerialNumber.Text(16))
+}
+
+// cleanURLPath remove the dot segments and empty segments which are resolved by backend, such as /public/../admin/,
+// the trailing slash is kept for prefix matching
+func cleanURLPath(urlPath string) string {
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+	cleanPath := path.Clean(urlPath)
+	// /admin/. and /admin/x/.. are resolved to /admin/
+	if (strings.HasSuffix(urlPath, "/") || strings.HasSuffix(urlPath, "/.") || strings.HasSuffix(urlPath, "/..")) && cleanPath != "/" {
+		cleanPath += "/"
+	}
+	return cleanPath
+}
@@ -0,0 +1,271 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-29 21:15:32
+ * @Last Modified: U2, 2021-05-29 21:15:32
+ */
+
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+
+	"janusec/models"
+	"janusec/utils"
+)
+
+// defaultCompressTypes is used if MIMETypes of application is empty
+var defaultCompressTypes = []string{
+	"text/html", "text/plain", "text/css", "text/xml", "text/javascript",
+	"application/javascript", "application/x-javascript", "application/json",
+	"application/xml", "application/rss+xml", "application/atom+xml",
+	"application/wasm", "image/svg+xml",
+}
+
+// precompressedExt is the file extension of precompressed variants in static/cdncache
+var precompressedExt = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// IsCompressibleType check the Content-Type with the MIME type allowlist of application
+func IsCompressibleType(compression *models.Compression, contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if len(mediaType) == 0 {
+		return false
+	}
+	compressTypes := defaultCompressTypes
+	if len(compression.MIMETypes) > 0 {
+		compressTypes = strings.Split(compression.MIMETypes, ",")
+	}
+	for _, compressType := range compressTypes {
+		compressType = strings.ToLower(strings.TrimSpace(compressType))
+		if compressType == mediaType {
+			return true
+		}
+		// Wildcard such as text/*
+		if strings.HasSuffix(compressType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(compressType, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiateEncoding select br or gzip according to Accept-Encoding, br is preferred when the quality values are equal,
+// return empty string if none is acceptable
+func NegotiateEncoding(acceptEncoding string, gzipEnabled bool, brotliEnabled bool) string {
+	qualities := map[string]float64{}
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(item, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if len(coding) == 0 {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		qualities[coding] = quality
+	}
+	getQuality := func(coding string) float64 {
+		if q, ok := qualities[coding]; ok {
+			return q
+		}
+		if q, ok := qualities["*"]; ok {
+			return q
+		}
+		return 0
+	}
+	brQuality, gzipQuality := 0.0, 0.0
+	if brotliEnabled {
+		brQuality = getQuality("br")
+	}
+	if gzipEnabled {
+		gzipQuality = getQuality("gzip")
+		if q, ok := qualities["x-gzip"]; ok && q > gzipQuality {
+			gzipQuality = q
+		}
+	}
+	if brQuality > 0 && brQuality >= gzipQuality {
+		return "br"
+	}
+	if gzipQuality > 0 {
+		return "gzip"
+	}
+	return ""
+}
+
+// newEncoder return the compression writer, the level of on-the-fly compression prefers speed
+func newEncoder(encoding string, w io.Writer, bestCompression bool) io.WriteCloser {
+	if encoding == "br" {
+		if bestCompression {
+			return brotli.NewWriterLevel(w, brotli.BestCompression)
+		}
+		return brotli.NewWriterLevel(w, 4)
+	}
+	if bestCompression {
+		gzipWriter, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return gzipWriter
+	}
+	return gzip.NewWriter(w)
+}
+
+// addVary add the header value if it does not exist
+func addVary(header http.Header, value string) {
+	for _, vary := range header.Values("Vary") {
+		for _, field := range strings.Split(vary, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+// compressedBody close both the pipe and the backend body, so that the encoding goroutine exits when client is gone
+type compressedBody struct {
+	*io.PipeReader
+	src io.Closer
+}
+
+// Close implements io.Closer
+func (body *compressedBody) Close() error {
+	_ = body.PipeReader.Close()
+	return body.src.Close()
+}
+
+// CompressResponse compress the response body on the fly if the backend did not compress it
+func CompressResponse(resp *http.Response, app *models.Application) {
+	compression := app.Compression
+	if compression == nil || !compression.Enabled {
+		return
+	}
+	r := resp.Request
+	if r.Method == http.MethodHead || resp.StatusCode < 200 || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusNotModified {
+		return
+	}
+	if contentEncoding := resp.Header.Get("Content-Encoding"); len(contentEncoding) > 0 && contentEncoding != "identity" {
+		return
+	}
+	if strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-transform") {
+		return
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < compression.MinSize {
+		return
+	}
+	if !IsCompressibleType(compression, resp.Header.Get("Content-Type")) {
+		return
+	}
+	addVary(resp.Header, "Accept-Encoding")
+	encoding := NegotiateEncoding(r.Header.Get("Accept-Encoding"), compression.Gzip, compression.Brotli)
+	if len(encoding) == 0 {
+		return
+	}
+	src := resp.Body
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		encoder := newEncoder(encoding, pipeWriter, false)
+		_, err := io.Copy(encoder, src)
+		if closeErr := encoder.Close(); err == nil {
+			err = closeErr
+		}
+		_ = src.Close()
+		_ = pipeWriter.CloseWithError(err)
+	}()
+	resp.Body = &compressedBody{PipeReader: pipeReader, src: src}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Accept-Ranges")
+	resp.Header.Set("Content-Encoding", encoding)
+	// The compressed representation is not byte-identical to the original
+	if etag := resp.Header.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+}
+
+// WritePrecompressedFiles write .br and .gz variants besides the cache file, or remove outdated variants
+func WritePrecompressedFiles(app *models.Application, targetFile string, contentType string, body []byte, modTime time.Time) {
+	compression := app.Compression
+	enabled := compression != nil && compression.Enabled &&
+		int64(len(body)) >= compression.MinSize &&
+		IsCompressibleType(compression, contentType)
+	for encoding, ext := range precompressedExt {
+		variantFile := targetFile + ext
+		if !enabled || (encoding == "br" && !compression.Brotli) || (encoding == "gzip" && !compression.Gzip) {
+			if err := os.Remove(variantFile); err != nil && !os.IsNotExist(err) {
+				utils.DebugPrintln("Remove precompressed file", variantFile, err)
+			}
+			removeMemoryFile(variantFile)
+			continue
+		}
+		var buf bytes.Buffer
+		encoder := newEncoder(encoding, &buf, true)
+		_, err := encoder.Write(body)
+		if closeErr := encoder.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			// Renamed into place, so that a partial variant is never served
+			err = writeFileAtomic(variantFile, buf.Bytes())
+		}
+		if err != nil {
+			utils.DebugPrintln("Write precompressed file", variantFile, err)
+			continue
+		}
+		if !modTime.IsZero() {
+			_ = os.Chtimes(variantFile, time.Now(), modTime)
+		}
+		setMemoryFile(variantFile, buf.Bytes())
+	}
+}
+
+// ServeCacheFile serve the precompressed variant if the client accepts it, otherwise the original file,
+// conditional and range requests are handled by http.ServeContent, return true if it is served from memory
+func ServeCacheFile(w http.ResponseWriter, r *http.Request, app *models.Application, targetFile string, contentType string, modTime time.Time) (fromMemory bool) {
+	if len(contentType) > 0 {
+		w.Header().Set("Content-Type", contentType)
+	}
+	compression := app.Compression
+	if compression != nil && compression.Enabled && IsCompressibleType(compression, contentType) {
+		addVary(w.Header(), "Accept-Encoding")
+		encoding := NegotiateEncoding(r.Header.Get("Accept-Encoding"), compression.Gzip, compression.Brotli)
+		// Ranges are served from the original file
+		if len(encoding) > 0 && len(r.Header.Get("Range")) == 0 {
+			if content, closer, fromMemory, err := openCacheFile(targetFile + precompressedExt[encoding]); err == nil {
+				defer closer.Close()
+				w.Header().Set("Content-Encoding", encoding)
+				// The compressed representation is not byte-identical to the original
+				if etag := w.Header().Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+					w.Header().Set("ETag", "W/"+etag)
+				}
+				http.ServeContent(w, r, "", modTime, content)
+				return fromMemory
+			}
+		}
+	}
+	content, closer, fromMemory, err := openCacheFile(targetFile)
+	if err != nil {
+		utils.DebugPrintln("ServeCacheFile", targetFile, err)
+		http.Error(w, "cache file not found", http.StatusInternalServerError)
+		return false
+	}
+	defer closer.Close()
+	http.ServeContent(w, r, "", modTime, content)
+	return fromMemory
+}
@@ -8,20 +8,16 @@
 package gateway
 
 import (
-	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
-	"os"
 	"os/exec"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	"janusec/backend"
@@ -34,7 +30,6 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/patrickmn/go-cache"
 	"github.com/yookoala/gofast"
-	"golang.org/x/net/http2"
 )
 
 var (
@@ -62,6 +57,23 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		decChan <- 1
 	}()
+	// Structured access log after the response completes, v1.2.4
+	var srcIP string
+	reqCtx := &RequestContext{OriginPath: r.URL.Path, StartTime: time.Now()}
+	// X-Request-ID is forwarded to destination and returned to client, and spans of each phase are recorded if tracing enabled, v1.2.4
+	reqCtx.Trace = newRequestTrace(r)
+	reqCtx.RequestID = getRequestID(r)
+	r.Header.Set("X-Request-ID", reqCtx.RequestID)
+	w.Header().Set("X-Request-ID", reqCtx.RequestID)
+	r = withRequestContext(r, reqCtx)
+	logWriter := &accessLogWriter{ResponseWriter: w}
+	w = logWriter
+	defer func() {
+		record := newAccessLogRecord(logWriter, r, reqCtx, srcIP)
+		utils.WriteAccessLog(record)
+		observeRequest(record)
+		reqCtx.Trace.finish(reqCtx, record)
+	}()
 	// r.Host may has the format: domain:port, first remove port
 	domainStr := r.Host
 	index := strings.IndexByte(r.Host, ':')
@@ -72,8 +84,12 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	// 获取domainStr对应的配置，domainStr为a.com/b.com/c.com......
 	domain := backend.GetDomainByName(domainStr)
 	if domain != nil && domain.Redirect {
+		// Rewrite rules of the redirect domain take precedence, v1.2.4
+		if domain.App != nil && ApplyRewriteRules(w, r, domain.App, domainStr) {
+			return
+		}
 		//配置了302的直接跳转
-		RedirectRequest(w, r, domain.Location)
+		RedirectDomain(w, r, domain)
 		return
 	}
 	// 获取domainStr对应的app配置
@@ -92,14 +108,24 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	}
 	if (r.TLS == nil) && (app.RedirectHTTPS) {
 		//如果开启了https跳转，则将请求跳转至https
-		if data.CFG.ListenHTTPS == ":443" {
+		if data.GetConfig().ListenHTTPS == ":443" {
 			RedirectRequest(w, r, "https://"+domainStr+r.URL.Path)
 		} else {
-			RedirectRequest(w, r, "https://"+domainStr+data.CFG.ListenHTTPS+r.URL.Path)
+			RedirectRequest(w, r, "https://"+domainStr+data.GetConfig().ListenHTTPS+r.URL.Path)
 		}
 		return
 	}
 
+	// URL rewrite and redirect rules, v1.2.4
+	if ApplyRewriteRules(w, r, app, domainStr) {
+		return
+	}
+
+	// Mutual TLS, checked after rewriting so that the allowlist applies to the path of backend, v1.2.4
+	if !CheckClientCert(w, r, app) {
+		return
+	}
+
 	//设置处理请求转发到后端应用
 
 	//设置 request URI 中的Scheme和HOST
@@ -108,37 +134,42 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 
 	nowTimeStamp := time.Now().Unix()
 	// dynamic
-	srcIP := GetClientIP(r, app)
+	reqCtx.AppID = app.ID
+	srcIP = GetClientIP(r, app)
 	ua := r.UserAgent()
 
 	// 处理IP规则
 	// IP Policy
 	isAllowIP := false
-	//如果ClientIPMethod是IPMethod_REMOTE_ADDR类型（直连类型？）
-	if app.ClientIPMethod == models.IPMethod_REMOTE_ADDR {
-		// First check whether it has IP Policy
-		ipPolicy := firewall.GetIPPolicyByIPAddr(srcIP)
-		//根据IP查找ip对应的处理类型
-		if ipPolicy != nil {
-			if ipPolicy.ApplyToCC {
-				if ipPolicy.IsAllow {
-					// Allow list, legal security testing
-					isAllowIP = true
-				} else {
-					// Block IP 15 minutes
+	// srcIP is resolved with trusted proxies, so IP policy works with all ClientIPMethod, v1.2.4
+	// First check whether it has IP Policy
+	span := reqCtx.Trace.StartSpan("ip_policy")
+	ipPolicy := firewall.GetIPPolicyByIPAddr(srcIP)
+	//根据IP查找ip对应的处理类型
+	if ipPolicy != nil {
+		if ipPolicy.ApplyToCC {
+			if ipPolicy.IsAllow {
+				// Allow list, legal security testing
+				isAllowIP = true
+			} else {
+				// Block IP 15 minutes
+				reqCtx.Verdict = verdictIPBlock
+				if isNetworkBlockable(r, app, srcIP) {
 					go firewall.AddIP2NFTables(srcIP, 900.0)
-					return
 				}
+				w.WriteHeader(http.StatusForbidden)
+				return
 			}
 		}
 	}
+	span.End()
 
-	//1.非IPMethod_REMOTE_ADDR类型
-	//2.未寻找到源IP策略
+	//未寻找到源IP策略
 
 	// 5-second shield from v1.2.0
 	if !isAllowIP && app.ShieldEnabled {
 		//非白名单IP且开启了ShieldEnabled策略
+		span = reqCtx.Trace.StartSpan("shield")
 		session, _ := store.Get(r, "janusec-token")
 		// check authorization
 		// 从cookies-store中尝试获取shldtoken
@@ -155,22 +186,29 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 				if isCrawler {
 					// 判断是否为爬虫
 					// Block IP
-					go firewall.AddIP2NFTables(srcIP, 900.0)
+					reqCtx.Verdict = verdictCrawler
+					if isNetworkBlockable(r, app, srcIP) {
+						go firewall.AddIP2NFTables(srcIP, 900.0)
+					}
 					return
 				}
 				// not search engine, not crawler, show 5-second shield
+				reqCtx.Verdict = verdictShield
 				GenerateShieldPage(w, r, r.URL.Path)
 				return
 			}
 			// search engine, or authorization ok, continue
 		}
+		span.End()
 	}
 
 	// Check CC
 	// 判断源IP是否触发CC攻击
 	if !isAllowIP {
+		span = reqCtx.Trace.StartSpan("cc")
 		isCC, ccPolicy, clientID, needLog := firewall.IsCCAttack(r, app, srcIP)
 		if isCC {
+			incCCHit(app.ID, ccPolicy.Action)
 			//cc攻击状态，下发对应的策略
 			targetURL := r.URL.Path
 			if len(r.URL.RawQuery) > 0 {
@@ -182,25 +220,29 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 				Action:    ccPolicy.Action,
 				ClientID:  clientID,
 				TargetURL: targetURL,
-				BlockTime: nowTimeStamp}
+				BlockTime: nowTimeStamp,
+				RequestID: reqCtx.RequestID}
 			//根据配置CC防护策略
 			switch ccPolicy.Action {
 			case models.Action_Block_100:
+				reqCtx.Verdict = verdictCCBlock
 				if needLog {
-					go firewall.LogCCRequest(r, app.ID, srcIP, ccPolicy)
+					go firewall.LogCCRequest(r, app.ID, srcIP, ccPolicy, reqCtx.RequestID)
 				}
-				if app.ClientIPMethod == models.IPMethod_REMOTE_ADDR {
+				if isNetworkBlockable(r, app, srcIP) {
 					go firewall.AddIP2NFTables(srcIP, ccPolicy.BlockSeconds)
 				}
 				GenerateBlockPage(w, hitInfo)
 				return
 			case models.Action_BypassAndLog_200:
+				reqCtx.Verdict = verdictCCLog
 				if needLog {
-					go firewall.LogCCRequest(r, app.ID, srcIP, ccPolicy)
+					go firewall.LogCCRequest(r, app.ID, srcIP, ccPolicy, reqCtx.RequestID)
 				}
 			case models.Action_CAPTCHA_300:
+				reqCtx.Verdict = verdictCCCaptcha
 				if needLog {
-					go firewall.LogCCRequest(r, app.ID, srcIP, ccPolicy)
+					go firewall.LogCCRequest(r, app.ID, srcIP, ccPolicy, reqCtx.RequestID)
 				}
 				captchaHitInfo.Store(hitInfo.ClientID, hitInfo)
 				captchaURL := CaptchaEntrance + "?id=" + hitInfo.ClientID
@@ -208,23 +250,43 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
+		span.End()
+	}
+
+	// Limit the request body size, v1.2.4
+	var maxBodyReader *backend.MaxBodyReader
+	if maxBodySize := backend.GetMaxBodySize(app); maxBodySize > 0 {
+		if r.ContentLength > maxBodySize {
+			GenerateRequestTooLargeResponse(w)
+			return
+		}
+		if r.ContentLength < 0 {
+			// Chunked request
+			maxBodyReader = backend.NewMaxBodyReader(r.Body, maxBodySize)
+			r.Body = maxBodyReader
+		}
 	}
 
 	// WAF Check
 	if !isAllowIP && app.WAFEnabled {
 		//waf防护策略开启
+		span = reqCtx.Trace.StartSpan("waf_request")
 		if isHit, policy := firewall.IsRequestHitPolicy(r, app.ID, srcIP); isHit {
+			incWAFHit(app.ID, policy)
 			switch policy.Action {
 			case models.Action_Block_100:
 				vulnName, _ := firewall.VulnMap.Load(policy.VulnID)
-				hitInfo := &models.HitInfo{TypeID: 2, PolicyID: policy.ID, VulnName: vulnName.(string)}
-				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy)
+				hitInfo := &models.HitInfo{TypeID: 2, PolicyID: policy.ID, VulnName: vulnName.(string), RequestID: reqCtx.RequestID}
+				reqCtx.Verdict = verdictWAFBlock
+				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy, reqCtx.RequestID)
 				GenerateBlockPage(w, hitInfo)
 				return
 			case models.Action_BypassAndLog_200:
-				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy)
+				reqCtx.Verdict = verdictWAFLog
+				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy, reqCtx.RequestID)
 			case models.Action_CAPTCHA_300:
-				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy)
+				reqCtx.Verdict = verdictWAFCaptcha
+				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy, reqCtx.RequestID)
 				clientID := GenClientID(r, app.ID, srcIP)
 				targetURL := r.URL.Path
 				if len(r.URL.RawQuery) > 0 {
@@ -233,7 +295,8 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 				hitInfo := &models.HitInfo{TypeID: 2,
 					PolicyID: policy.ID, VulnName: "Group Policy Hit",
 					Action: policy.Action, ClientID: clientID,
-					TargetURL: targetURL, BlockTime: nowTimeStamp}
+					TargetURL: targetURL, BlockTime: nowTimeStamp,
+					RequestID: reqCtx.RequestID}
 				captchaHitInfo.Store(clientID, hitInfo)
 				captchaURL := CaptchaEntrance + "?id=" + clientID
 				http.Redirect(w, r, captchaURL, http.StatusTemporaryRedirect)
@@ -242,20 +305,36 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 				// models.Action_Pass_400 do nothing
 			}
 		}
+		// The body has been read by WAF
+		if maxBodyReader != nil && maxBodyReader.Exceeded() {
+			GenerateRequestTooLargeResponse(w)
+			return
+		}
+		span.End()
+	}
+
+	// Waiting room, search engines are skipped as 5-second shield, v1.2.4
+	if app.WaitingRoom != nil && app.WaitingRoom.Enabled && !(data.NodeSetting.SkipSEEnabled && IsSearchEngine(ua)) {
+		span = reqCtx.Trace.StartSpan("waiting_room")
+		if !CheckWaitingRoom(w, r, app, reqCtx) {
+			return
+		}
+		span.End()
 	}
 
 	// Check OAuth
 	if app.OAuthRequired && data.NodeSetting.AuthConfig.Enabled {
 		//检查oauth策略
+		span = reqCtx.Trace.StartSpan("auth")
 		session, _ := store.Get(r, "janusec-token")
 		//尝试从cookie中获取userid
 		usernameI := session.Values["userid"]
 		var url string
 		if r.TLS != nil {
-			if data.CFG.ListenHTTPS == ":443" {
+			if data.GetConfig().ListenHTTPS == ":443" {
 				url = "https://" + domainStr + r.URL.Path
 			} else {
-				url = "https://" + domainStr + data.CFG.ListenHTTPS + r.URL.Path
+				url = "https://" + domainStr + data.GetConfig().ListenHTTPS + r.URL.Path
 			}
 		} else {
 			url = r.URL.String()
@@ -334,17 +413,49 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		// 0.9.15 change to X-Auth-Token
 		r.Header.Set("X-Auth-Token", accessToken)
 		r.Header.Set("X-Auth-User", usernameI.(string))
+		reqCtx.AuthUser = usernameI.(string)
+		span.End()
 	}
 
 	//选择转发的后端路由
+	originPath := r.URL.Path
+	reqCtx.OriginPath = originPath
+
+	// Concurrency limits of application and destination, excess requests wait in the queue, v1.2.4
+	ticket := backend.NewConcurrencyTicket(app, originPath)
+	defer ticket.Release()
+	if ticket != nil {
+		span = reqCtx.Trace.StartSpan("queue")
+	}
+	if !ticket.AcquireApp(r.Context(), app.ID) {
+		reqCtx.Verdict = verdictConcurrencyLimit
+		GenerateServiceUnavailableResponse(w, ticket.Limit)
+		return
+	}
+	span.End()
+
 	dest := backend.SelectBackendRoute(app, r, srcIP)
 	if dest == nil {
+		w.WriteHeader(http.StatusBadGateway)
 		errInfo := &models.InternalErrorInfo{
 			Description: "Internal Servers Offline",
 		}
 		GenerateInternalErrorResponse(w, errInfo)
 		return
 	}
+	reqCtx.Dest = dest
+	if ticket != nil && dest.MaxConcurrency > 0 {
+		span = reqCtx.Trace.StartSpan("queue_destination")
+	}
+	if !ticket.AcquireDestination(r.Context(), dest) {
+		reqCtx.Verdict = verdictConcurrencyLimit
+		GenerateServiceUnavailableResponse(w, ticket.Limit)
+		return
+	}
+	span.End()
+	// in-flight requests, used by least connections load balancing
+	atomic.AddInt64(&dest.Connections, 1)
+	defer atomic.AddInt64(&dest.Connections, -1)
 
 	// Modify Origin if client http and backend https
 	if (r.TLS == nil) && (app.InternalScheme == "https") {
@@ -354,14 +465,16 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Add access log and statistics
-	go utils.AccessLog(domainStr, r.Method, srcIP, r.RequestURI, ua)
+	// Add statistics, the access log is written after the response completes
 	go IncAccessStat(app.ID, r.URL.Path)
 	referer := r.Referer()
 	if len(referer) > 0 {
 		go IncRefererStat(app.ID, referer, srcIP, ua)
 	}
 
+	// Header rules of request, v1.2.4
+	ApplyHeaderRules(app, models.HeaderRequest, r.Header, originPath, 0)
+
 	if dest.RouteType == models.StaticRoute {
 		// Static Web site
 		staticHandler := http.FileServer(http.Dir(dest.BackendRoute))
@@ -373,8 +486,8 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		http.StripPrefix(dest.RequestRoute, staticHandler).ServeHTTP(w, r)
 		return
 	} else if dest.RouteType == models.FastCGIRoute {
-		// FastCGI
-		connFactory := gofast.SimpleConnFactory("tcp", dest.Destination)
+		// FastCGI over TCP or Unix domain socket
+		connFactory := gofast.SimpleConnFactory(backend.GetDestinationAddr(dest.Destination))
 		urlPath := utils.GetRoutePath(r.URL.Path)
 		newPath := r.URL.Path
 		if urlPath != "/" {
@@ -384,143 +497,91 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 			gofast.NewFileEndpoint(dest.BackendRoute+newPath)(gofast.BasicSession),
 			gofast.SimpleClientFactory(connFactory),
 		)
+		reqCtx.Trace.injectTraceparent(r, dest)
+		reqCtx.UpstreamStart = time.Now()
 		fastCGIHandler.ServeHTTP(w, r)
 		return
+	} else if dest.RouteType == models.UWSGIRoute || dest.RouteType == models.SCGIRoute {
+		// uWSGI or SCGI, v1.2.4
+		reqCtx.Trace.injectTraceparent(r, dest)
+		reqCtx.UpstreamStart = time.Now()
+		ServeCGIProxy(w, r, dest, srcIP)
+		return
 	}
 
-	//设置http.reverseproxy的转发属性
-	// var transport http.RoundTripper
-	transport := &http.Transport{
-		TLSHandshakeTimeout:   30 * time.Second,
-		IdleConnTimeout:       30 * time.Second,
-		ExpectContinueTimeout: 5 * time.Second,
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			conn, err := net.Dial("tcp", dest.Destination)
-			dest.CheckTime = nowTimeStamp
-			if err != nil {
-				dest.Online = false
-				utils.DebugPrintln("DialContext error", err)
-				if data.NodeSetting.SMTP.SMTPEnabled {
-					sendOfflineNotification(app, dest.Destination)
-				}
-				errInfo := &models.InternalErrorInfo{
-					Description: "Internal Server Offline",
+	// Shared cache honoring Cache-Control and cache rules, keyed on host, path, query and Vary, v1.2.4
+	if ServeFromCache(w, r, app, dest, srcIP) {
+		return
+	}
+
+	// Send the client address to destination with PROXY protocol, v1.2.4
+	if dest.ProxyProtocol != backend.ProxyProtocolNone {
+		localAddr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		r = r.WithContext(backend.WithProxyProtocolAddr(r.Context(), getClientAddr(r, srcIP), localAddr))
+	}
+
+	// Reverse Proxy, retry idempotent requests on other destinations if enabled
+	retryTransport := &backend.RetryRoundTripper{
+		App:        app,
+		SrcIP:      srcIP,
+		Dest:       dest,
+		OriginPath: originPath,
+		Ticket:     ticket,
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			//req.URL.Scheme = app.InternalScheme
+			//req.URL.Host = r.Host
+		},
+		Transport:      retryTransport,  //transport属性
+		ModifyResponse: rewriteResponse, //支持修改response
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			reqCtx.UpstreamLatency = time.Since(reqCtx.UpstreamStart)
+			mirror := reqCtx.Mirror
+			if errors.Is(err, backend.ErrRequestBodyTooLarge) {
+				if mirror != nil {
+					mirror.Done(http.StatusRequestEntityTooLarge)
 				}
-				GenerateInternalErrorResponse(w, errInfo)
+				GenerateRequestTooLargeResponse(w)
+				return
 			}
-			return conn, err
-		},
-		//TLS拨号属性
-		DialTLS: func(network, addr string) (net.Conn, error) {
-			conn, err := net.Dial("tcp", dest.Destination)
-			dest.CheckTime = nowTimeStamp
-			if err != nil {
-				dest.Online = false
-				dest.CheckTime = nowTimeStamp
-				utils.DebugPrintln("DialContext error", err)
-				if data.NodeSetting.SMTP.SMTPEnabled {
-					sendOfflineNotification(app, dest.Destination)
+			if errors.Is(err, backend.ErrConcurrencyLimit) {
+				// The destination of retry is full
+				if mirror != nil {
+					mirror.Done(http.StatusServiceUnavailable)
 				}
+				reqCtx.Verdict = verdictConcurrencyLimit
+				GenerateServiceUnavailableResponse(w, ticket.Limit)
+				return
+			}
+			if mirror != nil {
+				mirror.Done(http.StatusBadGateway)
+			}
+			lastDest := retryTransport.Dest
+			incUpstreamError(lastDest)
+			reqCtx.Trace.setUpstreamError(err)
+			// Upstream TLS verification failures are reported with the reason, v1.2.4
+			if reason := backend.DescribeUpstreamTLSError(err); len(reason) > 0 {
+				utils.DebugPrintln("ReverseProxy upstream TLS error", app.Name, lastDest.Destination, reason)
+				w.WriteHeader(http.StatusBadGateway)
 				errInfo := &models.InternalErrorInfo{
-					Description: "Internal Server Offline",
+					Title:       "Upstream TLS Error",
+					Description: "The gateway could not establish a trusted connection to the backend, " + reason,
 				}
 				GenerateInternalErrorResponse(w, errInfo)
-				return nil, err
+				return
 			}
-			cfg := &tls.Config{
-				ServerName:         domainStr,
-				NextProtos:         []string{"h2", "http/1.1"},
-				MinVersion:         tls.VersionTLS12,
-				InsecureSkipVerify: true,
+			utils.DebugPrintln("ReverseProxy error", lastDest.Destination, err)
+			if !lastDest.Online.Load() {
+				backend.SendOfflineNotification(app, lastDest.Destination, "")
 			}
-			tlsConn := tls.Client(conn, cfg)
-			if err := tlsConn.Handshake(); err != nil {
-				utils.DebugPrintln("tlsConn.Handshake error", err)
+			w.WriteHeader(http.StatusBadGateway)
+			errInfo := &models.InternalErrorInfo{
+				Description: "Internal Server Offline",
 			}
-			return tlsConn, err //net.Dial("tcp", dest)
+			GenerateInternalErrorResponse(w, errInfo)
 		},
 	}
-	err := http2.ConfigureTransport(transport)
-	if err != nil {
-		utils.DebugPrintln("http2.ConfigureTransport error", err)
-	}
-
-	// Check static cache
-	isStatic := firewall.IsStaticResource(r)
-	if isStatic {
-		// First check Header Range, not cache for range
-		rangeValue := r.Header.Get("Range")
-		if rangeValue == "" {
-			staticRoot := fmt.Sprintf("./static/cdncache/%d", app.ID)
-			targetFile := staticRoot + r.URL.Path
-			// Check Static Cache
-			fi, err := os.Stat(targetFile)
-			if err == nil {
-				// Found targetFile
-				now := time.Now()
-				fiStat := fi.Sys().(*syscall.Stat_t)
-				// Use ctime fiStat.Ctim.Sec to mark the last check time
-				pastSeconds := now.Unix() - int64(fiStat.Ctim.Sec)
-				if pastSeconds > 1800 {
-					// check update
-					backendAddr := fmt.Sprintf("%s://%s%s", app.InternalScheme, dest.Destination, r.RequestURI)
-					req, err := http.NewRequest("GET", backendAddr, nil)
-					if err != nil {
-						utils.DebugPrintln("Check Update NewRequest", err)
-					}
-					if err == nil {
-						req.Header.Set("Host", domainStr)
-						modTimeGMT := fi.ModTime().UTC().Format(http.TimeFormat)
-						//If-Modified-Since: Sun, 14 Jun 2020 13:54:20 GMT
-						req.Header.Set("If-Modified-Since", modTimeGMT)
-						client := http.Client{
-							Transport: transport,
-						}
-						resp, err := client.Do(req)
-						if err != nil {
-							utils.DebugPrintln("Cache update Do", err)
-							return
-						}
-						defer resp.Body.Close()
-						if resp.StatusCode == http.StatusOK {
-							//fmt.Println("200", backendAddr)
-							bodyBuf, _ := ioutil.ReadAll(resp.Body)
-							err = ioutil.WriteFile(targetFile, bodyBuf, 0600)
-							if err != nil {
-								utils.DebugPrintln("CDN WriteFile", targetFile, err)
-							}
-							lastModified, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
-							if err != nil {
-								utils.DebugPrintln("CDN Parse Last-Modified", targetFile, err)
-							}
-							err = os.Chtimes(targetFile, now, lastModified)
-							if err != nil {
-								utils.DebugPrintln("CDN Chtimes", targetFile, err)
-							}
-						} else if resp.StatusCode == http.StatusNotModified {
-							//fmt.Println("304", backendAddr)
-							err := os.Chtimes(targetFile, now, fi.ModTime())
-							if err != nil {
-								utils.DebugPrintln("Cache update access time", err)
-							}
-						}
-					}
-				}
-				http.ServeFile(w, r, targetFile)
-				return
-			}
-		}
-		// Has Range Header, or resource Not Found, Continue
-	}
-
-	// Reverse Proxy
-	proxy := &httputil.ReverseProxy{
-		Director: func(req *http.Request) {
-			//req.URL.Scheme = app.InternalScheme
-			//req.URL.Host = r.Host
-		},
-		Transport:      transport,       //transport属性
-		ModifyResponse: rewriteResponse} //支持修改response
 	if utils.Debug {
 		dump, err := httputil.DumpRequest(r, true)
 		if err != nil {
@@ -530,7 +591,11 @@ func ReverseHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	}
 	//设置转发代理请求的头部Host字段
 	r.Host = domainStr
+	// Send a copy to the shadow destination of route, and compare the responses, v1.2.4
+	reqCtx.Mirror = backend.MirrorRequest(app, r, dest)
 	//执行真正的代理请求
+	reqCtx.Trace.injectTraceparent(r, dest)
+	reqCtx.UpstreamStart = time.Now()
 	proxy.ServeHTTP(w, r)
 }
 
@@ -594,26 +659,36 @@ func GenClientID(r *http.Request, appID int64, srcIP string) string {
 
 // GetClientIP acquire the client IP address
 // 根据app中的设置获取不同类型的源IP
+// The headers are used only when the peer is a trusted proxy, v1.2.4
 func GetClientIP(r *http.Request, app *models.Application) (clientIP string) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if app.ClientIPMethod == models.IPMethod_REMOTE_ADDR || !IsTrustedProxy(remoteIP, app) {
+		return remoteIP
+	}
 	switch app.ClientIPMethod {
-	case models.IPMethod_REMOTE_ADDR:
-		clientIP, _, _ = net.SplitHostPort(r.RemoteAddr)
-		return clientIP
 	case models.IPMethod_X_FORWARDED_FOR:
-		xForwardedFor := r.Header.Get("X-Forwarded-For")
-		ips := strings.Split(xForwardedFor, ", ")
-		clientIP = ips[len(ips)-1]
+		return getForwardedIP(r, app, remoteIP)
 	case models.IPMethod_X_REAL_IP:
-		clientIP = r.Header.Get("X-Real-IP")
+		clientIP = strings.TrimSpace(r.Header.Get("X-Real-IP"))
 	case models.IPMethod_REAL_IP:
-		clientIP = r.Header.Get("Real-IP")
+		clientIP = strings.TrimSpace(r.Header.Get("Real-IP"))
 	}
-	if len(clientIP) == 0 {
-		clientIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+	if net.ParseIP(clientIP) == nil {
+		return remoteIP
 	}
 	return clientIP
 }
 
+// getClientAddr return the address of client, the port is 0 if the client IP is resolved from headers
+func getClientAddr(r *http.Request, clientIP string) net.Addr {
+	remoteIP, remotePort, _ := net.SplitHostPort(r.RemoteAddr)
+	port := 0
+	if remoteIP == clientIP {
+		port, _ = strconv.Atoi(remotePort)
+	}
+	return &net.TCPAddr{IP: net.ParseIP(clientIP), Port: port}
+}
+
 // OAuthLogout Clear OAuth Information
 func OAuthLogout(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "janusec-token")
@@ -681,26 +756,6 @@ func CheckExpiringCertificates() {
 	}
 }
 
-// sendOfflineNotification ...
-func sendOfflineNotification(app *models.Application, dest string) {
-	var emails string
-	if data.IsPrimary {
-		emails = data.DAL.GetAppAdminAndOwnerEmails(app.Owner)
-	} else {
-		emails = data.NodeSetting.SMTP.AdminEmails
-	}
-	mailBody := "Backend server: " + dest + " (" + app.Name + ") was offline."
-	if len(mailBody) > 0 && len(emails) > 0 {
-		go utils.SendEmail(data.NodeSetting.SMTP.SMTPServer,
-			data.NodeSetting.SMTP.SMTPPort,
-			data.NodeSetting.SMTP.SMTPAccount,
-			data.NodeSetting.SMTP.SMTPPassword,
-			emails,
-			"[JANUSEC] Backend server offline notification",
-			mailBody)
-	}
-}
-
 // Test ...
 func Test(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Done"))
@@ -10,6 +10,7 @@ package gateway
 import (
 	"html/template"
 	"net/http"
+	"strconv"
 
 	"janusec/models"
 	"janusec/utils"
@@ -22,6 +23,9 @@ func GenerateInternalErrorResponse(w http.ResponseWriter, errInfo *models.Intern
 	if tmpl502 == nil {
 		tmpl502, _ = template.New("InternalError").Parse(internalErrorHTML)
 	}
+	if len(errInfo.RequestID) == 0 {
+		errInfo.RequestID = w.Header().Get("X-Request-ID")
+	}
 
 	err := tmpl502.Execute(w, errInfo)
 	if err != nil {
@@ -29,10 +33,52 @@ func GenerateInternalErrorResponse(w http.ResponseWriter, errInfo *models.Intern
 	}
 }
 
+// GenerateRequestTooLargeResponse response 413 if the request body exceeds the limit of application, v1.2.4
+func GenerateRequestTooLargeResponse(w http.ResponseWriter) {
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	errInfo := &models.InternalErrorInfo{
+		Title:       "Request Entity Too Large",
+		Description: "The request body exceeds the limit",
+	}
+	GenerateInternalErrorResponse(w, errInfo)
+}
+
+// GenerateForbiddenResponse response 403 with the reason, such as client certificate errors, v1.2.4
+func GenerateForbiddenResponse(w http.ResponseWriter, title string, description string) {
+	w.WriteHeader(http.StatusForbidden)
+	errInfo := &models.InternalErrorInfo{
+		Title:       title,
+		Description: description,
+	}
+	GenerateInternalErrorResponse(w, errInfo)
+}
+
+// GenerateServiceUnavailableResponse response 503 with Retry-After if the concurrency limit of application is reached, v1.2.4
+func GenerateServiceUnavailableResponse(w http.ResponseWriter, limit *models.ConcurrencyLimit) {
+	if limit.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(limit.RetryAfter, 10))
+	}
+	if len(limit.ErrorPage) > 0 {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, err := w.Write([]byte(limit.ErrorPage)); err != nil {
+			utils.DebugPrintln("GenerateServiceUnavailableResponse w.Write error", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	errInfo := &models.InternalErrorInfo{
+		Title:       "Service Unavailable",
+		Description: "Too many requests in progress, please retry later",
+	}
+	GenerateInternalErrorResponse(w, errInfo)
+}
+
 const internalErrorHTML = `<!DOCTYPE html>
  <html>
  <head>
- <title>Internal Error</title>
+ <title>{{ if .Title }}{{ .Title }}{{ else }}Internal Error{{ end }}</title>
  </head>
  <style>
  body {
@@ -49,9 +95,10 @@ const internalErrorHTML = `<!DOCTYPE html>
  </style>
  <body>
  <div class="block_div">
- <h1>Internal Server Offline</h1>
+ <h1>{{ if .Title }}{{ .Title }}{{ else }}Internal Server Offline{{ end }}</h1>
  <hr>
  {{ .Description }}. Detected by Janusec Application Gateway
+ {{ if .RequestID }}<br>Request ID: {{ .RequestID }}{{ end }}
  </div>
  </body>
  </html>
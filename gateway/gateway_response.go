@@ -9,12 +9,9 @@ package gateway
 
 import (
 	"bytes"
-	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"path/filepath"
 	"regexp"
 	"time"
 
@@ -25,12 +22,20 @@ import (
 	"janusec/data"
 	"janusec/firewall"
 	"janusec/models"
-	"janusec/utils"
 )
 
 func rewriteResponse(resp *http.Response) (err error) {
 	r := resp.Request
+	reqCtx := GetRequestContext(r)
+	if !reqCtx.UpstreamStart.IsZero() {
+		reqCtx.UpstreamLatency = time.Since(reqCtx.UpstreamStart)
+	}
+	if mirror := reqCtx.Mirror; mirror != nil {
+		mirror.Done(resp.StatusCode)
+	}
 	app := backend.GetApplicationByDomain(r.Host)
+	// X-Request-ID of gateway has been set to the response
+	resp.Header.Del("X-Request-ID")
 	locationStr := resp.Header.Get("Location")
 	indexHTTP := strings.Index(locationStr, "http")
 	if indexHTTP == 0 {
@@ -47,17 +52,17 @@ func rewriteResponse(resp *http.Response) (err error) {
 			var userScheme string
 			if resp.Request.TLS != nil {
 				userScheme = "https"
-				if data.CFG.ListenHTTPS == ":443" {
+				if data.GetConfig().ListenHTTPS == ":443" {
 					newHost = host
 				} else {
-					newHost = host + data.CFG.ListenHTTPS
+					newHost = host + data.GetConfig().ListenHTTPS
 				}
 			} else {
 				userScheme = "http"
-				if data.CFG.ListenHTTP == ":80" {
+				if data.GetConfig().ListenHTTP == ":80" {
 					newHost = host
 				} else {
-					newHost = host + data.CFG.ListenHTTP
+					newHost = host + data.GetConfig().ListenHTTP
 				}
 			}
 			newLocation := strings.Replace(locationURL.String(), oldHost, newHost, -1)
@@ -74,12 +79,15 @@ func rewriteResponse(resp *http.Response) (err error) {
 
 	srcIP := GetClientIP(r, app)
 	if app.WAFEnabled {
+		span := reqCtx.Trace.StartSpan("waf_response")
 		if isHit, policy := firewall.IsResponseHitPolicy(resp, app.ID); isHit {
+			incWAFHit(app.ID, policy)
 			switch policy.Action {
 			case models.Action_Block_100:
 				vulnName, _ := firewall.VulnMap.Load(policy.VulnID)
-				hitInfo := &models.HitInfo{TypeID: 2, PolicyID: policy.ID, VulnName: vulnName.(string)}
-				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy)
+				hitInfo := &models.HitInfo{TypeID: 2, PolicyID: policy.ID, VulnName: vulnName.(string), RequestID: reqCtx.RequestID}
+				reqCtx.Verdict = verdictWAFBlock
+				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy, reqCtx.RequestID)
 				blockContent := GenerateBlockConcent(hitInfo)
 				resp.StatusCode = 403
 				resp.Body = ioutil.NopCloser(bytes.NewBuffer(blockContent))
@@ -88,8 +96,10 @@ func rewriteResponse(resp *http.Response) (err error) {
 				resp.Header.Del("Content-Encoding")
 				return nil
 			case models.Action_BypassAndLog_200:
-				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy)
+				reqCtx.Verdict = verdictWAFLog
+				go firewall.LogGroupHitRequest(r, app.ID, srcIP, policy, reqCtx.RequestID)
 			case models.Action_CAPTCHA_300:
+				reqCtx.Verdict = verdictWAFCaptcha
 				clientID := GenClientID(r, app.ID, srcIP)
 				targetURL := r.URL.Path
 				if len(r.URL.RawQuery) > 0 {
@@ -98,7 +108,8 @@ func rewriteResponse(resp *http.Response) (err error) {
 				hitInfo := &models.HitInfo{TypeID: 2,
 					PolicyID: policy.ID, VulnName: "Group Policy Hit",
 					Action: policy.Action, ClientID: clientID,
-					TargetURL: targetURL, BlockTime: time.Now().Unix()}
+					TargetURL: targetURL, BlockTime: time.Now().Unix(),
+					RequestID: reqCtx.RequestID}
 				captchaHitInfo.Store(clientID, hitInfo)
 				captchaURL := CaptchaEntrance + "?id=" + clientID
 				resp.Header.Set("Location", captchaURL)
@@ -109,19 +120,13 @@ func rewriteResponse(resp *http.Response) (err error) {
 				// models.Action_Pass_400 do nothing
 			}
 		}
+		span.End()
 	}
 
-	// HSTS
-	if (app.HSTSEnabled) && (r.TLS != nil) {
-		resp.Header.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-	}
-
-	// CSP Content-Security-Policy, 0.9.11+
-	if app.CSPEnabled {
-		resp.Header.Set("Content-Security-Policy", app.CSP)
-	}
+	// Shared cache honoring Cache-Control, before the headers of gateway are applied, v1.2.4
+	StoreCacheResponse(resp, app)
 
-	// if client http and backend https, remove "; Secure" and replace https by http
+	// if client http and backend https, remove "; Secure"
 	if (r.TLS == nil) && (app.InternalScheme == "https") {
 		cookies := resp.Cookies()
 		for _, cookie := range cookies {
@@ -129,60 +134,43 @@ func rewriteResponse(resp *http.Response) (err error) {
 			cookieStr := re.ReplaceAllLiteralString(cookie.Raw, "")
 			resp.Header.Set("Set-Cookie", cookieStr)
 		}
-		origin := resp.Header.Get("Access-Control-Allow-Origin")
-		if len(origin) > 0 {
-			resp.Header.Set("Access-Control-Allow-Origin", strings.Replace(origin, "https", "http", 1))
-		}
-		csp := resp.Header.Get("Content-Security-Policy")
-		if len(csp) > 0 {
-			resp.Header.Set("Content-Security-Policy", strings.Replace(origin, "https", "http", -1))
-		}
 	}
 
-	// Static Cache
-	if resp.StatusCode == http.StatusOK && firewall.IsStaticResource(r) {
-		if resp.ContentLength < 0 || resp.ContentLength > 1024*1024*10 {
-			// Not cache big files which size bigger than 10MB or unkonwn
-			return nil
-		}
-		staticRoot := fmt.Sprintf("./static/cdncache/%d", app.ID)
-		targetFile := staticRoot + r.URL.Path
-		cacheFilePath := filepath.Dir(targetFile)
-		bodyBuf, _ := ioutil.ReadAll(resp.Body)
-		resp.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBuf))
-		err := os.MkdirAll(cacheFilePath, 0666)
-		if err != nil {
-			utils.DebugPrintln("Cache Path Error", err)
-		}
-		contentEncoding := resp.Header.Get("Content-Encoding")
-		switch contentEncoding {
-		case "gzip":
-			reader, _ := gzip.NewReader(bytes.NewBuffer(bodyBuf))
-			defer reader.Close()
-			decompressedBodyBuf, err := ioutil.ReadAll(reader)
-			if err != nil {
-				utils.DebugPrintln("Gzip decompress Error", err)
-			}
-			_ = ioutil.WriteFile(targetFile, decompressedBodyBuf, 0600)
-		default:
-			_ = ioutil.WriteFile(targetFile, bodyBuf, 0600)
-		}
-		if err != nil {
-			utils.DebugPrintln("Cache File Error", targetFile, err)
-		}
-		lastModified, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
-		if err != nil {
-			//utils.DebugPrintln("Cache File Parse Last-Modified", targetFile, err)
-			return nil
-		}
-		err = os.Chtimes(targetFile, time.Now(), lastModified)
-		if err != nil {
-			utils.DebugPrintln("Cache File Chtimes", targetFile, err)
-		}
-	}
+	SetAppResponseHeaders(resp.Header, r, app, resp.StatusCode)
+
+	// Compression on the fly if the backend did not compress it, v1.2.4
+	CompressResponse(resp, app)
 
 	//body, err := httputil.DumpResponse(resp, true)
 	//fmt.Println("Dump Response:")
 	//fmt.Println(string(body))
 	return nil
 }
+
+// SetAppResponseHeaders apply HSTS, CSP and header rules of application, used by both proxied and cached responses
+func SetAppResponseHeaders(header http.Header, r *http.Request, app *models.Application, statusCode int) {
+	// HSTS
+	if (app.HSTSEnabled) && (r.TLS != nil) {
+		header.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+	}
+
+	// CSP Content-Security-Policy, 0.9.11+
+	if app.CSPEnabled {
+		header.Set("Content-Security-Policy", app.CSP)
+	}
+
+	// if client http and backend https, replace https by http
+	if (r.TLS == nil) && (app.InternalScheme == "https") {
+		origin := header.Get("Access-Control-Allow-Origin")
+		if len(origin) > 0 {
+			header.Set("Access-Control-Allow-Origin", strings.Replace(origin, "https", "http", 1))
+		}
+		csp := header.Get("Content-Security-Policy")
+		if len(csp) > 0 {
+			header.Set("Content-Security-Policy", strings.Replace(csp, "https", "http", -1))
+		}
+	}
+
+	// Header rules of response, after the built-in headers so that they can be overwritten, v1.2.4
+	ApplyHeaderRules(app, models.HeaderResponse, header, GetRequestContext(r).OriginPath, statusCode)
+}
@@ -0,0 +1,68 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-26 22:36:45
+ * @Last Modified: U2, 2021-05-26 22:36:45
+ */
+
+package gateway
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"janusec/backend"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// regexpCache map[pattern string]*regexp.Regexp, cache of compiled patterns of header and rewrite rules
+var regexpCache = sync.Map{}
+
+func getCachedRegexp(pattern string) *regexp.Regexp {
+	if reI, ok := regexpCache.Load(pattern); ok {
+		return reI.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		utils.DebugPrintln("getCachedRegexp", pattern, err)
+		return nil
+	}
+	regexpCache.Store(pattern, re)
+	return re
+}
+
+// ApplyHeaderRules modify the header in order of rules, statusCode is ignored for request
+func ApplyHeaderRules(app *models.Application, direction models.HeaderDirection, header http.Header, path string, statusCode int) {
+	for _, rule := range app.HeaderRules {
+		if rule.Direction != direction {
+			continue
+		}
+		if len(rule.PathPrefix) > 0 && !strings.HasPrefix(path, rule.PathPrefix) {
+			continue
+		}
+		if direction == models.HeaderResponse && len(rule.StatusCodes) > 0 && !backend.IsExpectedStatus(rule.StatusCodes, statusCode) {
+			continue
+		}
+		switch rule.Action {
+		case models.HeaderSet:
+			header.Set(rule.Name, rule.Value)
+		case models.HeaderAppend:
+			header.Add(rule.Name, rule.Value)
+		case models.HeaderRemove:
+			header.Del(rule.Name)
+		case models.HeaderReplace:
+			re := getCachedRegexp(rule.Pattern)
+			if re == nil {
+				continue
+			}
+			// Values returns the underlying slice, so replace in place
+			values := header.Values(rule.Name)
+			for i, value := range values {
+				values[i] = re.ReplaceAllString(value, rule.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,371 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-09 21:26:03
+ * @Last Modified: U2, 2021-06-09 21:26:03
+ */
+
+package gateway
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"janusec/backend"
+	"janusec/data"
+	"janusec/firewall"
+	"janusec/models"
+)
+
+// latencyBuckets of histograms in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram is a Prometheus histogram, the counts are not cumulative until output
+type histogram struct {
+	counts []int64
+	count  int64
+	// sum in microseconds
+	sum int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(duration time.Duration) {
+	seconds := duration.Seconds()
+	for i, bucket := range latencyBuckets {
+		if seconds <= bucket {
+			atomic.AddInt64(&h.counts[i], 1)
+			break
+		}
+	}
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, duration.Microseconds())
+}
+
+// appMetrics is the metrics of an application on current node
+type appMetrics struct {
+	// requests by status class, index 1 to 5 for 1xx to 5xx
+	requests         [6]int64
+	duration         *histogram
+	upstreamDuration *histogram
+}
+
+// metricKey is the key of labeled counters
+type metricKey struct {
+	appID int64
+	name  string
+	value string
+}
+
+var (
+	// appMetricsMap map[appID int64]*appMetrics
+	appMetricsMap = sync.Map{}
+
+	// wafHits map[metricKey{appID, policyID, vulnName}]*int64
+	wafHits = sync.Map{}
+
+	// ccHits map[metricKey{appID, action}]*int64
+	ccHits = sync.Map{}
+
+	// upstreamErrors map[metricKey{appID, destination}]*int64
+	upstreamErrors = sync.Map{}
+)
+
+var ccActionNames = map[models.PolicyAction]string{
+	models.Action_Block_100:        "block",
+	models.Action_BypassAndLog_200: "log",
+	models.Action_CAPTCHA_300:      "captcha",
+	models.Action_Pass_400:         "pass",
+}
+
+func getAppMetrics(appID int64) *appMetrics {
+	if metricsI, ok := appMetricsMap.Load(appID); ok {
+		return metricsI.(*appMetrics)
+	}
+	metricsI, _ := appMetricsMap.LoadOrStore(appID, &appMetrics{duration: newHistogram(), upstreamDuration: newHistogram()})
+	return metricsI.(*appMetrics)
+}
+
+func incCounter(counters *sync.Map, key metricKey) {
+	counterI, ok := counters.Load(key)
+	if !ok {
+		counterI, _ = counters.LoadOrStore(key, new(int64))
+	}
+	atomic.AddInt64(counterI.(*int64), 1)
+}
+
+// observeRequest record the status and latency after the response completes
+func observeRequest(record *models.AccessLogRecord) {
+	if record.AppID == 0 {
+		return
+	}
+	metrics := getAppMetrics(record.AppID)
+	statusClass := record.Status / 100
+	if statusClass < 1 || statusClass > 5 {
+		statusClass = 0
+	}
+	atomic.AddInt64(&metrics.requests[statusClass], 1)
+	metrics.duration.observe(time.Duration(record.TotalLatency * float64(time.Millisecond)))
+	if len(record.Destination) > 0 && record.UpstreamLatency > 0 {
+		metrics.upstreamDuration.observe(time.Duration(record.UpstreamLatency * float64(time.Millisecond)))
+	}
+}
+
+// incWAFHit count the hits of group policies, including the responses
+func incWAFHit(appID int64, policy *models.GroupPolicy) {
+	vulnName := ""
+	if vulnNameI, ok := firewall.VulnMap.Load(policy.VulnID); ok {
+		vulnName = vulnNameI.(string)
+	}
+	incCounter(&wafHits, metricKey{appID: appID, name: strconv.FormatInt(policy.ID, 10), value: vulnName})
+}
+
+// incCCHit count the requests hit by CC policy
+func incCCHit(appID int64, action models.PolicyAction) {
+	incCounter(&ccHits, metricKey{appID: appID, name: ccActionNames[action]})
+}
+
+// incUpstreamError count the failures of destinations, such as connection refused or timeout
+func incUpstreamError(dest *models.Destination) {
+	incCounter(&upstreamErrors, metricKey{appID: dest.AppID, name: dest.Destination})
+}
+
+// MetricsHandlerFunc expose the metrics in Prometheus text format, on the listener of metrics in config.json
+func MetricsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != data.GetConfig().Metrics.Path {
+		http.NotFound(w, r)
+		return
+	}
+	if len(data.GetConfig().Metrics.AllowedSources) > 0 {
+		remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(remoteIP)
+		allowed := false
+		for _, ipNet := range ParseTrustedProxies(strings.Join(data.GetConfig().Metrics.AllowedSources, ",")) {
+			if ip != nil && ipNet.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bufWriter := bufio.NewWriter(w)
+	writeMetrics(bufWriter)
+	_ = bufWriter.Flush()
+}
+
+// metricsWriter write the HELP and TYPE once for each metric family
+type metricsWriter struct {
+	*bufio.Writer
+}
+
+func (mw metricsWriter) family(name string, metricType string, help string) {
+	fmt.Fprintf(mw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func (mw metricsWriter) sample(name string, labels []string, value float64) {
+	mw.WriteString(name)
+	if len(labels) > 0 {
+		mw.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.WriteByte(',')
+			}
+			mw.WriteString(labels[i])
+			mw.WriteString(`="`)
+			mw.WriteString(escapeLabelValue(labels[i+1]))
+			mw.WriteByte('"')
+		}
+		mw.WriteByte('}')
+	}
+	mw.WriteByte(' ')
+	mw.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	mw.WriteByte('\n')
+}
+
+func (mw metricsWriter) histogram(name string, labels []string, h *histogram) {
+	cumulative := int64(0)
+	for i, bucket := range latencyBuckets {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		mw.sample(name+"_bucket", append(labels, "le", strconv.FormatFloat(bucket, 'g', -1, 64)), float64(cumulative))
+	}
+	count := atomic.LoadInt64(&h.count)
+	mw.sample(name+"_bucket", append(labels, "le", "+Inf"), float64(count))
+	mw.sample(name+"_sum", labels, float64(atomic.LoadInt64(&h.sum))/1e6)
+	mw.sample(name+"_count", labels, float64(count))
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// appLabels return the labels of application, the name is empty if the application has been deleted
+func appLabels(appID int64) []string {
+	appName := ""
+	if app, err := backend.GetApplicationByID(appID); err == nil {
+		appName = app.Name
+	}
+	return []string{"app_id", strconv.FormatInt(appID, 10), "app", appName}
+}
+
+// sortedKeys return the keys of labeled counters in stable order
+func sortedKeys(counters *sync.Map) []metricKey {
+	keys := []metricKey{}
+	counters.Range(func(key, value interface{}) bool {
+		keys = append(keys, key.(metricKey))
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].appID != keys[j].appID {
+			return keys[i].appID < keys[j].appID
+		}
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].value < keys[j].value
+	})
+	return keys
+}
+
+func loadCounter(counters *sync.Map, key metricKey) float64 {
+	counterI, _ := counters.Load(key)
+	return float64(atomic.LoadInt64(counterI.(*int64)))
+}
+
+func writeMetrics(w *bufio.Writer) {
+	mw := metricsWriter{w}
+	mw.family("janusec_info", "gauge", "Version and node role of Janusec Application Gateway.")
+	mw.sample("janusec_info", []string{"version", data.Version, "node_role", strings.ToLower(data.GetConfig().NodeRole)}, 1)
+	mw.family("janusec_start_time_seconds", "gauge", "Start time of the process since unix epoch in seconds.")
+	mw.sample("janusec_start_time_seconds", nil, float64(startTime))
+	mw.family("janusec_concurrent_requests", "gauge", "Requests in progress.")
+	mw.sample("janusec_concurrent_requests", nil, float64(concurrency))
+	mw.family("janusec_goroutines", "gauge", "Number of goroutines.")
+	mw.sample("janusec_goroutines", nil, float64(runtime.NumGoroutine()))
+
+	// Applications
+	appIDs := []int64{}
+	appMetricsMap.Range(func(key, value interface{}) bool {
+		appIDs = append(appIDs, key.(int64))
+		return true
+	})
+	sort.Slice(appIDs, func(i, j int) bool { return appIDs[i] < appIDs[j] })
+	mw.family("janusec_http_requests_total", "counter", "Requests of applications by status class.")
+	for _, appID := range appIDs {
+		metrics := getAppMetrics(appID)
+		labels := appLabels(appID)
+		for statusClass := 1; statusClass <= 5; statusClass++ {
+			mw.sample("janusec_http_requests_total", append(labels, "code", strconv.Itoa(statusClass)+"xx"), float64(atomic.LoadInt64(&metrics.requests[statusClass])))
+		}
+	}
+	mw.family("janusec_http_request_duration_seconds", "histogram", "Latency of requests from receiving to the completion of response.")
+	for _, appID := range appIDs {
+		mw.histogram("janusec_http_request_duration_seconds", appLabels(appID), getAppMetrics(appID).duration)
+	}
+	mw.family("janusec_upstream_response_duration_seconds", "histogram", "Latency of destinations until the response header.")
+	for _, appID := range appIDs {
+		mw.histogram("janusec_upstream_response_duration_seconds", appLabels(appID), getAppMetrics(appID).upstreamDuration)
+	}
+	mw.family("janusec_upstream_errors_total", "counter", "Failed requests to destinations, such as connection refused or timeout.")
+	for _, key := range sortedKeys(&upstreamErrors) {
+		mw.sample("janusec_upstream_errors_total", append(appLabels(key.appID), "destination", key.name), loadCounter(&upstreamErrors, key))
+	}
+
+	// Destinations
+	mw.family("janusec_destination_online", "gauge", "Online status of destinations, 1 for online.")
+	for _, app := range backend.Apps {
+		for _, dest := range app.Destinations {
+			online := 0.0
+			if dest.Online.Load() {
+				online = 1
+			}
+			mw.sample("janusec_destination_online", []string{"app_id", strconv.FormatInt(app.ID, 10), "app", app.Name, "route", dest.RequestRoute, "destination", dest.Destination}, online)
+		}
+	}
+	mw.family("janusec_destination_connections", "gauge", "In-flight requests of destinations.")
+	for _, app := range backend.Apps {
+		for _, dest := range app.Destinations {
+			mw.sample("janusec_destination_connections", []string{"app_id", strconv.FormatInt(app.ID, 10), "app", app.Name, "route", dest.RequestRoute, "destination", dest.Destination}, float64(atomic.LoadInt64(&dest.Connections)))
+		}
+	}
+
+	// Firewall
+	mw.family("janusec_waf_hits_total", "counter", "Requests and responses hit by group policies of WAF.")
+	for _, key := range sortedKeys(&wafHits) {
+		mw.sample("janusec_waf_hits_total", append(appLabels(key.appID), "policy_id", key.name, "vuln", key.value), loadCounter(&wafHits, key))
+	}
+	mw.family("janusec_cc_hits_total", "counter", "Requests hit by CC policies by action.")
+	for _, key := range sortedKeys(&ccHits) {
+		mw.sample("janusec_cc_hits_total", append(appLabels(key.appID), "action", key.name), loadCounter(&ccHits, key))
+	}
+	if blockedIPs, err := firewall.GetBlockedIPCount(); err == nil {
+		mw.family("janusec_nftables_blocked_ips", "gauge", "IP addresses in the block list of nftables.")
+		mw.sample("janusec_nftables_blocked_ips", nil, float64(blockedIPs))
+	}
+
+	// Cache
+	cacheStats, _ := GetCacheStats(&models.AuthUser{IsAppAdmin: true})
+	mw.family("janusec_cache_requests_total", "counter", "Requests to shared cache by result.")
+	for _, cacheStat := range cacheStats.Apps {
+		labels := appLabels(cacheStat.AppID)
+		mw.sample("janusec_cache_requests_total", append(labels, "result", "hit"), float64(cacheStat.Hits))
+		mw.sample("janusec_cache_requests_total", append(labels, "result", "miss"), float64(cacheStat.Misses))
+		mw.sample("janusec_cache_requests_total", append(labels, "result", "stale"), float64(cacheStat.Stale))
+	}
+	mw.family("janusec_cache_hit_ratio", "gauge", "Ratio of cache hits since start.")
+	for _, cacheStat := range cacheStats.Apps {
+		ratio := 0.0
+		if total := cacheStat.Hits + cacheStat.Misses + cacheStat.Stale; total > 0 {
+			ratio = float64(cacheStat.Hits+cacheStat.Stale) / float64(total)
+		}
+		mw.sample("janusec_cache_hit_ratio", appLabels(cacheStat.AppID), ratio)
+	}
+	mw.family("janusec_cache_size_bytes", "gauge", "Size of cached bodies on disk.")
+	mw.sample("janusec_cache_size_bytes", nil, float64(cacheStats.Size))
+	mw.family("janusec_cache_memory_size_bytes", "gauge", "Size of the in-memory tier of cache.")
+	mw.sample("janusec_cache_memory_size_bytes", nil, float64(cacheStats.MemorySize))
+
+	// Port forwarding
+	vipStats := backend.GetVipStats()
+	mw.family("janusec_vip_connections_total", "counter", "Accepted connections of port forwarding, or UDP sessions.")
+	for _, vipStat := range vipStats {
+		mw.sample("janusec_vip_connections_total", vipLabels(vipStat), float64(vipStat.Connections))
+	}
+	mw.family("janusec_vip_active_connections", "gauge", "Active TCP connections of port forwarding.")
+	for _, vipStat := range vipStats {
+		mw.sample("janusec_vip_active_connections", vipLabels(vipStat), float64(vipStat.ActiveConnections))
+	}
+	mw.family("janusec_vip_bytes_total", "counter", "Bytes of port forwarding, TCP streams are counted when each direction ends.")
+	for _, vipStat := range vipStats {
+		mw.sample("janusec_vip_bytes_total", append(vipLabels(vipStat), "direction", "in"), float64(vipStat.BytesIn))
+		mw.sample("janusec_vip_bytes_total", append(vipLabels(vipStat), "direction", "out"), float64(vipStat.BytesOut))
+	}
+
+	// Replica
+	if !data.IsPrimary {
+		mw.family("janusec_replica_sync_age_seconds", "gauge", "Seconds since the last successful sync with primary node.")
+		mw.sample("janusec_replica_sync_age_seconds", nil, float64(time.Now().Unix()-atomic.LoadInt64(&lastSyncTime)))
+	}
+}
+
+func vipLabels(vipStat *models.VipStat) []string {
+	protocol := "udp"
+	if vipStat.IsTCP {
+		protocol = "tcp"
+	}
+	return []string{"vip_app_id", strconv.FormatInt(vipStat.VipAppID, 10), "vip_app", vipStat.Name, "protocol", protocol}
+}
@@ -0,0 +1,180 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-10 21:03:27
+ * @Last Modified: U2, 2021-06-10 21:03:27
+ */
+
+package gateway
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"janusec/data"
+	"janusec/utils"
+)
+
+// otlpBatchSize is the max count of spans in each export request
+const otlpBatchSize = 512
+
+var (
+	spanQueue    = make(chan *traceSpan, 8192)
+	droppedSpans int64
+	otlpClient   = &http.Client{Timeout: 10 * time.Second}
+)
+
+// OTLP/HTTP JSON encoding of ExportTraceServiceRequest, IDs are hex and int64 values are strings
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue,omitempty"`
+	IntValue    string `json:"intValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// queueSpan without blocking the request, spans are dropped if the collector falls behind
+func queueSpan(span *traceSpan) {
+	select {
+	case spanQueue <- span:
+	default:
+		if dropped := atomic.AddInt64(&droppedSpans, 1); dropped%1000 == 1 {
+			utils.DebugPrintln("queueSpan queue is full, dropped", dropped)
+		}
+	}
+}
+
+// TraceExportTick send the spans to OTLP endpoint in batches, every 5 seconds or when the batch is full
+func TraceExportTick() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	batch := make([]*traceSpan, 0, otlpBatchSize)
+	for {
+		select {
+		case span := <-spanQueue:
+			batch = append(batch, span)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		exportSpans(batch)
+		batch = batch[:0]
+	}
+}
+
+func exportSpans(spans []*traceSpan) {
+	cfg := data.GetConfig().Tracing
+	if len(cfg.OTLPEndpoint) == 0 {
+		return
+	}
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		otlpSpans = append(otlpSpans, span.toOTLP())
+	}
+	exportRequest := &otlpTraceRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpKeyValue{
+				{Key: "service.name", Value: otlpAnyValue{StringValue: cfg.ServiceName}},
+				{Key: "service.version", Value: otlpAnyValue{StringValue: data.Version}},
+			}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "janusec/gateway", Version: data.Version},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(exportRequest)
+	if err != nil {
+		utils.DebugPrintln("exportSpans json.Marshal", err)
+		return
+	}
+	req, err := http.NewRequest("POST", cfg.OTLPEndpoint, bytes.NewReader(body))
+	if err != nil {
+		utils.DebugPrintln("exportSpans NewRequest", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range cfg.OTLPHeaders {
+		req.Header.Set(name, value)
+	}
+	resp, err := otlpClient.Do(req)
+	if err != nil {
+		utils.DebugPrintln("exportSpans", err)
+		return
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		utils.DebugPrintln("exportSpans", cfg.OTLPEndpoint, resp.Status)
+	}
+}
+
+func (span *traceSpan) toOTLP() otlpSpan {
+	otlp := otlpSpan{
+		TraceID:           hex.EncodeToString(span.traceID[:]),
+		SpanID:            hex.EncodeToString(span.spanID[:]),
+		Name:              span.name,
+		Kind:              span.kind,
+		StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		Attributes:        span.attributes,
+		Status:            otlpStatus{Code: span.status, Message: span.message},
+	}
+	if span.parentID != [8]byte{} {
+		otlp.ParentSpanID = hex.EncodeToString(span.parentID[:])
+	}
+	return otlp
+}
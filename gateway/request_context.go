@@ -0,0 +1,65 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-26 22:20:31
+ * @Last Modified: U2, 2021-05-26 22:20:31
+ */
+
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"janusec/backend"
+	"janusec/models"
+)
+
+type requestContextKey struct{}
+
+// RequestContext is the state of a request shared by ReverseHandlerFunc and rewriteResponse, v1.2.4
+type RequestContext struct {
+	// OriginPath is the path requested by client, before replaced with the backend route
+	OriginPath string
+
+	// CacheRule is the first cache rule matched by the request, nil if none
+	CacheRule *models.CacheRule
+	// CacheBypass is true if the request matched the bypass conditions of CacheRule
+	CacheBypass bool
+	// CacheKey and CacheRuleKey are computed before the request is modified for the backend
+	CacheKey     string
+	CacheRuleKey string
+
+	// Mirror is the copy sent to the shadow destination, nil if not mirrored
+	Mirror *backend.Mirror
+
+	// RequestID is the X-Request-ID of request and response, v1.2.4
+	RequestID string
+	// Trace of W3C traceparent, nil if tracing is disabled
+	Trace *requestTrace
+
+	// StartTime and the fields below are written to access log after the response completes
+	StartTime time.Time
+	AppID     int64
+	Dest      *models.Destination
+	// UpstreamStart is the time of forwarding to destination, and UpstreamLatency until the response header
+	UpstreamStart   time.Time
+	UpstreamLatency time.Duration
+	// Verdict of WAF or CC, empty if passed
+	Verdict  string
+	AuthUser string
+}
+
+// withRequestContext bind the state to the request
+func withRequestContext(r *http.Request, reqCtx *RequestContext) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestContextKey{}, reqCtx))
+}
+
+// GetRequestContext return an empty state if not bound
+func GetRequestContext(r *http.Request) *RequestContext {
+	if reqCtx, ok := r.Context().Value(requestContextKey{}).(*RequestContext); ok {
+		return reqCtx
+	}
+	return &RequestContext{OriginPath: r.URL.Path}
+}
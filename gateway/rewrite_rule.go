@@ -0,0 +1,88 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-28 22:03:17
+ * @Last Modified: U2, 2021-05-28 22:03:17
+ */
+
+package gateway
+
+import (
+	"net/http"
+	"strings"
+
+	"janusec/models"
+)
+
+// ApplyRewriteRules apply the first matched rewrite rule,
+// return true if the request has been redirected, otherwise the URL may be rewritten and the request continues
+func ApplyRewriteRules(w http.ResponseWriter, r *http.Request, app *models.Application, domainStr string) bool {
+	for _, rule := range app.RewriteRules {
+		if len(rule.Domain) > 0 && !strings.EqualFold(rule.Domain, domainStr) {
+			continue
+		}
+		re := getCachedRegexp(rule.Pattern)
+		if re == nil {
+			continue
+		}
+		path := r.URL.Path
+		match := re.FindStringSubmatchIndex(path)
+		if match == nil {
+			continue
+		}
+		target := string(re.ExpandString(nil, rule.Target, path, match))
+		targetPath, query := target, ""
+		if index := strings.IndexByte(target, '?'); index >= 0 {
+			targetPath, query = target[:index], target[index+1:]
+		}
+		targetPath, query = preserveRequestURL(r, targetPath, query, rule.PreservePath, rule.PreserveQuery)
+		if rule.Type == models.RewriteRedirect {
+			location := targetPath
+			if len(query) > 0 {
+				location += "?" + query
+			}
+			http.Redirect(w, r, location, int(rule.StatusCode))
+			return true
+		}
+		// Internal rewrite, the target is a path
+		if !strings.HasPrefix(targetPath, "/") {
+			targetPath = "/" + targetPath
+		}
+		r.URL.Path = targetPath
+		r.URL.RawPath = ""
+		r.URL.RawQuery = query
+		return false
+	}
+	return false
+}
+
+// preserveRequestURL append the original path to target path, and the original query string to query if required
+func preserveRequestURL(r *http.Request, targetPath string, query string, preservePath bool, preserveQuery bool) (string, string) {
+	if preservePath {
+		targetPath = strings.TrimSuffix(targetPath, "/") + r.URL.Path
+	}
+	if preserveQuery && len(r.URL.RawQuery) > 0 {
+		if len(query) > 0 {
+			query += "&"
+		}
+		query += r.URL.RawQuery
+	}
+	return targetPath, query
+}
+
+// RedirectDomain redirect the whole domain to its Location, v1.2.4
+func RedirectDomain(w http.ResponseWriter, r *http.Request, domain *models.Domain) {
+	location, query := domain.Location, ""
+	if index := strings.IndexByte(location, '?'); index >= 0 {
+		location, query = location[:index], location[index+1:]
+	}
+	location, query = preserveRequestURL(r, location, query, domain.PreservePath, domain.PreserveQuery)
+	if len(query) > 0 {
+		location += "?" + query
+	}
+	statusCode := int(domain.RedirectStatus)
+	if statusCode == 0 {
+		statusCode = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, location, statusCode)
+}
@@ -8,6 +8,7 @@
 package gateway
 
 import (
+	"sync/atomic"
 	"time"
 
 	"janusec/backend"
@@ -19,6 +20,9 @@ import (
 var (
 	// syncTicker used for check update from primary node
 	syncTicker *time.Ticker
+
+	// lastSyncTime is the unix time of last successful sync, used by metrics, v1.2.4
+	lastSyncTime = time.Now().Unix()
 )
 
 // SyncTimeTick let replica nodes get/sync NodeSettings from Primary Node
@@ -30,7 +34,13 @@ func SyncTimeTick() {
 		lastBackendModified := data.NodeSetting.BackendLastModified
 		lastFirewallModified := data.NodeSetting.FirewallLastModified
 		lastSyncInterval := data.NodeSetting.SyncInterval
-		data.NodeSetting = data.RPCGetNodeSetting()
+		nodeSetting := data.RPCGetNodeSetting()
+		if nodeSetting == nil {
+			// Primary node unreachable, keep current settings and retry at next tick
+			continue
+		}
+		data.NodeSetting = nodeSetting
+		atomic.StoreInt64(&lastSyncTime, time.Now().Unix())
 		// Check update
 		if lastBackendModified < data.NodeSetting.BackendLastModified {
 			go backend.LoadAppConfiguration()
@@ -38,6 +48,8 @@ func SyncTimeTick() {
 		if lastFirewallModified < data.NodeSetting.FirewallLastModified {
 			go firewall.InitFirewall()
 		}
+		// Cache purges are replayed in order, and retried at next sync if failed, v1.2.4
+		go SyncCachePurges()
 		if lastSyncInterval != data.NodeSetting.SyncInterval {
 			syncTicker.Stop()
 			syncTicker = time.NewTicker(data.NodeSetting.SyncInterval)
@@ -0,0 +1,263 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-10 20:16:42
+ * @Last Modified: U2, 2021-06-10 20:16:42
+ */
+
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"janusec/data"
+	"janusec/models"
+)
+
+// maxRequestIDLength of X-Request-ID accepted from client
+const maxRequestIDLength = 128
+
+// Span kinds of OpenTelemetry
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// spanStatusError is the status code of failed span, 0 means unset
+const spanStatusError = 2
+
+// getRequestID accept the X-Request-ID from client if it is valid, or generate a new one
+func getRequestID(r *http.Request) string {
+	if !data.GetConfig().Tracing.RegenerateRequestID {
+		if requestID := r.Header.Get("X-Request-ID"); isValidRequestID(requestID) {
+			return requestID
+		}
+	}
+	return newRandomHex(16)
+}
+
+// isValidRequestID limit the characters, as the ID is shown on pages and written to logs
+func isValidRequestID(requestID string) bool {
+	if len(requestID) == 0 || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case strings.ContainsRune("-_.:+/=", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRandomHex(size int) string {
+	buf := make([]byte, size)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// requestTrace is the W3C trace context of a request, spans are recorded only if sampled,
+// all of them are written by the goroutine of handler
+type requestTrace struct {
+	traceID [16]byte
+	// parentID is the span of caller in traceparent, zero if the trace starts at gateway
+	parentID [8]byte
+	// spanID of the server span of gateway, which is the parent of the phases
+	spanID   [8]byte
+	sampled  bool
+	spans    []*traceSpan
+	upstream *traceSpan
+}
+
+// traceSpan is a phase of request, such as cc, waf_request or upstream
+type traceSpan struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes []otlpKeyValue
+	status     int
+	message    string
+}
+
+// newRequestTrace continue the trace of traceparent, or start a new one, nil if tracing is disabled
+func newRequestTrace(r *http.Request) *requestTrace {
+	cfg := data.GetConfig().Tracing
+	if !cfg.Enabled {
+		return nil
+	}
+	trace := &requestTrace{}
+	if traceID, parentID, flags, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		trace.traceID = traceID
+		trace.parentID = parentID
+		trace.sampled = flags&1 == 1
+	} else {
+		_, _ = rand.Read(trace.traceID[:])
+		trace.sampled = mathrand.Float64() < cfg.SampleRatio
+	}
+	_, _ = rand.Read(trace.spanID[:])
+	return trace
+}
+
+// parseTraceparent parse version-traceid-parentid-flags, future versions may append fields
+func parseTraceparent(value string) (traceID [16]byte, parentID [8]byte, flags byte, ok bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return
+	}
+	version := value[:2]
+	if version == "ff" || (version == "00" && len(value) != 55) || (len(value) > 55 && value[55] != '-') {
+		return
+	}
+	var versionBytes, flagBytes [1]byte
+	if _, err := hex.Decode(versionBytes[:], []byte(version)); err != nil {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(value[3:35])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(parentID[:], []byte(value[36:52])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(flagBytes[:], []byte(value[53:55])); err != nil {
+		return
+	}
+	if traceID == [16]byte{} || parentID == [8]byte{} {
+		return
+	}
+	return traceID, parentID, flagBytes[0], true
+}
+
+func formatTraceparent(traceID [16]byte, spanID [8]byte, sampled bool) string {
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(traceID[:]) + "-" + hex.EncodeToString(spanID[:]) + "-" + flags
+}
+
+// TraceID return the hex trace ID, empty if tracing is disabled
+func (trace *requestTrace) TraceID() string {
+	if trace == nil {
+		return ""
+	}
+	return hex.EncodeToString(trace.traceID[:])
+}
+
+// StartSpan begin a phase under the server span, nil if not sampled, the span is ended when the request completes if End is not called
+func (trace *requestTrace) StartSpan(name string) *traceSpan {
+	if trace == nil || !trace.sampled {
+		return nil
+	}
+	span := &traceSpan{
+		traceID:  trace.traceID,
+		parentID: trace.spanID,
+		name:     name,
+		kind:     spanKindInternal,
+		start:    time.Now(),
+	}
+	_, _ = rand.Read(span.spanID[:])
+	trace.spans = append(trace.spans, span)
+	return span
+}
+
+// injectTraceparent start the upstream span and send it as the parent to destination
+func (trace *requestTrace) injectTraceparent(r *http.Request, dest *models.Destination) {
+	if trace == nil {
+		return
+	}
+	var spanID [8]byte
+	if span := trace.StartSpan("upstream"); span != nil {
+		span.kind = spanKindClient
+		span.SetAttribute("server.address", dest.Destination)
+		trace.upstream = span
+		spanID = span.spanID
+	} else {
+		_, _ = rand.Read(spanID[:])
+	}
+	r.Header.Set("traceparent", formatTraceparent(trace.traceID, spanID, trace.sampled))
+}
+
+// setUpstreamError mark the upstream span failed, such as connection refused
+func (trace *requestTrace) setUpstreamError(err error) {
+	if trace == nil || trace.upstream == nil {
+		return
+	}
+	trace.upstream.status = spanStatusError
+	trace.upstream.message = err.Error()
+}
+
+// finish create the server span with the access log record, end the open spans and queue them for export
+func (trace *requestTrace) finish(reqCtx *RequestContext, record *models.AccessLogRecord) {
+	if trace == nil || !trace.sampled || len(data.GetConfig().Tracing.OTLPEndpoint) == 0 {
+		return
+	}
+	now := time.Now()
+	if span := trace.upstream; span != nil && span.end.IsZero() && reqCtx.UpstreamLatency > 0 {
+		span.end = span.start.Add(reqCtx.UpstreamLatency)
+	}
+	server := &traceSpan{
+		traceID:  trace.traceID,
+		spanID:   trace.spanID,
+		parentID: trace.parentID,
+		name:     record.Method,
+		kind:     spanKindServer,
+		start:    reqCtx.StartTime,
+		end:      now,
+	}
+	server.SetAttribute("http.request.method", record.Method)
+	server.SetAttribute("url.path", reqCtx.OriginPath)
+	server.SetAttribute("server.address", record.Domain)
+	server.SetAttribute("client.address", record.ClientIP)
+	server.SetAttribute("user_agent.original", record.UserAgent)
+	server.SetIntAttribute("http.response.status_code", int64(record.Status))
+	server.SetAttribute("janusec.request_id", record.RequestID)
+	server.SetIntAttribute("janusec.app_id", record.AppID)
+	server.SetAttribute("janusec.verdict", record.Verdict)
+	server.SetAttribute("janusec.destination", record.Destination)
+	if record.Status >= 500 {
+		server.status = spanStatusError
+	}
+	queueSpan(server)
+	for _, span := range trace.spans {
+		if span.end.IsZero() {
+			span.end = now
+		}
+		queueSpan(span)
+	}
+}
+
+// End the span, nil is allowed when not sampled
+func (span *traceSpan) End() {
+	if span != nil && span.end.IsZero() {
+		span.end = time.Now()
+	}
+}
+
+// SetAttribute add a string attribute, empty value is omitted
+func (span *traceSpan) SetAttribute(key string, value string) {
+	if span == nil || len(value) == 0 {
+		return
+	}
+	span.attributes = append(span.attributes, otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: value}})
+}
+
+// SetIntAttribute add an integer attribute
+func (span *traceSpan) SetIntAttribute(key string, value int64) {
+	if span == nil {
+		return
+	}
+	span.attributes = append(span.attributes, otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: strconv.FormatInt(value, 10)}})
+}
@@ -0,0 +1,101 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-23 10:18:37
+ * @Last Modified: U2, 2021-05-23 10:18:37
+ */
+
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+)
+
+// trustedNets map[cidrList string][]*net.IPNet, cache of parsed trusted proxies
+var trustedNets = sync.Map{}
+
+// ParseTrustedProxies parse CIDR list separated by comma or new line, single IP is also supported
+func ParseTrustedProxies(cidrList string) []*net.IPNet {
+	if netsI, ok := trustedNets.Load(cidrList); ok {
+		return netsI.([]*net.IPNet)
+	}
+	nets := []*net.IPNet{}
+	items := strings.FieldsFunc(cidrList, func(c rune) bool {
+		return c == ',' || c == '\n' || c == '\r' || c == ' '
+	})
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil {
+				if ip.To4() != nil {
+					item += "/32"
+				} else {
+					item += "/128"
+				}
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			utils.DebugPrintln("ParseTrustedProxies invalid CIDR", item)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	trustedNets.Store(cidrList, nets)
+	return nets
+}
+
+// IsTrustedProxy check the IP address with global and application trusted proxies
+func IsTrustedProxy(ipStr string, app *models.Application) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range ParseTrustedProxies(data.NodeSetting.TrustedProxies) {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	for _, ipNet := range ParseTrustedProxies(app.TrustedProxies) {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNetworkBlockable return true if the client IP is the TCP peer and not a trusted proxy,
+// otherwise it is blocked at L7 only, so that a CDN or load balancer is never dropped by nftables
+func isNetworkBlockable(r *http.Request, app *models.Application, srcIP string) bool {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return srcIP == remoteIP && !IsTrustedProxy(srcIP, app)
+}
+
+// getForwardedIP walk X-Forwarded-For from right to left, skip trusted proxies,
+// and return the first untrusted address, or the leftmost address if all are trusted
+func getForwardedIP(r *http.Request, app *models.Application, remoteIP string) string {
+	hops := []string{}
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	clientIP := remoteIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			// invalid address, stop at the last valid hop
+			break
+		}
+		clientIP = hops[i]
+		if !IsTrustedProxy(clientIP, app) {
+			break
+		}
+	}
+	return clientIP
+}
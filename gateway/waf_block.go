@@ -79,6 +79,7 @@ body {
 <h1 class="text-logo">JANUSEC</h1>
 <hr>
 Reason: {{.VulnName}}, Policy ID: {{.PolicyID}}, by Janusec Application Gateway
+{{ if .RequestID }}<br>Request ID: {{.RequestID}}{{ end }}
 </div>
 </body>
 </html>
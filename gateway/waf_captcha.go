@@ -34,6 +34,9 @@ func ShowCaptchaHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	go ClearExpiredCapthchaHitInfo()
 	id := r.FormValue("id")
 	captchaContext := models.CaptchaContext{CaptchaId: captcha.New(), ClientID: id}
+	if mapHitInfo, ok := captchaHitInfo.Load(id); ok {
+		captchaContext.RequestID = mapHitInfo.(*models.HitInfo).RequestID
+	}
 	if err := formTemplate.Execute(w, &captchaContext); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -152,6 +155,7 @@ div {
 </form>
 <a href="#zh">中文</a>
 <a href="#en">English</a>
+{{if .RequestID}}<p style="font-size: 12px; color: #888;">Request ID: {{.RequestID}}</p>{{end}}
 <div>
 
 <script>
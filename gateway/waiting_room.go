@@ -0,0 +1,192 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-12 21:46:05
+ * @Last Modified: U2, 2021-06-12 21:46:05
+ */
+
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"janusec/backend"
+	"janusec/data"
+	"janusec/models"
+	"janusec/utils"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// queueTicketCookie is the signed queue ticket: appID.ticketID.signature
+const queueTicketCookie = "janusec-queue"
+
+var (
+	tmplWaitingRoom *template.Template
+	// admittedTickets avoid asking the primary node for each request of admitted visitors,
+	// the sessions are renewed on primary node when the cache expires
+	admittedTickets = cache.New(10*time.Second, 60*time.Second)
+	ticketKey       []byte
+	ticketKeyOnce   sync.Once
+)
+
+// getTicketKey derive the signing key from the nodes key, so that the tickets are valid on all nodes
+func getTicketKey() []byte {
+	ticketKeyOnce.Do(func() {
+		nodesKey := data.NodeKey
+		if data.IsPrimary {
+			nodesKey = data.NodesKey
+		}
+		mac := hmac.New(sha256.New, nodesKey)
+		mac.Write([]byte("janusec-waiting-room"))
+		ticketKey = mac.Sum(nil)
+	})
+	return ticketKey
+}
+
+func signQueueTicket(appID int64, ticketID string) string {
+	mac := hmac.New(sha256.New, getTicketKey())
+	mac.Write([]byte(strconv.FormatInt(appID, 10) + "." + ticketID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getQueueTicket return the ticket ID if the cookie is issued for the application and the signature is valid
+func getQueueTicket(r *http.Request, appID int64) (string, bool) {
+	cookie, err := r.Cookie(queueTicketCookie)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || parts[0] != strconv.FormatInt(appID, 10) || len(parts[1]) == 0 {
+		return "", false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(signQueueTicket(appID, parts[1]))) {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// CheckWaitingRoom return true if the visitor is admitted, or the position page has been responded, v1.2.4
+// The visitor is admitted if the primary node is unreachable, CC and concurrency limit still work
+func CheckWaitingRoom(w http.ResponseWriter, r *http.Request, app *models.Application, reqCtx *RequestContext) bool {
+	ticketID, ok := getQueueTicket(r, app.ID)
+	if !ok {
+		ticketID = newRandomHex(16)
+		http.SetCookie(w, &http.Cookie{
+			Name:     queueTicketCookie,
+			Value:    strconv.FormatInt(app.ID, 10) + "." + ticketID + "." + signQueueTicket(app.ID, ticketID),
+			Path:     "/",
+			Secure:   r.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	cacheKey := strconv.FormatInt(app.ID, 10) + "." + ticketID
+	if _, found := admittedTickets.Get(cacheKey); found {
+		return true
+	}
+	status, err := backend.CheckWaitingRoom(app.ID, ticketID)
+	if err != nil {
+		utils.DebugPrintln("CheckWaitingRoom", app.ID, err)
+		return true
+	}
+	if status.Admitted {
+		admittedTickets.Set(cacheKey, true, cache.DefaultExpiration)
+		return true
+	}
+	reqCtx.Verdict = verdictWaitingRoom
+	GenerateWaitingRoomPage(w, status, reqCtx.RequestID)
+	return false
+}
+
+// GenerateWaitingRoomPage show the position in queue, and refresh automatically
+func GenerateWaitingRoomPage(w http.ResponseWriter, status *models.WaitingRoomStatus, requestID string) {
+	if tmplWaitingRoom == nil {
+		tmplWaitingRoom, _ = template.New("waitingRoom").Parse(waitingRoomHTML)
+	}
+	refreshInterval := status.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = 10
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.FormatInt(refreshInterval, 10))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	err := tmplWaitingRoom.Execute(w, models.WaitingRoomInfo{
+		Position:        status.Position,
+		RefreshInterval: refreshInterval,
+		RequestID:       requestID,
+	})
+	if err != nil {
+		utils.DebugPrintln("GenerateWaitingRoomPage tmpl.Execute error", err)
+	}
+}
+
+const waitingRoomHTML = `<!DOCTYPE html>
+<html>
+<head>
+<title>Waiting Room</title>
+</head>
+<style>
+body {
+    font-family: Arial, Helvetica, sans-serif;
+    text-align: center;
+}
+
+.text-logo {
+    display: block;
+	width: 260px;
+    font-size: 48px;
+    background-color: #F9F9F9;
+    color: #f5f5f5;
+    text-decoration: none;
+    text-shadow: 2px 2px 4px #000000;
+    box-shadow: 2px 2px 3px #D5D5D5;
+    padding: 15px;
+    margin: auto;
+}
+
+.block_div {
+    padding: 10px;
+    width: 70%;
+    margin: auto;
+}
+
+</style>
+<body>
+<div class="block_div">
+<h1 class="text-logo">JANUSEC</h1>
+<hr>
+<p>
+There are too many visitors at the moment, you are in the waiting room.
+</p>
+<p>
+Your position in line: <strong>{{ .Position }}</strong>
+</p>
+<p>
+Please keep this page open, it will refresh in <span id="countdown">{{ .RefreshInterval }}</span> seconds ...
+</p>
+{{ if .RequestID }}<p>Request ID: {{ .RequestID }}</p>{{ end }}
+</div>
+<script>
+var t={{ .RefreshInterval }};
+var countdown=setInterval(function(){
+	t--;
+	document.getElementById("countdown").innerHTML=t;
+	if(t<=0) {
+		clearInterval(countdown);
+		window.location.replace(window.location.pathname + window.location.search);
+	}
+}, 1000);
+</script>
+</body>
+</html>
+`
@@ -13,14 +13,16 @@ import (
 	"encoding/gob"
 	"flag"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	// _ "net/http/pprof"
 	"janusec/backend"
@@ -58,8 +60,13 @@ func main() {
 		utils.DebugPrintln("Warning: Janusec is running in Debug mode.")
 	}
 
+	// Listeners handed over by the old process, v1.2.4
+	backend.InitInheritedListeners()
+
 	//初始化Mysql
 	data.InitConfig()
+	// Buffered asynchronous access log, v1.2.4
+	utils.InitAccessLog(data.GetConfig().AccessLog)
 	if data.IsPrimary {
 		//db 表结构初始化（如果是主库）
 		backend.InitDatabase()
@@ -71,12 +78,24 @@ func main() {
 	if !data.IsPrimary {
 		go gateway.SyncTimeTick()
 	}
+	go backend.HealthCheckTick()
+	go backend.OCSPStaplingTick()
 	go gateway.InitAccessStat()
+	// Shared cache, expired entries are removed by CacheCleanTick, v1.2.4
+	go func() {
+		gateway.InitCache()
+		gateway.CacheCleanTick()
+	}()
 	go gateway.Counter()
+	// Spans are exported to the OTLP endpoint of config.json if tracing enabled, v1.2.4
+	go gateway.TraceExportTick()
 
 	//开启子协程，处理每日定时任务
 	go gateway.DailyRoutineTasks()
 
+	// Graceful shutdown, reload and handover, v1.2.4
+	go HandleSignals()
+
 	tlsconfig := &tls.Config{
 		GetCertificate: func(helloInfo *tls.ClientHelloInfo) (*tls.Certificate, error) {
 			cert, err := backend.GetCertificateByDomain(helloInfo)
@@ -101,24 +120,28 @@ func main() {
 			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
 		},
 	}
+	// TLS profiles of domains and client certificates for applications with mutual TLS, v1.2.4
+	tlsconfig.GetConfigForClient = func(helloInfo *tls.ClientHelloInfo) (*tls.Config, error) {
+		return backend.GetTLSConfigForClient(tlsconfig, helloInfo)
+	}
 	gateMux := http.NewServeMux()
 	if data.IsPrimary {
-		admin := data.CFG.PrimaryNode.Admin
+		admin := data.GetConfig().PrimaryNode.Admin
 		if admin.Listen {
 			adminMux := http.NewServeMux()
 			//加载管理API
 			LoadAPIRoute(adminMux)
 			if len(admin.ListenHTTP) > 0 {
 				go func() {
-					listen, err := net.Listen("tcp", admin.ListenHTTP)
+					listen, err := backend.Listen("tcp", admin.ListenHTTP)
 					if err != nil {
 						utils.CheckError("Admin Port occupied.", err)
 						utils.DebugPrintln("Admin Port occupied.", err)
 						os.Exit(1)
 					}
 					utils.DebugPrintln("Admin Listen HTTP ", admin.ListenHTTP)
-					err = http.Serve(listen, adminMux)
-					if err != nil {
+					err = newServer(adminMux).Serve(listen)
+					if err != nil && err != http.ErrServerClosed {
 						utils.CheckError("http.Serve adminMux error", err)
 						utils.DebugPrintln("http.Serve adminMux error", err)
 						os.Exit(1)
@@ -128,15 +151,16 @@ func main() {
 			}
 			if len(admin.ListenHTTPS) > 0 {
 				go func() {
-					listen, err := tls.Listen("tcp", admin.ListenHTTPS, tlsconfig)
+					listen, err := backend.Listen("tcp", admin.ListenHTTPS)
 					if err != nil {
 						utils.CheckError("Admin Port occupied.", err)
 						utils.DebugPrintln("Admin Port occupied.", err)
 						os.Exit(1)
 					}
+					listen = tls.NewListener(listen, tlsconfig)
 					utils.DebugPrintln("Admin Listen HTTPS", admin.ListenHTTPS)
-					err = http.Serve(listen, adminMux)
-					if err != nil {
+					err = newServer(adminMux).Serve(listen)
+					if err != nil && err != http.ErrServerClosed {
 						utils.CheckError("http.Serve adminMux error", err)
 						utils.DebugPrintln("http.Serve adminMux error", err)
 						os.Exit(1)
@@ -175,6 +199,22 @@ func main() {
 	// Test only
 	// gateMux.HandleFunc("/.auth/test", gateway.Test)
 
+	// Prometheus metrics on a separate listener, v1.2.4
+	if len(data.GetConfig().Metrics.Listen) > 0 {
+		go func() {
+			listen, err := backend.Listen("tcp", data.GetConfig().Metrics.Listen)
+			if err != nil {
+				utils.DebugPrintln("Metrics Port occupied.", err)
+				return
+			}
+			utils.DebugPrintln("Metrics Listen HTTP", data.GetConfig().Metrics.Listen)
+			err = newServer(http.HandlerFunc(gateway.MetricsHandlerFunc)).Serve(listen)
+			if err != nil && err != http.ErrServerClosed {
+				utils.DebugPrintln("http.Serve metrics error", err)
+			}
+		}()
+	}
+
 	// 默认路由（没有命中上面的路由）会走到此，gateway.ReverseHandlerFunc
 	// Reverse Proxy
 	gateMux.HandleFunc("/", gateway.ReverseHandlerFunc)
@@ -182,41 +222,54 @@ func main() {
 	//重要，为gateMux增加ctx支持
 	ctxGateMux := AddContextHandler(gateMux)
 	go func(listenPort string) {
-		listen, err := net.Listen("tcp", listenPort)
+		listen, err := backend.Listen("tcp", listenPort)
 		if err != nil {
 			msg := "Port " + listenPort + " is occupied."
 			utils.CheckError(msg, err)
 			utils.DebugPrintln(msg, err)
 			os.Exit(1)
 		}
+		if data.GetConfig().ProxyProtocol.Enabled {
+			listen = backend.NewProxyProtocolListener(listen)
+		}
 		utils.DebugPrintln("Listen HTTP ", listenPort)
 		// err = http.Serve(listen, ctxGateMux)
-		err = http.Serve(listen, backend.AcmeCertManager.HTTPHandler(ctxGateMux))
-		if err != nil {
+		err = newServer(backend.AcmeCertManager.HTTPHandler(ctxGateMux)).Serve(listen)
+		if err != nil && err != http.ErrServerClosed {
 			utils.CheckError("http.Serve error", err)
 			utils.DebugPrintln("http.Serve error", err)
 			os.Exit(1)
 		}
 		defer listen.Close()
-	}(data.CFG.ListenHTTP)
-	listen, err := tls.Listen("tcp", data.CFG.ListenHTTPS, tlsconfig)
+	}(data.GetConfig().ListenHTTP)
+	listen, err := backend.Listen("tcp", data.GetConfig().ListenHTTPS)
 	if err != nil {
-		msg := "Port " + data.CFG.ListenHTTPS + " is occupied."
+		msg := "Port " + data.GetConfig().ListenHTTPS + " is occupied."
 		utils.CheckError(msg, err)
 		utils.DebugPrintln(msg, err)
 		os.Exit(1)
 	}
-	utils.DebugPrintln("Listen HTTPS", data.CFG.ListenHTTPS)
+	// PROXY protocol header is before TLS handshake, v1.2.4
+	if data.GetConfig().ProxyProtocol.Enabled {
+		listen = backend.NewProxyProtocolListener(listen)
+	}
+	listen = tls.NewListener(listen, tlsconfig)
+	utils.DebugPrintln("Listen HTTPS", data.GetConfig().ListenHTTPS)
 	//err = http.Serve(listen, ctxGateMux)
 
+	// Tell systemd and the old process that listeners are ready, v1.2.4
+	backend.NotifyReady()
+
 	//利用acme实现证书自动更新
-	err = http.Serve(listen, backend.AcmeCertManager.HTTPHandler(ctxGateMux))
-	if err != nil {
+	err = newServer(backend.AcmeCertManager.HTTPHandler(ctxGateMux)).Serve(listen)
+	if err != nil && err != http.ErrServerClosed {
 		utils.CheckError("http.Serve error", err)
 		utils.DebugPrintln("http.Serve error", err)
 		os.Exit(1)
 	}
 	defer listen.Close()
+	// Waiting for GracefulShutdown to exit
+	select {}
 }
 
 // AddContextHandler to add context handler
@@ -229,15 +282,125 @@ func AddContextHandler(next http.Handler) http.Handler {
 	})
 }
 
+// AddBodyLimitHandler limit the request body of admin API with max_body_size in config.json, v1.2.4
+func AddBodyLimitHandler(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, data.GetConfig().Server.MaxBodySize)
+		next.ServeHTTP(w, r)
+	})
+}
+
 // LoadAPIRoute set HandleFunc
 func LoadAPIRoute(mux *http.ServeMux) {
-	mux.HandleFunc("/janusec-admin/api", gateway.ReplicaAPIHandlerFunc)
-	mux.HandleFunc("/janusec-admin/ui-api", gateway.AdminAPIHandlerFunc)
+	mux.Handle("/janusec-admin/api", AddBodyLimitHandler(gateway.ReplicaAPIHandlerFunc))
+	mux.Handle("/janusec-admin/ui-api", AddBodyLimitHandler(gateway.AdminAPIHandlerFunc))
 	mux.HandleFunc("/janusec-admin/webssh", gateway.WebSSHHandlerFunc)
 	mux.HandleFunc("/janusec-admin/oauth/info", gateway.OAuthGetHandleFunc)
 	mux.HandleFunc("/janusec-admin/", gateway.AdminHandlerFunc) //默认路由
 }
 
+var (
+	// servers of gateway and admin, used for graceful shutdown
+	servers      = []*http.Server{}
+	serversMutex sync.Mutex
+
+	// activeRequests include the hijacked connections such as WebSocket, which are not tracked by http.Server
+	activeRequests int64
+)
+
+// newServer create http.Server with the timeouts and limits in config.json, v1.2.4
+func newServer(handler http.Handler) *http.Server {
+	cfg := data.GetConfig().Server
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&activeRequests, 1)
+			defer atomic.AddInt64(&activeRequests, -1)
+			handler.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(cfg.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	serversMutex.Lock()
+	servers = append(servers, server)
+	serversMutex.Unlock()
+	return server
+}
+
+// HandleSignals SIGTERM/SIGINT for graceful shutdown, SIGHUP for reloading config.json,
+// SIGUSR2 for starting the new (upgraded) process with current listeners, v1.2.4
+func HandleSignals() {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR2)
+	for sig := range signalChan {
+		utils.DebugPrintln("Received signal", sig)
+		switch sig {
+		case syscall.SIGHUP:
+			ReloadConfiguration()
+		case syscall.SIGUSR2:
+			err := backend.StartNewProcess()
+			if err != nil {
+				utils.DebugPrintln("StartNewProcess error", err)
+			}
+		default:
+			GracefulShutdown()
+		}
+	}
+}
+
+// ReloadConfiguration reload config.json, applications and firewall policies
+func ReloadConfiguration() {
+	err := data.ReloadConfig()
+	if err != nil {
+		utils.DebugPrintln("ReloadConfig error", err)
+		return
+	}
+	backend.LoadAppConfiguration()
+	firewall.InitFirewall()
+	utils.ReloadAccessLog(data.GetConfig().AccessLog)
+	utils.DebugPrintln("ReloadConfiguration completed")
+}
+
+// GracefulShutdown stop accepting new connections, and wait for in-flight requests
+// and port forwarding streams until shutdown_timeout
+func GracefulShutdown() {
+	utils.DebugPrintln("Graceful shutdown, in-flight requests:", atomic.LoadInt64(&activeRequests), "port forwarding streams:", backend.ActiveVipConns())
+	backend.NotifyStopping()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(data.GetConfig().Server.ShutdownTimeout)*time.Second)
+	defer cancel()
+	backend.StopVipApps()
+	var wg sync.WaitGroup
+	serversMutex.Lock()
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			err := server.Shutdown(ctx)
+			if err != nil {
+				utils.DebugPrintln("Shutdown server", err)
+			}
+		}(server)
+	}
+	serversMutex.Unlock()
+	wg.Wait()
+	drainTicker := time.NewTicker(100 * time.Millisecond)
+	defer drainTicker.Stop()
+	for atomic.LoadInt64(&activeRequests) > 0 || backend.ActiveVipConns() > 0 {
+		select {
+		case <-ctx.Done():
+			utils.DebugPrintln("Graceful shutdown timeout, in-flight requests:", atomic.LoadInt64(&activeRequests), "port forwarding streams:", backend.ActiveVipConns())
+			utils.CloseAccessLog()
+			os.Exit(0)
+		case <-drainTicker.C:
+		}
+	}
+	utils.CloseAccessLog()
+	utils.DebugPrintln("Graceful shutdown completed")
+	os.Exit(0)
+}
+
 // SetOSEnv set environment
 // 初始化
 func SetOSEnv() {
@@ -9,8 +9,11 @@ package models
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"encoding/json"
 	"sync"
+	"sync/atomic"
 )
 
 //一个Application可以关联多个Domain
@@ -43,6 +46,51 @@ type Application struct {
 	// CSP (Content Security Policy) v0.9.11
 	CSPEnabled bool   `json:"csp_enabled"`
 	CSP        string `json:"csp"`
+
+	// TrustedProxies CIDR list separated by comma, besides the global trusted proxies, v1.2.4
+	TrustedProxies string `json:"trusted_proxies"`
+
+	// MaxBodySize of request in bytes, 0 means the max_body_size in config.json, v1.2.4
+	MaxBodySize int64 `json:"max_body_size"`
+
+	// CacheMaxSize is the disk quota of shared cache in bytes, 0 means limited by the cache.max_size in config.json, v1.2.4
+	CacheMaxSize int64 `json:"cache_max_size"`
+
+	// RoutePolicies for load balancing of each route, v1.2.4
+	RoutePolicies []*RoutePolicy `json:"route_policies"`
+
+	// HealthCheck active HTTP(S) probe of destinations, v1.2.4
+	HealthCheck *HealthCheck `json:"health_check"`
+
+	// HeaderRules ordered by priority, v1.2.4
+	HeaderRules []*HeaderRule `json:"header_rules"`
+
+	// RewriteRules ordered by priority, the first matched rule is applied, v1.2.4
+	RewriteRules []*RewriteRule `json:"rewrite_rules"`
+
+	// CacheRules ordered by priority, the first matched rule is applied, v1.2.4
+	CacheRules []*CacheRule `json:"cache_rules"`
+
+	// TrafficRules ordered by priority, split the traffic of a route between destination groups, v1.2.4
+	TrafficRules []*TrafficRule `json:"traffic_rules"`
+
+	// Compression of responses on the gateway, v1.2.4
+	Compression *Compression `json:"compression"`
+
+	// ClientAuth mutual TLS setting, v1.2.4
+	ClientAuth *ClientAuth `json:"client_auth"`
+
+	// ClientCertRules allowlist of client certificate subjects by path prefix, v1.2.4
+	ClientCertRules []*ClientCertRule `json:"client_cert_rules"`
+
+	// UpstreamTLS verification of HTTPS backends, nil means not verified as previous versions, v1.2.4
+	UpstreamTLS *UpstreamTLS `json:"upstream_tls"`
+
+	// ConcurrencyLimit of in-flight requests and the queue, nil means unlimited, v1.2.4
+	ConcurrencyLimit *ConcurrencyLimit `json:"concurrency_limit"`
+
+	// WaitingRoom queue the visitors when the active sessions reach the maximum, nil means disabled, v1.2.4
+	WaitingRoom *WaitingRoom `json:"waiting_room"`
 }
 
 // DBApplication for storage in database
@@ -65,6 +113,12 @@ type DBApplication struct {
 	// CSP (Content Security Policy) v0.9.11
 	CSPEnabled bool   `json:"csp_enabled"`
 	CSP        string `json:"csp"`
+	// TrustedProxies v1.2.4
+	TrustedProxies string `json:"trusted_proxies"`
+	// MaxBodySize v1.2.4
+	MaxBodySize int64 `json:"max_body_size"`
+	// CacheMaxSize v1.2.4
+	CacheMaxSize int64 `json:"cache_max_size"`
 }
 
 type DomainRelation struct {
@@ -72,6 +126,9 @@ type DomainRelation struct {
 	Cert     *CertItem
 	Redirect bool
 	Location string
+
+	// TLSProfileID v1.2.4
+	TLSProfileID int64
 }
 
 //
@@ -84,6 +141,16 @@ type Domain struct {
 	Location string       `json:"location"`
 	App      *Application `json:"-"`
 	Cert     *CertItem    `json:"-"`
+
+	// TLSProfileID 0 means the default TLS setting of listener, v1.2.4
+	TLSProfileID int64 `json:"tls_profile_id"`
+
+	// RedirectStatus of the whole domain redirect, 301, 302, 307 or 308, v1.2.4
+	RedirectStatus int64 `json:"redirect_status"`
+
+	// PreservePath and PreserveQuery append the original path and query string to Location, v1.2.4
+	PreservePath  bool `json:"preserve_path"`
+	PreserveQuery bool `json:"preserve_query"`
 }
 
 type DBDomain struct {
@@ -93,6 +160,14 @@ type DBDomain struct {
 	CertID   int64  `json:"cert_id"`
 	Redirect bool   `json:"redirect"`
 	Location string `json:"location"`
+
+	// TLSProfileID v1.2.4
+	TLSProfileID int64 `json:"tls_profile_id"`
+
+	// RedirectStatus, PreservePath and PreserveQuery v1.2.4
+	RedirectStatus int64 `json:"redirect_status"`
+	PreservePath   bool  `json:"preserve_path"`
+	PreserveQuery  bool  `json:"preserve_query"`
 }
 
 // RouteType used for backend routing
@@ -107,6 +182,12 @@ const (
 
 	// StaticRoute used for static web server
 	StaticRoute RouteType = 1 << 2
+
+	// UWSGIRoute used for Python applications running under uWSGI, v1.2.4
+	UWSGIRoute RouteType = 1 << 3
+
+	// SCGIRoute used for SCGI applications, v1.2.4
+	SCGIRoute RouteType = 1 << 4
 )
 
 // Destination is used for backend routing
@@ -123,14 +204,535 @@ type Destination struct {
 	BackendRoute string `json:"backend_route"`
 
 	// Destination is backend IP:Port , or static directory
+	// Unix domain socket with prefix unix: such as unix:/run/php/php-fpm.sock, v1.2.4
 	Destination string `json:"destination"`
 
 	AppID  int64 `json:"app_id"`
 	NodeID int64 `json:"node_id"`
 
 	// Online status of Destination (IP:Port), added in V0.9.11
-	Online    bool  `json:"online"`
-	CheckTime int64 `json:"check_time"`
+	// Updated by health checks and dial errors concurrently, v1.2.4
+	Online    OnlineStatus `json:"online"`
+	CheckTime AtomicInt64  `json:"check_time"`
+
+	// Weight used for weighted load balancing, 0 means draining, v1.2.4
+	Weight int64 `json:"weight"`
+
+	// ProxyProtocol version of header sent to destination, 0: none, 1: v1, 2: v2, v1.2.4
+	ProxyProtocol int64 `json:"proxy_protocol"`
+
+	// Group name of destinations in the same route, such as blue, green or canary, selected by traffic rules, v1.2.4
+	Group string `json:"group"`
+
+	// MaxConcurrency of in-flight requests, 0 means unlimited, the queue settings of application apply, v1.2.4
+	MaxConcurrency int64 `json:"max_concurrency"`
+
+	// Connections is the count of in-flight requests, memory use only
+	Connections int64 `json:"-"`
+
+	// CheckResult is the result of last active health check, such as "200 OK" or error, v1.2.4
+	CheckResult SyncString `json:"check_result"`
+
+	// HealthSuccesses and HealthFailures are consecutive probe results, compared with rise and fall
+	HealthSuccesses int64 `json:"-"`
+	HealthFailures  int64 `json:"-"`
+}
+
+// OnlineStatus is the atomic online status of destination, encoded as bool in JSON, v1.2.4
+type OnlineStatus struct {
+	value int32
+}
+
+// NewOnlineStatus ...
+func NewOnlineStatus(online bool) OnlineStatus {
+	status := OnlineStatus{}
+	status.Store(online)
+	return status
+}
+
+// Load return true if online
+func (status *OnlineStatus) Load() bool {
+	return atomic.LoadInt32(&status.value) == 1
+}
+
+// Store return true if the status changed
+func (status *OnlineStatus) Store(online bool) bool {
+	var value int32
+	if online {
+		value = 1
+	}
+	return atomic.SwapInt32(&status.value, value) != value
+}
+
+// MarshalJSON implements json.Marshaler
+func (status *OnlineStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(status.Load())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, used by replica nodes
+func (status *OnlineStatus) UnmarshalJSON(b []byte) error {
+	var online bool
+	if err := json.Unmarshal(b, &online); err != nil {
+		return err
+	}
+	status.Store(online)
+	return nil
+}
+
+// AtomicInt64 is updated by concurrent requests, encoded as number in JSON, v1.2.4
+type AtomicInt64 struct {
+	value int64
+}
+
+// Load ...
+func (i *AtomicInt64) Load() int64 {
+	return atomic.LoadInt64(&i.value)
+}
+
+// Store ...
+func (i *AtomicInt64) Store(value int64) {
+	atomic.StoreInt64(&i.value, value)
+}
+
+// MarshalJSON implements json.Marshaler
+func (i *AtomicInt64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.Load())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, used by replica nodes
+func (i *AtomicInt64) UnmarshalJSON(b []byte) error {
+	var value int64
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	i.Store(value)
+	return nil
+}
+
+// SyncString is guarded by mutex, encoded as string in JSON, v1.2.4
+type SyncString struct {
+	mutex sync.RWMutex
+	value string
+}
+
+// Load ...
+func (s *SyncString) Load() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.value
+}
+
+// Store ...
+func (s *SyncString) Store(value string) {
+	s.mutex.Lock()
+	s.value = value
+	s.mutex.Unlock()
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *SyncString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Load())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, used by replica nodes
+func (s *SyncString) UnmarshalJSON(b []byte) error {
+	var value string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	s.Store(value)
+	return nil
+}
+
+// HeaderDirection is the target of header rule, request to backend or response to client
+type HeaderDirection int64
+
+const (
+	HeaderRequest  HeaderDirection = 1
+	HeaderResponse HeaderDirection = 1 << 1
+)
+
+// HeaderAction is the operation of header rule
+type HeaderAction int64
+
+const (
+	// HeaderSet replace all values of the header
+	HeaderSet HeaderAction = 1
+	// HeaderAppend add a value to the header
+	HeaderAppend HeaderAction = 1 << 1
+	// HeaderRemove delete the header
+	HeaderRemove HeaderAction = 1 << 2
+	// HeaderReplace replace the values matched by Pattern, Value can use $1 for captures
+	HeaderReplace HeaderAction = 1 << 3
+)
+
+// HeaderRule rewrite request or response headers, v1.2.4
+type HeaderRule struct {
+	ID        int64           `json:"id"`
+	AppID     int64           `json:"app_id"`
+	Priority  int64           `json:"priority"`
+	Direction HeaderDirection `json:"direction"`
+	Action    HeaderAction    `json:"action"`
+	Name      string          `json:"name"`
+	Value     string          `json:"value"`
+	Pattern   string          `json:"pattern"`
+
+	// PathPrefix condition, empty means all paths
+	PathPrefix string `json:"path_prefix"`
+
+	// StatusCodes condition of response, such as "200,301-302", empty means all
+	StatusCodes string `json:"status_codes"`
+}
+
+// RewriteType internal rewrite or external redirect
+type RewriteType int64
+
+const (
+	// RewriteInternal change the URL sent to backend
+	RewriteInternal RewriteType = 1
+	// RewriteRedirect response 301/302/307/308 to client
+	RewriteRedirect RewriteType = 1 << 1
+)
+
+// RewriteRule rewrite or redirect the request path matched by Pattern, v1.2.4
+type RewriteRule struct {
+	ID       int64 `json:"id"`
+	AppID    int64 `json:"app_id"`
+	Priority int64 `json:"priority"`
+
+	// Domain condition, empty means all domains of the application
+	Domain string `json:"domain"`
+
+	// Pattern is the regex of path, such as ^/old/(.*)$
+	Pattern string `json:"pattern"`
+
+	// Target can use $1 for captures, such as /new/$1 or https://www.example.com/new/$1
+	Target string `json:"target"`
+
+	Type RewriteType `json:"type"`
+
+	// StatusCode of redirect, 301, 302, 307 or 308
+	StatusCode int64 `json:"status_code"`
+
+	// PreservePath append the original path to target
+	PreservePath bool `json:"preserve_path"`
+
+	// PreserveQuery append the original query string to target
+	PreserveQuery bool `json:"preserve_query"`
+}
+
+// TrafficSplitType is the condition of traffic rule
+type TrafficSplitType int64
+
+const (
+	// SplitPercent match a percentage of clients, hashed by client IP and User-Agent, or the cookie Name if present
+	SplitPercent TrafficSplitType = 1
+	// SplitHeader match the value of request header Name
+	SplitHeader TrafficSplitType = 1 << 1
+	// SplitCookie match the value of cookie Name
+	SplitCookie TrafficSplitType = 1 << 2
+	// SplitCIDR match the client IP with the CIDRs in Value
+	SplitCIDR TrafficSplitType = 1 << 3
+)
+
+// TrafficRule send the matched requests of a route to a destination group, for canary and blue/green releases, v1.2.4
+// The first matched rule is applied, percentage rules take consecutive shares, such as 10% canary then 90% stable.
+// If no rule matched, the destinations without group are used
+type TrafficRule struct {
+	ID       int64 `json:"id"`
+	AppID    int64 `json:"app_id"`
+	Priority int64 `json:"priority"`
+
+	// RequestRoute is the route of destinations, such as /abc/ , .php , /
+	RequestRoute string `json:"request_route"`
+
+	Type TrafficSplitType `json:"type"`
+
+	// Name of header or cookie
+	Name string `json:"name"`
+
+	// Value is the header or cookie values, or CIDRs, comma separated
+	Value string `json:"value"`
+
+	// Percent of clients for SplitPercent, 0-100
+	Percent int64 `json:"percent"`
+
+	// Group of destinations, if none of them is online, all destinations of the route are used
+	Group string `json:"group"`
+}
+
+// CacheRule caches the dynamic responses matched for a short time, such as API listings, v1.2.4
+type CacheRule struct {
+	ID       int64 `json:"id"`
+	AppID    int64 `json:"app_id"`
+	Priority int64 `json:"priority"`
+
+	// Methods such as "GET,HEAD", empty means GET and HEAD, HEAD requests are served with the response of GET
+	Methods string `json:"methods"`
+
+	// PathPattern is the regex of path, such as ^/api/v1/products$, empty means all paths
+	PathPattern string `json:"path_pattern"`
+
+	// QueryParams condition, such as "page,type=list", each parameter must exist, and equal the value if specified
+	QueryParams string `json:"query_params"`
+
+	// StatusCodes of response to be cached, such as "200,404", empty means 200, 5xx responses are never cached
+	StatusCodes string `json:"status_codes"`
+
+	// TTL in seconds, used if the backend does not specify the freshness
+	TTL int64 `json:"ttl"`
+
+	// StaleWhileRevalidate in seconds, the stale response is served while it is revalidated in background
+	StaleWhileRevalidate int64 `json:"stale_while_revalidate"`
+
+	// IgnoreOrigin ignores max-age, s-maxage and Expires of the backend, so that TTL is used,
+	// responses with no-store, private or Set-Cookie are still not cached
+	IgnoreOrigin bool `json:"ignore_origin"`
+
+	// KeyQueryParams are the names of query parameters in cache key, empty means the whole query string
+	KeyQueryParams string `json:"key_query_params"`
+
+	// KeyHeaders and KeyCookies are the names of request headers and cookies added to cache key
+	KeyHeaders string `json:"key_headers"`
+	KeyCookies string `json:"key_cookies"`
+
+	// Bypass the cache if the request has any of these headers, cookies or query parameters, such as a session cookie
+	BypassHeaders     string `json:"bypass_headers"`
+	BypassCookies     string `json:"bypass_cookies"`
+	BypassQueryParams string `json:"bypass_query_params"`
+}
+
+// HealthCheck is the active HTTP(S) health check setting of an application, v1.2.4
+type HealthCheck struct {
+	ID      int64 `json:"id"`
+	AppID   int64 `json:"app_id"`
+	Enabled bool  `json:"enabled"`
+
+	// Path of probe request, such as /health
+	Path string `json:"path"`
+
+	// ExpectedStatus such as "200,301-302", empty means 200-399
+	ExpectedStatus string `json:"expected_status"`
+
+	// BodyRegex is optional, the response body must match it if not empty
+	BodyRegex string `json:"body_regex"`
+
+	// Interval and Timeout in seconds
+	Interval int64 `json:"interval"`
+	Timeout  int64 `json:"timeout"`
+
+	// Rise consecutive successes to mark online, Fall consecutive failures to mark offline
+	Rise int64 `json:"rise"`
+	Fall int64 `json:"fall"`
+
+	// LastCheckTime memory use only
+	LastCheckTime int64 `json:"-"`
+}
+
+// Compression is the gzip and brotli setting of an application, v1.2.4
+type Compression struct {
+	ID      int64 `json:"id"`
+	AppID   int64 `json:"app_id"`
+	Enabled bool  `json:"enabled"`
+	Gzip    bool  `json:"gzip"`
+	Brotli  bool  `json:"brotli"`
+
+	// MIMETypes separated by comma, empty means the default text types
+	MIMETypes string `json:"mime_types"`
+
+	// MinSize in bytes, responses with smaller Content-Length are not compressed
+	MinSize int64 `json:"min_size"`
+}
+
+// ClientCertMode is whether the client certificate is optional or required, v1.2.4
+type ClientCertMode int64
+
+const (
+	// ClientCertOptional verify the client certificate if the client sends it
+	ClientCertOptional ClientCertMode = 1
+	// ClientCertRequired reject the requests without a valid client certificate
+	ClientCertRequired ClientCertMode = 1 << 1
+)
+
+// ClientAuth is the mutual TLS setting of an application, v1.2.4
+type ClientAuth struct {
+	ID      int64          `json:"id"`
+	AppID   int64          `json:"app_id"`
+	Enabled bool           `json:"enabled"`
+	Mode    ClientCertMode `json:"mode"`
+
+	// CACertID is the CA bundle used for verifying client certificates
+	CACertID int64 `json:"ca_cert_id"`
+
+	// CRLCheck reject the revoked certificates according to the CRL of the CA bundle
+	CRLCheck bool `json:"crl_check"`
+
+	// ForwardHeaders send X-Client-Cert-* headers (subject, SAN, fingerprint) to the backend
+	ForwardHeaders bool `json:"forward_headers"`
+}
+
+// ClientCertRule allow the client certificates whose subject matches the pattern to access the path prefix, v1.2.4
+// If several rules match the path, the rules with the longest path prefix are used
+type ClientCertRule struct {
+	ID         int64  `json:"id"`
+	AppID      int64  `json:"app_id"`
+	PathPrefix string `json:"path_prefix"`
+
+	// SubjectPattern is the regular expression of subject, such as ^CN=admin,O=Janusec$
+	SubjectPattern string `json:"subject_pattern"`
+}
+
+// UpstreamTLS is the TLS setting for connecting HTTPS backends of an application, v1.2.4
+type UpstreamTLS struct {
+	ID    int64 `json:"id"`
+	AppID int64 `json:"app_id"`
+
+	// Verify the certificate chain and host name of backend
+	Verify bool `json:"verify"`
+
+	// CACertID is the CA bundle for verifying backend, 0 means the system roots
+	CACertID int64 `json:"ca_cert_id"`
+
+	// ServerName overrides the SNI and the name to verify, empty means the domain of request
+	ServerName string `json:"server_name"`
+
+	// ClientCertID is the certificate sent to backend for mutual TLS, 0 means none
+	ClientCertID int64 `json:"client_cert_id"`
+}
+
+// ConcurrencyLimit is the max in-flight requests of an application, excess requests wait in a bounded queue, v1.2.4
+type ConcurrencyLimit struct {
+	ID      int64 `json:"id"`
+	AppID   int64 `json:"app_id"`
+	Enabled bool  `json:"enabled"`
+
+	// MaxConcurrency of the application, 0 means only the destinations are limited by their own max_concurrency
+	MaxConcurrency int64 `json:"max_concurrency"`
+
+	// QueueSize is the max count of waiting requests, 0 means rejected immediately if the limit is reached
+	QueueSize int64 `json:"queue_size"`
+
+	// QueueTimeout in milliseconds, the waiting request is rejected with 503 after timeout
+	QueueTimeout int64 `json:"queue_timeout"`
+
+	// RetryAfter in seconds of the 503 response
+	RetryAfter int64 `json:"retry_after"`
+
+	// PriorityPaths are path prefixes separated by comma, such as /checkout/, which bypass the queue
+	PriorityPaths string `json:"priority_paths"`
+
+	// ErrorPage is the HTML of the 503 response, empty means the built-in page
+	ErrorPage string `json:"error_page"`
+}
+
+// WaitingRoom of application, the state is kept by primary node and shared with replica nodes, v1.2.4
+type WaitingRoom struct {
+	ID      int64 `json:"id"`
+	AppID   int64 `json:"app_id"`
+	Enabled bool  `json:"enabled"`
+
+	// MaxSessions is the max count of active visitors admitted to the application
+	MaxSessions int64 `json:"max_sessions"`
+
+	// SessionTimeout in seconds, the active session is released if the visitor is idle for such a long time
+	SessionTimeout int64 `json:"session_timeout"`
+
+	// RefreshInterval in seconds of the position page
+	RefreshInterval int64 `json:"refresh_interval"`
+}
+
+// WaitingRoomStatus of a queue ticket
+type WaitingRoomStatus struct {
+	Admitted bool `json:"admitted"`
+
+	// Position in the queue starting from 1, 0 if admitted
+	Position int64 `json:"position"`
+
+	// RefreshInterval in seconds of the position page
+	RefreshInterval int64 `json:"refresh_interval"`
+}
+
+// RPCWaitingRoomStatus for replica nodes
+type RPCWaitingRoomStatus struct {
+	Error  *string            `json:"err"`
+	Object *WaitingRoomStatus `json:"object"`
+}
+
+// LBMethod is the load balancing method of destinations, v1.2.4
+type LBMethod int64
+
+const (
+	// LBIPHash Hash(IP+UA), the default method of previous versions
+	LBIPHash LBMethod = 1
+
+	// LBRoundRobin select online destinations in turn
+	LBRoundRobin LBMethod = 1 << 1
+
+	// LBWeightedRoundRobin smooth weighted round-robin
+	LBWeightedRoundRobin LBMethod = 1 << 2
+
+	// LBLeastConnections select the destination with least in-flight requests (divided by weight)
+	LBLeastConnections LBMethod = 1 << 3
+
+	// LBConsistentHash select by consistent hashing (rendezvous) of the HashKey
+	LBConsistentHash LBMethod = 1 << 4
+)
+
+// HashKey is the key used for consistent hashing
+type HashKey int64
+
+const (
+	HashKeyIP     HashKey = 1
+	HashKeyCookie HashKey = 1 << 1
+	HashKeyHeader HashKey = 1 << 2
+)
+
+// RoutePolicy is the load balancing setting of one route, such as /abc/ , .php , / , v1.2.4
+type RoutePolicy struct {
+	ID           int64    `json:"id"`
+	AppID        int64    `json:"app_id"`
+	RequestRoute string   `json:"request_route"`
+	LBMethod     LBMethod `json:"lb_method"`
+	HashKey      HashKey  `json:"hash_key"`
+
+	// HashKeyName is the cookie name or header name when HashKey is cookie or header
+	HashKeyName string `json:"hash_key_name"`
+
+	// MirrorEnabled send a copy of requests to the shadow destination asynchronously, the responses are discarded, v1.2.4
+	MirrorEnabled bool `json:"mirror_enabled"`
+
+	// MirrorDestination is the shadow backend IP:Port, using the same scheme and upstream TLS setting of application
+	MirrorDestination string `json:"mirror_destination"`
+
+	// MirrorPercent of requests are mirrored, 1-100
+	MirrorPercent int64 `json:"mirror_percent"`
+
+	// MirrorMaxBodySize in bytes, requests with bigger bodies are not mirrored, 0 means 1MB
+	MirrorMaxBodySize int64 `json:"mirror_max_body_size"`
+
+	// MirrorMaxConcurrency is the limit of in-flight mirrored requests of the route, 0 means 100, the excess are dropped
+	MirrorMaxConcurrency int64 `json:"mirror_max_concurrency"`
+}
+
+// MirrorStat is the comparison of primary and shadow responses of a route on current node, v1.2.4
+type MirrorStat struct {
+	AppID             int64  `json:"app_id"`
+	RoutePolicyID     int64  `json:"route_policy_id"`
+	RequestRoute      string `json:"request_route"`
+	MirrorDestination string `json:"mirror_destination"`
+	InFlight          int64  `json:"in_flight"`
+	Mirrored          int64  `json:"mirrored"`
+	// Dropped by concurrency or body size limit
+	Dropped int64 `json:"dropped"`
+	// Errors of shadow destination, such as connection refused or timeout
+	Errors int64 `json:"errors"`
+	// Compared is the count of mirrored requests whose primary response is received
+	Compared         int64 `json:"compared"`
+	StatusMatched    int64 `json:"status_matched"`
+	StatusMismatched int64 `json:"status_mismatched"`
+	// Average latency of response headers in milliseconds
+	PrimaryLatency int64 `json:"primary_latency"`
+	ShadowLatency  int64 `json:"shadow_latency"`
 }
 
 type CertItem struct {
@@ -143,6 +745,69 @@ type CertItem struct {
 	Description    string          `json:"description"`
 }
 
+// TLSProfileType is the preset of TLS versions, cipher suites and curves, v1.2.4
+type TLSProfileType int64
+
+const (
+	// TLSProfileModern TLS 1.3 only
+	TLSProfileModern TLSProfileType = 1
+	// TLSProfileIntermediate TLS 1.2 and 1.3 with ECDHE and AEAD cipher suites
+	TLSProfileIntermediate TLSProfileType = 1 << 1
+	// TLSProfileLegacy TLS 1.0 to 1.3 with CBC and RSA key exchange cipher suites, for old clients such as IoT devices
+	TLSProfileLegacy TLSProfileType = 1 << 2
+	// TLSProfileCustom use the versions, cipher suites and curves of the profile
+	TLSProfileCustom TLSProfileType = 1 << 3
+)
+
+// TLSProfile is the TLS policy attached to domains, v1.2.4
+type TLSProfile struct {
+	ID   int64          `json:"id"`
+	Name string         `json:"name"`
+	Type TLSProfileType `json:"type"`
+
+	// MinVersion and MaxVersion such as TLS1.2, used by custom profile
+	MinVersion string `json:"min_version"`
+	MaxVersion string `json:"max_version"`
+
+	// CipherSuites names separated by comma, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, used by custom profile,
+	// the cipher suites of TLS 1.3 are not configurable
+	CipherSuites string `json:"cipher_suites"`
+
+	// Curves names separated by comma, such as X25519,P256, used by custom profile
+	Curves string `json:"curves"`
+
+	// OCSPStapling staple the OCSP response of uploaded certificates
+	OCSPStapling bool   `json:"ocsp_stapling"`
+	Description  string `json:"description"`
+
+	// Parsed from the profile, memory use only
+	MinTLSVersion  uint16        `json:"-"`
+	MaxTLSVersion  uint16        `json:"-"`
+	CipherSuiteIDs []uint16      `json:"-"`
+	CurveIDs       []tls.CurveID `json:"-"`
+}
+
+// CACertItem is the CA bundle for verifying client certificates or backends, v1.2.4
+type CACertItem struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+
+	// CAContent is one or more PEM encoded CA certificates
+	CAContent string `json:"ca_content"`
+
+	// CRLContent is one or more PEM encoded CRLs issued by the CAs, optional
+	CRLContent string `json:"crl_content"`
+
+	// ExpireTime of the earliest CA certificate, CRLNextUpdate of the earliest CRL
+	ExpireTime    int64  `json:"expire_time"`
+	CRLNextUpdate int64  `json:"crl_next_update"`
+	Description   string `json:"description"`
+
+	// Pool and RevokedSerials are parsed from the content, memory use only
+	Pool           *x509.CertPool  `json:"-"`
+	RevokedSerials map[string]bool `json:"-"`
+}
+
 type DBCertItem struct {
 	ID               int64
 	CommonName       string
@@ -239,6 +904,15 @@ type VipApp struct {
 
 	Description string `json:"description"`
 
+	// LBMethod load balancing method of targets, v1.2.4
+	LBMethod LBMethod `json:"lb_method"`
+
+	// AcceptProxyProtocol parse PROXY protocol header from trusted sources, TCP only, v1.2.4
+	AcceptProxyProtocol bool `json:"accept_proxy_protocol"`
+
+	// SendProxyProtocol version of header sent to targets, 0: none, 1: v1, 2: v2, TCP only, v1.2.4
+	SendProxyProtocol int64 `json:"send_proxy_protocol"`
+
 	// ExitChan used for exit, when VipApp deleted or port changed.
 	ExitChan chan bool `json:"-"`
 }
@@ -254,4 +928,23 @@ type VipTarget struct {
 	// Online status of Destination (IP:Port)
 	Online    bool  `json:"online"`
 	CheckTime int64 `json:"check_time"`
+
+	// Weight used for weighted load balancing, 0 means draining, v1.2.4
+	Weight int64 `json:"weight"`
+
+	// Connections is the count of active connections, memory use only
+	Connections int64 `json:"-"`
+}
+
+// VipStat is the statistics of port forwarding on current node, v1.2.4
+type VipStat struct {
+	VipAppID int64  `json:"vip_app_id"`
+	Name     string `json:"name"`
+	IsTCP    bool   `json:"is_tcp"`
+	// Connections accepted, or UDP sessions, and ActiveConnections of TCP
+	Connections       int64 `json:"connections"`
+	ActiveConnections int64 `json:"active_connections"`
+	// BytesIn from clients, and BytesOut to clients
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
 }
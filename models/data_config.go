@@ -14,6 +14,33 @@ type Config struct {
 	ListenHTTPS string            `json:"listen_https"`
 	PrimaryNode PrimaryNodeConfig `json:"primary_node"`
 	ReplicaNode ReplicaNodeConfig `json:"replica_node"`
+
+	// BackendTransport for connection pool to backend destinations, v1.2.4
+	BackendTransport TransportConfig `json:"backend_transport"`
+
+	// BackendRetry retry idempotent requests on another destination, v1.2.4
+	BackendRetry RetryConfig `json:"backend_retry"`
+
+	// CircuitBreaker eject failed destinations temporarily, v1.2.4
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+
+	// ProxyProtocol for inbound connections from L4 balancers, v1.2.4
+	ProxyProtocol ProxyProtocolConfig `json:"proxy_protocol"`
+
+	// Server timeouts and size limits of gateway and admin listeners, v1.2.4
+	Server ServerConfig `json:"server"`
+
+	// Cache is the quota of shared cache on this node, v1.2.4
+	Cache CacheConfig `json:"cache"`
+
+	// AccessLog of gateway, v1.2.4
+	AccessLog AccessLogConfig `json:"access_log"`
+
+	// Metrics endpoint for Prometheus, v1.2.4
+	Metrics MetricsConfig `json:"metrics"`
+
+	// Tracing of request ID and OpenTelemetry, v1.2.4
+	Tracing TracingConfig `json:"tracing"`
 }
 
 type OAuthConfig struct {
@@ -47,6 +74,141 @@ type AdminConfig struct {
 	WebSSHEnabled bool   `json:"webssh_enabled"`
 }
 
+// TransportConfig is the connection pool setting for each backend destination
+// Timeouts are in seconds, 0 means using the default value
+type TransportConfig struct {
+	MaxIdleConns          int   `json:"max_idle_conns"`
+	MaxIdleConnsPerHost   int   `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int   `json:"max_conns_per_host"`
+	DialTimeout           int64 `json:"dial_timeout"`
+	IdleConnTimeout       int64 `json:"idle_conn_timeout"`
+	TLSHandshakeTimeout   int64 `json:"tls_handshake_timeout"`
+	ResponseHeaderTimeout int64 `json:"response_header_timeout"`
+}
+
+// RetryConfig is the retry setting of idempotent requests when the destination failed
+type RetryConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// MaxRetries for each request
+	MaxRetries int64 `json:"max_retries"`
+
+	// BudgetRatio limit the retries to a ratio of requests, such as 0.2
+	BudgetRatio float64 `json:"budget_ratio"`
+
+	// MinRetriesPerSecond is always allowed besides the budget ratio
+	MinRetriesPerSecond int64 `json:"min_retries_per_second"`
+}
+
+// CircuitBreakerConfig is the setting of circuit breaker for each destination
+type CircuitBreakerConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// ConsecutiveFailures (connection errors or 5xx) to open the circuit
+	ConsecutiveFailures int64 `json:"consecutive_failures"`
+
+	// ErrorRatio of 5xx in the window to open the circuit, such as 0.5
+	ErrorRatio float64 `json:"error_ratio"`
+
+	// MinRequests in the window before the error ratio is checked
+	MinRequests int64 `json:"min_requests"`
+
+	// Window and Cooldown in seconds, the circuit is half-open after cooldown
+	Window   int64 `json:"window"`
+	Cooldown int64 `json:"cooldown"`
+}
+
+// ProxyProtocolConfig is the setting of PROXY protocol v1/v2 on the gateway listeners
+type ProxyProtocolConfig struct {
+	// Enabled on the main HTTP/HTTPS listeners, port forwarding is configured in each VipApp
+	Enabled bool `json:"enabled"`
+
+	// TrustedSources IP or CIDR of L4 balancers, the header from other sources is not parsed
+	TrustedSources []string `json:"trusted_sources"`
+}
+
+// ServerConfig is the setting of http.Server, timeouts are in seconds
+type ServerConfig struct {
+	ReadHeaderTimeout int64 `json:"read_header_timeout"`
+
+	// ReadTimeout includes the body, 300 by default so that slow bodies can not hold the connections,
+	// 0 means no timeout for large uploads, WebSocket is not limited after upgraded
+	ReadTimeout int64 `json:"read_timeout"`
+
+	// WriteTimeout includes the body, 0 means no timeout for large downloads
+	WriteTimeout int64 `json:"write_timeout"`
+
+	IdleTimeout    int64 `json:"idle_timeout"`
+	MaxHeaderBytes int   `json:"max_header_bytes"`
+
+	// MaxBodySize of request in bytes, the default value for applications, responses 413 if exceeded
+	MaxBodySize int64 `json:"max_body_size"`
+
+	// ShutdownTimeout is the deadline of draining connections when SIGTERM received
+	ShutdownTimeout int64 `json:"shutdown_timeout"`
+}
+
+// CacheConfig is the setting of shared cache in ./static/cdncache
+type CacheConfig struct {
+	// MaxSize of cached bodies in bytes, the least recently used responses are evicted if exceeded
+	MaxSize int64 `json:"max_size"`
+
+	// MaxMemorySize of the in-memory tier in front of disk in bytes, -1 disables it
+	MaxMemorySize int64 `json:"max_memory_size"`
+}
+
+// AccessLogConfig is the setting of structured access log, written by a buffered asynchronous writer
+type AccessLogConfig struct {
+	// Format json or combined, off disables the access log
+	Format string `json:"format"`
+
+	// File path, rotated files are renamed with the time, such as ./log/access-20210607-150405.log
+	File string `json:"file"`
+
+	// MaxSize in bytes and RotateInterval in seconds, the file is rotated when either is reached
+	MaxSize        int64 `json:"max_size"`
+	RotateInterval int64 `json:"rotate_interval"`
+
+	// BufferSize is the count of queued records, records are dropped if the writer falls behind
+	BufferSize int `json:"buffer_size"`
+
+	// FlushInterval in seconds
+	FlushInterval int64 `json:"flush_interval"`
+}
+
+// MetricsConfig is the Prometheus metrics endpoint on a separate listener
+type MetricsConfig struct {
+	// Listen address such as 127.0.0.1:9145, empty disables the endpoint
+	Listen string `json:"listen"`
+
+	// Path of metrics, /metrics by default
+	Path string `json:"path"`
+
+	// AllowedSources IP or CIDR of Prometheus servers, empty means any
+	AllowedSources []string `json:"allowed_sources"`
+}
+
+// TracingConfig is the setting of X-Request-ID and OpenTelemetry spans
+type TracingConfig struct {
+	// RegenerateRequestID ignore the X-Request-ID from client, a new ID is always generated
+	RegenerateRequestID bool `json:"regenerate_request_id"`
+
+	// Enabled spans of each phase and W3C traceparent propagation to destinations
+	Enabled bool `json:"enabled"`
+
+	// OTLPEndpoint of OTLP/HTTP with JSON encoding, such as http://127.0.0.1:4318/v1/traces, empty only propagates traceparent
+	OTLPEndpoint string `json:"otlp_endpoint"`
+
+	// OTLPHeaders are sent to the collector, such as Authorization
+	OTLPHeaders map[string]string `json:"otlp_headers"`
+
+	// ServiceName of resource, janusec by default
+	ServiceName string `json:"service_name"`
+
+	// SampleRatio of new traces from 0 to 1, 1 by default, the sampled flag of incoming traceparent is followed
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
 type DBConfig struct {
 	Host     string `json:"host"`
 	Port     string `json:"port"`
@@ -61,6 +223,33 @@ type EncryptedConfig struct {
 	ListenHTTPS string            `json:"listen_https"`
 	PrimaryNode PrimaryNodeConfig `json:"primary_node"`
 	ReplicaNode ReplicaNodeConfig `json:"replica_node"`
+
+	// BackendTransport for connection pool to backend destinations, v1.2.4
+	BackendTransport TransportConfig `json:"backend_transport"`
+
+	// BackendRetry retry idempotent requests on another destination, v1.2.4
+	BackendRetry RetryConfig `json:"backend_retry"`
+
+	// CircuitBreaker eject failed destinations temporarily, v1.2.4
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+
+	// ProxyProtocol for inbound connections from L4 balancers, v1.2.4
+	ProxyProtocol ProxyProtocolConfig `json:"proxy_protocol"`
+
+	// Server timeouts and size limits of gateway and admin listeners, v1.2.4
+	Server ServerConfig `json:"server"`
+
+	// Cache is the quota of shared cache on this node, v1.2.4
+	Cache CacheConfig `json:"cache"`
+
+	// AccessLog of gateway, v1.2.4
+	AccessLog AccessLogConfig `json:"access_log"`
+
+	// Metrics endpoint for Prometheus, v1.2.4
+	Metrics MetricsConfig `json:"metrics"`
+
+	// Tracing of request ID and OpenTelemetry, v1.2.4
+	Tracing TracingConfig `json:"tracing"`
 }
 
 type WxworkConfig struct {
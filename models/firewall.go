@@ -165,6 +165,8 @@ type CCLog struct {
 	RawRequest  string       `json:"raw_request"`
 	Action      PolicyAction `json:"action"`
 	AppID       int64        `json:"app_id"`
+	// RequestID is the X-Request-ID of the request, v1.2.4
+	RequestID string `json:"request_id"`
 }
 
 type SimpleCCLog struct {
@@ -194,6 +196,8 @@ type GroupHitLog struct {
 	PolicyID    int64        `json:"policy_id"`
 	VulnID      int64        `json:"vuln_id"`
 	AppID       int64        `json:"app_id"`
+	// RequestID is the X-Request-ID of the request, v1.2.4
+	RequestID string `json:"request_id"`
 }
 
 type SimpleGroupHitLog struct {
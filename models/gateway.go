@@ -17,11 +17,14 @@ type HitInfo struct {
 	ClientID  string // for CC/Attack Client ID
 	TargetURL string // for CAPTCHA redirect
 	BlockTime int64
+	RequestID string // X-Request-ID shown on block and CAPTCHA pages, v1.2.4
 }
 
 type CaptchaContext struct {
 	CaptchaId string
 	ClientID  string
+	// RequestID of the request which triggered CAPTCHA, v1.2.4
+	RequestID string
 }
 
 type OAuthState struct {
@@ -57,7 +60,11 @@ type PopularContent struct {
 
 // InternalErrorInfo i.e. 502 or server offline
 type InternalErrorInfo struct {
+	// Title is optional, default Internal Server Offline, v1.2.4
+	Title       string `json:"title"`
 	Description string `json:"description"`
+	// RequestID is shown for support, filled with the X-Request-ID of response if empty, v1.2.4
+	RequestID string `json:"request_id"`
 }
 
 // GateHealth give basic information
@@ -97,6 +104,13 @@ type ShieldInfo struct {
 	Callback string
 }
 
+// WaitingRoomInfo used for the position page of waiting room, v1.2.4
+type WaitingRoomInfo struct {
+	Position        int64
+	RefreshInterval int64
+	RequestID       string
+}
+
 // SMTPSetting shared with all nodes
 type SMTPSetting struct {
 	SMTPEnabled  bool   `json:"smtp_enabled"`
@@ -136,6 +150,9 @@ type PrimarySetting struct {
 	SkipSEEnabled bool   `json:"skip_se_enabled"`
 	SearchEngines string `json:"search_engines"`
 
+	// TrustedProxies CIDR list separated by comma, such as CDN, v1.2.4
+	TrustedProxies string `json:"trusted_proxies"`
+
 	// SMTP
 	SMTP *SMTPSetting `json:"smtp"`
 }
@@ -156,6 +173,12 @@ type NodeShareSetting struct {
 	SkipSEEnabled        bool   `json:"skip_se_enabled"`
 	SearchEnginesPattern string `json:"search_engines_pattern"`
 
+	// TrustedProxies for client IP resolution, shared with PrimarySetting, v1.2.4
+	TrustedProxies string `json:"trusted_proxies"`
+
+	// CachePurgeID is the ID of latest cache purge, replica nodes replay the purges after their last one, v1.2.4
+	CachePurgeID int64 `json:"cache_purge_id"`
+
 	// AuthConfig for authentication
 	AuthConfig *OAuthConfig `json:"auth_config"`
 
@@ -168,3 +191,90 @@ type SMTPTestRequest struct {
 	Action string          `json:"action"`
 	Object *SMTPSetting    `json:"object"`
 }
+
+// CachePurgeType v1.2.4
+type CachePurgeType int64
+
+const (
+	// PurgeAll remove all cached responses of all applications
+	PurgeAll CachePurgeType = 1
+	// PurgeApp remove cached responses of an application
+	PurgeApp CachePurgeType = 2
+	// PurgeURL remove all variants of the URL, such as www.example.com/index.html?id=1
+	PurgeURL CachePurgeType = 3
+	// PurgePrefix remove the URLs with the prefix, or matching the wildcard such as www.example.com/static/*.js
+	PurgePrefix CachePurgeType = 4
+)
+
+// CachePurge is a purge request of shared cache, replica nodes replay them in order of ID, v1.2.4
+type CachePurge struct {
+	ID        int64          `json:"id"`
+	Type      CachePurgeType `json:"type"`
+	AppID     int64          `json:"app_id"`
+	Pattern   string         `json:"pattern"`
+	PurgeTime int64          `json:"purge_time"`
+}
+
+// RPCCachePurges for replica nodes, v1.2.4
+type RPCCachePurges struct {
+	Error  *string       `json:"err"`
+	Object []*CachePurge `json:"object"`
+}
+
+// CacheStat is the statistics of shared cache of an application on current node, v1.2.4
+type CacheStat struct {
+	AppID   int64 `json:"app_id"`
+	Entries int64 `json:"entries"`
+	// Size of cached bodies in bytes, MaxSize 0 means limited by the global quota only
+	Size        int64 `json:"size"`
+	MaxSize     int64 `json:"max_size"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Stale       int64 `json:"stale"`
+	Revalidated int64 `json:"revalidated"`
+	Evictions   int64 `json:"evictions"`
+	// MemoryHits are the hits served from the in-memory tier
+	MemoryHits int64 `json:"memory_hits"`
+}
+
+// CacheStats of current node, v1.2.4
+type CacheStats struct {
+	Entries int64 `json:"entries"`
+	Size    int64 `json:"size"`
+	MaxSize int64 `json:"max_size"`
+	// MemoryFiles and MemorySize are the usage of in-memory tier, including precompressed variants
+	MemoryFiles   int64        `json:"memory_files"`
+	MemorySize    int64        `json:"memory_size"`
+	MaxMemorySize int64        `json:"max_memory_size"`
+	Apps          []*CacheStat `json:"apps"`
+}
+
+// AccessLogRecord is written after the response of gateway completes, v1.2.4
+type AccessLogRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	AppID     int64     `json:"app_id"`
+	Domain    string    `json:"domain"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	// Bytes of response body sent to client
+	Bytes     int64  `json:"bytes"`
+	Referer   string `json:"referer"`
+	UserAgent string `json:"user_agent"`
+	// Destination selected for the request, empty if not forwarded, such as blocked or served from cache
+	Destination string `json:"destination"`
+	// RequestLatency before forwarding, UpstreamLatency until the response header of destination, and TotalLatency, in milliseconds
+	RequestLatency  float64 `json:"request_latency"`
+	UpstreamLatency float64 `json:"upstream_latency"`
+	TotalLatency    float64 `json:"total_latency"`
+	// Verdict of WAF or CC, such as waf_block, cc_captcha, empty if passed
+	Verdict    string `json:"verdict"`
+	Cache      string `json:"cache"`
+	TLSVersion string `json:"tls_version"`
+	AuthUser   string `json:"auth_user"`
+	// TraceID of W3C traceparent, empty if tracing is disabled
+	TraceID string `json:"trace_id,omitempty"`
+}
@@ -41,6 +41,16 @@ type RPCCertItems struct {
 	Object []*CertItem `json:"object"`
 }
 
+type RPCCACertItems struct {
+	Error  *string       `json:"err"`
+	Object []*CACertItem `json:"object"`
+}
+
+type RPCTLSProfiles struct {
+	Error  *string       `json:"err"`
+	Object []*TLSProfile `json:"object"`
+}
+
 type RPCApplications struct {
 	Error  *string        `json:"err"`
 	Object []*Application `json:"object"`
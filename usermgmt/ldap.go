@@ -114,8 +114,8 @@ func LDAPAuthFunc(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		RecordAuthLog(r, authUser.Username, "LDAP", data.CFG.PrimaryNode.Admin.Portal)
-		http.Redirect(w, r, data.CFG.PrimaryNode.Admin.Portal, http.StatusFound)
+		RecordAuthLog(r, authUser.Username, "LDAP", data.GetConfig().PrimaryNode.Admin.Portal)
+		http.Redirect(w, r, data.GetConfig().PrimaryNode.Admin.Portal, http.StatusFound)
 		return
 	}
 	// Gateway OAuth for employees and internal application
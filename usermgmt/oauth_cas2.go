@@ -92,8 +92,8 @@ func CAS2CallbackWithCode(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			utils.DebugPrintln("CAS2CallbackWithCode session save error", err)
 		}
-		RecordAuthLog(r, authUser.Username, "CAS2", data.CFG.PrimaryNode.Admin.Portal)
-		http.Redirect(w, r, data.CFG.PrimaryNode.Admin.Portal, http.StatusFound)
+		RecordAuthLog(r, authUser.Username, "CAS2", data.GetConfig().PrimaryNode.Admin.Portal)
+		http.Redirect(w, r, data.GetConfig().PrimaryNode.Admin.Portal, http.StatusFound)
 		return
 
 	} else {
@@ -105,8 +105,8 @@ func DingtalkCallbackWithCode(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			utils.DebugPrintln("DingtalkCallbackWithCode session save error", err)
 		}
-		RecordAuthLog(r, authUser.Username, "DingTalk", data.CFG.PrimaryNode.Admin.Portal)
-		http.Redirect(w, r, data.CFG.PrimaryNode.Admin.Portal, http.StatusFound)
+		RecordAuthLog(r, authUser.Username, "DingTalk", data.GetConfig().PrimaryNode.Admin.Portal)
+		http.Redirect(w, r, data.GetConfig().PrimaryNode.Admin.Portal, http.StatusFound)
 		return
 	}
 	// Gateway OAuth for employees and internal application
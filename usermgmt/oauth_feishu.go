@@ -120,8 +120,8 @@ func FeishuCallbackWithCode(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			utils.DebugPrintln("FeishuCallbackWithCode session save error", err)
 		}
-		RecordAuthLog(r, authUser.Username, "Feishu", data.CFG.PrimaryNode.Admin.Portal)
-		http.Redirect(w, r, data.CFG.PrimaryNode.Admin.Portal, http.StatusFound)
+		RecordAuthLog(r, authUser.Username, "Feishu", data.GetConfig().PrimaryNode.Admin.Portal)
+		http.Redirect(w, r, data.GetConfig().PrimaryNode.Admin.Portal, http.StatusFound)
 		return
 	}
 	// Gateway OAuth for employees and internal application
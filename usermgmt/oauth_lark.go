@@ -126,8 +126,8 @@ func LarkCallbackWithCode(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			utils.DebugPrintln("LarkCallbackWithCode session save error", err)
 		}
-		RecordAuthLog(r, authUser.Username, "Lark", data.CFG.PrimaryNode.Admin.Portal)
-		http.Redirect(w, r, data.CFG.PrimaryNode.Admin.Portal, http.StatusFound)
+		RecordAuthLog(r, authUser.Username, "Lark", data.GetConfig().PrimaryNode.Admin.Portal)
+		http.Redirect(w, r, data.GetConfig().PrimaryNode.Admin.Portal, http.StatusFound)
 		return
 	}
 	// Gateway OAuth for employees and internal application
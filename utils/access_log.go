@@ -0,0 +1,237 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-06-08 21:12:40
+ * @Last Modified: U2, 2021-06-08 21:12:40
+ */
+
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"janusec/models"
+)
+
+// accessLogger is the buffered asynchronous writer of access log, only its goroutine writes the file
+type accessLogger struct {
+	records chan *models.AccessLogRecord
+	configs chan models.AccessLogConfig
+	stop    chan chan struct{}
+
+	cfg    models.AccessLogConfig
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	// period is the index of RotateInterval of current file
+	period int64
+
+	// dropped records when the buffer is full
+	dropped int64
+}
+
+var accessLog *accessLogger
+
+// InitAccessLog start the writer of access log, called once after config.json is loaded
+func InitAccessLog(cfg models.AccessLogConfig) {
+	accessLog = &accessLogger{
+		records: make(chan *models.AccessLogRecord, cfg.BufferSize),
+		configs: make(chan models.AccessLogConfig, 1),
+		stop:    make(chan chan struct{}),
+		cfg:     cfg,
+	}
+	go accessLog.run()
+}
+
+// ReloadAccessLog apply the new format and rotation setting, the buffer size requires restart
+func ReloadAccessLog(cfg models.AccessLogConfig) {
+	if accessLog != nil {
+		accessLog.configs <- cfg
+	}
+}
+
+// CloseAccessLog flush the queued records and close the file, used by graceful shutdown
+func CloseAccessLog() {
+	if accessLog == nil {
+		return
+	}
+	done := make(chan struct{})
+	accessLog.stop <- done
+	<-done
+}
+
+// WriteAccessLog queue the record without blocking the request
+func WriteAccessLog(record *models.AccessLogRecord) {
+	if accessLog == nil {
+		return
+	}
+	select {
+	case accessLog.records <- record:
+	default:
+		if dropped := atomic.AddInt64(&accessLog.dropped, 1); dropped%1000 == 1 {
+			DebugPrintln("WriteAccessLog buffer is full, dropped", dropped)
+		}
+	}
+}
+
+func (logger *accessLogger) run() {
+	flushTicker := time.NewTicker(time.Duration(logger.cfg.FlushInterval) * time.Second)
+	defer flushTicker.Stop()
+	for {
+		select {
+		case record := <-logger.records:
+			logger.write(record)
+		case cfg := <-logger.configs:
+			logger.close()
+			logger.cfg = cfg
+			flushTicker.Reset(time.Duration(cfg.FlushInterval) * time.Second)
+		case <-flushTicker.C:
+			logger.flush()
+			// Rotate idle file by time
+			if logger.file != nil && time.Now().Unix()/logger.cfg.RotateInterval != logger.period {
+				logger.rotate()
+			}
+		case done := <-logger.stop:
+			for len(logger.records) > 0 {
+				logger.write(<-logger.records)
+			}
+			logger.close()
+			close(done)
+			return
+		}
+	}
+}
+
+func (logger *accessLogger) write(record *models.AccessLogRecord) {
+	var line []byte
+	switch logger.cfg.Format {
+	case "off":
+		return
+	case "combined":
+		line = []byte(formatCombinedLog(record))
+	default:
+		var err error
+		if line, err = json.Marshal(record); err != nil {
+			DebugPrintln("WriteAccessLog json.Marshal", err)
+			return
+		}
+		line = append(line, '\n')
+	}
+	if logger.file == nil && !logger.open() {
+		return
+	}
+	if logger.size+int64(len(line)) > logger.cfg.MaxSize || time.Now().Unix()/logger.cfg.RotateInterval != logger.period {
+		if !logger.rotate() {
+			return
+		}
+	}
+	n, err := logger.writer.Write(line)
+	logger.size += int64(n)
+	if err != nil {
+		DebugPrintln("WriteAccessLog", err)
+	}
+}
+
+// open the file for appending, the period of an existing file is its last modification
+func (logger *accessLogger) open() bool {
+	if err := os.MkdirAll(filepath.Dir(logger.cfg.File), 0700); err != nil {
+		DebugPrintln("AccessLog MkdirAll", err)
+		return false
+	}
+	file, err := os.OpenFile(logger.cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		DebugPrintln("AccessLog OpenFile", err)
+		return false
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		DebugPrintln("AccessLog Stat", err)
+		file.Close()
+		return false
+	}
+	logger.file = file
+	logger.writer = bufio.NewWriterSize(file, 64<<10)
+	logger.size = fi.Size()
+	logger.period = time.Now().Unix() / logger.cfg.RotateInterval
+	if fi.Size() > 0 {
+		logger.period = fi.ModTime().Unix() / logger.cfg.RotateInterval
+	}
+	return true
+}
+
+// rotate rename the current file with the time and open a new one
+func (logger *accessLogger) rotate() bool {
+	fileName := logger.cfg.File
+	logger.close()
+	if fi, err := os.Stat(fileName); err == nil && fi.Size() > 0 {
+		ext := filepath.Ext(fileName)
+		baseName := strings.TrimSuffix(fileName, ext) + "-" + time.Now().Format("20060102-150405")
+		rotatedName := baseName + ext
+		// More than one rotation in a second if the records are large
+		for i := 1; fileExists(rotatedName); i++ {
+			rotatedName = baseName + "." + strconv.Itoa(i) + ext
+		}
+		if err = os.Rename(fileName, rotatedName); err != nil {
+			DebugPrintln("AccessLog rotate", err)
+		}
+	}
+	return logger.open()
+}
+
+func (logger *accessLogger) flush() {
+	if logger.writer == nil {
+		return
+	}
+	if err := logger.writer.Flush(); err != nil {
+		DebugPrintln("AccessLog flush", err)
+	}
+}
+
+func (logger *accessLogger) close() {
+	if logger.file == nil {
+		return
+	}
+	logger.flush()
+	if err := logger.file.Close(); err != nil {
+		DebugPrintln("AccessLog close", err)
+	}
+	logger.file = nil
+	logger.writer = nil
+}
+
+// formatCombinedLog return the line in Apache/Nginx combined log format
+func formatCombinedLog(record *models.AccessLogRecord) string {
+	bytesSent := "-"
+	if record.Bytes > 0 {
+		bytesSent = strconv.FormatInt(record.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] %s %d %s %s %s\n",
+		record.ClientIP,
+		dashIfEmpty(record.AuthUser),
+		record.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(record.Method+" "+record.URI+" "+record.Proto),
+		record.Status,
+		bytesSent,
+		strconv.Quote(dashIfEmpty(record.Referer)),
+		strconv.Quote(dashIfEmpty(record.UserAgent)))
+}
+
+func fileExists(fileName string) bool {
+	_, err := os.Stat(fileName)
+	return err == nil
+}
+
+func dashIfEmpty(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
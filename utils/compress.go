@@ -0,0 +1,42 @@
+/*
+ * @Copyright Reserved By Janusec (https://www.janusec.com/).
+ * @Author: U2
+ * @Date: 2021-05-29 20:31:27
+ * @Last Modified: U2, 2021-05-29 20:31:27
+ */
+
+package utils
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DecodeContent decompress the body according to Content-Encoding, gzip, deflate and br are supported
+func DecodeContent(contentEncoding string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return body, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case "deflate":
+		flateReader := flate.NewReader(bytes.NewReader(body))
+		defer flateReader.Close()
+		reader = flateReader
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return body, nil
+	}
+	return ioutil.ReadAll(reader)
+}
@@ -64,15 +64,16 @@ func DebugPrintln(a ...interface{}) {
 	}
 }
 
-// AccessLog record log for each application
-func AccessLog(domain string, method string, ip string, url string, ua string) {
+// appendLogFile write a line to the daily log file, a logger for each write instead of redirecting the standard logger,
+// which is shared by other goroutines
+func appendLogFile(prefix string, format string, v ...interface{}) {
 	now := time.Now()
-	f, err := os.OpenFile("./log/"+domain+now.Format("20060102")+".log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	f, err := os.OpenFile("./log/"+prefix+now.Format("20060102")+".log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
 		log.Printf("error opening file: %s\n", err.Error())
+		return
 	}
-	log.SetOutput(f)
-	log.Printf("[%s] %s [%s] UA:[%s]\n", ip, method, url, ua)
+	log.New(f, "", log.LstdFlags).Printf(format, v...)
 	if err := f.Close(); err != nil {
 		log.Printf("error closing file: %s\n", err.Error())
 	}
@@ -80,44 +81,17 @@ func AccessLog(domain string, method string, ip string, url string, ua string) {
 
 // VipAccessLog record logs of port forwarding
 func VipAccessLog(name string, clientAddr string, gateAddr string, backendAddr string) {
-	now := time.Now()
-	f, err := os.OpenFile("./log/PortForwarding"+now.Format("20060102")+".log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
-	if err != nil {
-		log.Printf("error opening file: %s\n", err.Error())
-	}
-	log.SetOutput(f)
-	log.Printf("[%s] [%s] -> [%s] -> [%s]\n", name, clientAddr, gateAddr, backendAddr)
-	if err := f.Close(); err != nil {
-		log.Printf("error closing file: %s\n", err.Error())
-	}
+	appendLogFile("PortForwarding", "[%s] [%s] -> [%s] -> [%s]\n", name, clientAddr, gateAddr, backendAddr)
 }
 
 // AuthLog record log for each successful authentication
 func AuthLog(ip string, username string, provider string, callback string) {
-	now := time.Now()
-	f, err := os.OpenFile("./log/auth"+now.Format("20060102")+".log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
-	if err != nil {
-		log.Printf("error opening file: %s\n", err.Error())
-	}
-	log.SetOutput(f)
-	log.Printf("[%s] [%s] [%s] [%s]\n", ip, username, provider, callback)
-	if err := f.Close(); err != nil {
-		log.Printf("error closing file: %s\n", err.Error())
-	}
+	appendLogFile("auth", "[%s] [%s] [%s] [%s]\n", ip, username, provider, callback)
 }
 
 // OperationLog ...
 func OperationLog(ip string, username string, operation string, object string) {
-	now := time.Now()
-	f, err := os.OpenFile("./log/operation"+now.Format("20060102")+".log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
-	if err != nil {
-		log.Printf("error opening file: %s\n", err.Error())
-	}
-	log.SetOutput(f)
-	log.Printf("[%s] [%s] [%s] [%s]\n", ip, username, operation, object)
-	if err := f.Close(); err != nil {
-		log.Printf("error closing file: %s\n", err.Error())
-	}
+	appendLogFile("operation", "[%s] [%s] [%s] [%s]\n", ip, username, operation, object)
 }
 
 // SendEmail for notification